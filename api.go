@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/MISW/mischan-bot/config"
+	"golang.org/x/xerrors"
+)
+
+// apiClient calls the admin API of the running bot.
+// Commands changing state go through it so that only the server opens the state.
+type apiClient struct {
+	baseURL string
+	token   string
+	client  *http.Client
+}
+
+// newAPIClient initializes apiClient for SERVER_URL with ADMIN_TOKEN
+func newAPIClient(cfg *config.Config) (*apiClient, error) {
+	if cfg.AdminToken == "" {
+		return nil, xerrors.New("ADMIN_TOKEN is required to call the admin API of the running bot")
+	}
+
+	baseURL := cfg.ServerURL
+	if baseURL == "" {
+		if cfg.Port == 0 {
+			return nil, xerrors.New("SERVER_URL or PORT is required to call the admin API of the running bot")
+		}
+
+		baseURL = fmt.Sprintf("http://localhost:%d", cfg.Port)
+	}
+
+	return &apiClient{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		token:   cfg.AdminToken,
+		client:  http.DefaultClient,
+	}, nil
+}
+
+// do sends a request with body to path and decodes the response into v.
+// Error responses are returned as errors with their messages.
+func (ac *apiClient) do(ctx context.Context, method, path string, header http.Header, body []byte, v any) error {
+	req, err := http.NewRequestWithContext(ctx, method, ac.baseURL+path, bytes.NewReader(body))
+
+	if err != nil {
+		return xerrors.Errorf("failed to create request: %w", err)
+	}
+
+	for k, values := range header {
+		req.Header[k] = values
+	}
+	req.Header.Set("Authorization", "Bearer "+ac.token)
+
+	resp, err := ac.client.Do(req)
+
+	if err != nil {
+		return xerrors.Errorf("failed to call %s %s: %w", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+
+	if err != nil {
+		return xerrors.Errorf("failed to read response of %s %s: %w", method, path, err)
+	}
+
+	if resp.StatusCode/100 != 2 {
+		var e struct {
+			Message string `json:"message"`
+			Error   string `json:"error"`
+		}
+		if err := json.Unmarshal(b, &e); err != nil || e.Message == "" {
+			return xerrors.Errorf("%s %s responded %s", method, path, resp.Status)
+		}
+
+		if e.Error != "" {
+			return xerrors.Errorf("%s: %s", e.Message, e.Error)
+		}
+
+		return xerrors.New(e.Message)
+	}
+
+	if v == nil {
+		return nil
+	}
+
+	if err := json.Unmarshal(b, v); err != nil {
+		return xerrors.Errorf("invalid response of %s %s: %w", method, path, err)
+	}
+
+	return nil
+}
+
+// postJSON sends v as JSON to path and decodes the response into res
+func (ac *apiClient) postJSON(ctx context.Context, path string, v, res any) error {
+	body, err := json.Marshal(v)
+
+	if err != nil {
+		return xerrors.Errorf("failed to encode request: %w", err)
+	}
+
+	return ac.do(ctx, http.MethodPost, path, http.Header{"Content-Type": {"application/json"}}, body, res)
+}
+
+// appPath returns the path of the app in the admin API
+func appPath(app string) string {
+	return "/api/apps/" + url.PathEscape(app)
+}
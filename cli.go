@@ -0,0 +1,570 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/MISW/mischan-bot/config"
+	"github.com/MISW/mischan-bot/intenral/audit"
+	"github.com/MISW/mischan-bot/intenral/auth"
+	"github.com/MISW/mischan-bot/intenral/ghsink"
+	"github.com/MISW/mischan-bot/repository"
+	"github.com/MISW/mischan-bot/usecase"
+	"go.uber.org/dig"
+	"golang.org/x/xerrors"
+)
+
+const usage = `Usage: mischan-bot <command> [flags]
+
+Commands:
+  serve                   start the webhook server(default)
+  run -app APP [-sha SHA] [-environment ENV]
+                          deploy an app through the same path as webhooks
+  plan -app APP [-sha SHA] [-environment ENV]
+                          show what run would do without changing anything
+  apps list [-json]       list app repositories and their config
+  config validate         validate the config and report missing credentials
+  replay [-event TYPE] [-delivery ID] PAYLOAD.json
+                          handle a saved webhook payload and wait for it
+  rollback -app APP [-environment ENV]
+                          open a pull request to roll back an app
+  audit [flags]           search the audit log of actions on GitHub
+
+run, plan, replay and rollback call the admin API of the running bot at SERVER_URL
+(http://localhost:PORT by default) with ADMIN_TOKEN.
+
+Run mischan-bot <command> -h for flags of each command.
+`
+
+// runCommand runs the subcommand
+func runCommand(container *dig.Container, name string, args []string) error {
+	switch name {
+	case "serve":
+		return serveCommand(container, args)
+	case "run":
+		return runAppCommand(container, args)
+	case "plan":
+		return planCommand(container, args)
+	case "apps":
+		return appsCommand(container, args)
+	case "config":
+		return configCommand(container, args)
+	case "replay":
+		return replayCommand(container, args)
+	case "rollback":
+		return rollbackCommand(container, args)
+	case "audit":
+		return auditCommand(container, args)
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(os.Stdout, usage)
+
+		return nil
+	default:
+		fmt.Fprint(os.Stderr, usage)
+
+		return xerrors.Errorf("unknown command: %s", name)
+	}
+}
+
+// printJSON prints v as indented JSON
+func printJSON(v any) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(v)
+}
+
+// serveCommand starts the webhook server
+//
+//	mischan-bot serve
+func serveCommand(container *dig.Container, args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	flush, err := start(container)
+
+	if err != nil {
+		return err
+	}
+	defer flush()
+
+	serve(container)
+
+	return nil
+}
+
+// runFlags are flags of run and plan
+type runFlags struct {
+	fs          *flag.FlagSet
+	app         *string
+	sha         *string
+	environment *string
+}
+
+func newRunFlags(name string) *runFlags {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+
+	return &runFlags{
+		fs:          fs,
+		app:         fs.String("app", "", "name of the app repository(e.g. portal or MISW/Portal)"),
+		sha:         fs.String("sha", "", "SHA to deploy instead of the head of the target branch. Checks must have passed on it"),
+		environment: fs.String("environment", "", "environment to promote from instead of the first one"),
+	}
+}
+
+func (rf *runFlags) parse(args []string) (string, repository.RunOptions, error) {
+	if err := rf.fs.Parse(args); err != nil {
+		return "", repository.RunOptions{}, err
+	}
+
+	if *rf.app == "" {
+		rf.fs.Usage()
+
+		return "", repository.RunOptions{}, xerrors.New("-app is required")
+	}
+
+	return *rf.app, repository.RunOptions{
+		SHA:         *rf.sha,
+		Environment: *rf.environment,
+	}, nil
+}
+
+// runAppCommand runs an app once on the running bot as on webhooks and prints the run
+//
+//	mischan-bot run -app portal [-sha SHA] [-environment staging]
+func runAppCommand(container *dig.Container, args []string) error {
+	app, opts, err := newRunFlags("run").parse(args)
+
+	if err != nil {
+		return err
+	}
+
+	return container.Invoke(func(cfg *config.Config) error {
+		client, err := newAPIClient(cfg)
+
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		defer cancel()
+
+		var run json.RawMessage
+		if err := client.postJSON(ctx, appPath(app)+"/deploy", map[string]string{
+			"sha":         opts.SHA,
+			"environment": opts.Environment,
+		}, &run); err != nil {
+			return err
+		}
+
+		return printJSON(run)
+	})
+}
+
+// planCommand prints what run would do on the running bot without changing anything
+//
+//	mischan-bot plan -app portal [-sha SHA] [-environment staging]
+func planCommand(container *dig.Container, args []string) error {
+	app, opts, err := newRunFlags("plan").parse(args)
+
+	if err != nil {
+		return err
+	}
+
+	return container.Invoke(func(cfg *config.Config) error {
+		client, err := newAPIClient(cfg)
+
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+
+		query := url.Values{}
+		if opts.SHA != "" {
+			query.Set("sha", opts.SHA)
+		}
+		if opts.Environment != "" {
+			query.Set("environment", opts.Environment)
+		}
+
+		var plan json.RawMessage
+		if err := client.do(ctx, http.MethodGet, appPath(app)+"/plan?"+query.Encode(), nil, nil, &plan); err != nil {
+			return err
+		}
+
+		return printJSON(plan)
+	})
+}
+
+// appInfo is an app repository listed by apps list
+type appInfo struct {
+	Name         string                     `json:"name"`
+	Registered   bool                       `json:"registered"`
+	TargetBranch string                     `json:"targetBranch,omitempty"`
+	Runnable     bool                       `json:"runnable"`
+	Mode         config.Mode                `json:"mode"`
+	Environments []config.EnvironmentConfig `json:"environments,omitempty"`
+	Preview      bool                       `json:"preview"`
+	Notify       []string                   `json:"notify,omitempty"`
+}
+
+// appsCommand lists registered app repositories and apps in the config
+//
+//	mischan-bot apps list [-json]
+func appsCommand(container *dig.Container, args []string) error {
+	if len(args) == 0 || args[0] != "list" {
+		fmt.Fprint(os.Stderr, usage)
+
+		return xerrors.New("usage: mischan-bot apps list [-json]")
+	}
+
+	fs := flag.NewFlagSet("apps list", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print apps as JSON")
+
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	flush, err := start(container)
+
+	if err != nil {
+		return err
+	}
+	defer flush()
+
+	return container.Invoke(func(cfg *config.Config, repoBundler *repository.RepositoryBundler) error {
+		var apps []*appInfo
+		registered := map[string]bool{}
+
+		for _, repo := range repoBundler.Repositories() {
+			app := cfg.App(repo.FullName())
+			info := &appInfo{
+				Name:         repo.FullName(),
+				Registered:   true,
+				Mode:         app.Mode,
+				Environments: app.Environments,
+				Preview:      app.Preview != nil,
+				Notify:       cfg.NotifyChannels(repo.FullName()),
+			}
+
+			if watcher, ok := repo.(repository.BranchWatcher); ok {
+				info.TargetBranch = watcher.TargetBranch()
+			}
+			_, info.Runnable = repo.(repository.Runner)
+
+			apps = append(apps, info)
+			registered[repo.FullName()] = true
+		}
+
+		// Apps configured but not registered are likely typos
+		names := make([]string, 0, len(cfg.Apps))
+		for name := range cfg.Apps {
+			if !registered[name] {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			app := cfg.Apps[name]
+
+			apps = append(apps, &appInfo{
+				Name:         name,
+				Mode:         app.Mode,
+				Environments: app.Environments,
+				Preview:      app.Preview != nil,
+				Notify:       cfg.NotifyChannels(name),
+			})
+		}
+
+		if *asJSON {
+			return printJSON(apps)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tBRANCH\tMODE\tENVIRONMENTS\tPREVIEW\tNOTIFY")
+
+		for _, app := range apps {
+			branch := app.TargetBranch
+			switch {
+			case !app.Registered:
+				branch = "(not registered)"
+			case branch == "":
+				branch = "-"
+			}
+
+			environments := make([]string, 0, len(app.Environments))
+			for _, env := range app.Environments {
+				environments = append(environments, env.Name)
+			}
+
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%t\t%s\n", app.Name, branch, app.Mode, orDash(strings.Join(environments, ",")), app.Preview, orDash(strings.Join(app.Notify, ",")))
+		}
+
+		return w.Flush()
+	})
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+
+	return s
+}
+
+// configCommand validates the config without connecting to GitHub
+//
+//	mischan-bot config validate
+func configCommand(container *dig.Container, args []string) error {
+	if len(args) == 0 || args[0] != "validate" {
+		fmt.Fprint(os.Stderr, usage)
+
+		return xerrors.New("usage: mischan-bot config validate")
+	}
+
+	fs := flag.NewFlagSet("config validate", flag.ExitOnError)
+
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	return container.Invoke(func(cfg *config.Config) error {
+		problems := cfg.Check()
+
+		// The private key is parsed without requests to GitHub
+		if cfg.PrivateKey.Path != "" || cfg.PrivateKey.Raw != "" {
+			if _, err := ghsink.NewGitHubSink(cfg, nil); err != nil {
+				problems = append(problems, err)
+			}
+		}
+
+		if _, err := auth.NewAuthenticator(cfg); err != nil {
+			problems = append(problems, err)
+		}
+
+		for _, p := range problems {
+			fmt.Fprintln(os.Stdout, "- "+p.Error())
+		}
+
+		if len(problems) != 0 {
+			return xerrors.Errorf("%d problems found", len(problems))
+		}
+
+		fmt.Fprintf(os.Stdout, "config is valid: %d apps, %d freeze windows, %d notification channels, %d webhooks\n", len(cfg.Apps), len(cfg.FreezeWindows), len(cfg.Notifications.Channels), len(cfg.Webhooks))
+
+		return nil
+	})
+}
+
+// replayCommand handles a webhook payload saved from GitHub on the running bot as if it was delivered, and waits until it is handled.
+// The event type is guessed from the payload unless -event is given.
+//
+//	mischan-bot replay [-event check_suite] [-delivery ID] payload.json
+func replayCommand(container *dig.Container, args []string) error {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	eventType := fs.String("event", "", "type of the event(push, check_suite, create or pull_request)")
+	deliveryID := fs.String("delivery", "replay", "delivery ID recorded as the trigger")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+
+		return xerrors.New("a payload file is required")
+	}
+
+	var payload []byte
+	var err error
+	if fs.Arg(0) == "-" {
+		payload, err = io.ReadAll(os.Stdin)
+	} else {
+		payload, err = os.ReadFile(fs.Arg(0))
+	}
+
+	if err != nil {
+		return xerrors.Errorf("failed to read payload: %w", err)
+	}
+
+	if *eventType == "" {
+		if *eventType, err = guessEventType(payload); err != nil {
+			return err
+		}
+	}
+
+	return container.Invoke(func(cfg *config.Config) error {
+		client, err := newAPIClient(cfg)
+
+		if err != nil {
+			return err
+		}
+
+		header := http.Header{
+			"Content-Type":      {"application/json"},
+			"X-GitHub-Event":    {*eventType},
+			"X-GitHub-Delivery": {*deliveryID},
+		}
+
+		// The running bot responds after the event is handled
+		if err := client.do(context.Background(), http.MethodPost, "/api/webhooks/replay", header, payload, nil); err != nil {
+			return err
+		}
+
+		slog.Info("replayed", "event", *eventType, "delivery", *deliveryID)
+
+		return nil
+	})
+}
+
+// guessEventType returns the type of the event handled by the bot from fields of the payload
+func guessEventType(payload []byte) (string, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &fields); err != nil {
+		return "", xerrors.Errorf("payload is invalid json: %w", err)
+	}
+
+	has := func(name string) bool {
+		_, ok := fields[name]
+
+		return ok
+	}
+
+	switch {
+	case has("check_suite"):
+		return "check_suite", nil
+	case has("pull_request") && has("number"):
+		return "pull_request", nil
+	case has("ref_type") && has("master_branch"):
+		return "create", nil
+	case has("pusher"):
+		return "push", nil
+	default:
+		return "", xerrors.New("failed to guess the event type. Specify it with -event")
+	}
+}
+
+// rollbackCommand opens a pull request on the running bot to roll back an app to the previously deployed version
+//
+//	mischan-bot rollback -app MISW/Portal [-environment production]
+func rollbackCommand(container *dig.Container, args []string) error {
+	fs := flag.NewFlagSet("rollback", flag.ExitOnError)
+	app := fs.String("app", "", "name of the app repository(e.g. MISW/Portal)")
+	environment := fs.String("environment", "", "environment to roll back. The bases directory is rolled back if empty")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *app == "" {
+		fs.Usage()
+
+		return xerrors.New("-app is required")
+	}
+
+	return container.Invoke(func(cfg *config.Config) error {
+		client, err := newAPIClient(cfg)
+
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		defer cancel()
+
+		var result json.RawMessage
+		if err := client.postJSON(ctx, "/api/rollback", map[string]string{
+			"app":         *app,
+			"environment": *environment,
+		}, &result); err != nil {
+			return err
+		}
+
+		return printJSON(result)
+	})
+}
+
+// auditCommand searches the audit log and prints matching records as JSON lines, newest first
+//
+//	mischan-bot audit [-action pr.merge] [-repo MISW/k8s] [-ref main] [-actor octocat] [-delivery ID] [-sha SHA] [-since 2006-01-02T15:04:05Z] [-until ...] [-limit 100]
+func auditCommand(container *dig.Container, args []string) error {
+	fs := flag.NewFlagSet("audit", flag.ExitOnError)
+	action := fs.String("action", "", "action or its prefix(e.g. pr or pr.merge)")
+	repo := fs.String("repo", "", "repository acted on(e.g. MISW/k8s)")
+	ref := fs.String("ref", "", "ref or branch name")
+	actor := fs.String("actor", "", "sender of the triggering event")
+	delivery := fs.String("delivery", "", "delivery ID of the triggering webhook")
+	sha := fs.String("sha", "", "SHA or its prefix before or after the action")
+	since := fs.String("since", "", "RFC3339 time from which records are searched")
+	until := fs.String("until", "", "RFC3339 time until which records are searched")
+	limit := fs.Int("limit", 100, "maximum number of records. No limit if 0")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	q := audit.Query{
+		Action:     *action,
+		Repository: *repo,
+		Ref:        *ref,
+		Actor:      *actor,
+		DeliveryID: *delivery,
+		SHA:        *sha,
+		Limit:      *limit,
+	}
+
+	for _, t := range []struct {
+		name  string
+		value string
+		dest  *time.Time
+	}{
+		{"since", *since, &q.Since},
+		{"until", *until, &q.Until},
+	} {
+		if t.value == "" {
+			continue
+		}
+
+		v, err := time.Parse(time.RFC3339, t.value)
+
+		if err != nil {
+			return xerrors.Errorf("-%s must be in RFC3339: %w", t.name, err)
+		}
+
+		*t.dest = v
+	}
+
+	return container.Invoke(func(au usecase.AuditUsecase) error {
+		records, err := au.Search(q)
+
+		if err != nil {
+			return err
+		}
+
+		enc := json.NewEncoder(os.Stdout)
+		for _, r := range records {
+			if err := enc.Encode(r); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
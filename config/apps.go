@@ -0,0 +1,272 @@
+package config
+
+import (
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"golang.org/x/xerrors"
+	"gopkg.in/yaml.v3"
+)
+
+// Mode represents how changes are applied to the manifest repository
+type Mode string
+
+const (
+	// ModePullRequest opens a pull request for each change
+	ModePullRequest Mode = "pull-request"
+
+	// ModeDirect commits changes onto the base branch of the manifest repository
+	ModeDirect Mode = "direct"
+)
+
+// AppConfig represents settings for each app repository
+type AppConfig struct {
+	Mode Mode `yaml:"mode"`
+
+	// AllowedPaths are directories or files in the manifest repository the app may modify.
+	// Paths declared by the app are used if empty.
+	AllowedPaths []string `yaml:"allowedPaths"`
+
+	// AllowNewFiles allows adding files under AllowedPaths
+	AllowNewFiles bool `yaml:"allowNewFiles"`
+
+	// AllowDeletions allows deleting files under AllowedPaths
+	AllowDeletions bool `yaml:"allowDeletions"`
+
+	// Environments are updated in order, each after the previous one is merged and soaked.
+	// The bases directory of the app is updated at once if empty.
+	Environments []EnvironmentConfig `yaml:"environments"`
+
+	// FreezeWindows are applied to the app in addition to the global ones
+	FreezeWindows []FreezeWindow `yaml:"freezeWindows"`
+
+	// Preview enables preview environments for pull requests if set
+	Preview *PreviewConfig `yaml:"preview"`
+
+	// Notify are names of channels notified of deployments of the app. The default channels are used if nil.
+	Notify []string `yaml:"notify"`
+}
+
+// EnvironmentConfig represents an environment an app is promoted through
+type EnvironmentConfig struct {
+	Name string `yaml:"name"`
+
+	// Path is the overlay directory in the manifest repository to update images in
+	Path string `yaml:"path"`
+
+	// SoakTime is how long changes stay in the environment without failures before promoted to the next one
+	SoakTime time.Duration `yaml:"soakTime"`
+
+	// Mode overrides the mode of the app for the environment. The mode of the app is used if empty.
+	Mode Mode `yaml:"mode"`
+}
+
+// Environment returns the environment with the name
+func (ac AppConfig) Environment(name string) (EnvironmentConfig, bool) {
+	for _, env := range ac.Environments {
+		if env.Name == name {
+			return env, true
+		}
+	}
+
+	return EnvironmentConfig{}, false
+}
+
+// appsFile is a format of the file at APPS_CONFIG_PATH
+//
+//	apps:
+//	  MISW/Portal:
+//	    mode: direct
+//	    allowedPaths:
+//	      - bases/portal/kustomization.yaml
+//	    preview:
+//	      template: previews/portal-template
+//	      name: portal
+//	      host: portal-pr-${PR_NUMBER}.preview.misw.jp
+//	      ttl: 168h
+//	    notify: [portal]
+//	  MISW/mischan-bot:
+//	    environments:
+//	      - name: staging
+//	        path: overlays/staging/mischan-bot
+//	        soakTime: 1h
+//	        mode: direct
+//	      - name: production
+//	        path: overlays/production/mischan-bot
+//	    freezeWindows:
+//	      - name: club-event
+//	        start: 2026-11-01
+//	        end: 2026-11-04
+//	        timeZone: Asia/Tokyo
+//	freezeWindows:
+//	  - name: weekend
+//	    cron: "0 18 * * FRI"
+//	    duration: 62h
+//	    timeZone: Asia/Tokyo
+//	    environments: [production]
+//	notifications:
+//	  channels:
+//	    - name: portal
+//	      slack:
+//	        webhookURLEnv: SLACK_PORTAL_WEBHOOK_URL
+//	    - name: infra
+//	      discord:
+//	        webhookURLEnv: DISCORD_INFRA_WEBHOOK_URL
+//	        forum: true
+//	  default: [infra]
+//	webhooks:
+//	  - name: grafana
+//	    url: https://grafana.misw.jp/api/webhooks/deployments
+//	    secretEnv: GRAFANA_WEBHOOK_SECRET
+//	    events: [pr.merged, commit.pushed]
+//	auth:
+//	  org: MISW
+//	  teams:
+//	    infra: admin
+//	    developers: deployer
+//	  tokens:
+//	    - name: ci
+//	      tokenEnv: CI_DEPLOY_TOKEN
+//	      role: deployer
+type appsFile struct {
+	Apps          map[string]AppConfig `yaml:"apps"`
+	FreezeWindows []FreezeWindow       `yaml:"freezeWindows"`
+	Notifications NotificationsConfig  `yaml:"notifications"`
+	Webhooks      []WebhookConfig      `yaml:"webhooks"`
+	Auth          AuthConfig           `yaml:"auth"`
+}
+
+func (ac *AppConfig) validate() error {
+	if ac.Mode == "" {
+		ac.Mode = ModePullRequest
+	}
+
+	if err := ac.Mode.validate(); err != nil {
+		return err
+	}
+
+	for _, p := range ac.AllowedPaths {
+		if !isRelativePath(p) {
+			return xerrors.Errorf("allowed path must be relative to the root of the manifest repository: %s", p)
+		}
+	}
+
+	names := map[string]bool{}
+	for i := range ac.Environments {
+		env := &ac.Environments[i]
+
+		if env.Name == "" || strings.Contains(env.Name, "/") {
+			return xerrors.Errorf("invalid environment name: %q", env.Name)
+		}
+
+		if names[env.Name] {
+			return xerrors.Errorf("duplicated environment: %s", env.Name)
+		}
+		names[env.Name] = true
+
+		if env.Path == "" || !isRelativePath(env.Path) {
+			return xerrors.Errorf("path for environment %s must be relative to the root of the manifest repository: %q", env.Name, env.Path)
+		}
+
+		if env.SoakTime < 0 {
+			return xerrors.Errorf("soak time for environment %s must not be negative", env.Name)
+		}
+
+		if env.Mode == "" {
+			env.Mode = ac.Mode
+		}
+
+		if err := env.Mode.validate(); err != nil {
+			return xerrors.Errorf("invalid mode for environment %s: %w", env.Name, err)
+		}
+	}
+
+	for i := range ac.FreezeWindows {
+		if err := ac.FreezeWindows[i].validate(); err != nil {
+			return err
+		}
+	}
+
+	if ac.Preview != nil {
+		if err := ac.Preview.validate(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m Mode) validate() error {
+	switch m {
+	case ModePullRequest, ModeDirect:
+		return nil
+	default:
+		return xerrors.Errorf("unknown mode: %s", m)
+	}
+}
+
+func isRelativePath(p string) bool {
+	return !path.IsAbs(p) && p != ".." && !strings.HasPrefix(p, "../")
+}
+
+func readAppsConfig(path string) (*appsFile, error) {
+	b, err := os.ReadFile(path)
+
+	if err != nil {
+		return nil, xerrors.Errorf("failed to read %s: %w", path, err)
+	}
+
+	var f appsFile
+	if err := yaml.Unmarshal(b, &f); err != nil {
+		return nil, xerrors.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	for name, app := range f.Apps {
+		if err := app.validate(); err != nil {
+			return nil, xerrors.Errorf("invalid config for %s: %w", name, err)
+		}
+
+		f.Apps[name] = app
+	}
+
+	for i := range f.FreezeWindows {
+		if err := f.FreezeWindows[i].validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := f.Notifications.validate(); err != nil {
+		return nil, err
+	}
+
+	if err := validateWebhooks(f.Webhooks); err != nil {
+		return nil, err
+	}
+
+	if err := f.Auth.validate(); err != nil {
+		return nil, xerrors.Errorf("invalid auth config: %w", err)
+	}
+
+	for name, app := range f.Apps {
+		if err := f.Notifications.validateRoutes(app.Notify); err != nil {
+			return nil, xerrors.Errorf("invalid config for %s: %w", name, err)
+		}
+	}
+
+	return &f, nil
+}
+
+// App returns config for the app repository(e.g. MISW/Portal)
+// Default settings are returned if the repository is not configured.
+func (cfg *Config) App(fullName string) AppConfig {
+	app, ok := cfg.Apps[fullName]
+
+	if !ok {
+		app = AppConfig{}
+		app.validate()
+	}
+
+	return app
+}
@@ -0,0 +1,91 @@
+package config
+
+import (
+	"testing"
+)
+
+func TestAppConfigModes(t *testing.T) {
+	tests := []struct {
+		name  string
+		app   AppConfig
+		valid bool
+		want  map[string]Mode
+	}{
+		{
+			name:  "defaults",
+			app:   AppConfig{Environments: []EnvironmentConfig{{Name: "staging", Path: "overlays/staging"}}},
+			valid: true,
+			want:  map[string]Mode{"": ModePullRequest, "staging": ModePullRequest},
+		},
+		{
+			name: "inherited from the app",
+			app: AppConfig{
+				Mode:         ModeDirect,
+				Environments: []EnvironmentConfig{{Name: "staging", Path: "overlays/staging"}},
+			},
+			valid: true,
+			want:  map[string]Mode{"": ModeDirect, "staging": ModeDirect},
+		},
+		{
+			name: "overridden for environments",
+			app: AppConfig{
+				Environments: []EnvironmentConfig{
+					{Name: "staging", Path: "overlays/staging", Mode: ModeDirect},
+					{Name: "production", Path: "overlays/production"},
+				},
+			},
+			valid: true,
+			want:  map[string]Mode{"": ModePullRequest, "staging": ModeDirect, "production": ModePullRequest},
+		},
+		{
+			name: "pull requests only for production",
+			app: AppConfig{
+				Mode: ModeDirect,
+				Environments: []EnvironmentConfig{
+					{Name: "staging", Path: "overlays/staging"},
+					{Name: "production", Path: "overlays/production", Mode: ModePullRequest},
+				},
+			},
+			valid: true,
+			want:  map[string]Mode{"": ModeDirect, "staging": ModeDirect, "production": ModePullRequest},
+		},
+		{
+			name: "unknown mode of app",
+			app:  AppConfig{Mode: "merge"},
+		},
+		{
+			name: "unknown mode of environment",
+			app:  AppConfig{Environments: []EnvironmentConfig{{Name: "staging", Path: "overlays/staging", Mode: "merge"}}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.app.validate()
+
+			if !tt.valid {
+				if err == nil {
+					t.Error("invalid mode is accepted")
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("valid app is rejected: %v", err)
+			}
+
+			for name, want := range tt.want {
+				got := tt.app.Mode
+				if name != "" {
+					env, _ := tt.app.Environment(name)
+					got = env.Mode
+				}
+
+				if got != want {
+					t.Errorf("mode of %q is %s, want %s", name, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package config
+
+import (
+	"os"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+// Role is a set of permissions on the admin API and the dashboard. Each role includes the previous ones.
+type Role string
+
+const (
+	// RoleViewer reads the dashboard, runs, deployments and deliveries
+	RoleViewer Role = "viewer"
+
+	// RoleDeployer also deploys and rolls back apps and retries runs
+	RoleDeployer Role = "deployer"
+
+	// RoleAdmin also overrides freeze windows and reads the audit log
+	RoleAdmin Role = "admin"
+)
+
+var roleLevels = map[Role]int{
+	RoleViewer:   1,
+	RoleDeployer: 2,
+	RoleAdmin:    3,
+}
+
+// Allows returns true if the role includes required
+func (r Role) Allows(required Role) bool {
+	level, ok := roleLevels[r]
+
+	return ok && level >= roleLevels[required]
+}
+
+// Valid returns true if the role is known
+func (r Role) Valid() bool {
+	_, ok := roleLevels[r]
+
+	return ok
+}
+
+// Higher returns the role with more permissions of r and other
+func (r Role) Higher(other Role) Role {
+	if roleLevels[other] > roleLevels[r] {
+		return other
+	}
+
+	return r
+}
+
+// AuthConfig grants roles on the admin API and the dashboard.
+// Users log in with GitHub through the OAuth credentials of the GitHub App and get roles from memberships of teams in Org.
+// The GitHub App needs the read permission of organization members.
+type AuthConfig struct {
+	Org string `yaml:"org"`
+
+	// Teams are roles by slugs of teams in Org
+	Teams map[string]Role `yaml:"teams"`
+
+	// Users are roles by logins of GitHub users. They are granted in addition to those of teams.
+	Users map[string]Role `yaml:"users"`
+
+	// Tokens are static bearer tokens for automation
+	Tokens []TokenConfig `yaml:"tokens"`
+
+	// Routes override roles required for routes(e.g. "GET /api/audit": viewer)
+	Routes map[string]Role `yaml:"routes"`
+}
+
+// TokenConfig is a static bearer token read from an environment variable not to write secrets in the file
+type TokenConfig struct {
+	Name     string `yaml:"name"`
+	TokenEnv string `yaml:"tokenEnv"`
+	Role     Role   `yaml:"role"`
+}
+
+// Token returns the token
+func (tc *TokenConfig) Token() string {
+	return os.Getenv(tc.TokenEnv)
+}
+
+func (ac *AuthConfig) validate() error {
+	if len(ac.Teams) != 0 && ac.Org == "" {
+		return xerrors.New("org is required for teams")
+	}
+
+	for team, role := range ac.Teams {
+		if !role.Valid() {
+			return xerrors.Errorf("unknown role for team %s: %s", team, role)
+		}
+	}
+
+	for user, role := range ac.Users {
+		if !role.Valid() {
+			return xerrors.Errorf("unknown role for user %s: %s", user, role)
+		}
+	}
+
+	names := map[string]bool{}
+	for _, tc := range ac.Tokens {
+		if tc.Name == "" || tc.TokenEnv == "" {
+			return xerrors.New("name and tokenEnv of token must not be empty")
+		}
+
+		if names[tc.Name] {
+			return xerrors.Errorf("duplicated token: %s", tc.Name)
+		}
+		names[tc.Name] = true
+
+		if !tc.Role.Valid() {
+			return xerrors.Errorf("unknown role for token %s: %s", tc.Name, tc.Role)
+		}
+	}
+
+	for route, role := range ac.Routes {
+		if method, path, ok := strings.Cut(route, " "); !ok || method == "" || !strings.HasPrefix(path, "/") {
+			return xerrors.Errorf("route must be a method and a path: %q", route)
+		}
+
+		if !role.Valid() {
+			return xerrors.Errorf("unknown role for route %s: %s", route, role)
+		}
+	}
+
+	return nil
+}
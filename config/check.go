@@ -0,0 +1,85 @@
+package config
+
+import (
+	"os"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+// Check returns problems which ReadConfig accepts but will fail the bot at runtime,
+// such as missing credentials or environment variables referenced by the apps config
+func (cfg *Config) Check() []error {
+	var problems []error
+
+	if cfg.AppID == 0 {
+		problems = append(problems, xerrors.New("APP_ID is not set"))
+	}
+
+	switch {
+	case cfg.PrivateKey.Path == "" && cfg.PrivateKey.Raw == "":
+		problems = append(problems, xerrors.New("PRIVATE_KEY_PATH or PRIVATE_KEY is required"))
+	case cfg.PrivateKey.Path != "":
+		if _, err := os.Stat(cfg.PrivateKey.Path); err != nil {
+			problems = append(problems, xerrors.Errorf("PRIVATE_KEY_PATH is not readable: %w", err))
+		}
+	}
+
+	if cfg.WebhookSecret == "" {
+		problems = append(problems, xerrors.New("WEBHOOK_SECRET is not set"))
+	}
+
+	if owner, repo, ok := strings.Cut(cfg.ManifestRepo, "/"); !ok || owner == "" || repo == "" || strings.Contains(repo, "/") {
+		problems = append(problems, xerrors.Errorf("MANIFEST_REPO must be owner/repo: %q", cfg.ManifestRepo))
+	}
+
+	for _, dir := range cfg.SchemaDirs {
+		st, err := os.Stat(dir)
+
+		switch {
+		case os.IsNotExist(err) && cfg.SchemaIgnoreMissing:
+			// Directories mounted only when needed, such as schemas of custom resources, are optional
+		case err != nil || !st.IsDir():
+			problems = append(problems, xerrors.Errorf("schema directory %s does not exist", dir))
+		}
+	}
+
+	for _, c := range cfg.Notifications.Channels {
+		switch {
+		case c.Slack != nil && c.Slack.TokenEnv != "":
+			if c.Slack.Token() == "" {
+				problems = append(problems, xerrors.Errorf("%s for notification channel %s is not set", c.Slack.TokenEnv, c.Name))
+			}
+		case c.Slack != nil:
+			if c.Slack.WebhookURL() == "" {
+				problems = append(problems, xerrors.Errorf("%s for notification channel %s is not set", c.Slack.WebhookURLEnv, c.Name))
+			}
+		case c.Discord != nil:
+			if c.Discord.WebhookURL() == "" {
+				problems = append(problems, xerrors.Errorf("%s for notification channel %s is not set", c.Discord.WebhookURLEnv, c.Name))
+			}
+		}
+	}
+
+	for _, wc := range cfg.Webhooks {
+		if wc.SecretEnv != "" && wc.Secret() == "" {
+			problems = append(problems, xerrors.Errorf("%s for webhook %s is not set", wc.SecretEnv, wc.Name))
+		}
+	}
+
+	for _, tc := range cfg.Auth.Tokens {
+		if tc.Token() == "" {
+			problems = append(problems, xerrors.Errorf("%s for token %s is not set", tc.TokenEnv, tc.Name))
+		}
+	}
+
+	if (cfg.OAuthClientID == "") != (cfg.OAuthClientSecret == "") {
+		problems = append(problems, xerrors.New("both GITHUB_CLIENT_ID and GITHUB_CLIENT_SECRET are required to log in with GitHub"))
+	}
+
+	if (len(cfg.Auth.Teams) != 0 || len(cfg.Auth.Users) != 0) && cfg.OAuthClientID == "" {
+		problems = append(problems, xerrors.New("roles of teams and users are not used without GITHUB_CLIENT_ID"))
+	}
+
+	return problems
+}
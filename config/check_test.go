@@ -0,0 +1,47 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckSchemaDirs(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "schema.json")
+	missing := filepath.Join(dir, "crds")
+
+	if err := os.WriteFile(file, []byte("{}"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name          string
+		dirs          []string
+		ignoreMissing bool
+		want          []string
+	}{
+		{"existing", []string{dir}, false, nil},
+		{"missing", []string{dir, missing}, false, []string{missing}},
+		{"missing ignored", []string{dir, missing}, true, nil},
+		{"file", []string{file}, true, []string{file}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{SchemaDirs: tt.dirs, SchemaIgnoreMissing: tt.ignoreMissing}
+
+			var got []string
+			for _, err := range cfg.Check() {
+				if dir, ok := strings.CutPrefix(err.Error(), "schema directory "); ok {
+					got = append(got, strings.TrimSuffix(dir, " does not exist"))
+				}
+			}
+
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("missing schema directories = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,7 +1,8 @@
 package config
 
 import (
-	"fmt"
+	"log/slog"
+	"sort"
 
 	"github.com/caarlos0/env/v9"
 	"golang.org/x/xerrors"
@@ -17,10 +18,82 @@ type PrivateKey struct {
 type Config struct {
 	WebhookSecret string `env:"WEBHOOK_SECRET"`
 	AppID         int64  `env:"APP_ID"`
-	ManifestRepo  string `env:"MANIFEST_REPO"`
+	ManifestRepo  string `env:"MANIFEST_REPO" envDefault:"MISW/k8s"`
 	Port          int    `env:"PORT"`
 
+	// MirrorDir is a directory to keep mirrors of the manifest repository. Fresh clones are used if empty.
+	MirrorDir string `env:"MIRROR_DIR"`
+
+	// SchemaDirs are directories with JSON schemas to validate rendered manifests
+	SchemaDirs []string `env:"SCHEMA_DIRS" envSeparator:":"`
+
+	// SchemaIgnoreMissing accepts resources without schemas in SchemaDirs
+	SchemaIgnoreMissing bool `env:"SCHEMA_IGNORE_MISSING"`
+
+	// StateDir is a directory to persist state such as promotions between environments. State is kept in memory if empty.
+	StateDir string `env:"STATE_DIR"`
+
+	// AuditLogPath is a JSON lines file recording actions on GitHub. STATE_DIR/audit.jsonl is used if empty and STATE_DIR is set.
+	AuditLogPath string `env:"AUDIT_LOG_PATH"`
+
+	// AuditLogMaxSizeMB is the size in megabytes at which the audit log is rotated
+	AuditLogMaxSizeMB int64 `env:"AUDIT_LOG_MAX_SIZE_MB" envDefault:"100"`
+
+	// AuditLogMaxFiles is the number of rotated audit logs kept. All of them are kept if 0.
+	AuditLogMaxFiles int `env:"AUDIT_LOG_MAX_FILES"`
+
+	// AdminToken is a bearer token with the admin role
+	AdminToken string `env:"ADMIN_TOKEN"`
+
+	// ServerURL is the URL of the running bot whose admin API is called by commands such as run and rollback.
+	// http://localhost:PORT is used if empty.
+	ServerURL string `env:"SERVER_URL"`
+
+	// OAuthClientID and OAuthClientSecret are the OAuth credentials of the GitHub App to log in to the admin API and the dashboard.
+	// Login with GitHub is disabled if empty.
+	OAuthClientID     string `env:"GITHUB_CLIENT_ID"`
+	OAuthClientSecret string `env:"GITHUB_CLIENT_SECRET"`
+
+	// OAuthURL and OAuthAPIURL are GitHub and its REST API used for login
+	OAuthURL    string `env:"OAUTH_URL" envDefault:"https://github.com"`
+	OAuthAPIURL string `env:"OAUTH_API_URL" envDefault:"https://api.github.com/"`
+
+	// ExternalURL is the URL of the bot seen from browsers(e.g. https://mischan-bot.misw.jp) to build the callback URL of login.
+	// The host of requests is used if empty.
+	ExternalURL string `env:"EXTERNAL_URL"`
+
+	// SessionSecret signs session cookies. A random key is used if empty, and sessions are lost on restart.
+	SessionSecret string `env:"SESSION_SECRET"`
+
+	// LogLevel is the minimum level of logs(debug, info, warn or error)
+	LogLevel slog.Level `env:"LOG_LEVEL" envDefault:"info"`
+
+	// MaxInFlightEvents is the number of events handled concurrently at which the bot reports not ready. No limit if 0.
+	MaxInFlightEvents int `env:"MAX_IN_FLIGHT_EVENTS" envDefault:"32"`
+
+	// NotifyStandIn posts notifications to a local server which logs them instead of Slack and Discord for testing
+	NotifyStandIn bool `env:"NOTIFY_STAND_IN"`
+
+	// TracesExporter is the exporter of OpenTelemetry spans(otlp, console or none).
+	// The OTLP exporter is configured by the standard OTEL_EXPORTER_OTLP_* variables.
+	TracesExporter string `env:"OTEL_TRACES_EXPORTER" envDefault:"none"`
+
+	// AppsConfigPath is a path to YAML file with settings for each app repository
+	AppsConfigPath string `env:"APPS_CONFIG_PATH"`
+
 	PrivateKey PrivateKey
+
+	Apps map[string]AppConfig
+
+	// FreezeWindows are applied to all apps
+	FreezeWindows []FreezeWindow
+
+	Notifications NotificationsConfig
+
+	// Webhooks receive deployment events
+	Webhooks []WebhookConfig
+
+	Auth AuthConfig
 }
 
 // ReadConfig reads config from env, json and yaml
@@ -37,7 +110,60 @@ func ReadConfig() (*Config, error) {
 		return nil, xerrors.Errorf("failed to perse config: %w", err)
 	}
 
-	fmt.Println(cfg)
+	if cfg.AppsConfigPath != "" {
+		f, err := readAppsConfig(cfg.AppsConfigPath)
+
+		if err != nil {
+			return nil, xerrors.Errorf("failed to read apps config: %w", err)
+		}
+
+		cfg.Apps = f.Apps
+		cfg.FreezeWindows = f.FreezeWindows
+		cfg.Notifications = f.Notifications
+		cfg.Webhooks = f.Webhooks
+		cfg.Auth = f.Auth
+	}
 
 	return &cfg, err
 }
+
+// LogValue implements slog.LogValuer not to print secrets in logs
+func (cfg *Config) LogValue() slog.Value {
+	apps := make([]string, 0, len(cfg.Apps))
+	for name := range cfg.Apps {
+		apps = append(apps, name)
+	}
+	sort.Strings(apps)
+
+	return slog.GroupValue(
+		slog.Int64("appID", cfg.AppID),
+		slog.String("manifestRepo", cfg.ManifestRepo),
+		slog.Int("port", cfg.Port),
+		slog.String("mirrorDir", cfg.MirrorDir),
+		slog.Any("schemaDirs", cfg.SchemaDirs),
+		slog.String("stateDir", cfg.StateDir),
+		slog.String("auditLogPath", cfg.AuditLogPath),
+		slog.String("logLevel", cfg.LogLevel.String()),
+		slog.String("tracesExporter", cfg.TracesExporter),
+		slog.Int("maxInFlightEvents", cfg.MaxInFlightEvents),
+		slog.String("appsConfigPath", cfg.AppsConfigPath),
+		slog.String("privateKeyPath", cfg.PrivateKey.Path),
+		slog.Bool("webhookSecretSet", cfg.WebhookSecret != ""),
+		slog.Bool("adminTokenSet", cfg.AdminToken != ""),
+		slog.Bool("loginEnabled", cfg.OAuthClientID != "" && cfg.OAuthClientSecret != ""),
+		slog.String("authOrg", cfg.Auth.Org),
+		slog.Any("apps", apps),
+		slog.Int("freezeWindows", len(cfg.FreezeWindows)),
+		slog.Int("notificationChannels", len(cfg.Notifications.Channels)),
+		slog.Bool("notifyStandIn", cfg.NotifyStandIn),
+		slog.Int("webhooks", len(cfg.Webhooks)),
+	)
+}
+
+// LogValue implements slog.LogValuer not to print the key in logs
+func (pk PrivateKey) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("path", pk.Path),
+		slog.Bool("raw", pk.Raw != ""),
+	)
+}
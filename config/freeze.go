@@ -0,0 +1,156 @@
+package config
+
+import (
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"golang.org/x/xerrors"
+)
+
+// dateLayouts are accepted for start and end of freeze windows
+var dateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02",
+}
+
+// FreezeWindow is a period during which changes are not deployed.
+// Either Cron and Duration, or Start and End must be set.
+type FreezeWindow struct {
+	Name string `yaml:"name"`
+
+	// Cron starts a window at each time of the schedule(e.g. "0 18 * * FRI"), which lasts for Duration
+	Cron     string        `yaml:"cron"`
+	Duration time.Duration `yaml:"duration"`
+
+	// Start and End specify a single window(e.g. "2026-07-20" or "2026-07-20T09:00")
+	Start string `yaml:"start"`
+	End   string `yaml:"end"`
+
+	// TimeZone is an IANA time zone(e.g. Asia/Tokyo) for Cron, Start and End. UTC is used if empty.
+	TimeZone string `yaml:"timeZone"`
+
+	// Environments limits the window to the environments.
+	// Every environment and apps without environments are frozen if empty.
+	Environments []string `yaml:"environments"`
+
+	location   *time.Location
+	schedule   cron.Schedule
+	start, end time.Time
+}
+
+func (fw *FreezeWindow) validate() error {
+	if fw.Name == "" {
+		return xerrors.New("name of freeze window is required")
+	}
+
+	loc, err := time.LoadLocation(fw.TimeZone)
+
+	if err != nil {
+		return xerrors.Errorf("invalid time zone for freeze window %s: %w", fw.Name, err)
+	}
+	fw.location = loc
+
+	switch {
+	case fw.Cron != "" && fw.Start == "" && fw.End == "":
+		fw.schedule, err = cron.ParseStandard(fw.Cron)
+
+		if err != nil {
+			return xerrors.Errorf("invalid cron for freeze window %s: %w", fw.Name, err)
+		}
+
+		if fw.Duration <= 0 {
+			return xerrors.Errorf("duration for freeze window %s must be positive", fw.Name)
+		}
+	case fw.Cron == "" && fw.Start != "" && fw.End != "":
+		if fw.start, err = parseDate(fw.Start, loc); err != nil {
+			return xerrors.Errorf("invalid start for freeze window %s: %w", fw.Name, err)
+		}
+
+		if fw.end, err = parseDate(fw.End, loc); err != nil {
+			return xerrors.Errorf("invalid end for freeze window %s: %w", fw.Name, err)
+		}
+
+		if !fw.start.Before(fw.end) {
+			return xerrors.Errorf("freeze window %s ends before it starts", fw.Name)
+		}
+	default:
+		return xerrors.Errorf("either cron and duration, or start and end must be set for freeze window %s", fw.Name)
+	}
+
+	return nil
+}
+
+func parseDate(s string, loc *time.Location) (time.Time, error) {
+	for _, layout := range dateLayouts {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, xerrors.Errorf("unknown date format: %s", s)
+}
+
+// Applies returns true if the window freezes the environment
+func (fw *FreezeWindow) Applies(environment string) bool {
+	if len(fw.Environments) == 0 {
+		return true
+	}
+
+	for _, env := range fw.Environments {
+		if env == environment {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Active returns the end of the window if t is in the window
+func (fw *FreezeWindow) Active(t time.Time) (end time.Time, active bool) {
+	if fw.schedule == nil {
+		return fw.end, !t.Before(fw.start) && t.Before(fw.end)
+	}
+
+	// The latest window started before t is the first one started after t-Duration
+	start := fw.schedule.Next(t.In(fw.location).Add(-fw.Duration))
+
+	if start.After(t) {
+		return time.Time{}, false
+	}
+
+	// Overlapping windows are merged
+	end = start.Add(fw.Duration)
+	for i := 0; i < 1000; i++ {
+		next := fw.schedule.Next(start)
+
+		if next.After(end) {
+			break
+		}
+
+		start, end = next, next.Add(fw.Duration)
+	}
+
+	return end, true
+}
+
+// ActiveFreezeWindow returns the window freezing the environment of the app at t, which ends last.
+// environment is empty for apps without environments.
+func (cfg *Config) ActiveFreezeWindow(app, environment string, t time.Time) (window *FreezeWindow, end time.Time, active bool) {
+	windows := append(append([]FreezeWindow(nil), cfg.FreezeWindows...), cfg.App(app).FreezeWindows...)
+
+	for i := range windows {
+		fw := &windows[i]
+
+		if !fw.Applies(environment) {
+			continue
+		}
+
+		if e, ok := fw.Active(t); ok && e.After(end) {
+			window, end, active = fw, e, true
+		}
+	}
+
+	return window, end, active
+}
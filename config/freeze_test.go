@@ -0,0 +1,181 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFreezeWindowValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		window FreezeWindow
+		valid  bool
+	}{
+		{"cron", FreezeWindow{Name: "weekend", Cron: "0 18 * * FRI", Duration: 63 * time.Hour}, true},
+		{"dates", FreezeWindow{Name: "holiday", Start: "2026-12-28", End: "2027-01-04"}, true},
+		{"times in time zone", FreezeWindow{Name: "event", Start: "2026-07-20T09:00", End: "2026-07-20T18:00:00", TimeZone: "Asia/Tokyo"}, true},
+		{"RFC 3339", FreezeWindow{Name: "event", Start: "2026-07-20T09:00:00+09:00", End: "2026-07-20T18:00:00Z"}, true},
+		{"without name", FreezeWindow{Cron: "0 18 * * FRI", Duration: time.Hour}, false},
+		{"invalid cron", FreezeWindow{Name: "weekend", Cron: "every friday", Duration: time.Hour}, false},
+		{"cron without duration", FreezeWindow{Name: "weekend", Cron: "0 18 * * FRI"}, false},
+		{"negative duration", FreezeWindow{Name: "weekend", Cron: "0 18 * * FRI", Duration: -time.Hour}, false},
+		{"without end", FreezeWindow{Name: "holiday", Start: "2026-12-28"}, false},
+		{"ends before start", FreezeWindow{Name: "holiday", Start: "2027-01-04", End: "2026-12-28"}, false},
+		{"empty period", FreezeWindow{Name: "holiday", Start: "2026-12-28", End: "2026-12-28"}, false},
+		{"unknown date format", FreezeWindow{Name: "holiday", Start: "12/28/2026", End: "2027-01-04"}, false},
+		{"both cron and dates", FreezeWindow{Name: "mixed", Cron: "0 18 * * FRI", Duration: time.Hour, Start: "2026-12-28", End: "2027-01-04"}, false},
+		{"neither cron nor dates", FreezeWindow{Name: "empty"}, false},
+		{"unknown time zone", FreezeWindow{Name: "holiday", Start: "2026-12-28", End: "2027-01-04", TimeZone: "Mars/Olympus"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.window.validate()
+
+			if tt.valid && err != nil {
+				t.Errorf("valid window is rejected: %v", err)
+			}
+
+			if !tt.valid && err == nil {
+				t.Error("invalid window is accepted")
+			}
+		})
+	}
+}
+
+func TestFreezeWindowActive(t *testing.T) {
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	at := func(s string) time.Time {
+		t.Helper()
+
+		v, err := time.ParseInLocation("2006-01-02T15:04", s, tokyo)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return v
+	}
+
+	windows := map[string]FreezeWindow{
+		// From Friday 18:00 to Monday 09:00 in Tokyo
+		"weekend": {Name: "weekend", Cron: "0 18 * * FRI", Duration: 63 * time.Hour, TimeZone: "Asia/Tokyo"},
+		// Daily windows of 2 hours every hour overlap each other
+		"overlapping": {Name: "overlapping", Cron: "0 9-11 * * *", Duration: 2 * time.Hour, TimeZone: "Asia/Tokyo"},
+		"holiday":     {Name: "holiday", Start: "2026-12-28", End: "2027-01-04", TimeZone: "Asia/Tokyo"},
+	}
+
+	tests := []struct {
+		window string
+		at     string
+		active bool
+		end    string
+	}{
+		{"weekend", "2026-10-16T17:59", false, ""},
+		{"weekend", "2026-10-16T18:00", true, "2026-10-19T09:00"},
+		{"weekend", "2026-10-18T12:00", true, "2026-10-19T09:00"},
+		{"weekend", "2026-10-19T09:00", false, ""},
+		{"weekend", "2026-10-21T12:00", false, ""},
+		{"overlapping", "2026-10-19T08:59", false, ""},
+		{"overlapping", "2026-10-19T09:30", true, "2026-10-19T13:00"},
+		{"overlapping", "2026-10-19T12:59", true, "2026-10-19T13:00"},
+		{"overlapping", "2026-10-19T13:00", false, ""},
+		{"holiday", "2026-12-27T23:59", false, ""},
+		{"holiday", "2026-12-28T00:00", true, "2027-01-04T00:00"},
+		{"holiday", "2027-01-03T23:59", true, "2027-01-04T00:00"},
+		{"holiday", "2027-01-04T00:00", false, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.window+"/"+tt.at, func(t *testing.T) {
+			fw := windows[tt.window]
+
+			if err := fw.validate(); err != nil {
+				t.Fatal(err)
+			}
+
+			// Time zones of t do not matter
+			end, active := fw.Active(at(tt.at).UTC())
+
+			if active != tt.active {
+				t.Fatalf("Active() = %v, want %v", active, tt.active)
+			}
+
+			if tt.active && !end.Equal(at(tt.end)) {
+				t.Errorf("window ends at %v, want %s", end.In(tokyo), tt.end)
+			}
+		})
+	}
+}
+
+func TestActiveFreezeWindow(t *testing.T) {
+	cfg := &Config{
+		FreezeWindows: []FreezeWindow{
+			{Name: "release", Start: "2026-10-19T00:00", End: "2026-10-20T00:00"},
+			{Name: "maintenance", Start: "2026-10-19T00:00", End: "2026-10-19T12:00", Environments: []string{"production"}},
+		},
+		Apps: map[string]AppConfig{
+			"MISW/Portal": {
+				FreezeWindows: []FreezeWindow{
+					{Name: "portal", Start: "2026-10-19T00:00", End: "2026-10-21T00:00", Environments: []string{"production"}},
+				},
+			},
+		},
+	}
+
+	for i := range cfg.FreezeWindows {
+		if err := cfg.FreezeWindows[i].validate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, app := range cfg.Apps {
+		for i := range app.FreezeWindows {
+			if err := app.FreezeWindows[i].validate(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	tests := []struct {
+		app, environment string
+		at               time.Time
+		window           string
+	}{
+		// The window ending last wins
+		{"MISW/Portal", "production", time.Date(2026, 10, 19, 6, 0, 0, 0, time.UTC), "portal"},
+		{"MISW/Portal", "staging", time.Date(2026, 10, 19, 6, 0, 0, 0, time.UTC), "release"},
+		{"MISW/modoki-k8s", "production", time.Date(2026, 10, 19, 6, 0, 0, 0, time.UTC), "release"},
+		// Apps without environments are frozen by windows without environments only
+		{"MISW/modoki-k8s", "", time.Date(2026, 10, 19, 6, 0, 0, 0, time.UTC), "release"},
+		{"MISW/Portal", "production", time.Date(2026, 10, 20, 6, 0, 0, 0, time.UTC), "portal"},
+		{"MISW/Portal", "staging", time.Date(2026, 10, 20, 6, 0, 0, 0, time.UTC), ""},
+		{"MISW/modoki-k8s", "production", time.Date(2026, 10, 18, 23, 59, 0, 0, time.UTC), ""},
+	}
+
+	for _, tt := range tests {
+		window, end, active := cfg.ActiveFreezeWindow(tt.app, tt.environment, tt.at)
+
+		if tt.window == "" {
+			if active {
+				t.Errorf("%s in %s is frozen by %s at %v", tt.app, tt.environment, window.Name, tt.at)
+			}
+
+			continue
+		}
+
+		if !active || window.Name != tt.window {
+			t.Errorf("%s in %s at %v is frozen by %v, want %s", tt.app, tt.environment, tt.at, window, tt.window)
+			continue
+		}
+
+		if !end.Equal(window.end) {
+			t.Errorf("end of %s is %v, want %v", tt.window, end, window.end)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package config
+
+import (
+	"os"
+
+	"golang.org/x/xerrors"
+)
+
+// NotificationsConfig routes deployment lifecycle events of apps to chat channels
+type NotificationsConfig struct {
+	Channels []ChannelConfig `yaml:"channels"`
+
+	// Default are names of channels for apps without notify
+	Default []string `yaml:"default"`
+}
+
+// ChannelConfig is a chat channel notified through an incoming webhook. Either Slack or Discord must be set.
+// Webhook URLs and tokens are read from environment variables not to write secrets in the file.
+type ChannelConfig struct {
+	Name string `yaml:"name"`
+
+	Slack   *SlackConfig   `yaml:"slack"`
+	Discord *DiscordConfig `yaml:"discord"`
+}
+
+// SlackConfig is a Slack channel
+type SlackConfig struct {
+	// WebhookURLEnv is the environment variable with the URL of the incoming webhook
+	WebhookURLEnv string `yaml:"webhookURLEnv"`
+
+	// TokenEnv is the environment variable with a bot token.
+	// Messages are posted to Channel with chat.postMessage instead of the webhook to be threaded per SHA if set.
+	TokenEnv string `yaml:"tokenEnv"`
+	Channel  string `yaml:"channel"`
+}
+
+// DiscordConfig is a Discord channel
+type DiscordConfig struct {
+	// WebhookURLEnv is the environment variable with the URL of the webhook
+	WebhookURLEnv string `yaml:"webhookURLEnv"`
+
+	// Forum creates a thread for each SHA. The webhook must belong to a forum channel.
+	Forum bool `yaml:"forum"`
+}
+
+// WebhookURL returns the URL of the incoming webhook
+func (sc *SlackConfig) WebhookURL() string {
+	return os.Getenv(sc.WebhookURLEnv)
+}
+
+// Token returns the bot token. Empty if TokenEnv is not set.
+func (sc *SlackConfig) Token() string {
+	if sc.TokenEnv == "" {
+		return ""
+	}
+
+	return os.Getenv(sc.TokenEnv)
+}
+
+// WebhookURL returns the URL of the webhook
+func (dc *DiscordConfig) WebhookURL() string {
+	return os.Getenv(dc.WebhookURLEnv)
+}
+
+// Channel returns the channel with the name
+func (nc *NotificationsConfig) Channel(name string) (ChannelConfig, bool) {
+	for _, c := range nc.Channels {
+		if c.Name == name {
+			return c, true
+		}
+	}
+
+	return ChannelConfig{}, false
+}
+
+func (nc *NotificationsConfig) validate() error {
+	names := map[string]bool{}
+
+	for _, c := range nc.Channels {
+		if c.Name == "" {
+			return xerrors.New("name of notification channel must not be empty")
+		}
+
+		if names[c.Name] {
+			return xerrors.Errorf("duplicated notification channel: %s", c.Name)
+		}
+		names[c.Name] = true
+
+		switch {
+		case (c.Slack == nil) == (c.Discord == nil):
+			return xerrors.Errorf("either slack or discord must be set for notification channel %s", c.Name)
+		case c.Slack != nil && c.Slack.TokenEnv != "":
+			if c.Slack.Channel == "" {
+				return xerrors.Errorf("slack channel is required with a token for notification channel %s", c.Name)
+			}
+		case c.Slack != nil:
+			if c.Slack.WebhookURLEnv == "" {
+				return xerrors.Errorf("webhookURLEnv or tokenEnv is required for notification channel %s", c.Name)
+			}
+		case c.Discord != nil:
+			if c.Discord.WebhookURLEnv == "" {
+				return xerrors.Errorf("webhookURLEnv is required for notification channel %s", c.Name)
+			}
+		}
+	}
+
+	return nc.validateRoutes(nc.Default)
+}
+
+// validateRoutes returns an error if any of channels is not configured
+func (nc *NotificationsConfig) validateRoutes(channels []string) error {
+	for _, name := range channels {
+		if _, ok := nc.Channel(name); !ok {
+			return xerrors.Errorf("unknown notification channel: %s", name)
+		}
+	}
+
+	return nil
+}
+
+// NotifyChannels returns names of channels notified of events of the app
+func (cfg *Config) NotifyChannels(app string) []string {
+	if a, ok := cfg.Apps[app]; ok && a.Notify != nil {
+		return a.Notify
+	}
+
+	return cfg.Notifications.Default
+}
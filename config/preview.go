@@ -0,0 +1,96 @@
+package config
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+const (
+	// defaultPreviewDir is the directory previews are generated in by default
+	defaultPreviewDir = "previews"
+)
+
+// PreviewConfig enables preview environments for pull requests of an app.
+// Images of the app must be built for every commit of pull requests with the same tags as the target branch.
+//
+// Placeholders below are replaced in files copied from Template and in Host:
+//   - ${PREVIEW_NAME}: the name of the preview(e.g. portal-pr-123)
+//   - ${PREVIEW_HOST}: the hostname of the preview
+//   - ${PR_NUMBER}: the number of the pull request
+type PreviewConfig struct {
+	// Template is the directory in the manifest repository copied for each pull request.
+	// It should be as deep as Dir/<preview> so that relative paths in it point to the same directories after copied.
+	Template string `yaml:"template"`
+
+	// Dir is the directory in the manifest repository previews are generated in. "previews" is used if empty.
+	Dir string `yaml:"dir"`
+
+	// Name is the prefix of names of previews(e.g. portal for previews/portal-pr-123)
+	Name string `yaml:"name"`
+
+	// Host is the hostname of previews(e.g. portal-pr-${PR_NUMBER}.preview.misw.jp)
+	Host string `yaml:"host"`
+
+	// TTL is how long previews are kept after the last update. They are kept until pull requests are closed if 0.
+	TTL time.Duration `yaml:"ttl"`
+}
+
+func (pc *PreviewConfig) validate() error {
+	if pc.Dir == "" {
+		pc.Dir = defaultPreviewDir
+	}
+
+	if pc.Template == "" || !isRelativePath(pc.Template) {
+		return xerrors.Errorf("preview template must be relative to the root of the manifest repository: %q", pc.Template)
+	}
+
+	if !isRelativePath(pc.Dir) {
+		return xerrors.Errorf("preview directory must be relative to the root of the manifest repository: %q", pc.Dir)
+	}
+
+	if pc.Name == "" || strings.Contains(pc.Name, "/") {
+		return xerrors.Errorf("invalid preview name: %q", pc.Name)
+	}
+
+	if pc.Host == "" {
+		return xerrors.New("preview host is required")
+	}
+
+	if pc.TTL < 0 {
+		return xerrors.New("preview TTL must not be negative")
+	}
+
+	return nil
+}
+
+// PreviewName returns the name of the preview for the pull request
+func (pc *PreviewConfig) PreviewName(number int) string {
+	return fmt.Sprintf("%s-pr-%d", pc.Name, number)
+}
+
+// Overlay returns the directory of the preview for the pull request in the manifest repository
+func (pc *PreviewConfig) Overlay(number int) string {
+	return path.Join(pc.Dir, pc.PreviewName(number))
+}
+
+// Replacer returns a replacer of placeholders for the pull request
+func (pc *PreviewConfig) Replacer(number int) *strings.Replacer {
+	vars := []string{
+		"${PREVIEW_NAME}", pc.PreviewName(number),
+		"${PR_NUMBER}", strconv.Itoa(number),
+	}
+
+	host := strings.NewReplacer(vars...).Replace(pc.Host)
+
+	return strings.NewReplacer(append(vars, "${PREVIEW_HOST}", host)...)
+}
+
+// PreviewHost returns the hostname of the preview for the pull request
+func (pc *PreviewConfig) PreviewHost(number int) string {
+	return pc.Replacer(number).Replace(pc.Host)
+}
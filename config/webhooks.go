@@ -0,0 +1,69 @@
+package config
+
+import (
+	"net/url"
+	"os"
+	"slices"
+
+	"golang.org/x/xerrors"
+)
+
+// WebhookConfig is an endpoint receiving deployment events as CloudEvents
+type WebhookConfig struct {
+	Name string `yaml:"name"`
+	URL  string `yaml:"url"`
+
+	// SecretEnv is the environment variable with the secret to sign payloads with HMAC-SHA256. Payloads are not signed if empty.
+	SecretEnv string `yaml:"secretEnv"`
+
+	// Events are types of events sent to the endpoint(e.g. pr.merged). All events are sent if empty.
+	Events []string `yaml:"events"`
+
+	// Apps are app repositories whose events are sent to the endpoint. Events of all apps are sent if empty.
+	Apps []string `yaml:"apps"`
+}
+
+// Secret returns the secret to sign payloads with
+func (wc *WebhookConfig) Secret() string {
+	if wc.SecretEnv == "" {
+		return ""
+	}
+
+	return os.Getenv(wc.SecretEnv)
+}
+
+// Matches returns true if events of the type for the app are sent to the endpoint
+func (wc *WebhookConfig) Matches(eventType, app string) bool {
+	if len(wc.Events) != 0 && !slices.Contains(wc.Events, eventType) {
+		return false
+	}
+
+	if len(wc.Apps) != 0 && !slices.Contains(wc.Apps, app) {
+		return false
+	}
+
+	return true
+}
+
+func validateWebhooks(webhooks []WebhookConfig) error {
+	names := map[string]bool{}
+
+	for _, wc := range webhooks {
+		if wc.Name == "" {
+			return xerrors.New("name of webhook must not be empty")
+		}
+
+		if names[wc.Name] {
+			return xerrors.Errorf("duplicated webhook: %s", wc.Name)
+		}
+		names[wc.Name] = true
+
+		u, err := url.Parse(wc.URL)
+
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return xerrors.Errorf("url of webhook %s must be an absolute HTTP(S) URL", wc.Name)
+		}
+	}
+
+	return nil
+}
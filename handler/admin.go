@@ -0,0 +1,519 @@
+package handler
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/MISW/mischan-bot/config"
+	"github.com/MISW/mischan-bot/intenral/audit"
+	"github.com/MISW/mischan-bot/intenral/auth"
+	"github.com/MISW/mischan-bot/intenral/history"
+	"github.com/MISW/mischan-bot/intenral/trigger"
+	"github.com/MISW/mischan-bot/repository"
+	"github.com/MISW/mischan-bot/usecase"
+	"github.com/google/go-github/v55/github"
+	"github.com/labstack/echo/v4"
+	"golang.org/x/xerrors"
+)
+
+// AdminHandler is a echo handler for administrative operations
+type AdminHandler interface {
+	Rollback(c echo.Context) error
+	OverrideFreeze(c echo.Context) error
+	ListDeployments(c echo.Context) error
+	CurrentDeployment(c echo.Context) error
+	GetDeployment(c echo.Context) error
+	ListDeliveries(c echo.Context) error
+	SearchAudit(c echo.Context) error
+	Deploy(c echo.Context) error
+	Plan(c echo.Context) error
+	ListRuns(c echo.Context) error
+	GetRun(c echo.Context) error
+	RetryRun(c echo.Context) error
+	ReplayWebhook(c echo.Context) error
+}
+
+type adminHandler struct {
+	rollbackUsecase usecase.RollbackUsecase
+	freezeUsecase   usecase.FreezeUsecase
+	historyUsecase  usecase.HistoryUsecase
+	auditUsecase    usecase.AuditUsecase
+	runUsecase      usecase.RunUsecase
+	eventUsecase    usecase.GitHubEventUsecase
+}
+
+// BindAdminHandler binds admin handlers under /api for Echo
+// They require roles of bearer tokens or users logged in with GitHub and are not bound if neither is configured.
+func BindAdminHandler(e *echo.Echo, cfg *config.Config, authn *auth.Authenticator, ru usecase.RollbackUsecase, fu usecase.FreezeUsecase, hu usecase.HistoryUsecase, au usecase.AuditUsecase, rnu usecase.RunUsecase, geu usecase.GitHubEventUsecase) {
+	if !authn.Enabled() {
+		return
+	}
+
+	ah := &adminHandler{
+		rollbackUsecase: ru,
+		freezeUsecase:   fu,
+		historyUsecase:  hu,
+		auditUsecase:    au,
+		runUsecase:      rnu,
+		eventUsecase:    geu,
+	}
+
+	api := e.Group("/api", authorize(cfg, authn, false))
+
+	api.POST("/rollback", ah.Rollback)
+	api.POST("/freeze/override", ah.OverrideFreeze)
+	api.GET("/deployments", ah.ListDeployments)
+	api.GET("/deployments/current", ah.CurrentDeployment)
+	api.GET("/deployments/:id", ah.GetDeployment)
+	api.GET("/webhooks/deliveries", ah.ListDeliveries)
+	api.GET("/audit", ah.SearchAudit)
+	api.POST("/apps/:app/deploy", ah.Deploy)
+	api.POST("/apps/:owner/:repo/deploy", ah.Deploy)
+	api.GET("/apps/:app/plan", ah.Plan)
+	api.GET("/apps/:owner/:repo/plan", ah.Plan)
+	api.GET("/runs", ah.ListRuns)
+	api.GET("/runs/:id", ah.GetRun)
+	api.POST("/runs/:id/retry", ah.RetryRun)
+	api.POST("/webhooks/replay", ah.ReplayWebhook)
+}
+
+var _ AdminHandler = &adminHandler{}
+
+type rollbackRequest struct {
+	App         string `json:"app"`
+	Environment string `json:"environment"`
+}
+
+func (ah *adminHandler) Rollback(c echo.Context) error {
+	var req rollbackRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "request is invalid", "error": err.Error()})
+	}
+
+	if req.App == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "app is required"})
+	}
+
+	ctx, cancel := runContext(c, "admin-rollback")
+	defer cancel()
+
+	result, err := ah.rollbackUsecase.Rollback(ctx, req.App, req.Environment)
+
+	switch {
+	case err == nil:
+		return c.JSON(http.StatusCreated, result)
+	case xerrors.Is(err, repository.ErrUnknownRepository):
+		return c.JSON(http.StatusNotFound, map[string]string{"message": "unknown app", "error": err.Error()})
+	case xerrors.Is(err, repository.ErrUnknownEnvironment), xerrors.Is(err, usecase.ErrRollbackUnsupported):
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "rollback is not available", "error": err.Error()})
+	case xerrors.Is(err, usecase.ErrNoPreviousVersion):
+		return c.JSON(http.StatusConflict, map[string]string{"message": "no previous version to roll back to", "error": err.Error()})
+	default:
+		slog.ErrorContext(ctx, "rollback failed", "app", req.App, "environment", req.Environment, "error", err)
+
+		return c.JSON(http.StatusInternalServerError, map[string]string{"message": "rollback failed", "error": err.Error()})
+	}
+}
+
+type overrideFreezeRequest struct {
+	App         string `json:"app"`
+	Environment string `json:"environment"`
+}
+
+func (ah *adminHandler) OverrideFreeze(c echo.Context) error {
+	var req overrideFreezeRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "request is invalid", "error": err.Error()})
+	}
+
+	if req.App == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "app is required"})
+	}
+
+	// The authenticated user or token is always recorded so that the audit trail cannot be spoofed
+	override, err := ah.freezeUsecase.Override(c.Request().Context(), req.App, req.Environment, actor(c))
+
+	switch {
+	case err == nil:
+		return c.JSON(http.StatusCreated, override)
+	case xerrors.Is(err, repository.ErrUnknownRepository):
+		return c.JSON(http.StatusNotFound, map[string]string{"message": "unknown app", "error": err.Error()})
+	case xerrors.Is(err, repository.ErrNotFrozen):
+		return c.JSON(http.StatusConflict, map[string]string{"message": "no freeze window is active", "error": err.Error()})
+	default:
+		slog.ErrorContext(c.Request().Context(), "override of freeze window failed", "app", req.App, "environment", req.Environment, "error", err)
+
+		return c.JSON(http.StatusInternalServerError, map[string]string{"message": "override failed", "error": err.Error()})
+	}
+}
+
+// parseTime parses an optional RFC3339 query parameter
+func parseTime(c echo.Context, name string) (time.Time, error) {
+	v := c.QueryParam(name)
+
+	if v == "" {
+		return time.Time{}, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, v)
+
+	if err != nil {
+		return time.Time{}, xerrors.Errorf("%s must be in RFC3339: %w", name, err)
+	}
+
+	return t, nil
+}
+
+func (ah *adminHandler) ListDeployments(c echo.Context) error {
+	q := history.Query{
+		App:         c.QueryParam("app"),
+		Environment: c.QueryParam("environment"),
+		SHA:         c.QueryParam("sha"),
+		Status:      history.Status(c.QueryParam("status")),
+		Limit:       100,
+	}
+
+	var err error
+	if q.Since, err = parseTime(c, "since"); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "since is invalid", "error": err.Error()})
+	}
+
+	if q.Until, err = parseTime(c, "until"); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "until is invalid", "error": err.Error()})
+	}
+
+	if v := c.QueryParam("limit"); v != "" {
+		if q.Limit, err = strconv.Atoi(v); err != nil || q.Limit < 0 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": "limit must be a non-negative integer"})
+		}
+	}
+
+	deployments, err := ah.historyUsecase.Find(q)
+
+	if err != nil {
+		slog.ErrorContext(c.Request().Context(), "failed to query deployment history", "error", err)
+
+		return c.JSON(http.StatusInternalServerError, map[string]string{"message": "failed to query deployments", "error": err.Error()})
+	}
+
+	if deployments == nil {
+		deployments = []*history.Deployment{}
+	}
+
+	return c.JSON(http.StatusOK, deployments)
+}
+
+func (ah *adminHandler) CurrentDeployment(c echo.Context) error {
+	app := c.QueryParam("app")
+
+	if app == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "app is required"})
+	}
+
+	at, err := parseTime(c, "at")
+
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "at is invalid", "error": err.Error()})
+	}
+
+	if at.IsZero() {
+		at = time.Now()
+	}
+
+	deployment, err := ah.historyUsecase.Current(app, c.QueryParam("environment"), at)
+
+	switch {
+	case err == nil:
+		return c.JSON(http.StatusOK, deployment)
+	case xerrors.Is(err, history.ErrNotFound):
+		return c.JSON(http.StatusNotFound, map[string]string{"message": "no deployment found", "error": err.Error()})
+	default:
+		slog.ErrorContext(c.Request().Context(), "failed to query deployment history", "error", err)
+
+		return c.JSON(http.StatusInternalServerError, map[string]string{"message": "failed to query deployments", "error": err.Error()})
+	}
+}
+
+func (ah *adminHandler) GetDeployment(c echo.Context) error {
+	deployment, err := ah.historyUsecase.Get(c.Param("id"))
+
+	switch {
+	case err == nil:
+		return c.JSON(http.StatusOK, deployment)
+	case xerrors.Is(err, history.ErrNotFound):
+		return c.JSON(http.StatusNotFound, map[string]string{"message": "no deployment found", "error": err.Error()})
+	default:
+		slog.ErrorContext(c.Request().Context(), "failed to get deployment", "id", c.Param("id"), "error", err)
+
+		return c.JSON(http.StatusInternalServerError, map[string]string{"message": "failed to get deployment", "error": err.Error()})
+	}
+}
+
+func (ah *adminHandler) ListDeliveries(c echo.Context) error {
+	limit := 100
+
+	if v := c.QueryParam("limit"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil || limit < 0 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": "limit must be a non-negative integer"})
+		}
+	}
+
+	deliveries, err := ah.historyUsecase.Deliveries(c.QueryParam("endpoint"), limit)
+
+	if err != nil {
+		slog.ErrorContext(c.Request().Context(), "failed to query webhook deliveries", "error", err)
+
+		return c.JSON(http.StatusInternalServerError, map[string]string{"message": "failed to query deliveries", "error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, deliveries)
+}
+
+func (ah *adminHandler) SearchAudit(c echo.Context) error {
+	q := audit.Query{
+		Action:     c.QueryParam("action"),
+		Repository: c.QueryParam("repository"),
+		Ref:        c.QueryParam("ref"),
+		Actor:      c.QueryParam("actor"),
+		DeliveryID: c.QueryParam("delivery"),
+		SHA:        c.QueryParam("sha"),
+		Limit:      100,
+	}
+
+	var err error
+	if q.Since, err = parseTime(c, "since"); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "since is invalid", "error": err.Error()})
+	}
+
+	if q.Until, err = parseTime(c, "until"); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "until is invalid", "error": err.Error()})
+	}
+
+	if v := c.QueryParam("limit"); v != "" {
+		if q.Limit, err = strconv.Atoi(v); err != nil || q.Limit < 0 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": "limit must be a non-negative integer"})
+		}
+	}
+
+	records, err := ah.auditUsecase.Search(q)
+
+	switch {
+	case err == nil:
+		return c.JSON(http.StatusOK, records)
+	case xerrors.Is(err, audit.ErrNotPersisted):
+		return c.JSON(http.StatusNotFound, map[string]string{"message": "audit log is not persisted", "error": err.Error()})
+	default:
+		slog.ErrorContext(c.Request().Context(), "failed to search audit log", "error", err)
+
+		return c.JSON(http.StatusInternalServerError, map[string]string{"message": "failed to search audit log", "error": err.Error()})
+	}
+}
+
+// runContext returns a context for a run or a rollback triggered by the request with the authenticated actor.
+// It is not canceled when the client disconnects not to abort it halfway.
+func runContext(c echo.Context, event string) (context.Context, context.CancelFunc) {
+	ctx := trigger.With(context.WithoutCancel(c.Request().Context()), trigger.Trigger{
+		Event: event,
+		Actor: actor(c),
+	})
+
+	return context.WithTimeout(ctx, 5*time.Minute)
+}
+
+// runResponse responds the run or the error of Deploy or Retry
+func runResponse(c echo.Context, run *history.Run, err error) error {
+	switch {
+	case err == nil:
+		return c.JSON(http.StatusOK, run)
+	case run != nil:
+		// The failure is recorded in the run
+		return c.JSON(http.StatusInternalServerError, map[string]string{"message": "run failed", "id": run.ID, "error": err.Error()})
+	case xerrors.Is(err, repository.ErrUnknownRepository):
+		return c.JSON(http.StatusNotFound, map[string]string{"message": "unknown app", "error": err.Error()})
+	case xerrors.Is(err, history.ErrRunNotFound):
+		return c.JSON(http.StatusNotFound, map[string]string{"message": "no run found", "error": err.Error()})
+	case xerrors.Is(err, usecase.ErrUnknownCommit):
+		return c.JSON(http.StatusUnprocessableEntity, map[string]string{"message": "unknown sha", "error": err.Error()})
+	case xerrors.Is(err, repository.ErrUnknownEnvironment), xerrors.Is(err, usecase.ErrRunUnsupported):
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "request is invalid", "error": err.Error()})
+	default:
+		slog.ErrorContext(c.Request().Context(), "failed to start run", "error", err)
+
+		return c.JSON(http.StatusInternalServerError, map[string]string{"message": "failed to start run", "error": err.Error()})
+	}
+}
+
+type deployRequest struct {
+	SHA         string `json:"sha"`
+	Environment string `json:"environment"`
+}
+
+func (ah *adminHandler) Deploy(c echo.Context) error {
+	var req deployRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "request is invalid", "error": err.Error()})
+	}
+
+	app, err := appParam(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "app is invalid", "error": err.Error()})
+	}
+
+	ctx, cancel := runContext(c, "admin-deploy")
+	defer cancel()
+
+	run, err := ah.runUsecase.Deploy(ctx, app, repository.RunOptions{
+		SHA:         req.SHA,
+		Environment: req.Environment,
+	})
+
+	return runResponse(c, run, err)
+}
+
+// appParam returns the app in the path.
+// The app is either a name(e.g. portal), an escaped full name or the owner and the name in separate segments.
+func appParam(c echo.Context) (string, error) {
+	app, err := url.PathUnescape(c.Param("app"))
+	if err != nil {
+		return "", err
+	}
+
+	if app == "" {
+		app = c.Param("owner") + "/" + c.Param("repo")
+	}
+
+	return app, nil
+}
+
+func (ah *adminHandler) Plan(c echo.Context) error {
+	app, err := appParam(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "app is invalid", "error": err.Error()})
+	}
+
+	plan, err := ah.runUsecase.Plan(c.Request().Context(), app, repository.RunOptions{
+		SHA:         c.QueryParam("sha"),
+		Environment: c.QueryParam("environment"),
+	})
+
+	switch {
+	case err == nil:
+		return c.JSON(http.StatusOK, plan)
+	case xerrors.Is(err, repository.ErrUnknownRepository):
+		return c.JSON(http.StatusNotFound, map[string]string{"message": "unknown app", "error": err.Error()})
+	case xerrors.Is(err, usecase.ErrUnknownCommit):
+		return c.JSON(http.StatusUnprocessableEntity, map[string]string{"message": "unknown sha", "error": err.Error()})
+	case xerrors.Is(err, repository.ErrUnknownEnvironment), xerrors.Is(err, usecase.ErrRunUnsupported):
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "request is invalid", "error": err.Error()})
+	default:
+		slog.ErrorContext(c.Request().Context(), "failed to plan run", "app", app, "error", err)
+
+		return c.JSON(http.StatusInternalServerError, map[string]string{"message": "failed to plan run", "error": err.Error()})
+	}
+}
+
+func (ah *adminHandler) ListRuns(c echo.Context) error {
+	limit := 100
+
+	if v := c.QueryParam("limit"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil || limit < 0 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": "limit must be a non-negative integer"})
+		}
+	}
+
+	// status is the result of runs(running, skipped, frozen, deployed or failed)
+	runs, err := ah.runUsecase.Runs(c.QueryParam("app"), c.QueryParam("status"), limit)
+
+	switch {
+	case err == nil:
+		return c.JSON(http.StatusOK, runs)
+	case xerrors.Is(err, repository.ErrUnknownRepository):
+		return c.JSON(http.StatusNotFound, map[string]string{"message": "unknown app", "error": err.Error()})
+	default:
+		slog.ErrorContext(c.Request().Context(), "failed to query runs", "error", err)
+
+		return c.JSON(http.StatusInternalServerError, map[string]string{"message": "failed to query runs", "error": err.Error()})
+	}
+}
+
+func (ah *adminHandler) GetRun(c echo.Context) error {
+	run, err := ah.runUsecase.Get(c.Param("id"))
+
+	switch {
+	case err == nil:
+		return c.JSON(http.StatusOK, run)
+	case xerrors.Is(err, history.ErrRunNotFound):
+		return c.JSON(http.StatusNotFound, map[string]string{"message": "no run found", "error": err.Error()})
+	default:
+		slog.ErrorContext(c.Request().Context(), "failed to get run", "id", c.Param("id"), "error", err)
+
+		return c.JSON(http.StatusInternalServerError, map[string]string{"message": "failed to get run", "error": err.Error()})
+	}
+}
+
+func (ah *adminHandler) RetryRun(c echo.Context) error {
+	ctx, cancel := runContext(c, "admin-retry")
+	defer cancel()
+
+	run, err := ah.runUsecase.Retry(ctx, c.Param("id"))
+
+	return runResponse(c, run, err)
+}
+
+// ReplayWebhook handles a webhook payload saved from GitHub as if it was delivered, and responds after all events being handled are done.
+// The type of the event is given by the X-GitHub-Event header, and the delivery ID by X-GitHub-Delivery.
+func (ah *adminHandler) ReplayWebhook(c echo.Context) error {
+	eventType := c.Request().Header.Get("X-GitHub-Event")
+	deliveryID := c.Request().Header.Get("X-GitHub-Delivery")
+
+	if deliveryID == "" {
+		deliveryID = "replay"
+	}
+
+	payload, err := io.ReadAll(c.Request().Body)
+
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "failed to read payload", "error": err.Error()})
+	}
+
+	event, err := github.ParseWebHook(eventType, payload)
+
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "payload is invalid", "error": err.Error()})
+	}
+
+	ctx, cancel := runContext(c, "admin-replay")
+	defer cancel()
+
+	switch e := event.(type) {
+	case *github.PushEvent:
+		err = ah.eventUsecase.Push(ctx, deliveryID, e)
+	case *github.CheckSuiteEvent:
+		err = ah.eventUsecase.CheckSuite(ctx, deliveryID, e)
+	case *github.CreateEvent:
+		err = ah.eventUsecase.Create(ctx, deliveryID, e)
+	case *github.PullRequestEvent:
+		err = ah.eventUsecase.PullRequest(ctx, deliveryID, e)
+	default:
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "unsupported event", "error": eventType})
+	}
+
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to replay webhook", "event", eventType, "delivery", deliveryID, "error", err)
+
+		return c.JSON(http.StatusInternalServerError, map[string]string{"message": "failed to replay webhook", "error": err.Error()})
+	}
+
+	// Failures are logged by the handlers as for webhooks
+	ah.eventUsecase.Wait()
+	slog.InfoContext(ctx, "replayed webhook", "event", eventType, "delivery", deliveryID, "actor", actor(c))
+
+	return c.JSON(http.StatusOK, map[string]string{"event": eventType, "delivery": deliveryID})
+}
@@ -0,0 +1,275 @@
+package handler
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/MISW/mischan-bot/config"
+	"github.com/MISW/mischan-bot/intenral/auth"
+	"github.com/labstack/echo/v4"
+	"golang.org/x/xerrors"
+)
+
+const (
+	identityKey = "identity"
+
+	// stateCookie keeps the state and the page to return to during login
+	stateCookie = "mischan_bot_oauth_state"
+)
+
+// defaultRouteRoles are roles required for routes. Routes not listed require the admin role.
+// They can be overridden by routes in the auth config.
+var defaultRouteRoles = map[string]config.Role{
+	"GET /dashboard":                     config.RoleViewer,
+	"GET /auth/whoami":                   config.RoleViewer,
+	"GET /api/deployments":               config.RoleViewer,
+	"GET /api/deployments/current":       config.RoleViewer,
+	"GET /api/deployments/:id":           config.RoleViewer,
+	"GET /api/webhooks/deliveries":       config.RoleViewer,
+	"GET /api/runs":                      config.RoleViewer,
+	"GET /api/runs/:id":                  config.RoleViewer,
+	"POST /api/rollback":                 config.RoleDeployer,
+	"POST /api/apps/:app/deploy":         config.RoleDeployer,
+	"POST /api/apps/:owner/:repo/deploy": config.RoleDeployer,
+	"GET /api/apps/:app/plan":            config.RoleViewer,
+	"GET /api/apps/:owner/:repo/plan":    config.RoleViewer,
+	"POST /api/runs/:id/retry":           config.RoleDeployer,
+	"POST /api/freeze/override":          config.RoleAdmin,
+	"GET /api/audit":                     config.RoleAdmin,
+	"POST /api/webhooks/replay":          config.RoleAdmin,
+}
+
+// routeRole returns the role required for the route(e.g. POST /api/rollback)
+func routeRole(cfg *config.Config, route string) config.Role {
+	if role, ok := cfg.Auth.Routes[route]; ok {
+		return role
+	}
+
+	if role, ok := defaultRouteRoles[route]; ok {
+		return role
+	}
+
+	return config.RoleAdmin
+}
+
+// authorize returns a middleware which requires the role for the route.
+// Unauthenticated browsers are redirected to login with GitHub, or asked for a token with basic authentication if it is disabled.
+func authorize(cfg *config.Config, authn *auth.Authenticator, browser bool) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			id, err := authn.Authenticate(c.Request())
+
+			if err != nil {
+				slog.DebugContext(c.Request().Context(), "authentication failed", "path", c.Path(), "error", err)
+
+				switch {
+				case browser && authn.LoginEnabled():
+					return c.Redirect(http.StatusFound, "/auth/login?next="+url.QueryEscape(c.Request().URL.RequestURI()))
+				case browser:
+					c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Basic realm="mischan-bot"`)
+
+					return c.String(http.StatusUnauthorized, "unauthorized")
+				default:
+					c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Bearer realm="mischan-bot"`)
+
+					return c.JSON(http.StatusUnauthorized, map[string]string{"message": "unauthorized"})
+				}
+			}
+
+			route := c.Request().Method + " " + c.Path()
+			required := routeRole(cfg, route)
+
+			if !id.Role.Allows(required) {
+				slog.InfoContext(c.Request().Context(), "access denied", "route", route, "actor", id.Actor(), "role", id.Role, "required", required)
+
+				return c.JSON(http.StatusForbidden, map[string]string{"message": "forbidden", "error": string(required) + " role is required"})
+			}
+
+			c.Set(identityKey, id)
+
+			return next(c)
+		}
+	}
+}
+
+// identity returns the identity authorized by authorize
+func identity(c echo.Context) *auth.Identity {
+	id, _ := c.Get(identityKey).(*auth.Identity)
+
+	return id
+}
+
+// actor returns the actor recorded for actions of the request
+func actor(c echo.Context) string {
+	if id := identity(c); id != nil {
+		return id.Actor()
+	}
+
+	return ""
+}
+
+// AuthHandler is a echo handler for login with GitHub
+type AuthHandler interface {
+	Login(c echo.Context) error
+	Callback(c echo.Context) error
+	Logout(c echo.Context) error
+	WhoAmI(c echo.Context) error
+}
+
+type authHandler struct {
+	cfg           *config.Config
+	authenticator *auth.Authenticator
+}
+
+// BindAuthHandler binds login with GitHub under /auth for Echo
+func BindAuthHandler(e *echo.Echo, cfg *config.Config, authn *auth.Authenticator) {
+	if !authn.Enabled() {
+		return
+	}
+
+	ah := &authHandler{
+		cfg:           cfg,
+		authenticator: authn,
+	}
+
+	e.GET("/auth/whoami", ah.WhoAmI, authorize(cfg, authn, false))
+	e.POST("/auth/logout", ah.Logout)
+
+	if authn.LoginEnabled() {
+		e.GET("/auth/login", ah.Login)
+		e.GET("/auth/callback", ah.Callback)
+	}
+}
+
+var _ AuthHandler = &authHandler{}
+
+// callbackURL returns the URL to which GitHub redirects users after login
+func (ah *authHandler) callbackURL(c echo.Context) string {
+	base := ah.cfg.ExternalURL
+	if base == "" {
+		base = c.Scheme() + "://" + c.Request().Host
+	}
+
+	return strings.TrimSuffix(base, "/") + "/auth/callback"
+}
+
+// localPath returns next if it is a path on this server not to redirect users to other sites
+func localPath(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/dashboard"
+	}
+
+	return next
+}
+
+// Login redirects users to GitHub
+//
+//	GET /auth/login?next=/dashboard
+func (ah *authHandler) Login(c echo.Context) error {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return c.String(http.StatusInternalServerError, "failed to generate state")
+	}
+	state := hex.EncodeToString(b)
+
+	u, err := ah.authenticator.AuthorizeURL(state, ah.callbackURL(c))
+
+	if err != nil {
+		return c.String(http.StatusNotFound, err.Error())
+	}
+
+	c.SetCookie(&http.Cookie{
+		Name:     stateCookie,
+		Value:    state + ":" + url.QueryEscape(localPath(c.QueryParam("next"))),
+		Path:     "/auth/",
+		MaxAge:   int((10 * time.Minute).Seconds()),
+		HttpOnly: true,
+		Secure:   c.Scheme() == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	return c.Redirect(http.StatusFound, u)
+}
+
+// Callback starts a session for the user redirected back from GitHub
+//
+//	GET /auth/callback?code=...&state=...
+func (ah *authHandler) Callback(c echo.Context) error {
+	cookie, err := c.Cookie(stateCookie)
+
+	if err != nil {
+		return c.String(http.StatusBadRequest, "login has expired")
+	}
+
+	state, next, _ := strings.Cut(cookie.Value, ":")
+
+	if state == "" || c.QueryParam("state") != state {
+		return c.String(http.StatusBadRequest, "state does not match")
+	}
+
+	c.SetCookie(&http.Cookie{Name: stateCookie, Path: "/auth/", MaxAge: -1})
+
+	if v := c.QueryParam("error"); v != "" {
+		return c.String(http.StatusUnauthorized, "login was denied: "+v)
+	}
+
+	id, session, err := ah.authenticator.Login(c.Request().Context(), c.QueryParam("code"), ah.callbackURL(c))
+
+	switch {
+	case err == nil:
+	case xerrors.Is(err, auth.ErrNoRole):
+		slog.InfoContext(c.Request().Context(), "login denied", "error", err)
+
+		return c.String(http.StatusForbidden, "no role is granted to your account")
+	default:
+		slog.ErrorContext(c.Request().Context(), "login failed", "error", err)
+
+		return c.String(http.StatusBadGateway, "login failed")
+	}
+
+	slog.InfoContext(c.Request().Context(), "logged in", "actor", id.Actor(), "role", id.Role)
+
+	c.SetCookie(&http.Cookie{
+		Name:     auth.SessionCookie,
+		Value:    session,
+		Path:     "/",
+		Expires:  id.ExpiresAt,
+		HttpOnly: true,
+		Secure:   c.Scheme() == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	if next, err = url.QueryUnescape(next); err != nil {
+		next = ""
+	}
+
+	return c.Redirect(http.StatusFound, localPath(next))
+}
+
+// Logout ends the session
+//
+//	POST /auth/logout
+func (ah *authHandler) Logout(c echo.Context) error {
+	c.SetCookie(&http.Cookie{
+		Name:     auth.SessionCookie,
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   c.Scheme() == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// WhoAmI returns the identity of the request
+//
+//	GET /auth/whoami
+func (ah *authHandler) WhoAmI(c echo.Context) error {
+	return c.JSON(http.StatusOK, identity(c))
+}
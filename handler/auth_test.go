@@ -0,0 +1,350 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/MISW/mischan-bot/config"
+	"github.com/MISW/mischan-bot/intenral/auth"
+	"github.com/labstack/echo/v4"
+)
+
+const testExternalURL = "https://bot.example.com"
+
+// newFakeGitHub starts an OAuth server and REST API of GitHub where code logs alice in, a member of team staff in MISW
+func newFakeGitHub(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /login/oauth/access_token", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		if r.FormValue("code") != "code" || r.FormValue("redirect_uri") != testExternalURL+"/auth/callback" {
+			json.NewEncoder(w).Encode(map[string]string{"error": "bad_verification_code"})
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "token-alice"})
+	})
+	mux.HandleFunc("GET /api/user", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token-alice" {
+			http.Error(w, `{"message": "Bad credentials"}`, http.StatusUnauthorized)
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]string{"login": "alice"})
+	})
+	mux.HandleFunc("GET /api/orgs/MISW/teams/staff/memberships/alice", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"state": "active"})
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+// newTestServer returns Echo with login and routes of every role, and tokens by role
+func newTestServer(t *testing.T, routes map[string]config.Role) (*echo.Echo, *httptest.Server, map[config.Role]string) {
+	t.Helper()
+
+	gh := newFakeGitHub(t)
+
+	tokens := map[config.Role]string{
+		config.RoleViewer:   "viewer-token",
+		config.RoleDeployer: "deployer-token",
+		config.RoleAdmin:    "admin-token",
+	}
+
+	cfg := &config.Config{
+		AdminToken:        tokens[config.RoleAdmin],
+		OAuthClientID:     "Iv1.test",
+		OAuthClientSecret: "client-secret",
+		OAuthURL:          gh.URL,
+		OAuthAPIURL:       gh.URL + "/api/",
+		ExternalURL:       testExternalURL,
+		SessionSecret:     "secret",
+		Auth: config.AuthConfig{
+			Org:   "MISW",
+			Teams: map[string]config.Role{"staff": config.RoleViewer},
+			Tokens: []config.TokenConfig{
+				{Name: "viewer", TokenEnv: "TEST_VIEWER_TOKEN", Role: config.RoleViewer},
+				{Name: "deployer", TokenEnv: "TEST_DEPLOYER_TOKEN", Role: config.RoleDeployer},
+			},
+			Routes: routes,
+		},
+	}
+
+	t.Setenv("TEST_VIEWER_TOKEN", tokens[config.RoleViewer])
+	t.Setenv("TEST_DEPLOYER_TOKEN", tokens[config.RoleDeployer])
+
+	authn, err := auth.NewAuthenticator(cfg)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := echo.New()
+	BindAuthHandler(e, cfg, authn)
+
+	ok := func(c echo.Context) error {
+		return c.String(http.StatusOK, actor(c))
+	}
+
+	api := e.Group("/api", authorize(cfg, authn, false))
+	api.GET("/runs", ok)
+	api.POST("/runs/:id/retry", ok)
+	api.POST("/freeze/override", ok)
+	api.GET("/audit", ok)
+	api.GET("/unlisted", ok)
+	api.GET("/apps/:app/plan", ok)
+	api.POST("/webhooks/replay", ok)
+
+	e.GET("/dashboard", ok, authorize(cfg, authn, true))
+
+	return e, gh, tokens
+}
+
+func serve(e *echo.Echo, r *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, r)
+
+	return rec
+}
+
+func TestLocalPath(t *testing.T) {
+	tests := map[string]string{
+		"/dashboard?app=MISW%2FPortal": "/dashboard?app=MISW%2FPortal",
+		"/api/runs":                    "/api/runs",
+		"":                             "/dashboard",
+		"https://evil.example.com/":    "/dashboard",
+		"//evil.example.com/":          "/dashboard",
+		"/\\evil.example.com/":         "/dashboard",
+		"javascript:alert(1)":          "/dashboard",
+		"dashboard":                    "/dashboard",
+	}
+
+	for next, want := range tests {
+		if got := localPath(next); got != want {
+			t.Errorf("localPath(%q) = %q, want %q", next, got, want)
+		}
+	}
+}
+
+func TestRouteRoles(t *testing.T) {
+	routes := map[string]config.Role{
+		// Loosened and tightened by the config
+		"GET /api/audit": config.RoleViewer,
+		"GET /api/runs":  config.RoleDeployer,
+	}
+
+	e, _, tokens := newTestServer(t, routes)
+
+	tests := []struct {
+		method, path string
+		required     config.Role
+	}{
+		{http.MethodGet, "/api/runs", config.RoleDeployer},
+		{http.MethodPost, "/api/runs/1/retry", config.RoleDeployer},
+		{http.MethodPost, "/api/freeze/override", config.RoleAdmin},
+		{http.MethodGet, "/api/audit", config.RoleViewer},
+		{http.MethodGet, "/api/unlisted", config.RoleAdmin},
+		{http.MethodGet, "/api/apps/MISW%2FPortal/plan", config.RoleViewer},
+		{http.MethodPost, "/api/webhooks/replay", config.RoleAdmin},
+	}
+
+	for _, tt := range tests {
+		for role, token := range tokens {
+			r := httptest.NewRequest(tt.method, tt.path, nil)
+			r.Header.Set("Authorization", "Bearer "+token)
+
+			rec := serve(e, r)
+
+			want := http.StatusForbidden
+			if role.Allows(tt.required) {
+				want = http.StatusOK
+			}
+
+			if rec.Code != want {
+				t.Errorf("%s %s with %s: status %d, want %d", tt.method, tt.path, role, rec.Code, want)
+			}
+		}
+
+		rec := serve(e, httptest.NewRequest(tt.method, tt.path, nil))
+
+		if rec.Code != http.StatusUnauthorized || !strings.HasPrefix(rec.Header().Get(echo.HeaderWWWAuthenticate), "Bearer") {
+			t.Errorf("%s %s without credentials: status %d, want 401 with a bearer challenge", tt.method, tt.path, rec.Code)
+		}
+	}
+}
+
+func TestRouteRoleDefaults(t *testing.T) {
+	cfg := &config.Config{}
+
+	for route, want := range defaultRouteRoles {
+		if got := routeRole(cfg, route); got != want {
+			t.Errorf("routeRole(%q) = %s, want %s", route, got, want)
+		}
+	}
+
+	if got := routeRole(cfg, "DELETE /api/anything"); got != config.RoleAdmin {
+		t.Errorf("unlisted routes require %s, want admin", got)
+	}
+}
+
+// login starts login from the dashboard and returns the state cookie and state sent to GitHub
+func login(t *testing.T, e *echo.Echo, gh *httptest.Server) (*http.Cookie, string) {
+	t.Helper()
+
+	rec := serve(e, httptest.NewRequest(http.MethodGet, "/dashboard?app=MISW%2FPortal", nil))
+
+	if rec.Code != http.StatusFound || rec.Header().Get(echo.HeaderLocation) != "/auth/login?next=%2Fdashboard%3Fapp%3DMISW%252FPortal" {
+		t.Fatalf("browser is not redirected to login: %d %s", rec.Code, rec.Header().Get(echo.HeaderLocation))
+	}
+
+	rec = serve(e, httptest.NewRequest(http.MethodGet, rec.Header().Get(echo.HeaderLocation), nil))
+
+	if rec.Code != http.StatusFound {
+		t.Fatalf("login is not redirected to GitHub: %d", rec.Code)
+	}
+
+	u, err := url.Parse(rec.Header().Get(echo.HeaderLocation))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(u.String(), gh.URL+"/login/oauth/authorize?") || u.Query().Get("redirect_uri") != testExternalURL+"/auth/callback" {
+		t.Fatalf("unexpected authorize URL: %s", u)
+	}
+
+	cookies := rec.Result().Cookies()
+
+	if len(cookies) != 1 || cookies[0].Name != stateCookie || !cookies[0].HttpOnly {
+		t.Fatalf("unexpected cookies: %v", cookies)
+	}
+
+	return cookies[0], u.Query().Get("state")
+}
+
+func TestLoginFlow(t *testing.T) {
+	e, gh, _ := newTestServer(t, nil)
+
+	cookie, state := login(t, e, gh)
+
+	r := httptest.NewRequest(http.MethodGet, "/auth/callback?code=code&state="+state, nil)
+	r.AddCookie(cookie)
+
+	rec := serve(e, r)
+
+	if rec.Code != http.StatusFound || rec.Header().Get(echo.HeaderLocation) != "/dashboard?app=MISW%2FPortal" {
+		t.Fatalf("callback is not redirected back: %d %s %s", rec.Code, rec.Header().Get(echo.HeaderLocation), rec.Body)
+	}
+
+	var session *http.Cookie
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == auth.SessionCookie {
+			session = c
+		}
+	}
+
+	if session == nil || !session.HttpOnly {
+		t.Fatalf("no session cookie is set: %v", rec.Result().Cookies())
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/auth/whoami", nil)
+	r.AddCookie(session)
+
+	rec = serve(e, r)
+
+	var id auth.Identity
+	if err := json.Unmarshal(rec.Body.Bytes(), &id); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("whoami failed: %d %s", rec.Code, rec.Body)
+	}
+
+	if id.Login != "alice" || id.Role != config.RoleViewer || id.Method != auth.MethodGitHub {
+		t.Errorf("unexpected identity: %+v", id)
+	}
+
+	// Viewers cannot deploy
+	r = httptest.NewRequest(http.MethodPost, "/api/runs/1/retry", nil)
+	r.AddCookie(session)
+
+	if rec := serve(e, r); rec.Code != http.StatusForbidden {
+		t.Errorf("viewer retried a run: %d", rec.Code)
+	}
+}
+
+func TestLoginCallbackRejectsStateMismatch(t *testing.T) {
+	e, gh, _ := newTestServer(t, nil)
+
+	cookie, state := login(t, e, gh)
+
+	tests := map[string]*http.Request{
+		"another state": httptest.NewRequest(http.MethodGet, "/auth/callback?code=code&state=forged", nil),
+		"no state":      httptest.NewRequest(http.MethodGet, "/auth/callback?code=code", nil),
+		"no cookie":     httptest.NewRequest(http.MethodGet, "/auth/callback?code=code&state="+state, nil),
+	}
+
+	tests["another state"].AddCookie(cookie)
+	tests["no state"].AddCookie(cookie)
+
+	for name, r := range tests {
+		rec := serve(e, r)
+
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", name, rec.Code)
+		}
+
+		for _, c := range rec.Result().Cookies() {
+			if c.Name == auth.SessionCookie {
+				t.Errorf("%s: session cookie is set", name)
+			}
+		}
+	}
+}
+
+func TestLoginCallbackWithInvalidCode(t *testing.T) {
+	e, gh, _ := newTestServer(t, nil)
+
+	cookie, state := login(t, e, gh)
+
+	r := httptest.NewRequest(http.MethodGet, "/auth/callback?code=wrong&state="+state, nil)
+	r.AddCookie(cookie)
+
+	if rec := serve(e, r); rec.Code != http.StatusBadGateway {
+		t.Errorf("status %d, want 502", rec.Code)
+	}
+}
+
+func TestLoginDoesNotRedirectToOtherSites(t *testing.T) {
+	e, _, _ := newTestServer(t, nil)
+
+	rec := serve(e, httptest.NewRequest(http.MethodGet, "/auth/login?next="+url.QueryEscape("//evil.example.com/"), nil))
+
+	cookies := rec.Result().Cookies()
+
+	if len(cookies) != 1 {
+		t.Fatalf("unexpected cookies: %v", cookies)
+	}
+
+	state, next, _ := strings.Cut(cookies[0].Value, ":")
+
+	if next != url.QueryEscape("/dashboard") {
+		t.Errorf("next is kept for another site: %q", next)
+	}
+
+	// The callback checks next again in case the cookie was forged
+	r := httptest.NewRequest(http.MethodGet, "/auth/callback?code=code&state="+state, nil)
+	r.AddCookie(&http.Cookie{Name: stateCookie, Value: state + ":" + url.QueryEscape("https://evil.example.com/")})
+
+	rec = serve(e, r)
+
+	if rec.Code != http.StatusFound || rec.Header().Get(echo.HeaderLocation) != "/dashboard" {
+		t.Errorf("callback redirected to %d %s", rec.Code, rec.Header().Get(echo.HeaderLocation))
+	}
+}
@@ -0,0 +1,98 @@
+package handler
+
+import (
+	"bytes"
+	"embed"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/MISW/mischan-bot/config"
+	"github.com/MISW/mischan-bot/intenral/auth"
+	"github.com/MISW/mischan-bot/usecase"
+	"github.com/labstack/echo/v4"
+	"golang.org/x/xerrors"
+)
+
+const (
+	defaultDashboardRuns = 50
+	maxDashboardRuns     = 1000
+)
+
+//go:embed templates/dashboard.html
+var dashboardFS embed.FS
+
+var dashboardTemplate = template.Must(template.New("dashboard.html").Funcs(template.FuncMap{
+	"short": func(sha string) string {
+		if len(sha) > 7 {
+			return sha[:7]
+		}
+
+		return sha
+	},
+	"duration": func(start, end time.Time) string {
+		return end.Sub(start).Round(time.Millisecond).String()
+	},
+}).ParseFS(dashboardFS, "templates/dashboard.html"))
+
+// DashboardHandler is a echo handler for the HTML dashboard
+type DashboardHandler interface {
+	Dashboard(c echo.Context) error
+}
+
+type dashboardHandler struct {
+	dashboardUsecase usecase.DashboardUsecase
+}
+
+// BindDashboardHandler binds the dashboard at /dashboard for Echo
+// It requires the viewer role of a user logged in with GitHub or a token as the password of basic authentication.
+// It is not bound if neither is configured.
+func BindDashboardHandler(e *echo.Echo, cfg *config.Config, authn *auth.Authenticator, du usecase.DashboardUsecase) {
+	if !authn.Enabled() {
+		return
+	}
+
+	dh := &dashboardHandler{
+		dashboardUsecase: du,
+	}
+
+	e.GET("/dashboard", dh.Dashboard, authorize(cfg, authn, true))
+}
+
+var _ DashboardHandler = &dashboardHandler{}
+
+// Dashboard renders registered repositories, open pull requests and recent runs
+//
+//	GET /dashboard?runs=50
+func (dh *dashboardHandler) Dashboard(c echo.Context) error {
+	runs := defaultDashboardRuns
+
+	if s := c.QueryParam("runs"); s != "" {
+		n, err := strconv.Atoi(s)
+
+		if err != nil || n <= 0 || n > maxDashboardRuns {
+			return c.String(http.StatusBadRequest, "runs must be between 1 and "+strconv.Itoa(maxDashboardRuns))
+		}
+
+		runs = n
+	}
+
+	ctx := c.Request().Context()
+
+	d, err := dh.dashboardUsecase.Dashboard(ctx, runs)
+
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to collect dashboard", "error", err)
+
+		return c.String(http.StatusInternalServerError, "failed to collect dashboard")
+	}
+
+	var buf bytes.Buffer
+	if err := dashboardTemplate.Execute(&buf, d); err != nil {
+		return xerrors.Errorf("failed to render dashboard: %w", err)
+	}
+
+	return c.HTMLBlob(http.StatusOK, buf.Bytes())
+}
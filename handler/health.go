@@ -0,0 +1,46 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/MISW/mischan-bot/usecase"
+	"github.com/labstack/echo/v4"
+)
+
+// HealthHandler is a echo handler for probes
+type HealthHandler interface {
+	Healthz(c echo.Context) error
+	Readyz(c echo.Context) error
+}
+
+type healthHandler struct {
+	healthUsecase usecase.HealthUsecase
+}
+
+// BindHealthHandler binds /healthz and /readyz for liveness and readiness probes
+func BindHealthHandler(e *echo.Echo, hu usecase.HealthUsecase) {
+	hh := &healthHandler{
+		healthUsecase: hu,
+	}
+
+	e.GET("/healthz", hh.Healthz)
+	e.GET("/readyz", hh.Readyz)
+}
+
+var _ HealthHandler = &healthHandler{}
+
+// Healthz responds as long as the process serves requests
+func (hh *healthHandler) Healthz(c echo.Context) error {
+	return c.JSON(http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// Readyz responds with results of dependency checks. It fails with 503 if any check fails.
+func (hh *healthHandler) Readyz(c echo.Context) error {
+	report := hh.healthUsecase.Ready(c.Request().Context())
+
+	if !report.OK {
+		return c.JSON(http.StatusServiceUnavailable, report)
+	}
+
+	return c.JSON(http.StatusOK, report)
+}
@@ -0,0 +1,56 @@
+package handler
+
+import (
+	"log/slog"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+)
+
+// RequestLogger logs requests with slog.
+// Query parameters and headers other than webhook metadata are not logged not to leak credentials.
+func RequestLogger() echo.MiddlewareFunc {
+	return middleware.RequestLoggerWithConfig(middleware.RequestLoggerConfig{
+		HandleError: true,
+		LogMethod:   true,
+		LogURIPath:  true,
+		LogStatus:   true,
+		LogLatency:  true,
+		LogRemoteIP: true,
+		LogError:    true,
+		LogHeaders:  []string{"X-GitHub-Delivery", "X-GitHub-Event"},
+		LogValuesFunc: func(c echo.Context, v middleware.RequestLoggerValues) error {
+			level := slog.LevelInfo
+			if v.Error != nil || v.Status >= 500 {
+				level = slog.LevelError
+			} else if v.URIPath == "/healthz" || v.URIPath == "/readyz" {
+				// Probes are too frequent to log
+				level = slog.LevelDebug
+			}
+
+			attrs := []slog.Attr{
+				slog.String("method", v.Method),
+				slog.String("path", v.URIPath),
+				slog.Int("status", v.Status),
+				slog.Duration("latency", v.Latency),
+				slog.String("remoteIP", v.RemoteIP),
+			}
+
+			if d := v.Headers["X-Github-Delivery"]; len(d) != 0 {
+				attrs = append(attrs, slog.String("delivery", d[0]))
+			}
+
+			if e := v.Headers["X-Github-Event"]; len(e) != 0 {
+				attrs = append(attrs, slog.String("event", e[0]))
+			}
+
+			if v.Error != nil {
+				attrs = append(attrs, slog.String("error", v.Error.Error()))
+			}
+
+			slog.LogAttrs(c.Request().Context(), level, "request", attrs...)
+
+			return nil
+		},
+	})
+}
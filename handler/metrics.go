@@ -0,0 +1,11 @@
+package handler
+
+import (
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// BindMetricsHandler exposes Prometheus metrics at /metrics
+func BindMetricsHandler(e *echo.Echo) {
+	e.GET("/metrics", echo.WrapHandler(promhttp.Handler()))
+}
@@ -5,9 +5,12 @@ import (
 	"net/http"
 
 	"github.com/MISW/mischan-bot/config"
+	"github.com/MISW/mischan-bot/intenral/metrics"
+	"github.com/MISW/mischan-bot/intenral/tracing"
 	"github.com/MISW/mischan-bot/usecase"
 	"github.com/google/go-github/v55/github"
 	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // RootHandler is a echo handler
@@ -32,41 +35,82 @@ func BindHandler(e *echo.Echo, cfg *config.Config, ghu usecase.GitHubEventUsecas
 
 var _ RootHandler = &rootHandler{}
 
-func (rh *rootHandler) Webhook(c echo.Context) error {
+func (rh *rootHandler) Webhook(c echo.Context) (err error) {
+	// The event type is not trusted until the signature is verified
+	eventType, outcome := "", "accepted"
+
+	ctx, span := tracing.Start(c.Request().Context(), "rootHandler.Webhook")
+	defer func() {
+		if err != nil {
+			outcome = "error"
+		}
+
+		metrics.WebhookDeliveries.WithLabelValues(eventType, outcome).Inc()
+
+		span.SetAttributes(attribute.String("github.event", eventType), attribute.String("webhook.outcome", outcome))
+		tracing.End(span, err)
+	}()
+
 	payload, err := github.ValidatePayload(c.Request(), []byte(rh.webhookSecret))
 
 	if err != nil {
+		metrics.WebhookSignatureFailures.Inc()
+		outcome = "invalid_signature"
+
 		return c.JSON(http.StatusUnauthorized, map[string]string{"message": "token is invalid"})
 	}
 
-	switch c.Request().Header.Get("X-GitHub-Event") {
+	deliveryID := c.Request().Header.Get("X-GitHub-Delivery")
+	eventType = c.Request().Header.Get("X-GitHub-Event")
+	span.SetAttributes(attribute.String("github.delivery", deliveryID))
+
+	switch eventType {
 	case "push":
 		event := &github.PushEvent{}
 		if err := json.Unmarshal(payload, event); err != nil {
+			outcome = "invalid_payload"
+
 			return c.JSON(http.StatusBadRequest, map[string]string{"message": "payload is invalid json", "error": err.Error()})
 		}
 
-		if err := rh.githubEventUsecase.Push(event); err != nil {
+		if err := rh.githubEventUsecase.Push(ctx, deliveryID, event); err != nil {
 			return err
 		}
 	case "check_suite":
 		event := &github.CheckSuiteEvent{}
 		if err := json.Unmarshal(payload, event); err != nil {
+			outcome = "invalid_payload"
+
 			return c.JSON(http.StatusBadRequest, map[string]string{"message": "payload is invalid json", "error": err.Error()})
 		}
 
-		if err := rh.githubEventUsecase.CheckSuite(event); err != nil {
+		if err := rh.githubEventUsecase.CheckSuite(ctx, deliveryID, event); err != nil {
 			return err
 		}
 	case "create":
 		event := &github.CreateEvent{}
 		if err := json.Unmarshal(payload, event); err != nil {
+			outcome = "invalid_payload"
+
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": "payload is invalid json", "error": err.Error()})
+		}
+
+		if err := rh.githubEventUsecase.Create(ctx, deliveryID, event); err != nil {
+			return err
+		}
+	case "pull_request":
+		event := &github.PullRequestEvent{}
+		if err := json.Unmarshal(payload, event); err != nil {
+			outcome = "invalid_payload"
+
 			return c.JSON(http.StatusBadRequest, map[string]string{"message": "payload is invalid json", "error": err.Error()})
 		}
 
-		if err := rh.githubEventUsecase.Create(event); err != nil {
+		if err := rh.githubEventUsecase.PullRequest(ctx, deliveryID, event); err != nil {
 			return err
 		}
+	default:
+		outcome = "ignored"
 	}
 
 	return nil
@@ -0,0 +1,274 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MISW/mischan-bot/intenral/trigger"
+	"golang.org/x/xerrors"
+)
+
+// ErrNotPersisted is returned by Search if the log has no file
+var ErrNotPersisted = xerrors.New("audit log is not persisted")
+
+// Log appends records to a JSON lines file.
+// The file is rotated to <name>-<time><ext> in the same directory when it grows larger than maxSize.
+type Log struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+
+	lock sync.Mutex
+	file *os.File
+	size int64
+}
+
+// NewLog opens the log at path. maxFiles rotated files are kept, or all of them if 0.
+// Records are written only to the default logger if path is empty.
+func NewLog(path string, maxSize int64, maxFiles int) (*Log, error) {
+	l := &Log{
+		path:     path,
+		maxSize:  maxSize,
+		maxFiles: maxFiles,
+	}
+
+	if path == "" {
+		return l, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, xerrors.Errorf("failed to create directory for %s: %w", path, err)
+	}
+
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+func (l *Log) open() error {
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o640)
+
+	if err != nil {
+		return xerrors.Errorf("failed to open %s: %w", l.path, err)
+	}
+
+	st, err := f.Stat()
+
+	if err != nil {
+		f.Close()
+		return xerrors.Errorf("failed to stat %s: %w", l.path, err)
+	}
+
+	l.file = f
+	l.size = st.Size()
+
+	return nil
+}
+
+// Close closes the file
+func (l *Log) Close() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.file == nil {
+		return nil
+	}
+
+	return l.file.Close()
+}
+
+// Record appends r with the trigger in ctx. Failures are only logged not to fail actions.
+func (l *Log) Record(ctx context.Context, r *Record) {
+	if r.Time.IsZero() {
+		r.Time = time.Now()
+	}
+
+	t := trigger.From(ctx)
+	r.DeliveryID = t.DeliveryID
+	r.Event = t.Event
+	r.Actor = t.Actor
+
+	slog.DebugContext(ctx, "audit", "action", r.Action, "repository", r.Repository, "ref", r.Ref, "pullRequest", r.PullRequest, "status", r.Status)
+
+	if l.path == "" {
+		slog.InfoContext(ctx, "audit", "record", r)
+
+		return
+	}
+
+	b, err := json.Marshal(r)
+
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to encode audit record", "action", r.Action, "error", err)
+		return
+	}
+	b = append(b, '\n')
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.maxSize > 0 && l.size > 0 && l.size+int64(len(b)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			slog.ErrorContext(ctx, "failed to rotate audit log", "path", l.path, "error", err)
+		}
+	}
+
+	if l.file == nil {
+		slog.ErrorContext(ctx, "audit log is closed", "path", l.path, "record", r)
+		return
+	}
+
+	n, err := l.file.Write(b)
+	l.size += int64(n)
+
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to write audit record", "path", l.path, "record", r, "error", err)
+	}
+}
+
+// rotate renames the current file and opens a new one. The caller must hold the lock.
+func (l *Log) rotate() error {
+	if err := l.file.Close(); err != nil {
+		return xerrors.Errorf("failed to close %s: %w", l.path, err)
+	}
+	l.file = nil
+
+	ext := filepath.Ext(l.path)
+	rotated := strings.TrimSuffix(l.path, ext) + "-" + time.Now().UTC().Format("20060102T150405.000000000Z") + ext
+
+	if err := os.Rename(l.path, rotated); err != nil {
+		return xerrors.Errorf("failed to rename %s: %w", l.path, err)
+	}
+
+	if err := l.open(); err != nil {
+		return err
+	}
+
+	if l.maxFiles <= 0 {
+		return nil
+	}
+
+	files, err := l.rotatedFiles()
+
+	if err != nil {
+		return err
+	}
+
+	for len(files) > l.maxFiles {
+		if err := os.Remove(files[0]); err != nil {
+			return xerrors.Errorf("failed to remove %s: %w", files[0], err)
+		}
+
+		files = files[1:]
+	}
+
+	return nil
+}
+
+// rotatedFiles returns rotated files, oldest first
+func (l *Log) rotatedFiles() ([]string, error) {
+	ext := filepath.Ext(l.path)
+
+	files, err := filepath.Glob(globEscape(strings.TrimSuffix(l.path, ext)) + "-*" + globEscape(ext))
+
+	if err != nil {
+		return nil, xerrors.Errorf("failed to list rotated files: %w", err)
+	}
+
+	// Names contain times of rotation
+	sort.Strings(files)
+
+	return files, nil
+}
+
+// Search returns records matching q in the current and rotated files, newest first
+func (l *Log) Search(q Query) ([]*Record, error) {
+	if l.path == "" {
+		return nil, ErrNotPersisted
+	}
+
+	l.lock.Lock()
+	files, err := l.rotatedFiles()
+	l.lock.Unlock()
+
+	if err != nil {
+		return nil, err
+	}
+
+	files = append(files, l.path)
+
+	list := []*Record{}
+	for i := len(files) - 1; i >= 0; i-- {
+		records, err := readRecords(files[i])
+
+		if err != nil {
+			return nil, err
+		}
+
+		for j := len(records) - 1; j >= 0; j-- {
+			if !q.matches(records[j]) {
+				continue
+			}
+
+			list = append(list, records[j])
+
+			if q.Limit != 0 && len(list) >= q.Limit {
+				return list, nil
+			}
+		}
+	}
+
+	return list, nil
+}
+
+// readRecords reads records in the file in order. Lines which cannot be parsed such as partially written ones are skipped.
+func readRecords(path string) ([]*Record, error) {
+	f, err := os.Open(path)
+
+	if os.IsNotExist(err) {
+		// Removed by rotation
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, xerrors.Errorf("failed to open %s: %w", path, err)
+	}
+	defer f.Close()
+
+	var records []*Record
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+
+	for scanner.Scan() {
+		var r Record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			continue
+		}
+
+		records = append(records, &r)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, xerrors.Errorf("failed to read %s: %w", path, err)
+	}
+
+	return records, nil
+}
+
+// globEscape escapes metacharacters of filepath.Match in s
+func globEscape(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`)
+
+	return r.Replace(s)
+}
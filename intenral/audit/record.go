@@ -0,0 +1,79 @@
+package audit
+
+import (
+	"strings"
+	"time"
+)
+
+// Record is an action the bot took on GitHub
+type Record struct {
+	Time time.Time `json:"time"`
+
+	// Action is what was done(e.g. branch.create, git.push, pr.merge)
+	Action string `json:"action"`
+
+	// Repository is the repository acted on(e.g. MISW/k8s)
+	Repository  string `json:"repository,omitempty"`
+	Ref         string `json:"ref,omitempty"`
+	PullRequest int    `json:"pullRequest,omitempty"`
+
+	// Before and After are SHAs of Ref or the pull request before and after the action if known
+	Before string `json:"before,omitempty"`
+	After  string `json:"after,omitempty"`
+	Force  bool   `json:"force,omitempty"`
+
+	// Method, Path and Status describe the API request for actions through the REST API
+	Method string `json:"method,omitempty"`
+	Path   string `json:"path,omitempty"`
+	Status int    `json:"status,omitempty"`
+
+	// Error is set if the action failed
+	Error string `json:"error,omitempty"`
+
+	// Trigger of the action
+	DeliveryID string `json:"deliveryID,omitempty"`
+	Event      string `json:"event,omitempty"`
+	Actor      string `json:"actor,omitempty"`
+}
+
+// Query filters records. Empty fields match any records.
+type Query struct {
+	// Action matches records whose action starts with it(e.g. pr matches pr.open and pr.merge)
+	Action     string
+	Repository string
+	Ref        string
+	Actor      string
+	DeliveryID string
+
+	// SHA matches records whose Before or After starts with it
+	SHA string
+
+	// Since and Until limit Time to [Since, Until)
+	Since, Until time.Time
+
+	// Limit is the maximum number of records returned. No limit if 0.
+	Limit int
+}
+
+func (q *Query) matches(r *Record) bool {
+	switch {
+	case q.Action != "" && r.Action != q.Action && !strings.HasPrefix(r.Action, q.Action+"."):
+		return false
+	case q.Repository != "" && !strings.EqualFold(r.Repository, q.Repository):
+		return false
+	case q.Ref != "" && r.Ref != q.Ref && strings.TrimPrefix(r.Ref, "refs/heads/") != q.Ref:
+		return false
+	case q.Actor != "" && r.Actor != q.Actor:
+		return false
+	case q.DeliveryID != "" && r.DeliveryID != q.DeliveryID:
+		return false
+	case q.SHA != "" && (r.Before == "" || !strings.HasPrefix(r.Before, q.SHA)) && (r.After == "" || !strings.HasPrefix(r.After, q.SHA)):
+		return false
+	case !q.Since.IsZero() && r.Time.Before(q.Since):
+		return false
+	case !q.Until.IsZero() && !r.Time.Before(q.Until):
+		return false
+	}
+
+	return true
+}
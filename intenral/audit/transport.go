@@ -0,0 +1,222 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// maxBodySize is the maximum size of request and response bodies inspected for SHAs
+const maxBodySize = 1 << 20
+
+type action struct {
+	method  string
+	pattern *regexp.Regexp
+	name    string
+}
+
+// actions maps mutating REST API calls to actions.
+// Patterns capture the repository and optionally a ref or a number.
+var actions = []action{
+	{http.MethodPost, regexp.MustCompile(`^/repos/([^/]+/[^/]+)/git/refs$`), "branch.create"},
+	{http.MethodPatch, regexp.MustCompile(`^/repos/([^/]+/[^/]+)/git/refs/(.+)$`), "branch.update"},
+	{http.MethodDelete, regexp.MustCompile(`^/repos/([^/]+/[^/]+)/git/refs/(.+)$`), "branch.delete"},
+	{http.MethodPost, regexp.MustCompile(`^/repos/([^/]+/[^/]+)/pulls$`), "pr.open"},
+	{http.MethodPatch, regexp.MustCompile(`^/repos/([^/]+/[^/]+)/pulls/(\d+)$`), "pr.update"},
+	{http.MethodPut, regexp.MustCompile(`^/repos/([^/]+/[^/]+)/pulls/(\d+)/merge$`), "pr.merge"},
+	{http.MethodPut, regexp.MustCompile(`^/repos/([^/]+/[^/]+)/pulls/(\d+)/update-branch$`), "pr.update_branch"},
+	{http.MethodPost, regexp.MustCompile(`^/repos/([^/]+/[^/]+)/issues/(\d+)/comments$`), "comment.create"},
+	{http.MethodPatch, regexp.MustCompile(`^/repos/([^/]+/[^/]+)/issues/comments/(\d+)$`), "comment.update"},
+	{http.MethodDelete, regexp.MustCompile(`^/repos/([^/]+/[^/]+)/issues/comments/(\d+)$`), "comment.delete"},
+	{http.MethodPost, regexp.MustCompile(`^/repos/([^/]+/[^/]+)/check-runs$`), "check_run.create"},
+	{http.MethodPatch, regexp.MustCompile(`^/repos/([^/]+/[^/]+)/check-runs/(\d+)$`), "check_run.update"},
+}
+
+// ignored are mutating calls which do not change repositories
+var ignored = []*regexp.Regexp{
+	regexp.MustCompile(`^/app/installations/\d+/access_tokens$`),
+}
+
+type transport struct {
+	base http.RoundTripper
+	log  *Log
+}
+
+// Transport records mutating requests to the GitHub REST API sent through base.
+// The trigger is taken from the context of requests.
+func Transport(base http.RoundTripper, log *Log) http.RoundTripper {
+	return &transport{
+		base: base,
+		log:  log,
+	}
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return t.base.RoundTrip(req)
+	}
+
+	path := strings.TrimPrefix(req.URL.Path, "/api/v3")
+	for _, re := range ignored {
+		if re.MatchString(path) {
+			return t.base.RoundTrip(req)
+		}
+	}
+
+	r := &Record{
+		Action: "github." + strings.ToLower(req.Method),
+		Method: req.Method,
+		Path:   path,
+	}
+
+	var match []string
+	for _, a := range actions {
+		if a.method != req.Method {
+			continue
+		}
+
+		if match = a.pattern.FindStringSubmatch(path); match != nil {
+			r.Action = a.name
+			break
+		}
+	}
+
+	var reqBody map[string]any
+	if req.Body != nil && req.GetBody != nil {
+		if body, err := req.GetBody(); err == nil {
+			reqBody = decodeBody(body)
+		}
+	}
+
+	resp, err := t.base.RoundTrip(req)
+
+	var respBody map[string]any
+	if err != nil {
+		r.Error = err.Error()
+	} else {
+		r.Status = resp.StatusCode
+
+		if resp.StatusCode >= 400 {
+			r.Error = resp.Status
+		}
+
+		respBody, resp.Body = peekBody(resp.Body)
+	}
+
+	if match != nil {
+		describe(r, match, reqBody, respBody)
+	}
+
+	t.log.Record(req.Context(), r)
+
+	return resp, err
+}
+
+// describe fills the target and SHAs of r from the API call
+func describe(r *Record, match []string, reqBody, respBody map[string]any) {
+	r.Repository = match[1]
+
+	var number int
+	if len(match) > 2 {
+		number, _ = strconv.Atoi(match[2])
+	}
+
+	switch r.Action {
+	case "branch.create":
+		r.Ref = stringField(reqBody, "ref")
+		r.After = stringField(reqBody, "sha")
+	case "branch.update":
+		r.Ref = "refs/" + match[2]
+		r.After = stringField(reqBody, "sha")
+		r.Force, _ = reqBody["force"].(bool)
+	case "branch.delete":
+		r.Ref = "refs/" + match[2]
+	case "pr.open":
+		r.Ref = stringField(reqBody, "head")
+		r.PullRequest = int(numberField(respBody, "number"))
+		r.After = stringField(objectField(respBody, "head"), "sha")
+	case "pr.update":
+		r.PullRequest = number
+		switch stringField(reqBody, "state") {
+		case "closed":
+			r.Action = "pr.close"
+		case "open":
+			r.Action = "pr.reopen"
+		}
+		r.After = stringField(objectField(respBody, "head"), "sha")
+	case "pr.merge":
+		r.PullRequest = number
+		r.Before = stringField(reqBody, "sha")
+		r.After = stringField(respBody, "sha")
+	case "pr.update_branch":
+		r.PullRequest = number
+		r.Before = stringField(reqBody, "expected_head_sha")
+	case "comment.create":
+		r.PullRequest = number
+	case "check_run.create":
+		r.After = stringField(reqBody, "head_sha")
+	}
+}
+
+// decodeBody decodes a JSON object in body and closes it
+func decodeBody(body io.ReadCloser) map[string]any {
+	defer body.Close()
+
+	var m map[string]any
+	if err := json.NewDecoder(io.LimitReader(body, maxBodySize)).Decode(&m); err != nil {
+		return nil
+	}
+
+	return m
+}
+
+// peekBody decodes a JSON object in body and returns a body with the same content
+func peekBody(body io.ReadCloser) (map[string]any, io.ReadCloser) {
+	if body == nil {
+		return nil, body
+	}
+
+	b, err := io.ReadAll(io.LimitReader(body, maxBodySize))
+
+	rest := struct {
+		io.Reader
+		io.Closer
+	}{
+		Reader: io.MultiReader(bytes.NewReader(b), body),
+		Closer: body,
+	}
+
+	if err != nil {
+		return nil, rest
+	}
+
+	var m map[string]any
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, rest
+	}
+
+	return m, rest
+}
+
+func objectField(m map[string]any, key string) map[string]any {
+	v, _ := m[key].(map[string]any)
+
+	return v
+}
+
+func stringField(m map[string]any, key string) string {
+	v, _ := m[key].(string)
+
+	return v
+}
+
+func numberField(m map[string]any, key string) float64 {
+	v, _ := m[key].(float64)
+
+	return v
+}
@@ -0,0 +1,199 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/MISW/mischan-bot/intenral/trigger"
+)
+
+func TestTransport(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		body     string
+		status   int
+		response string
+		want     *Record
+	}{
+		{
+			name:   "create branch",
+			method: http.MethodPost,
+			path:   "/repos/MISW/k8s/git/refs",
+			body:   `{"ref": "refs/heads/mischan-bot/misw/portal/0123456", "sha": "aaaaaaa"}`,
+			status: http.StatusCreated,
+			want:   &Record{Action: "branch.create", Repository: "MISW/k8s", Ref: "refs/heads/mischan-bot/misw/portal/0123456", After: "aaaaaaa"},
+		},
+		{
+			name:   "force-update branch on GitHub Enterprise",
+			method: http.MethodPatch,
+			path:   "/api/v3/repos/MISW/k8s/git/refs/heads/mischan-bot/misw/portal/0123456",
+			body:   `{"sha": "bbbbbbb", "force": true}`,
+			status: http.StatusOK,
+			want:   &Record{Action: "branch.update", Repository: "MISW/k8s", Ref: "refs/heads/mischan-bot/misw/portal/0123456", After: "bbbbbbb", Force: true},
+		},
+		{
+			name:   "delete branch",
+			method: http.MethodDelete,
+			path:   "/repos/MISW/k8s/git/refs/heads/old",
+			status: http.StatusNoContent,
+			want:   &Record{Action: "branch.delete", Repository: "MISW/k8s", Ref: "refs/heads/old"},
+		},
+		{
+			name:     "open pull request",
+			method:   http.MethodPost,
+			path:     "/repos/MISW/k8s/pulls",
+			body:     `{"head": "mischan-bot/misw/portal/0123456", "base": "master"}`,
+			status:   http.StatusCreated,
+			response: `{"number": 12, "head": {"sha": "ccccccc"}}`,
+			want:     &Record{Action: "pr.open", Repository: "MISW/k8s", Ref: "mischan-bot/misw/portal/0123456", PullRequest: 12, After: "ccccccc"},
+		},
+		{
+			name:     "close pull request",
+			method:   http.MethodPatch,
+			path:     "/repos/MISW/k8s/pulls/12",
+			body:     `{"state": "closed"}`,
+			status:   http.StatusOK,
+			response: `{"number": 12, "head": {"sha": "ccccccc"}}`,
+			want:     &Record{Action: "pr.close", Repository: "MISW/k8s", PullRequest: 12, After: "ccccccc"},
+		},
+		{
+			name:     "merge pull request",
+			method:   http.MethodPut,
+			path:     "/repos/MISW/k8s/pulls/12/merge",
+			body:     `{"sha": "ccccccc"}`,
+			status:   http.StatusOK,
+			response: `{"sha": "ddddddd", "merged": true}`,
+			want:     &Record{Action: "pr.merge", Repository: "MISW/k8s", PullRequest: 12, Before: "ccccccc", After: "ddddddd"},
+		},
+		{
+			name:     "failed merge",
+			method:   http.MethodPut,
+			path:     "/repos/MISW/k8s/pulls/12/merge",
+			body:     `{"sha": "ccccccc"}`,
+			status:   http.StatusConflict,
+			response: `{"message": "Head branch was modified"}`,
+			want:     &Record{Action: "pr.merge", Repository: "MISW/k8s", PullRequest: 12, Before: "ccccccc", Error: "409 Conflict"},
+		},
+		{
+			name:   "create check run",
+			method: http.MethodPost,
+			path:   "/repos/MISW/k8s/check-runs",
+			body:   `{"name": "mischan-bot/validate", "head_sha": "eeeeeee"}`,
+			status: http.StatusCreated,
+			want:   &Record{Action: "check_run.create", Repository: "MISW/k8s", After: "eeeeeee"},
+		},
+		{
+			name:   "unknown mutation",
+			method: http.MethodPost,
+			path:   "/repos/MISW/k8s/labels",
+			body:   `{"name": "bot"}`,
+			status: http.StatusCreated,
+			want:   &Record{Action: "github.post"},
+		},
+		{
+			name:   "read",
+			method: http.MethodGet,
+			path:   "/repos/MISW/k8s/pulls",
+			status: http.StatusOK,
+		},
+		{
+			name:   "installation token",
+			method: http.MethodPost,
+			path:   "/app/installations/1/access_tokens",
+			status: http.StatusCreated,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var received string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				b, _ := io.ReadAll(r.Body)
+				received = string(b)
+
+				w.WriteHeader(tt.status)
+				io.WriteString(w, tt.response)
+			}))
+			defer server.Close()
+
+			log, err := NewLog(filepath.Join(t.TempDir(), "audit.log"), 0, 0)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer log.Close()
+
+			client := &http.Client{Transport: Transport(http.DefaultTransport, log)}
+
+			ctx := trigger.With(context.Background(), trigger.Trigger{Actor: "octocat", Event: "push", DeliveryID: "delivery"})
+
+			req, err := http.NewRequestWithContext(ctx, tt.method, server.URL+tt.path, bytes.NewReader([]byte(tt.body)))
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			resp, err := client.Do(req)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Bodies are passed through as is
+			b, err := io.ReadAll(resp.Body)
+			resp.Body.Close()
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(b) != tt.response || received != tt.body {
+				t.Errorf("bodies are modified: sent %q, received %q", received, b)
+			}
+
+			records, err := log.Search(Query{})
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.want == nil {
+				if len(records) != 0 {
+					t.Errorf("unexpected records: %+v", records[0])
+				}
+
+				return
+			}
+
+			if len(records) != 1 {
+				t.Fatalf("%d records are written, want 1", len(records))
+			}
+
+			got := records[0]
+
+			if got.Time.IsZero() || got.Method != tt.method || got.Path != strings.TrimPrefix(tt.path, "/api/v3") || got.Status != tt.status {
+				t.Errorf("unexpected request: %+v", got)
+			}
+
+			if got.Actor != "octocat" || got.Event != "push" || got.DeliveryID != "delivery" {
+				t.Errorf("unexpected trigger: %+v", got)
+			}
+
+			got.Time, got.Method, got.Path, got.Status = tt.want.Time, "", "", 0
+			got.Actor, got.Event, got.DeliveryID = "", "", ""
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Record = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,174 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/MISW/mischan-bot/config"
+	"golang.org/x/xerrors"
+)
+
+const (
+	// SessionCookie is the name of the cookie with the session of a user logged in with GitHub
+	SessionCookie = "mischan_bot_session"
+
+	sessionTTL = 12 * time.Hour
+)
+
+var (
+	// ErrUnauthenticated is returned if requests have no valid credentials
+	ErrUnauthenticated = xerrors.New("unauthenticated")
+
+	// ErrNoRole is returned if no role is granted to the user
+	ErrNoRole = xerrors.New("no role is granted")
+
+	// ErrLoginDisabled is returned if login with GitHub is not configured
+	ErrLoginDisabled = xerrors.New("login with GitHub is disabled")
+)
+
+type token struct {
+	name   string
+	digest [sha256.Size]byte
+	role   config.Role
+}
+
+// Authenticator authenticates requests with static bearer tokens or sessions of users logged in with GitHub
+type Authenticator struct {
+	tokens   []token
+	sessions *sessions
+	github   *gitHubLogin
+}
+
+// NewAuthenticator initializes Authenticator. ADMIN_TOKEN is a token named admin with the admin role.
+func NewAuthenticator(cfg *config.Config) (*Authenticator, error) {
+	sessions, err := newSessions(cfg.SessionSecret, sessionTTL)
+
+	if err != nil {
+		return nil, err
+	}
+
+	a := &Authenticator{
+		sessions: sessions,
+	}
+
+	if cfg.AdminToken != "" {
+		a.tokens = append(a.tokens, token{name: "admin", digest: sha256.Sum256([]byte(cfg.AdminToken)), role: config.RoleAdmin})
+	}
+
+	for _, tc := range cfg.Auth.Tokens {
+		v := tc.Token()
+
+		if v == "" {
+			slog.Warn("token is ignored since the environment variable is empty", "name", tc.Name, "env", tc.TokenEnv)
+			continue
+		}
+
+		a.tokens = append(a.tokens, token{name: tc.Name, digest: sha256.Sum256([]byte(v)), role: tc.Role})
+	}
+
+	if cfg.OAuthClientID != "" && cfg.OAuthClientSecret != "" {
+		a.github = newGitHubLogin(cfg)
+	}
+
+	return a, nil
+}
+
+// Enabled returns true if any tokens or login with GitHub are configured
+func (a *Authenticator) Enabled() bool {
+	return len(a.tokens) != 0 || a.LoginEnabled()
+}
+
+// LoginEnabled returns true if users can log in with GitHub
+func (a *Authenticator) LoginEnabled() bool {
+	return a.github != nil
+}
+
+// Authenticate returns the identity of a bearer token, the password of basic authentication or the session cookie in r
+func (a *Authenticator) Authenticate(r *http.Request) (*Identity, error) {
+	if v := r.Header.Get("Authorization"); v != "" {
+		if bearer, ok := strings.CutPrefix(v, "Bearer "); ok {
+			return a.token(bearer)
+		}
+
+		if _, password, ok := r.BasicAuth(); ok {
+			return a.token(password)
+		}
+
+		return nil, xerrors.Errorf("unsupported authorization scheme: %w", ErrUnauthenticated)
+	}
+
+	if c, err := r.Cookie(SessionCookie); err == nil {
+		id, err := a.sessions.decode(c.Value)
+
+		if err != nil {
+			return nil, xerrors.Errorf("%s: %w", err.Error(), ErrUnauthenticated)
+		}
+
+		return id, nil
+	}
+
+	return nil, ErrUnauthenticated
+}
+
+func (a *Authenticator) token(v string) (*Identity, error) {
+	digest := sha256.Sum256([]byte(v))
+
+	var found *token
+	for i := range a.tokens {
+		// All tokens are compared not to leak which one matched through timing
+		if subtle.ConstantTimeCompare(digest[:], a.tokens[i].digest[:]) == 1 && found == nil {
+			found = &a.tokens[i]
+		}
+	}
+
+	if found == nil {
+		return nil, xerrors.Errorf("unknown token: %w", ErrUnauthenticated)
+	}
+
+	return &Identity{
+		Login:  found.name,
+		Role:   found.role,
+		Method: MethodToken,
+	}, nil
+}
+
+// AuthorizeURL returns the URL of GitHub to log in. GitHub redirects users to redirectURI with state.
+func (a *Authenticator) AuthorizeURL(state, redirectURI string) (string, error) {
+	if a.github == nil {
+		return "", ErrLoginDisabled
+	}
+
+	return a.github.authorizeURL(state, redirectURI), nil
+}
+
+// Login exchanges the code from GitHub for the user and returns the value of the session cookie
+func (a *Authenticator) Login(ctx context.Context, code, redirectURI string) (*Identity, string, error) {
+	if a.github == nil {
+		return nil, "", ErrLoginDisabled
+	}
+
+	accessToken, err := a.github.exchange(ctx, code, redirectURI)
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	id, err := a.github.identify(ctx, accessToken)
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	session, err := a.sessions.encode(id)
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	return id, session, nil
+}
@@ -0,0 +1,155 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/MISW/mischan-bot/config"
+	"github.com/google/go-github/v55/github"
+	"golang.org/x/xerrors"
+)
+
+// gitHubLogin logs users in with the OAuth credentials of the GitHub App and grants roles by team memberships
+type gitHubLogin struct {
+	clientID, clientSecret string
+
+	// baseURL is GitHub(e.g. https://github.com) and apiURL is its REST API
+	baseURL, apiURL string
+
+	org   string
+	teams map[string]config.Role
+	users map[string]config.Role
+
+	client *http.Client
+}
+
+// authorizeURL returns the URL to which users are redirected to log in
+func (gl *gitHubLogin) authorizeURL(state, redirectURI string) string {
+	q := url.Values{}
+	q.Set("client_id", gl.clientID)
+	q.Set("state", state)
+	q.Set("redirect_uri", redirectURI)
+
+	return strings.TrimSuffix(gl.baseURL, "/") + "/login/oauth/authorize?" + q.Encode()
+}
+
+// exchange exchanges the code given to the callback for a user access token
+func (gl *gitHubLogin) exchange(ctx context.Context, code, redirectURI string) (string, error) {
+	form := url.Values{}
+	form.Set("client_id", gl.clientID)
+	form.Set("client_secret", gl.clientSecret)
+	form.Set("code", code)
+	form.Set("redirect_uri", redirectURI)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(gl.baseURL, "/")+"/login/oauth/access_token", strings.NewReader(form.Encode()))
+
+	if err != nil {
+		return "", xerrors.Errorf("failed to initialize request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := gl.client.Do(req)
+
+	if err != nil {
+		return "", xerrors.Errorf("failed to exchange code: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		AccessToken      string `json:"access_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", xerrors.Errorf("failed to decode response with status %d: %w", resp.StatusCode, err)
+	}
+
+	if body.Error != "" {
+		return "", xerrors.Errorf("failed to exchange code: %s: %s", body.Error, body.ErrorDescription)
+	}
+
+	if body.AccessToken == "" {
+		return "", xerrors.Errorf("no access token was issued with status %d", resp.StatusCode)
+	}
+
+	return body.AccessToken, nil
+}
+
+// identify returns the user of the token with the highest role granted by users and teams
+func (gl *gitHubLogin) identify(ctx context.Context, token string) (*Identity, error) {
+	// WithAuthToken replaces the transport of the given http.Client, so each user gets a copy
+	httpClient := *gl.client
+	client := github.NewClient(&httpClient).WithAuthToken(token)
+
+	apiURL := gl.apiURL
+	if !strings.HasSuffix(apiURL, "/") {
+		apiURL += "/"
+	}
+
+	var err error
+	if client.BaseURL, err = url.Parse(apiURL); err != nil {
+		return nil, xerrors.Errorf("invalid API URL %s: %w", gl.apiURL, err)
+	}
+
+	user, _, err := client.Users.Get(ctx, "")
+
+	if err != nil {
+		return nil, xerrors.Errorf("failed to get user: %w", err)
+	}
+
+	id := &Identity{
+		Login:  user.GetLogin(),
+		Method: MethodGitHub,
+	}
+
+	for login, role := range gl.users {
+		if strings.EqualFold(login, id.Login) {
+			id.Role = id.Role.Higher(role)
+		}
+	}
+
+	for slug, role := range gl.teams {
+		if id.Role.Allows(role) {
+			continue
+		}
+
+		membership, resp, err := client.Teams.GetTeamMembershipBySlug(ctx, gl.org, slug, id.Login)
+
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			continue
+		}
+
+		if err != nil {
+			return nil, xerrors.Errorf("failed to get membership of %s in %s/%s: %w", id.Login, gl.org, slug, err)
+		}
+
+		if membership.GetState() == "active" {
+			id.Role = id.Role.Higher(role)
+		}
+	}
+
+	if id.Role == "" {
+		return nil, xerrors.Errorf("%s: %w", id.Login, ErrNoRole)
+	}
+
+	return id, nil
+}
+
+func newGitHubLogin(cfg *config.Config) *gitHubLogin {
+	return &gitHubLogin{
+		clientID:     cfg.OAuthClientID,
+		clientSecret: cfg.OAuthClientSecret,
+		baseURL:      cfg.OAuthURL,
+		apiURL:       cfg.OAuthAPIURL,
+		org:          cfg.Auth.Org,
+		teams:        cfg.Auth.Teams,
+		users:        cfg.Auth.Users,
+		client:       &http.Client{Timeout: 10 * time.Second},
+	}
+}
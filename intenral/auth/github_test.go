@@ -0,0 +1,274 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/MISW/mischan-bot/config"
+	"golang.org/x/xerrors"
+)
+
+const (
+	testClientID     = "Iv1.test"
+	testClientSecret = "client-secret"
+	testRedirectURI  = "https://bot.example.com/auth/callback"
+)
+
+// fakeGitHub is an OAuth server and REST API of GitHub issuing a token for each code
+type fakeGitHub struct {
+	*httptest.Server
+
+	// logins are users by codes, and the access token of a user is "token-<login>"
+	logins map[string]string
+
+	// memberships are states of memberships by team slug and login
+	memberships map[string]map[string]string
+}
+
+func newFakeGitHub(t *testing.T) *fakeGitHub {
+	t.Helper()
+
+	fg := &fakeGitHub{
+		logins: map[string]string{
+			"code-alice": "alice",
+			"code-bob":   "bob",
+			"code-carol": "carol",
+			"code-dave":  "dave",
+			"code-eve":   "eve",
+		},
+		memberships: map[string]map[string]string{
+			"staff":    {"alice": "active", "bob": "active", "eve": "pending"},
+			"deployer": {"bob": "active"},
+			"sre":      {"carol": "active"},
+		},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /login/oauth/access_token", fg.accessToken)
+	mux.HandleFunc("GET /api/user", fg.user)
+	mux.HandleFunc("GET /api/orgs/MISW/teams/{team}/memberships/{login}", fg.membership)
+
+	fg.Server = httptest.NewServer(mux)
+	t.Cleanup(fg.Close)
+
+	return fg
+}
+
+func (fg *fakeGitHub) accessToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	login, ok := fg.logins[r.PostForm.Get("code")]
+
+	if !ok || r.PostForm.Get("client_id") != testClientID || r.PostForm.Get("client_secret") != testClientSecret || r.PostForm.Get("redirect_uri") != testRedirectURI {
+		// GitHub reports errors with 200 OK
+		json.NewEncoder(w).Encode(map[string]string{"error": "bad_verification_code", "error_description": "The code passed is incorrect or expired."})
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{"access_token": "token-" + login, "token_type": "bearer"})
+}
+
+// authenticated returns the login of the access token of r
+func (fg *fakeGitHub) authenticated(r *http.Request) (string, bool) {
+	for _, login := range fg.logins {
+		if r.Header.Get("Authorization") == "Bearer token-"+login {
+			return login, true
+		}
+	}
+
+	return "", false
+}
+
+func (fg *fakeGitHub) user(w http.ResponseWriter, r *http.Request) {
+	login, ok := fg.authenticated(r)
+
+	if !ok {
+		http.Error(w, `{"message": "Bad credentials"}`, http.StatusUnauthorized)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{"login": login})
+}
+
+func (fg *fakeGitHub) membership(w http.ResponseWriter, r *http.Request) {
+	if _, ok := fg.authenticated(r); !ok {
+		http.Error(w, `{"message": "Bad credentials"}`, http.StatusUnauthorized)
+		return
+	}
+
+	state, ok := fg.memberships[r.PathValue("team")][r.PathValue("login")]
+
+	if !ok {
+		http.Error(w, `{"message": "Not Found"}`, http.StatusNotFound)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{"state": state, "role": "member"})
+}
+
+// config returns the config logging in with fg
+func (fg *fakeGitHub) config() *config.Config {
+	return &config.Config{
+		OAuthClientID:     testClientID,
+		OAuthClientSecret: testClientSecret,
+		OAuthURL:          fg.URL,
+		OAuthAPIURL:       fg.URL + "/api",
+		SessionSecret:     "session-secret",
+		Auth: config.AuthConfig{
+			Org: "MISW",
+			Teams: map[string]config.Role{
+				"staff":    config.RoleViewer,
+				"deployer": config.RoleDeployer,
+			},
+			Users: map[string]config.Role{
+				"Carol": config.RoleAdmin,
+				"dave":  config.RoleViewer,
+			},
+		},
+	}
+}
+
+func TestAuthorizeURL(t *testing.T) {
+	fg := newFakeGitHub(t)
+
+	a, err := NewAuthenticator(fg.config())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := a.AuthorizeURL("state", testRedirectURI)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := url.Parse(u)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if parsed.Path != "/login/oauth/authorize" {
+		t.Errorf("unexpected path: %s", parsed.Path)
+	}
+
+	q := parsed.Query()
+	if q.Get("client_id") != testClientID || q.Get("state") != "state" || q.Get("redirect_uri") != testRedirectURI {
+		t.Errorf("unexpected query: %v", q)
+	}
+}
+
+func TestLoginRoles(t *testing.T) {
+	fg := newFakeGitHub(t)
+
+	a, err := NewAuthenticator(fg.config())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		code  string
+		login string
+		role  config.Role
+	}{
+		// Only a member of a viewer team
+		{"code-alice", "alice", config.RoleViewer},
+		// The highest role of teams
+		{"code-bob", "bob", config.RoleDeployer},
+		// Users are matched case-insensitively and teams without roles are ignored
+		{"code-carol", "carol", config.RoleAdmin},
+		// Granted by users without memberships
+		{"code-dave", "dave", config.RoleViewer},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.login, func(t *testing.T) {
+			id, session, err := a.Login(context.Background(), tt.code, testRedirectURI)
+
+			if err != nil {
+				t.Fatalf("login failed: %v", err)
+			}
+
+			if id.Login != tt.login || id.Role != tt.role || id.Method != MethodGitHub {
+				t.Errorf("unexpected identity: %+v", id)
+			}
+
+			r := httptest.NewRequest(http.MethodGet, "/dashboard", nil)
+			r.AddCookie(&http.Cookie{Name: SessionCookie, Value: session})
+
+			got, err := a.Authenticate(r)
+
+			if err != nil {
+				t.Fatalf("session is not accepted: %v", err)
+			}
+
+			if got.Login != tt.login || got.Role != tt.role {
+				t.Errorf("unexpected identity from session: %+v", got)
+			}
+		})
+	}
+}
+
+func TestLoginWithoutRole(t *testing.T) {
+	fg := newFakeGitHub(t)
+
+	a, err := NewAuthenticator(fg.config())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Pending memberships grant nothing
+	if _, _, err := a.Login(context.Background(), "code-eve", testRedirectURI); !xerrors.Is(err, ErrNoRole) {
+		t.Errorf("expected ErrNoRole, got %v", err)
+	}
+}
+
+func TestLoginWithInvalidCode(t *testing.T) {
+	fg := newFakeGitHub(t)
+
+	a, err := NewAuthenticator(fg.config())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := a.Login(context.Background(), "unknown", testRedirectURI); err == nil {
+		t.Error("login with an invalid code succeeded")
+	}
+
+	if _, _, err := a.Login(context.Background(), "code-alice", "https://evil.example.com/auth/callback"); err == nil {
+		t.Error("login with another redirect URI succeeded")
+	}
+}
+
+func TestLoginDisabled(t *testing.T) {
+	a, err := NewAuthenticator(&config.Config{AdminToken: "admin-token"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if a.LoginEnabled() {
+		t.Error("login is enabled without OAuth credentials")
+	}
+
+	if _, err := a.AuthorizeURL("state", testRedirectURI); !xerrors.Is(err, ErrLoginDisabled) {
+		t.Errorf("expected ErrLoginDisabled, got %v", err)
+	}
+
+	if _, _, err := a.Login(context.Background(), "code-alice", testRedirectURI); !xerrors.Is(err, ErrLoginDisabled) {
+		t.Errorf("expected ErrLoginDisabled, got %v", err)
+	}
+}
@@ -0,0 +1,35 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/MISW/mischan-bot/config"
+)
+
+const (
+	// MethodGitHub is used by users logged in with GitHub
+	MethodGitHub = "github"
+
+	// MethodToken is used by static bearer tokens
+	MethodToken = "token"
+)
+
+// Identity is an authenticated user or token
+type Identity struct {
+	// Login is the login of the GitHub user or the name of the token
+	Login  string      `json:"login"`
+	Role   config.Role `json:"role"`
+	Method string      `json:"method"`
+
+	// ExpiresAt is the expiry of the session of a user
+	ExpiresAt time.Time `json:"expiresAt,omitempty"`
+}
+
+// Actor returns the name recorded as the actor of actions by the identity
+func (id *Identity) Actor() string {
+	if id.Method == MethodToken {
+		return "token:" + id.Login
+	}
+
+	return id.Login
+}
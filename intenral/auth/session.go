@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// sessions encodes identities into cookie values signed with HMAC-SHA256
+type sessions struct {
+	key []byte
+	ttl time.Duration
+}
+
+func newSessions(secret string, ttl time.Duration) (*sessions, error) {
+	key := []byte(secret)
+
+	if len(key) == 0 {
+		key = make([]byte, 32)
+
+		if _, err := rand.Read(key); err != nil {
+			return nil, xerrors.Errorf("failed to generate session key: %w", err)
+		}
+	}
+
+	return &sessions{
+		key: key,
+		ttl: ttl,
+	}, nil
+}
+
+func (s *sessions) sign(payload string) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(payload))
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// encode returns a signed value for id and sets its expiry
+func (s *sessions) encode(id *Identity) (string, error) {
+	id.ExpiresAt = time.Now().Add(s.ttl).Truncate(time.Second)
+
+	b, err := json.Marshal(id)
+
+	if err != nil {
+		return "", xerrors.Errorf("failed to encode session: %w", err)
+	}
+
+	payload := base64.RawURLEncoding.EncodeToString(b)
+
+	return payload + "." + s.sign(payload), nil
+}
+
+// decode verifies the value and returns the identity if it has not expired
+func (s *sessions) decode(value string) (*Identity, error) {
+	payload, signature, ok := strings.Cut(value, ".")
+
+	if !ok || !hmac.Equal([]byte(signature), []byte(s.sign(payload))) {
+		return nil, xerrors.New("session is not signed")
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(payload)
+
+	if err != nil {
+		return nil, xerrors.Errorf("failed to decode session: %w", err)
+	}
+
+	var id Identity
+	if err := json.Unmarshal(b, &id); err != nil {
+		return nil, xerrors.Errorf("failed to decode session: %w", err)
+	}
+
+	if !time.Now().Before(id.ExpiresAt) {
+		return nil, xerrors.New("session has expired")
+	}
+
+	return &id, nil
+}
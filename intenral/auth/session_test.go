@@ -0,0 +1,196 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MISW/mischan-bot/config"
+	"golang.org/x/xerrors"
+)
+
+func TestSessionsRoundTrip(t *testing.T) {
+	s, err := newSessions("secret", time.Hour)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	value, err := s.encode(&Identity{Login: "alice", Role: config.RoleDeployer, Method: MethodGitHub})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := s.decode(value)
+
+	if err != nil {
+		t.Fatalf("failed to decode session: %v", err)
+	}
+
+	if id.Login != "alice" || id.Role != config.RoleDeployer || id.Method != MethodGitHub {
+		t.Errorf("unexpected identity: %+v", id)
+	}
+
+	if d := time.Until(id.ExpiresAt); d <= 0 || d > time.Hour {
+		t.Errorf("unexpected expiry: %v", id.ExpiresAt)
+	}
+}
+
+func TestSessionsRejectTampering(t *testing.T) {
+	s, err := newSessions("secret", time.Hour)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	value, err := s.encode(&Identity{Login: "alice", Role: config.RoleViewer, Method: MethodGitHub})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payload, signature, _ := strings.Cut(value, ".")
+
+	other, err := newSessions("other", time.Hour)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A session granting the admin role signed with another key
+	escalated, err := other.encode(&Identity{Login: "alice", Role: config.RoleAdmin, Method: MethodGitHub})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	escalatedPayload, _, _ := strings.Cut(escalated, ".")
+
+	tests := map[string]string{
+		"payload":                 escalatedPayload + "." + signature,
+		"signature":               payload + "." + strings.Repeat("A", len(signature)),
+		"no signature":            payload,
+		"empty":                   "",
+		"malformed":               "!!!." + s.sign("!!!"),
+		"signed with another key": escalated,
+	}
+
+	for name, v := range tests {
+		t.Run(name, func(t *testing.T) {
+			if id, err := s.decode(v); err == nil {
+				t.Errorf("tampered session is accepted: %+v", id)
+			}
+		})
+	}
+}
+
+func TestSessionsExpire(t *testing.T) {
+	s, err := newSessions("secret", -time.Minute)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	value, err := s.encode(&Identity{Login: "alice", Role: config.RoleViewer, Method: MethodGitHub})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.decode(value); err == nil {
+		t.Error("expired session is accepted")
+	}
+}
+
+func TestSessionsWithRandomKey(t *testing.T) {
+	a, err := newSessions("", time.Hour)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := newSessions("", time.Hour)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	value, err := a.encode(&Identity{Login: "alice", Role: config.RoleViewer})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := b.decode(value); err == nil {
+		t.Error("random keys are shared")
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	t.Setenv("TEST_DEPLOY_TOKEN", "deploy-token")
+	t.Setenv("TEST_EMPTY_TOKEN", "")
+
+	a, err := NewAuthenticator(&config.Config{
+		AdminToken:    "admin-token",
+		SessionSecret: "secret",
+		Auth: config.AuthConfig{
+			Tokens: []config.TokenConfig{
+				{Name: "ci", TokenEnv: "TEST_DEPLOY_TOKEN", Role: config.RoleDeployer},
+				{Name: "empty", TokenEnv: "TEST_EMPTY_TOKEN", Role: config.RoleAdmin},
+			},
+		},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session, err := a.sessions.encode(&Identity{Login: "alice", Role: config.RoleViewer, Method: MethodGitHub})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		setup func(r *http.Request)
+		actor string
+		role  config.Role
+	}{
+		{"bearer", func(r *http.Request) { r.Header.Set("Authorization", "Bearer admin-token") }, "token:admin", config.RoleAdmin},
+		{"basic", func(r *http.Request) { r.SetBasicAuth("anyone", "deploy-token") }, "token:ci", config.RoleDeployer},
+		{"cookie", func(r *http.Request) { r.AddCookie(&http.Cookie{Name: SessionCookie, Value: session}) }, "alice", config.RoleViewer},
+		{"unknown token", func(r *http.Request) { r.Header.Set("Authorization", "Bearer wrong") }, "", ""},
+		{"empty token", func(r *http.Request) { r.Header.Set("Authorization", "Bearer ") }, "", ""},
+		{"unsupported scheme", func(r *http.Request) { r.Header.Set("Authorization", "Token admin-token") }, "", ""},
+		{"tampered cookie", func(r *http.Request) { r.AddCookie(&http.Cookie{Name: SessionCookie, Value: session + "x"}) }, "", ""},
+		{"none", func(r *http.Request) {}, "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/runs", nil)
+			tt.setup(r)
+
+			id, err := a.Authenticate(r)
+
+			if tt.actor == "" {
+				if !xerrors.Is(err, ErrUnauthenticated) {
+					t.Errorf("expected ErrUnauthenticated, got %v(%+v)", err, id)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("authentication failed: %v", err)
+			}
+
+			if id.Actor() != tt.actor || id.Role != tt.role {
+				t.Errorf("unexpected identity: %+v", id)
+			}
+		})
+	}
+}
@@ -0,0 +1,229 @@
+package cloudevent
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/MISW/mischan-bot/config"
+	"github.com/MISW/mischan-bot/intenral/history"
+	"golang.org/x/xerrors"
+)
+
+const (
+	// SignatureHeader has the HMAC-SHA256 of the body with the secret of the endpoint as sha256=<hex>
+	SignatureHeader = "X-Mischan-Bot-Signature-256"
+
+	contentType = "application/cloudevents+json; charset=utf-8"
+
+	queueSize = 256
+	workers   = 4
+
+	maxAttempts    = 5
+	initialBackoff = time.Second
+	maxBackoff     = 30 * time.Second
+	requestTimeout = 10 * time.Second
+)
+
+type delivery struct {
+	endpoint config.WebhookConfig
+	event    *Event
+}
+
+// Dispatcher sends events to webhook endpoints in background with retries and records deliveries into history.Store
+type Dispatcher struct {
+	source    string
+	endpoints []config.WebhookConfig
+	store     *history.Store
+	client    *http.Client
+
+	queue chan *delivery
+}
+
+// NewDispatcher initializes a dispatcher of events to endpoints in cfg
+func NewDispatcher(cfg *config.Config, store *history.Store) *Dispatcher {
+	return &Dispatcher{
+		source:    "https://github.com/" + cfg.ManifestRepo,
+		endpoints: cfg.Webhooks,
+		store:     store,
+		client:    &http.Client{Timeout: requestTimeout},
+		queue:     make(chan *delivery, queueSize),
+	}
+}
+
+// Emit queues the event of the type to endpoints subscribing to it. Events are sent by Run.
+// Events are dropped and logged if the queue is full not to block deployments.
+func (d *Dispatcher) Emit(ctx context.Context, eventType string, data *Data) {
+	var event *Event
+
+	for _, endpoint := range d.endpoints {
+		if !endpoint.Matches(eventType, data.App) {
+			continue
+		}
+
+		if event == nil {
+			e, err := New(d.source, eventType, data)
+
+			if err != nil {
+				slog.ErrorContext(ctx, "failed to initialize event", "type", eventType, "app", data.App, "error", err)
+				return
+			}
+
+			event = e
+		}
+
+		select {
+		case d.queue <- &delivery{endpoint: endpoint, event: event}:
+		default:
+			slog.ErrorContext(ctx, "webhook queue is full, dropping event", "endpoint", endpoint.Name, "type", eventType, "id", event.ID)
+		}
+	}
+}
+
+// Run sends queued events until ctx is canceled
+func (d *Dispatcher) Run(ctx context.Context) {
+	for i := 0; i < workers; i++ {
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case dl := <-d.queue:
+					d.deliver(ctx, dl)
+				}
+			}
+		}()
+	}
+
+	<-ctx.Done()
+}
+
+// deliver sends the event to the endpoint with retries and records the result
+func (d *Dispatcher) deliver(ctx context.Context, dl *delivery) {
+	record := &history.Delivery{
+		Endpoint:  dl.endpoint.Name,
+		EventID:   dl.event.ID,
+		EventType: dl.event.Type,
+		App:       dl.event.Subject,
+		CreatedAt: time.Now(),
+	}
+
+	body, err := json.Marshal(dl.event)
+
+	if err != nil {
+		record.Error = xerrors.Errorf("failed to encode event: %w", err).Error()
+	} else {
+		d.send(ctx, dl.endpoint, body, record)
+	}
+
+	record.FinishedAt = time.Now()
+
+	if err := d.store.PutDelivery(record); err != nil {
+		slog.ErrorContext(ctx, "failed to record webhook delivery", "endpoint", record.Endpoint, "id", record.EventID, "error", err)
+	}
+
+	if !record.Delivered {
+		slog.ErrorContext(ctx, "failed to deliver webhook", "endpoint", record.Endpoint, "type", record.EventType, "id", record.EventID, "attempts", record.Attempts, "error", record.Error)
+	}
+}
+
+// send posts body until it is accepted, it fails permanently or attempts run out
+func (d *Dispatcher) send(ctx context.Context, endpoint config.WebhookConfig, body []byte, record *history.Delivery) {
+	backoff := initialBackoff
+
+	for {
+		record.Attempts++
+
+		status, err := d.post(ctx, endpoint, body)
+		record.StatusCode = status
+
+		if err == nil {
+			record.Delivered = true
+			record.Error = ""
+
+			return
+		}
+		record.Error = err.Error()
+
+		if !retryable(status, err) || record.Attempts >= maxAttempts {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+// post sends body to the endpoint and returns the status code
+func (d *Dispatcher) post(ctx context.Context, endpoint config.WebhookConfig, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.URL, bytes.NewReader(body))
+
+	if err != nil {
+		return 0, xerrors.Errorf("failed to initialize request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("User-Agent", "mischan-bot")
+
+	if secret := endpoint.Secret(); secret != "" {
+		req.Header.Set(SignatureHeader, Sign([]byte(secret), body))
+	}
+
+	resp, err := d.client.Do(req)
+
+	if err != nil {
+		// Errors contain URLs which may have credentials
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+
+		return 0, xerrors.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Drain the body to reuse the connection
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, xerrors.New("unexpected status " + strconv.Itoa(resp.StatusCode))
+	}
+
+	return resp.StatusCode, nil
+}
+
+// retryable returns true if the request may succeed later
+func retryable(status int, err error) bool {
+	switch {
+	case status == 0:
+		// No response
+		return !errors.Is(err, context.Canceled)
+	case status == http.StatusRequestTimeout, status == http.StatusTooManyRequests:
+		return true
+	default:
+		return status >= 500
+	}
+}
+
+// Sign returns the signature of body with secret in the format of SignatureHeader
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
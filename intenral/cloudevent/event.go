@@ -0,0 +1,94 @@
+package cloudevent
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+// Types of deployment events
+const (
+	// TypeRunStarted is emitted when a SHA of an app passed checks and is being deployed
+	TypeRunStarted = "run.started"
+
+	// TypeRunSucceeded is emitted when a run opened a pull request, committed changes or started a promotion
+	TypeRunSucceeded = "run.succeeded"
+
+	// TypeRunFrozen is emitted when a run was held back by a freeze window
+	TypeRunFrozen = "run.frozen"
+
+	// TypeRunFailed is emitted when a run failed
+	TypeRunFailed = "run.failed"
+
+	// TypePullRequestOpened is emitted when a pull request was opened in the manifest repository
+	TypePullRequestOpened = "pr.opened"
+
+	// TypePullRequestMerged is emitted when a pull request in the manifest repository was merged
+	TypePullRequestMerged = "pr.merged"
+
+	// TypePullRequestClosed is emitted when a pull request was closed without merge
+	TypePullRequestClosed = "pr.closed"
+
+	// TypePullRequestSuperseded is emitted when a pull request was closed since a newer one was opened
+	TypePullRequestSuperseded = "pr.superseded"
+
+	// TypeCommitPushed is emitted when changes were committed onto the base branch of the manifest repository without a pull request
+	TypeCommitPushed = "commit.pushed"
+)
+
+const specVersion = "1.0"
+
+// Event is a CloudEvents 1.0 event in the structured JSON format
+type Event struct {
+	SpecVersion     string    `json:"specversion"`
+	ID              string    `json:"id"`
+	Source          string    `json:"source"`
+	Type            string    `json:"type"`
+	Subject         string    `json:"subject,omitempty"`
+	Time            time.Time `json:"time"`
+	DataContentType string    `json:"datacontenttype"`
+	Data            *Data     `json:"data"`
+}
+
+// Data is the payload of deployment events
+type Data struct {
+	App         string            `json:"app"`
+	Environment string            `json:"environment,omitempty"`
+	SHA         string            `json:"sha,omitempty"`
+	Images      map[string]string `json:"images,omitempty"`
+
+	// PullRequest and URL are set for events of pull requests in the manifest repository
+	PullRequest int    `json:"pullRequest,omitempty"`
+	URL         string `json:"url,omitempty"`
+
+	// Commit is the commit in the manifest repository
+	Commit string `json:"commit,omitempty"`
+
+	DeploymentID string `json:"deploymentID,omitempty"`
+	RunID        string `json:"runID,omitempty"`
+
+	// Trigger of the event
+	Actor          string `json:"actor,omitempty"`
+	GitHubDelivery string `json:"githubDelivery,omitempty"`
+
+	Error string `json:"error,omitempty"`
+}
+
+// New initializes an event of the type about the app of data
+func New(source, eventType string, data *Data) (*Event, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+
+	return &Event{
+		SpecVersion:     specVersion,
+		ID:              hex.EncodeToString(b),
+		Source:          source,
+		Type:            eventType,
+		Subject:         data.App,
+		Time:            time.Now().UTC(),
+		DataContentType: "application/json",
+		Data:            data,
+	}, nil
+}
@@ -0,0 +1,199 @@
+package freeze
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+const (
+	// maxOverrides is the number of overrides kept as a record
+	maxOverrides = 100
+)
+
+// Pending is an update of an app held back by a freeze window
+type Pending struct {
+	App      string    `json:"app"`
+	SHA      string    `json:"sha"`
+	Window   string    `json:"window"`
+	QueuedAt time.Time `json:"queuedAt"`
+}
+
+// Override allows deployments during a freeze window
+type Override struct {
+	App         string `json:"app"`
+	Environment string `json:"environment,omitempty"`
+
+	// SHA limits the override to the SHA. Any SHA is allowed if empty.
+	SHA string `json:"sha,omitempty"`
+
+	// User is the GitHub login of the user who overrode the window
+	User string `json:"user"`
+
+	// Via is how the window was overridden(label or api)
+	Via string `json:"via"`
+
+	Window    string    `json:"window"`
+	Until     time.Time `json:"until"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type state struct {
+	Pending   []Pending  `json:"pending"`
+	Overrides []Override `json:"overrides"`
+}
+
+// Store keeps updates held back by freeze windows and overrides in a JSON file
+type Store struct {
+	path string
+
+	lock  sync.Mutex
+	state state
+}
+
+// NewStore loads the state from path. The state is kept only in memory if path is empty.
+func NewStore(path string) (*Store, error) {
+	s := &Store{
+		path: path,
+	}
+
+	if path == "" {
+		return s, nil
+	}
+
+	b, err := os.ReadFile(path)
+
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+
+	if err != nil {
+		return nil, xerrors.Errorf("failed to read %s: %w", path, err)
+	}
+
+	if err := json.Unmarshal(b, &s.state); err != nil {
+		return nil, xerrors.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	return s, nil
+}
+
+// Queue holds back the update. A pending update of the same app is replaced.
+func (s *Store) Queue(p Pending) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for i := range s.state.Pending {
+		if s.state.Pending[i].App == p.App {
+			s.state.Pending[i] = p
+
+			return s.persist()
+		}
+	}
+
+	s.state.Pending = append(s.state.Pending, p)
+
+	return s.persist()
+}
+
+// Dequeue removes the pending update of sha for the app
+func (s *Store) Dequeue(app, sha string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for i := range s.state.Pending {
+		if s.state.Pending[i].App == app && s.state.Pending[i].SHA == sha {
+			s.state.Pending = append(s.state.Pending[:i], s.state.Pending[i+1:]...)
+
+			return s.persist()
+		}
+	}
+
+	return nil
+}
+
+// Pending returns updates held back
+func (s *Store) Pending() []Pending {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return append([]Pending(nil), s.state.Pending...)
+}
+
+// AddOverride records the override
+func (s *Store) AddOverride(o Override) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.state.Overrides = append(s.state.Overrides, o)
+
+	if len(s.state.Overrides) > maxOverrides {
+		s.state.Overrides = s.state.Overrides[len(s.state.Overrides)-maxOverrides:]
+	}
+
+	return s.persist()
+}
+
+// Overridden returns the override allowing to deploy sha of the app to the environment at t
+func (s *Store) Overridden(app, environment, sha string, t time.Time) (*Override, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for i := len(s.state.Overrides) - 1; i >= 0; i-- {
+		o := s.state.Overrides[i]
+
+		if o.App != app || o.Environment != environment || !t.Before(o.Until) {
+			continue
+		}
+
+		if o.SHA == "" || o.SHA == sha {
+			return &o, true
+		}
+	}
+
+	return nil, false
+}
+
+// Overrides returns recorded overrides, newest first
+func (s *Store) Overrides() []Override {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	list := make([]Override, 0, len(s.state.Overrides))
+	for i := len(s.state.Overrides) - 1; i >= 0; i-- {
+		list = append(list, s.state.Overrides[i])
+	}
+
+	return list
+}
+
+func (s *Store) persist() error {
+	if s.path == "" {
+		return nil
+	}
+
+	b, err := json.MarshalIndent(s.state, "", "  ")
+
+	if err != nil {
+		return xerrors.Errorf("failed to encode freeze state: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return xerrors.Errorf("failed to create directory for %s: %w", s.path, err)
+	}
+
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return xerrors.Errorf("failed to write %s: %w", tmp, err)
+	}
+
+	if err := os.Rename(tmp, s.path); err != nil {
+		return xerrors.Errorf("failed to replace %s: %w", s.path, err)
+	}
+
+	return nil
+}
@@ -5,8 +5,11 @@ import (
 	"net/http"
 
 	"github.com/MISW/mischan-bot/config"
+	"github.com/MISW/mischan-bot/intenral/audit"
+	"github.com/MISW/mischan-bot/intenral/metrics"
 	"github.com/bradleyfalzon/ghinstallation/v2"
 	"github.com/google/go-github/v55/github"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 	"golang.org/x/xerrors"
 )
 
@@ -15,9 +18,14 @@ type GitHubSink struct {
 	appsTransport *ghinstallation.AppsTransport
 }
 
-// NewGitHubSink initializes a utility to initialize GitHub App client
-func NewGitHubSink(cfg *config.Config) (*GitHubSink, error) {
-	tr := http.DefaultTransport
+// NewGitHubSink initializes a utility to initialize GitHub App client.
+// Mutating API calls are recorded to auditLog if it is not nil.
+func NewGitHubSink(cfg *config.Config, auditLog *audit.Log) (*GitHubSink, error) {
+	var tr http.RoundTripper = metrics.GitHubTransport(http.DefaultTransport)
+	if auditLog != nil {
+		tr = audit.Transport(tr, auditLog)
+	}
+	tr = otelhttp.NewTransport(tr)
 
 	var appsTransport *ghinstallation.AppsTransport
 	var err error
@@ -2,12 +2,21 @@ package gitutil
 
 import (
 	"context"
+	"log/slog"
 	"net/url"
 	"os"
+	"path"
+	"strings"
+	"time"
 
+	"github.com/MISW/mischan-bot/intenral/metrics"
+	"github.com/MISW/mischan-bot/intenral/tracing"
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
 	"github.com/google/go-github/v55/github"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/xerrors"
 )
 
@@ -25,8 +34,38 @@ func NewGitHubUtil(token string, client *github.Client) *GitHubUtil {
 	}
 }
 
+// CloneOptions limits what CloneRepository fetches and checks out
+type CloneOptions struct {
+	// Depth limits fetched history to the number of commits. Full history is fetched if 0.
+	Depth int
+
+	// Paths limits checked out files to the directories(sparse checkout). All files are checked out if empty.
+	Paths []string
+
+	// NoCheckout skips checking out files, e.g. to read only history
+	NoCheckout bool
+}
+
+func (opts *CloneOptions) noCheckout() bool {
+	return opts != nil && opts.NoCheckout
+}
+
+func (opts *CloneOptions) sparseDirectories() []string {
+	if opts == nil || len(opts.Paths) == 0 {
+		return nil
+	}
+
+	dirs := make([]string, 0, len(opts.Paths))
+	for _, p := range opts.Paths {
+		dirs = append(dirs, strings.TrimSuffix(path.Clean(p), "/")+"/")
+	}
+
+	return dirs
+}
+
 // CloneRepository clones a repository on local filesystem
-func (ghu *GitHubUtil) CloneRepository(ctx context.Context, gitURL string, ref string) (repo *git.Repository, dir string, err error) {
+// opts can be nil to clone full history and check out all files
+func (ghu *GitHubUtil) CloneRepository(ctx context.Context, gitURL string, ref string, opts *CloneOptions) (repo *git.Repository, dir string, err error) {
 	dir, err = os.MkdirTemp("", "mischan-bot-")
 
 	if err != nil {
@@ -35,18 +74,104 @@ func (ghu *GitHubUtil) CloneRepository(ctx context.Context, gitURL string, ref s
 
 	u, err := url.Parse(gitURL)
 	if err != nil {
+		os.RemoveAll(dir)
 		return nil, "", xerrors.Errorf("failed to parse git url: %w", err)
 	}
 	u.User = url.UserPassword("x-access-token", ghu.token)
 
-	repo, err = git.PlainCloneContext(ctx, dir, false, &git.CloneOptions{
+	sparseDirs := opts.sparseDirectories()
+
+	cloneOpts := &git.CloneOptions{
 		URL:           u.String(),
 		ReferenceName: plumbing.NewBranchReferenceName(ref),
-	})
+		NoCheckout:    opts.noCheckout() || len(sparseDirs) != 0,
+	}
+
+	if opts != nil && opts.Depth > 0 {
+		cloneOpts.Depth = opts.Depth
+		cloneOpts.SingleBranch = true
+	}
+
+	start := time.Now()
+	repo, err = git.PlainCloneContext(ctx, dir, false, cloneOpts)
+	metrics.ObserveGit("clone", start)
 
 	if err != nil {
+		os.RemoveAll(dir)
 		return nil, "", xerrors.Errorf("failed to clone repository: %w", err)
 	}
 
+	if len(sparseDirs) != 0 && !opts.noCheckout() {
+		wt, err := repo.Worktree()
+
+		if err != nil {
+			os.RemoveAll(dir)
+			return nil, "", xerrors.Errorf("failed to get worktree: %w", err)
+		}
+
+		if err := wt.Checkout(&git.CheckoutOptions{
+			Branch:                    plumbing.NewBranchReferenceName(ref),
+			SparseCheckoutDirectories: sparseDirs,
+		}); err != nil {
+			os.RemoveAll(dir)
+			return nil, "", xerrors.Errorf("failed to check out %v: %w", sparseDirs, err)
+		}
+	}
+
 	return repo, dir, nil
 }
+
+// CheckoutRepository checks out a repository into a worktree backed by mirrors.
+// Depth in opts is ignored for mirrors since they always keep full history.
+// It falls back to a fresh clone if mirrors is nil or the mirror cannot be used.
+// release must be called to remove the worktree.
+func (ghu *GitHubUtil) CheckoutRepository(
+	ctx context.Context,
+	mirrors *MirrorCache,
+	gitURL string,
+	ref string,
+	opts *CloneOptions,
+) (repo *git.Repository, dir string, release func(), err error) {
+	ctx, span := tracing.Start(ctx, "GitHubUtil.CheckoutRepository", attribute.String("ref", ref))
+	defer func() { tracing.End(span, err) }()
+
+	if mirrors != nil {
+		u, err := url.Parse(gitURL)
+		if err != nil {
+			return nil, "", nil, xerrors.Errorf("failed to parse git url: %w", err)
+		}
+		u.User = url.UserPassword("x-access-token", ghu.token)
+
+		repo, dir, release, err := mirrors.Worktree(
+			ctx,
+			gitURL,
+			u.String(),
+			&http.BasicAuth{Username: "x-access-token", Password: ghu.token},
+			ref,
+			opts.sparseDirectories(),
+			opts.noCheckout(),
+		)
+
+		if err == nil {
+			return repo, dir, release, nil
+		}
+
+		span.AddEvent("mirror fallback", trace.WithAttributes(attribute.String("error", err.Error())))
+		slog.WarnContext(ctx, "failed to use mirror, falling back to a fresh clone", "url", gitURL, "error", err)
+	}
+
+	repo, dir, err = ghu.CloneRepository(ctx, gitURL, ref, opts)
+	release = func() {
+		if dir != "" {
+			os.RemoveAll(dir)
+		}
+	}
+
+	if err != nil {
+		release()
+
+		return nil, "", nil, err
+	}
+
+	return repo, dir, release, nil
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package gitutil
+
+// lockFile is a no-op on platforms without flock(2). Mirrors are still guarded by in-process locks.
+func lockFile(path string, exclusive bool) (unlock func(), err error) {
+	return func() {}, nil
+}
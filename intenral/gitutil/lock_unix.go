@@ -0,0 +1,40 @@
+//go:build unix
+
+package gitutil
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"golang.org/x/xerrors"
+)
+
+// lockFile takes an advisory lock on path so that mirrors can be shared between processes
+func lockFile(path string, exclusive bool) (unlock func(), err error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, xerrors.Errorf("failed to create directory for lock file: %w", err)
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o644)
+
+	if err != nil {
+		return nil, xerrors.Errorf("failed to open lock file: %w", err)
+	}
+
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+
+	if err := syscall.Flock(int(f.Fd()), how); err != nil {
+		f.Close()
+
+		return nil, xerrors.Errorf("failed to lock %s: %w", path, err)
+	}
+
+	return func() {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, nil
+}
@@ -0,0 +1,81 @@
+//go:build unix
+
+package gitutil
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWorktreeConcurrently(t *testing.T) {
+	gitURL, _ := newRemote(t)
+	dir := t.TempDir()
+
+	// Caches sharing a directory stand for processes sharing a volume
+	caches := []*MirrorCache{NewMirrorCache(dir), NewMirrorCache(dir)}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(mc *MirrorCache) {
+			defer wg.Done()
+
+			content, err := checkoutFile(context.Background(), mc, gitURL, "README.md")
+
+			if err != nil {
+				t.Errorf("failed to check out: %v", err)
+			} else if content != "initial\n" {
+				t.Errorf("README.md = %q, want %q", content, "initial\n")
+			}
+		}(caches[i%len(caches)])
+	}
+	wg.Wait()
+}
+
+func TestWorktreeWaitsForOtherProcess(t *testing.T) {
+	ctx := context.Background()
+	gitURL, push := newRemote(t)
+	dir := t.TempDir()
+
+	_, _, release, err := NewMirrorCache(dir).Worktree(ctx, gitURL, gitURL, nil, "master", nil, false)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	push("README.md", "updated\n")
+
+	// The mirror must not be fetched while a worktree of another process reads it
+	type result struct {
+		content string
+		err     error
+	}
+	done := make(chan result, 1)
+	go func() {
+		content, err := checkoutFile(ctx, NewMirrorCache(dir), gitURL, "README.md")
+		done <- result{content, err}
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("mirror was updated while a worktree was in use")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	release()
+
+	select {
+	case r := <-done:
+		if r.err != nil {
+			t.Fatalf("failed to check out: %v", r.err)
+		}
+
+		if r.content != "updated\n" {
+			t.Errorf("README.md = %q, want %q", r.content, "updated\n")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("worktree was not released")
+	}
+}
@@ -0,0 +1,351 @@
+package gitutil
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MISW/mischan-bot/intenral/metrics"
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/plumbing/format/idxfile"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/go-git/go-git/v5/storage/transactional"
+	"golang.org/x/xerrors"
+)
+
+const (
+	mirrorRemoteName = "origin"
+)
+
+// MirrorCache keeps bare mirrors of remote repositories on local filesystem
+// and checks them out into lightweight worktrees
+type MirrorCache struct {
+	dir string
+
+	lock  sync.Mutex
+	locks map[string]*sync.RWMutex
+}
+
+// NewMirrorCache initializes MirrorCache storing mirrors under dir
+func NewMirrorCache(dir string) *MirrorCache {
+	return &MirrorCache{
+		dir:   dir,
+		locks: map[string]*sync.RWMutex{},
+	}
+}
+
+func (mc *MirrorCache) mirrorPath(gitURL string) (string, error) {
+	u, err := url.Parse(gitURL)
+
+	if err != nil {
+		return "", xerrors.Errorf("failed to parse git url: %w", err)
+	}
+
+	name := strings.TrimSuffix(strings.Trim(u.Path, "/"), ".git")
+
+	if name == "" {
+		return "", xerrors.Errorf("git url has no repository path: %s", gitURL)
+	}
+
+	return filepath.Join(mc.dir, u.Hostname(), filepath.FromSlash(name)+".git"), nil
+}
+
+func (mc *MirrorCache) rwLock(path string) *sync.RWMutex {
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+
+	l, ok := mc.locks[path]
+
+	if !ok {
+		l = &sync.RWMutex{}
+		mc.locks[path] = l
+	}
+
+	return l
+}
+
+// update creates or fetches the mirror. The caller must hold the write lock.
+func (mc *MirrorCache) update(ctx context.Context, path, gitURL string, auth transport.AuthMethod) error {
+	repo, err := git.PlainOpen(path)
+
+	if err == git.ErrRepositoryNotExists {
+		repo, err = mc.initMirror(path, gitURL)
+	}
+
+	if err != nil {
+		return &corruptMirrorError{err: xerrors.Errorf("failed to open mirror: %w", err)}
+	}
+
+	// The config is read lazily, so a broken one would be reported as a fetch failure
+	if _, err := repo.Config(); err != nil {
+		return &corruptMirrorError{err: xerrors.Errorf("failed to read mirror config: %w", err)}
+	}
+
+	start := time.Now()
+	err = repo.FetchContext(ctx, &git.FetchOptions{
+		RemoteName: mirrorRemoteName,
+		Auth:       auth,
+		Force:      true,
+		Prune:      true,
+	})
+	metrics.ObserveGit("fetch", start)
+
+	if err != nil && err != git.NoErrAlreadyUpToDate {
+		return xerrors.Errorf("failed to fetch mirror: %w", err)
+	}
+
+	return nil
+}
+
+func (mc *MirrorCache) initMirror(path, gitURL string) (*git.Repository, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, xerrors.Errorf("failed to create mirror directory: %w", err)
+	}
+
+	repo, err := git.PlainInit(path, true)
+
+	if err != nil {
+		return nil, xerrors.Errorf("failed to initialize mirror: %w", err)
+	}
+
+	// Branches are stored as remote-tracking refs so that worktrees start without
+	// any local branch other than the one checked out.
+	if _, err := repo.CreateRemote(&config.RemoteConfig{
+		Name:  mirrorRemoteName,
+		URLs:  []string{gitURL},
+		Fetch: []config.RefSpec{"+refs/heads/*:refs/remotes/" + mirrorRemoteName + "/*"},
+	}); err != nil {
+		return nil, xerrors.Errorf("failed to configure remote for mirror: %w", err)
+	}
+
+	return repo, nil
+}
+
+// Worktree fetches the mirror for gitURL and checks ref out into a new temporary directory.
+// Objects are read from the mirror and new objects are kept in memory, so the mirror itself is never modified by the worktree.
+// Only sparseDirs are checked out if not empty, and nothing is checked out if noCheckout is true.
+// release must be called when the worktree is no longer used.
+func (mc *MirrorCache) Worktree(
+	ctx context.Context,
+	gitURL, pushURL string,
+	auth transport.AuthMethod,
+	ref string,
+	sparseDirs []string,
+	noCheckout bool,
+) (repo *git.Repository, dir string, release func(), err error) {
+	path, err := mc.mirrorPath(gitURL)
+
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	if err := mc.updateLocked(ctx, path, gitURL, auth); err != nil {
+		return nil, "", nil, err
+	}
+
+	l := mc.rwLock(path)
+	l.RLock()
+
+	unlock, err := lockFile(path+".lock", false)
+
+	if err != nil {
+		l.RUnlock()
+		return nil, "", nil, xerrors.Errorf("failed to lock mirror: %w", err)
+	}
+
+	repo, dir, err = mc.checkout(path, pushURL, ref, sparseDirs, noCheckout)
+
+	if err != nil {
+		unlock()
+		l.RUnlock()
+
+		// Objects are read from the mirror only when checked out
+		if isCorruptMirror(err) {
+			mc.removeLocked(path)
+		}
+
+		return nil, "", nil, err
+	}
+
+	return repo, dir, func() {
+		os.RemoveAll(dir)
+		unlock()
+		l.RUnlock()
+	}, nil
+}
+
+func (mc *MirrorCache) updateLocked(ctx context.Context, path, gitURL string, auth transport.AuthMethod) error {
+	l := mc.rwLock(path)
+	l.Lock()
+	defer l.Unlock()
+
+	unlock, err := lockFile(path+".lock", true)
+
+	if err != nil {
+		return xerrors.Errorf("failed to lock mirror: %w", err)
+	}
+	defer unlock()
+
+	if err := mc.update(ctx, path, gitURL, auth); err != nil {
+		// Remove a damaged mirror so that the next run starts from scratch.
+		// Network failures and cancellation leave it untouched, as cloning again would not help.
+		if isCorruptMirror(err) {
+			os.RemoveAll(path)
+		}
+
+		return xerrors.Errorf("failed to update mirror for %s: %w", gitURL, err)
+	}
+
+	return nil
+}
+
+// removeLocked removes the mirror at path so that the next run clones it again
+func (mc *MirrorCache) removeLocked(path string) {
+	l := mc.rwLock(path)
+	l.Lock()
+	defer l.Unlock()
+
+	unlock, err := lockFile(path+".lock", true)
+
+	if err != nil {
+		return
+	}
+	defer unlock()
+
+	os.RemoveAll(path)
+}
+
+// corruptMirrorError is returned when the mirror on disk cannot be opened.
+type corruptMirrorError struct {
+	err error
+}
+
+func (e *corruptMirrorError) Error() string {
+	return e.err.Error()
+}
+
+func (e *corruptMirrorError) Unwrap() error {
+	return e.err
+}
+
+// isCorruptMirror reports whether err means the objects or packfiles of a mirror are broken.
+func isCorruptMirror(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var corrupt *corruptMirrorError
+	var packErr *packfile.Error
+
+	if errors.As(err, &corrupt) || errors.As(err, &packErr) {
+		return true
+	}
+
+	for _, target := range []error{
+		plumbing.ErrObjectNotFound,
+		packfile.ErrMalformedPackFile,
+		packfile.ErrReferenceDeltaNotFound,
+		packfile.ErrInvalidDelta,
+		packfile.ErrDeltaCmd,
+		idxfile.ErrMalformedIdxFile,
+		object.ErrParentNotFound,
+	} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (mc *MirrorCache) checkout(path, pushURL, ref string, sparseDirs []string, noCheckout bool) (*git.Repository, string, error) {
+	base := filesystem.NewStorage(osfs.New(path), cache.NewObjectLRUDefault())
+
+	baseConfig, err := base.Config()
+
+	if err != nil {
+		return nil, "", xerrors.Errorf("failed to read mirror config: %w", err)
+	}
+
+	remoteRef, err := base.Reference(plumbing.NewRemoteReferenceName(mirrorRemoteName, ref))
+
+	if err != nil {
+		return nil, "", xerrors.Errorf("failed to find %s in mirror: %w", ref, err)
+	}
+
+	dir, err := os.MkdirTemp("", "mischan-bot-")
+
+	if err != nil {
+		return nil, "", xerrors.Errorf("failed to create temporary directory: %w", err)
+	}
+
+	st := transactional.NewStorage(base, memory.NewStorage())
+
+	cfg := *baseConfig
+	cfg.Core.IsBare = false
+	cfg.Remotes = map[string]*config.RemoteConfig{
+		mirrorRemoteName: {
+			Name:  mirrorRemoteName,
+			URLs:  []string{pushURL},
+			Fetch: []config.RefSpec{"+refs/heads/*:refs/remotes/" + mirrorRemoteName + "/*"},
+		},
+	}
+
+	if err := st.SetConfig(&cfg); err != nil {
+		os.RemoveAll(dir)
+		return nil, "", xerrors.Errorf("failed to configure worktree: %w", err)
+	}
+
+	branch := plumbing.NewBranchReferenceName(ref)
+
+	if err := st.SetReference(plumbing.NewHashReference(branch, remoteRef.Hash())); err != nil {
+		os.RemoveAll(dir)
+		return nil, "", xerrors.Errorf("failed to create branch %s: %w", ref, err)
+	}
+
+	if err := st.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, branch)); err != nil {
+		os.RemoveAll(dir)
+		return nil, "", xerrors.Errorf("failed to set HEAD: %w", err)
+	}
+
+	repo, err := git.Open(st, osfs.New(dir))
+
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, "", xerrors.Errorf("failed to open worktree: %w", err)
+	}
+
+	if noCheckout {
+		return repo, dir, nil
+	}
+
+	wt, err := repo.Worktree()
+
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, "", xerrors.Errorf("failed to get worktree: %w", err)
+	}
+
+	if err := wt.ResetSparsely(&git.ResetOptions{
+		Commit: remoteRef.Hash(),
+		Mode:   git.HardReset,
+	}, sparseDirs); err != nil {
+		os.RemoveAll(dir)
+		return nil, "", xerrors.Errorf("failed to check out %s: %w", ref, err)
+	}
+
+	return repo, dir, nil
+}
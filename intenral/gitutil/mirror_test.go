@@ -0,0 +1,210 @@
+package gitutil
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// newRemote creates a bare repository in a temporary directory and returns its URL with a function pushing a commit of a file to master
+func newRemote(t *testing.T) (gitURL string, push func(name, content string)) {
+	t.Helper()
+
+	remote := t.TempDir()
+
+	if _, err := git.PlainInit(remote, true); err != nil {
+		t.Fatal(err)
+	}
+
+	repo, err := git.PlainInit(t.TempDir(), false)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := repo.CreateRemote(&config.RemoteConfig{Name: "origin", URLs: []string{remote}}); err != nil {
+		t.Fatal(err)
+	}
+
+	push = func(name, content string) {
+		t.Helper()
+
+		wt, err := repo.Worktree()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(filepath.Join(wt.Filesystem.Root(), name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := wt.Add(name); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := wt.Commit("Update "+name, &git.CommitOptions{
+			Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()},
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := repo.Push(&git.PushOptions{RefSpecs: []config.RefSpec{"refs/heads/master:refs/heads/master"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	push("README.md", "initial\n")
+
+	return "file://" + remote, push
+}
+
+// checkoutFile checks master out of the mirror for gitURL and returns the content of name
+func checkoutFile(ctx context.Context, mc *MirrorCache, gitURL, name string) (string, error) {
+	_, dir, release, err := mc.Worktree(ctx, gitURL, gitURL, nil, "master", nil, false)
+
+	if err != nil {
+		return "", err
+	}
+	defer release()
+
+	b, err := os.ReadFile(filepath.Join(dir, name))
+
+	return string(b), err
+}
+
+func TestWorktreeRecoversCorruptMirror(t *testing.T) {
+	tests := []struct {
+		name    string
+		corrupt func(t *testing.T, path string)
+	}{
+		{
+			name: "broken config",
+			corrupt: func(t *testing.T, path string) {
+				if err := os.WriteFile(filepath.Join(path, "config"), []byte("[remote \"origin\"\n\turl = "), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			name: "broken packfiles",
+			corrupt: func(t *testing.T, path string) {
+				packs, err := filepath.Glob(filepath.Join(path, "objects", "pack", "*"))
+
+				if err != nil || len(packs) == 0 {
+					t.Fatalf("no packfiles in mirror: %v", err)
+				}
+
+				for _, p := range packs {
+					if err := os.WriteFile(p, []byte("broken"), 0o644); err != nil {
+						t.Fatal(err)
+					}
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			gitURL, push := newRemote(t)
+			mc := NewMirrorCache(t.TempDir())
+
+			path, err := mc.mirrorPath(gitURL)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := checkoutFile(ctx, mc, gitURL, "README.md"); err != nil {
+				t.Fatalf("failed to check out: %v", err)
+			}
+
+			tt.corrupt(t, path)
+			push("README.md", "updated\n")
+
+			// The damaged mirror is removed and cloned again on the next run
+			if _, err := checkoutFile(ctx, mc, gitURL, "README.md"); err == nil {
+				t.Fatal("expected an error from the corrupt mirror")
+			}
+
+			if _, err := os.Stat(path); !os.IsNotExist(err) {
+				t.Fatalf("corrupt mirror is not removed: %v", err)
+			}
+
+			content, err := checkoutFile(ctx, mc, gitURL, "README.md")
+
+			if err != nil {
+				t.Fatalf("failed to check out after recovery: %v", err)
+			}
+
+			if content != "updated\n" {
+				t.Errorf("README.md = %q, want %q", content, "updated\n")
+			}
+		})
+	}
+}
+
+func TestWorktreeKeepsMirror(t *testing.T) {
+	gitURL, _ := newRemote(t)
+	remote := strings.TrimPrefix(gitURL, "file://")
+	mc := NewMirrorCache(t.TempDir())
+
+	path, err := mc.mirrorPath(gitURL)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := checkoutFile(context.Background(), mc, gitURL, "README.md"); err != nil {
+		t.Fatalf("failed to check out: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		fail func(t *testing.T) (context.Context, func())
+	}{
+		{
+			name: "canceled",
+			fail: func(t *testing.T) (context.Context, func()) {
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+
+				return ctx, func() {}
+			},
+		},
+		{
+			name: "unreachable remote",
+			fail: func(t *testing.T) (context.Context, func()) {
+				if err := os.Rename(remote, remote+".moved"); err != nil {
+					t.Fatal(err)
+				}
+
+				return context.Background(), func() { os.Rename(remote+".moved", remote) }
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, restore := tt.fail(t)
+
+			_, err := checkoutFile(ctx, mc, gitURL, "README.md")
+			restore()
+
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+
+			if _, err := os.Stat(path); err != nil {
+				t.Fatalf("mirror is removed: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package history
+
+import (
+	"regexp"
+	"strconv"
+
+	"github.com/MISW/mischan-bot/intenral/trailer"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"golang.org/x/xerrors"
+)
+
+var (
+	// botCommitPattern matches subjects of commits by the bot(e.g. "Update MISW/Portal to abc1234 in staging (#12)")
+	botCommitPattern = regexp.MustCompile(`^(Update|Rollback) (\S+) to ([0-9a-f]{7})(?: in ([^\s(]+))?(?: \(#(\d+)\))?$`)
+
+	// mergeCommitPattern matches subjects of merge commits by GitHub
+	mergeCommitPattern = regexp.MustCompile(`^Merge pull request #(\d+) from [^/\s]+/(\S+)`)
+)
+
+// ParseCommit reconstructs a deployment from a commit on the base branch of the manifest repository.
+// Trailers on the commit are preferred, and subjects are parsed for commits made before they were introduced.
+// nil is returned for commits not made by the bot.
+func ParseCommit(c *object.Commit) (*Deployment, error) {
+	landed := c
+	subject := trailer.Subject(c.Message)
+	d := &Deployment{
+		Commit:     c.Hash.String(),
+		Status:     StatusMerged,
+		MergedAt:   c.Committer.When,
+		Backfilled: true,
+	}
+
+	if m := mergeCommitPattern.FindStringSubmatch(subject); m != nil {
+		if c.NumParents() < 2 {
+			return nil, nil
+		}
+
+		merged, err := c.Parent(1)
+
+		if err != nil {
+			return nil, xerrors.Errorf("failed to get merged commit of %s: %w", c.Hash, err)
+		}
+
+		d.PullRequest, _ = strconv.Atoi(m[1])
+		d.Branch = m[2]
+		d.MergedBy = c.Author.Name
+		landed = merged
+		subject = trailer.Subject(merged.Message)
+	}
+
+	m := botCommitPattern.FindStringSubmatch(subject)
+
+	if m == nil {
+		return nil, nil
+	}
+
+	d.Rollback = m[1] == "Rollback"
+	d.App = m[2]
+	d.SHA = m[3]
+	d.Environment = m[4]
+	d.OpenedAt = landed.Author.When
+
+	if m[5] != "" {
+		d.PullRequest, _ = strconv.Atoi(m[5])
+	}
+
+	// Squash merges keep the body of the original commit
+	if t, ok := trailer.Parse(landed.Message); ok {
+		if t.SourceRepository != "" {
+			d.App = t.SourceRepository
+		}
+
+		if len(t.SourceCommit) >= len(d.SHA) {
+			d.SHA = t.SourceCommit
+		}
+
+		d.Environment = t.Environment
+		d.Images = t.Images
+		d.Actor = t.Actor()
+	}
+
+	return d, nil
+}
@@ -0,0 +1,104 @@
+package history
+
+import (
+	"encoding/json"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"golang.org/x/xerrors"
+)
+
+const (
+	// deliveryRetention is the number of webhook deliveries kept in the store
+	deliveryRetention = 5000
+)
+
+var deliveriesBucket = []byte("deliveries")
+
+// Delivery is an attempt to send an event to a webhook endpoint
+type Delivery struct {
+	ID       string `json:"id"`
+	Endpoint string `json:"endpoint"`
+
+	EventID   string `json:"eventID"`
+	EventType string `json:"eventType"`
+	App       string `json:"app"`
+
+	// Attempts is the number of requests sent including retries
+	Attempts int `json:"attempts"`
+
+	// StatusCode is the status of the last response. 0 if no response was received.
+	StatusCode int    `json:"statusCode,omitempty"`
+	Error      string `json:"error,omitempty"`
+	Delivered  bool   `json:"delivered"`
+
+	CreatedAt  time.Time `json:"createdAt"`
+	FinishedAt time.Time `json:"finishedAt"`
+}
+
+// PutDelivery inserts the delivery and removes deliveries older than the latest deliveryRetention ones. ID is assigned if empty.
+func (s *Store) PutDelivery(d *Delivery) error {
+	if d.ID == "" {
+		id, err := newID(d.CreatedAt)
+
+		if err != nil {
+			return xerrors.Errorf("failed to generate ID: %w", err)
+		}
+
+		d.ID = id
+	}
+
+	b, err := json.Marshal(d)
+
+	if err != nil {
+		return xerrors.Errorf("failed to encode delivery: %w", err)
+	}
+
+	if err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(deliveriesBucket)
+
+		if err := bucket.Put([]byte(d.ID), b); err != nil {
+			return err
+		}
+
+		return prune(bucket, deliveryRetention)
+	}); err != nil {
+		return xerrors.Errorf("failed to save delivery %s: %w", d.ID, err)
+	}
+
+	return nil
+}
+
+// Deliveries returns the latest deliveries to the endpoint, newest first. Deliveries to all endpoints are returned if endpoint is empty.
+func (s *Store) Deliveries(endpoint string, limit int) ([]*Delivery, error) {
+	list := []*Delivery{}
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(deliveriesBucket).Cursor()
+
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			var d Delivery
+			if err := json.Unmarshal(v, &d); err != nil {
+				return xerrors.Errorf("failed to decode delivery %s: %w", k, err)
+			}
+
+			if endpoint != "" && d.Endpoint != endpoint {
+				continue
+			}
+
+			list = append(list, &d)
+
+			if limit != 0 && len(list) >= limit {
+				return nil
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return list, nil
+}
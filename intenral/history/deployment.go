@@ -0,0 +1,93 @@
+package history
+
+import (
+	"strings"
+	"time"
+)
+
+// Status represents the state of a deployment
+type Status string
+
+const (
+	// StatusOpened means a pull request for the deployment is open
+	StatusOpened Status = "opened"
+
+	// StatusMerged means the changes landed on the base branch of the manifest repository
+	StatusMerged Status = "merged"
+
+	// StatusClosed means the pull request was closed without merge
+	StatusClosed Status = "closed"
+
+	// StatusFailed means the changes could not be pushed to the manifest repository
+	StatusFailed Status = "failed"
+)
+
+// Deployment is an attempt to deploy a SHA of an app to an environment
+type Deployment struct {
+	ID  string `json:"id"`
+	App string `json:"app"`
+
+	// Environment is empty for apps without environments
+	Environment string `json:"environment,omitempty"`
+
+	// SHA is the source commit of the app. It is abbreviated for rollbacks and backfilled deployments.
+	SHA      string            `json:"sha"`
+	Images   map[string]string `json:"images,omitempty"`
+	Rollback bool              `json:"rollback,omitempty"`
+
+	Status Status `json:"status"`
+	Error  string `json:"error,omitempty"`
+
+	Branch      string `json:"branch,omitempty"`
+	PullRequest int    `json:"pullRequest,omitempty"`
+
+	// Commit is the commit in the manifest repository which landed on the base branch
+	Commit string `json:"commit,omitempty"`
+
+	// Actor is the user who triggered the deployment
+	Actor    string `json:"actor,omitempty"`
+	MergedBy string `json:"mergedBy,omitempty"`
+
+	OpenedAt time.Time `json:"openedAt"`
+	MergedAt time.Time `json:"mergedAt,omitempty"`
+	ClosedAt time.Time `json:"closedAt,omitempty"`
+
+	// Backfilled is true if the deployment was reconstructed from the history of the manifest repository
+	Backfilled bool `json:"backfilled,omitempty"`
+}
+
+// Query filters deployments. Empty fields match any deployments.
+type Query struct {
+	App         string
+	Environment string
+
+	// SHA matches deployments whose SHA starts with it
+	SHA string
+
+	Status Status
+
+	// Since and Until limit OpenedAt to [Since, Until)
+	Since, Until time.Time
+
+	// Limit is the maximum number of deployments returned. No limit if 0.
+	Limit int
+}
+
+func (q *Query) matches(d *Deployment) bool {
+	switch {
+	case q.App != "" && d.App != q.App:
+		return false
+	case q.Environment != "" && d.Environment != q.Environment:
+		return false
+	case q.SHA != "" && !strings.HasPrefix(d.SHA, q.SHA):
+		return false
+	case q.Status != "" && d.Status != q.Status:
+		return false
+	case !q.Since.IsZero() && d.OpenedAt.Before(q.Since):
+		return false
+	case !q.Until.IsZero() && !d.OpenedAt.Before(q.Until):
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,166 @@
+package history
+
+import (
+	"encoding/json"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"golang.org/x/xerrors"
+)
+
+const (
+	// runRetention is the number of runs kept in the store
+	runRetention = 1000
+)
+
+var (
+	runsBucket = []byte("runs")
+
+	// ErrRunNotFound is returned if no run matches
+	ErrRunNotFound = xerrors.New("run not found")
+)
+
+// Run is an attempt to deploy the latest SHA of an app which passed checks
+type Run struct {
+	ID  string `json:"id"`
+	App string `json:"app"`
+
+	// SHA is the SHA which passed checks, or the expected SHA if checks are not complete
+	SHA string `json:"sha,omitempty"`
+
+	// InstallationID is the installation of the GitHub App for the app
+	InstallationID int64 `json:"installationID"`
+
+	// Environment is the environment the promotion was started from if the run was triggered manually for it
+	Environment string `json:"environment,omitempty"`
+
+	// RetryOf is the ID of the run retried by this run
+	RetryOf string `json:"retryOf,omitempty"`
+
+	Event      string `json:"event,omitempty"`
+	Actor      string `json:"actor,omitempty"`
+	DeliveryID string `json:"deliveryID,omitempty"`
+
+	// Result is skipped, frozen, deployed or failed
+	Result string `json:"result"`
+	Error  string `json:"error,omitempty"`
+
+	StartedAt  time.Time `json:"startedAt"`
+	FinishedAt time.Time `json:"finishedAt"`
+}
+
+// PutRun inserts the run and removes runs older than the latest runRetention runs. ID is assigned if empty.
+func (s *Store) PutRun(r *Run) error {
+	if r.ID == "" {
+		id, err := newID(r.StartedAt)
+
+		if err != nil {
+			return xerrors.Errorf("failed to generate ID: %w", err)
+		}
+
+		r.ID = id
+	}
+
+	b, err := json.Marshal(r)
+
+	if err != nil {
+		return xerrors.Errorf("failed to encode run: %w", err)
+	}
+
+	if err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(runsBucket)
+
+		if err := bucket.Put([]byte(r.ID), b); err != nil {
+			return err
+		}
+
+		return prune(bucket, runRetention)
+	}); err != nil {
+		return xerrors.Errorf("failed to save run %s: %w", r.ID, err)
+	}
+
+	return nil
+}
+
+// GetRun returns the run with the ID
+func (s *Store) GetRun(id string) (*Run, error) {
+	var r *Run
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(runsBucket).Get([]byte(id))
+
+		if b == nil {
+			return xerrors.Errorf("%s: %w", id, ErrRunNotFound)
+		}
+
+		r = &Run{}
+
+		return json.Unmarshal(b, r)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Runs returns the latest runs of the app with the result, newest first.
+// Runs of all apps or with any results are returned if app or result is empty.
+func (s *Store) Runs(app, result string, limit int) ([]*Run, error) {
+	list := []*Run{}
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(runsBucket).Cursor()
+
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			var r Run
+			if err := json.Unmarshal(v, &r); err != nil {
+				return xerrors.Errorf("failed to decode run %s: %w", k, err)
+			}
+
+			if app != "" && r.App != app {
+				continue
+			}
+
+			if result != "" && r.Result != result {
+				continue
+			}
+
+			list = append(list, &r)
+
+			if limit != 0 && len(list) >= limit {
+				return nil
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return list, nil
+}
+
+// prune removes entries other than the latest retention entries in bucket
+func prune(bucket *bolt.Bucket, retention int) error {
+	var expired [][]byte
+	n := 0
+
+	c := bucket.Cursor()
+	for k, _ := c.Last(); k != nil; k, _ = c.Prev() {
+		if n++; n > retention {
+			expired = append(expired, append([]byte(nil), k...))
+		}
+	}
+
+	for _, k := range expired {
+		if err := bucket.Delete(k); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
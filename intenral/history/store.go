@@ -0,0 +1,220 @@
+package history
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"golang.org/x/xerrors"
+)
+
+var (
+	deploymentsBucket = []byte("deployments")
+
+	// ErrNotFound is returned if no deployment matches
+	ErrNotFound = xerrors.New("deployment not found")
+)
+
+// Store keeps deployments, runs and webhook deliveries in an embedded database ordered by the time they were started
+type Store struct {
+	db *bolt.DB
+
+	// temporary is removed on Close
+	temporary string
+}
+
+// NewStore opens the database at path. A temporary database is used if path is empty.
+func NewStore(path string) (*Store, error) {
+	s := &Store{}
+
+	if path == "" {
+		dir, err := os.MkdirTemp("", "mischan-bot-history-")
+
+		if err != nil {
+			return nil, xerrors.Errorf("failed to create temporary directory: %w", err)
+		}
+
+		s.temporary = dir
+		path = filepath.Join(dir, "history.db")
+	} else if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, xerrors.Errorf("failed to create directory for %s: %w", path, err)
+	}
+
+	db, err := bolt.Open(path, 0o644, &bolt.Options{Timeout: 10 * time.Second})
+
+	if err != nil {
+		return nil, xerrors.Errorf("failed to open %s: %w", path, err)
+	}
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{deploymentsBucket, runsBucket, deliveriesBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}); err != nil {
+		db.Close()
+		return nil, xerrors.Errorf("failed to initialize %s: %w", path, err)
+	}
+
+	s.db = db
+
+	return s, nil
+}
+
+// Close closes the database
+func (s *Store) Close() error {
+	err := s.db.Close()
+
+	if s.temporary != "" {
+		os.RemoveAll(s.temporary)
+	}
+
+	return err
+}
+
+// newID returns an ID sorted by t
+func newID(t time.Time) (string, error) {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return t.UTC().Format("20060102T150405.000000000Z") + "-" + hex.EncodeToString(b), nil
+}
+
+// Put inserts or updates the deployment. ID is assigned if empty.
+func (s *Store) Put(d *Deployment) error {
+	if d.ID == "" {
+		id, err := newID(d.OpenedAt)
+
+		if err != nil {
+			return xerrors.Errorf("failed to generate ID: %w", err)
+		}
+
+		d.ID = id
+	}
+
+	b, err := json.Marshal(d)
+
+	if err != nil {
+		return xerrors.Errorf("failed to encode deployment: %w", err)
+	}
+
+	if err := s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(deploymentsBucket).Put([]byte(d.ID), b)
+	}); err != nil {
+		return xerrors.Errorf("failed to save deployment %s: %w", d.ID, err)
+	}
+
+	return nil
+}
+
+// Get returns the deployment with the ID
+func (s *Store) Get(id string) (*Deployment, error) {
+	var d *Deployment
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(deploymentsBucket).Get([]byte(id))
+
+		if b == nil {
+			return xerrors.Errorf("%s: %w", id, ErrNotFound)
+		}
+
+		d = &Deployment{}
+
+		return json.Unmarshal(b, d)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+// each calls fn for deployments, newest first, until fn returns false
+func (s *Store) each(fn func(d *Deployment) bool) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(deploymentsBucket).Cursor()
+
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			var d Deployment
+			if err := json.Unmarshal(v, &d); err != nil {
+				return xerrors.Errorf("failed to decode deployment %s: %w", k, err)
+			}
+
+			if !fn(&d) {
+				return nil
+			}
+		}
+
+		return nil
+	})
+}
+
+// Find returns deployments matching the query, newest first
+func (s *Store) Find(q Query) ([]*Deployment, error) {
+	list := []*Deployment{}
+
+	err := s.each(func(d *Deployment) bool {
+		if q.matches(d) {
+			list = append(list, d)
+		}
+
+		return q.Limit == 0 || len(list) < q.Limit
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return list, nil
+}
+
+// Current returns the deployment of the app which was live in the environment at t
+func (s *Store) Current(app, environment string, t time.Time) (*Deployment, error) {
+	var current *Deployment
+
+	err := s.each(func(d *Deployment) bool {
+		if d.App != app || d.Environment != environment || d.Status != StatusMerged || d.MergedAt.After(t) {
+			return true
+		}
+
+		if current == nil || d.MergedAt.After(current.MergedAt) {
+			current = d
+		}
+
+		return true
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if current == nil {
+		return nil, xerrors.Errorf("%s %s at %s: %w", app, environment, t, ErrNotFound)
+	}
+
+	return current, nil
+}
+
+// Merged returns true if a deployment of the app to the environment with SHA starting with sha was merged
+func (s *Store) Merged(app, environment, sha string) (bool, error) {
+	found := false
+
+	err := s.each(func(d *Deployment) bool {
+		found = d.App == app && d.Environment == environment && strings.HasPrefix(d.SHA, sha) && d.Status == StatusMerged
+
+		return !found
+	})
+
+	return found, err
+}
@@ -0,0 +1,147 @@
+package kustomize
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-git/v5/utils/diff"
+	"github.com/sergi/go-diff/diffmatchpatch"
+	"golang.org/x/xerrors"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	diffContextLines = 3
+)
+
+// ResourceDiff is a difference of a rendered resource
+type ResourceDiff struct {
+	// Resource identifies the resource(e.g. Deployment/portal/frontend)
+	Resource string
+
+	// Diff is the difference in unified diff format
+	Diff string
+}
+
+type resourceHeader struct {
+	Kind     string `yaml:"kind"`
+	Metadata struct {
+		Name      string `yaml:"name"`
+		Namespace string `yaml:"namespace"`
+	} `yaml:"metadata"`
+}
+
+// SplitResources splits output of kustomize build into resources
+func SplitResources(rendered []byte) (map[string]string, error) {
+	resources := map[string]string{}
+
+	for _, doc := range bytes.Split(rendered, []byte("\n---\n")) {
+		doc = bytes.TrimPrefix(doc, []byte("---\n"))
+
+		if len(bytes.TrimSpace(doc)) == 0 {
+			continue
+		}
+
+		var h resourceHeader
+		if err := yaml.Unmarshal(doc, &h); err != nil {
+			return nil, xerrors.Errorf("failed to parse rendered resource: %w", err)
+		}
+
+		resources[resourceName(h)] = strings.TrimSuffix(string(doc), "\n") + "\n"
+	}
+
+	return resources, nil
+}
+
+func resourceName(h resourceHeader) string {
+	if h.Metadata.Namespace == "" {
+		return h.Kind + "/" + h.Metadata.Name
+	}
+
+	return h.Kind + "/" + h.Metadata.Namespace + "/" + h.Metadata.Name
+}
+
+// DiffResources returns differences of changed, added or removed resources sorted by name
+func DiffResources(before, after map[string]string) []ResourceDiff {
+	names := map[string]struct{}{}
+	for name := range before {
+		names[name] = struct{}{}
+	}
+	for name := range after {
+		names[name] = struct{}{}
+	}
+
+	var diffs []ResourceDiff
+	for name := range names {
+		if before[name] == after[name] {
+			continue
+		}
+
+		diffs = append(diffs, ResourceDiff{
+			Resource: name,
+			Diff:     unifiedDiff(before[name], after[name]),
+		})
+	}
+
+	sort.Slice(diffs, func(i, j int) bool {
+		return diffs[i].Resource < diffs[j].Resource
+	})
+
+	return diffs
+}
+
+type diffLine struct {
+	op   byte
+	text string
+}
+
+// unifiedDiff formats a line oriented diff with a few lines of context around changes
+func unifiedDiff(src, dst string) string {
+	var lines []diffLine
+	for _, d := range diff.Do(src, dst) {
+		op := byte(' ')
+		switch d.Type {
+		case diffmatchpatch.DiffInsert:
+			op = '+'
+		case diffmatchpatch.DiffDelete:
+			op = '-'
+		}
+
+		for _, l := range strings.SplitAfter(d.Text, "\n") {
+			if l == "" {
+				continue
+			}
+
+			lines = append(lines, diffLine{op: op, text: strings.TrimSuffix(l, "\n")})
+		}
+	}
+
+	var b strings.Builder
+	lastPrinted := -1
+	for i, l := range lines {
+		if !nearChange(lines, i) {
+			continue
+		}
+
+		if lastPrinted != -1 && lastPrinted != i-1 {
+			b.WriteString("@@\n")
+		}
+
+		fmt.Fprintf(&b, "%c %s\n", l.op, l.text)
+		lastPrinted = i
+	}
+
+	return b.String()
+}
+
+func nearChange(lines []diffLine, i int) bool {
+	for j := i - diffContextLines; j <= i+diffContextLines; j++ {
+		if j >= 0 && j < len(lines) && lines[j].op != ' ' {
+			return true
+		}
+	}
+
+	return false
+}
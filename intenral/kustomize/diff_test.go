@@ -0,0 +1,116 @@
+package kustomize
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitResources(t *testing.T) {
+	tests := []struct {
+		name     string
+		rendered string
+		want     map[string]string
+	}{
+		{
+			name:     "namespaced and cluster resources",
+			rendered: "apiVersion: v1\nkind: Namespace\nmetadata:\n  name: portal\n---\napiVersion: v1\nkind: Service\nmetadata:\n  name: frontend\n  namespace: portal\n",
+			want: map[string]string{
+				"Namespace/portal":        "apiVersion: v1\nkind: Namespace\nmetadata:\n  name: portal\n",
+				"Service/portal/frontend": "apiVersion: v1\nkind: Service\nmetadata:\n  name: frontend\n  namespace: portal\n",
+			},
+		},
+		{
+			name:     "leading separator and empty documents",
+			rendered: "---\nkind: ConfigMap\nmetadata:\n  name: env\n---\n\n---\nkind: Secret\nmetadata:\n  name: env",
+			want: map[string]string{
+				"ConfigMap/env": "kind: ConfigMap\nmetadata:\n  name: env\n",
+				"Secret/env":    "kind: Secret\nmetadata:\n  name: env\n",
+			},
+		},
+		{
+			name:     "empty",
+			rendered: "",
+			want:     map[string]string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := SplitResources([]byte(tt.rendered))
+
+			if err != nil {
+				t.Fatalf("SplitResources failed: %v", err)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SplitResources() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := SplitResources([]byte("kind: [")); err == nil {
+		t.Error("invalid resource is accepted")
+	}
+}
+
+func TestDiffResources(t *testing.T) {
+	deployment := func(tag string) string {
+		return "kind: Deployment\nmetadata:\n  name: frontend\nspec:\n  replicas: 2\n  template:\n    spec:\n      containers:\n      - name: frontend\n        image: registry.misw.jp/portal/frontend:" + tag + "\n        ports:\n        - containerPort: 80\n"
+	}
+
+	tests := []struct {
+		name          string
+		before, after map[string]string
+		want          []ResourceDiff
+	}{
+		{
+			name:   "unchanged",
+			before: map[string]string{"Deployment/frontend": deployment("sha-0000000")},
+			after:  map[string]string{"Deployment/frontend": deployment("sha-0000000")},
+			want:   nil,
+		},
+		{
+			name:   "changed with context",
+			before: map[string]string{"Deployment/frontend": deployment("sha-0000000")},
+			after:  map[string]string{"Deployment/frontend": deployment("sha-1111111")},
+			want: []ResourceDiff{{
+				Resource: "Deployment/frontend",
+				Diff: "      spec:\n" +
+					"        containers:\n" +
+					"        - name: frontend\n" +
+					"-         image: registry.misw.jp/portal/frontend:sha-0000000\n" +
+					"+         image: registry.misw.jp/portal/frontend:sha-1111111\n" +
+					"          ports:\n" +
+					"          - containerPort: 80\n",
+			}},
+		},
+		{
+			name:   "added and removed sorted by name",
+			before: map[string]string{"Service/old": "kind: Service\n"},
+			after:  map[string]string{"ConfigMap/new": "kind: ConfigMap\n"},
+			want: []ResourceDiff{
+				{Resource: "ConfigMap/new", Diff: "+ kind: ConfigMap\n"},
+				{Resource: "Service/old", Diff: "- kind: Service\n"},
+			},
+		},
+		{
+			name:   "distant changes in separate hunks",
+			before: map[string]string{"ConfigMap/env": "a: 1\nb: 1\nc: 1\nd: 1\ne: 1\nf: 1\ng: 1\nh: 1\ni: 1\nj: 1\n"},
+			after:  map[string]string{"ConfigMap/env": "a: 2\nb: 1\nc: 1\nd: 1\ne: 1\nf: 1\ng: 1\nh: 1\ni: 1\nj: 2\n"},
+			want: []ResourceDiff{{
+				Resource: "ConfigMap/env",
+				Diff:     "- a: 1\n+ a: 2\n  b: 1\n  c: 1\n  d: 1\n@@\n  g: 1\n  h: 1\n  i: 1\n- j: 1\n+ j: 2\n",
+			}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := DiffResources(tt.before, tt.after)
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DiffResources() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package kustomize
+
+import (
+	"path"
+
+	"golang.org/x/xerrors"
+	"gopkg.in/yaml.v3"
+)
+
+// image is an entry of images in kustomization.yaml
+type image struct {
+	Name    string `yaml:"name"`
+	NewName string `yaml:"newName"`
+	NewTag  string `yaml:"newTag"`
+	Digest  string `yaml:"digest"`
+}
+
+// KustomizationPaths returns candidates of the kustomization file in dir
+func KustomizationPaths(dir string) []string {
+	paths := make([]string, 0, len(kustomizationFiles))
+	for _, k := range kustomizationFiles {
+		paths = append(paths, path.Join(dir, k))
+	}
+
+	return paths
+}
+
+// ImageTags returns tags set by images in a kustomization file by image name
+func ImageTags(kustomization []byte) (map[string]string, error) {
+	var k struct {
+		Images []image `yaml:"images"`
+	}
+
+	if err := yaml.Unmarshal(kustomization, &k); err != nil {
+		return nil, xerrors.Errorf("failed to parse kustomization: %w", err)
+	}
+
+	tags := make(map[string]string, len(k.Images))
+	for _, img := range k.Images {
+		tags[img.Name] = img.NewTag
+	}
+
+	return tags, nil
+}
@@ -0,0 +1,257 @@
+package kustomize
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/MISW/mischan-bot/intenral/tracing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/xerrors"
+	"gopkg.in/yaml.v3"
+)
+
+var kustomizationFiles = []string{"kustomization.yaml", "kustomization.yml", "Kustomization"}
+
+// kustomization is a subset of kustomization.yaml to resolve dependencies between directories
+type kustomization struct {
+	Resources  []string `yaml:"resources"`
+	Bases      []string `yaml:"bases"`
+	Components []string `yaml:"components"`
+}
+
+// Tree is a snapshot of the manifest repository exported on local filesystem
+type Tree struct {
+	dir string
+}
+
+// Export writes all files in the commit into a new temporary directory
+// Close must be called to remove the directory.
+func Export(commit *object.Commit) (*Tree, error) {
+	dir, err := os.MkdirTemp("", "mischan-bot-kustomize-")
+
+	if err != nil {
+		return nil, xerrors.Errorf("failed to create temporary directory: %w", err)
+	}
+
+	t := &Tree{dir: dir}
+
+	files, err := commit.Files()
+
+	if err != nil {
+		t.Close()
+		return nil, xerrors.Errorf("failed to list files in %s: %w", commit.Hash, err)
+	}
+
+	err = files.ForEach(func(f *object.File) error {
+		if !f.Mode.IsFile() {
+			return nil
+		}
+
+		dst := filepath.Join(dir, filepath.FromSlash(f.Name))
+
+		if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+			return err
+		}
+
+		r, err := f.Reader()
+
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+
+		w, err := os.Create(dst)
+
+		if err != nil {
+			return err
+		}
+		defer w.Close()
+
+		_, err = io.Copy(w, r)
+
+		return err
+	})
+
+	if err != nil {
+		t.Close()
+		return nil, xerrors.Errorf("failed to export %s: %w", commit.Hash, err)
+	}
+
+	return t, nil
+}
+
+// Dir returns the root directory of the tree
+func (t *Tree) Dir() string {
+	return t.dir
+}
+
+// Close removes the exported files
+func (t *Tree) Close() error {
+	return os.RemoveAll(t.dir)
+}
+
+// kustomizations returns dependencies of each kustomization directory relative to the root
+func (t *Tree) kustomizations() (map[string][]string, error) {
+	deps := map[string][]string{}
+
+	err := filepath.WalkDir(t.dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() || !isKustomizationFile(d.Name()) {
+			return nil
+		}
+
+		b, err := os.ReadFile(p)
+
+		if err != nil {
+			return err
+		}
+
+		var k kustomization
+		if err := yaml.Unmarshal(b, &k); err != nil {
+			return xerrors.Errorf("failed to parse %s: %w", p, err)
+		}
+
+		rel, err := filepath.Rel(t.dir, filepath.Dir(p))
+
+		if err != nil {
+			return err
+		}
+		dir := filepath.ToSlash(rel)
+
+		var list []string
+		for _, r := range append(append(k.Resources, k.Bases...), k.Components...) {
+			if isRemote(r) {
+				continue
+			}
+
+			list = append(list, path.Clean(path.Join(dir, r)))
+		}
+
+		deps[dir] = list
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, xerrors.Errorf("failed to find kustomizations: %w", err)
+	}
+
+	return deps, nil
+}
+
+// Overlays returns kustomization directories that are not included by other kustomizations and depend on any of paths
+func (t *Tree) Overlays(paths []string) ([]string, error) {
+	deps, err := t.kustomizations()
+
+	if err != nil {
+		return nil, err
+	}
+
+	included := map[string]bool{}
+	for _, list := range deps {
+		for _, d := range list {
+			included[d] = true
+		}
+	}
+
+	var overlays []string
+	for dir := range deps {
+		if included[dir] {
+			continue
+		}
+
+		if dependsOn(deps, dir, paths, map[string]bool{}) {
+			overlays = append(overlays, dir)
+		}
+	}
+
+	sort.Strings(overlays)
+
+	return overlays, nil
+}
+
+func dependsOn(deps map[string][]string, dir string, paths []string, visited map[string]bool) bool {
+	if visited[dir] {
+		return false
+	}
+	visited[dir] = true
+
+	for _, p := range paths {
+		if contains(dir, p) {
+			return true
+		}
+	}
+
+	for _, d := range deps[dir] {
+		if _, ok := deps[d]; ok {
+			if dependsOn(deps, d, paths, visited) {
+				return true
+			}
+
+			continue
+		}
+
+		// A resource file
+		for _, p := range paths {
+			if d == path.Clean(p) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Build runs kustomize build for the directory relative to the root
+func (t *Tree) Build(ctx context.Context, dir string) (_ []byte, err error) {
+	ctx, span := tracing.Start(ctx, "kustomize.Build", attribute.String("dir", dir))
+	defer func() { tracing.End(span, err) }()
+
+	cmd := exec.CommandContext(ctx, "kustomize", "build", filepath.FromSlash(dir))
+	cmd.Dir = t.dir
+
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+
+	b, err := cmd.Output()
+
+	if err != nil {
+		return nil, xerrors.Errorf("failed to build %s(%s): %w", dir, stderr.String(), err)
+	}
+
+	return b, nil
+}
+
+func isKustomizationFile(name string) bool {
+	for _, k := range kustomizationFiles {
+		if name == k {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isRemote(resource string) bool {
+	return strings.Contains(resource, "://") ||
+		strings.HasPrefix(resource, "github.com/") ||
+		strings.Contains(resource, "?ref=")
+}
+
+// contains returns true if p is dir or a file under dir
+func contains(dir, p string) bool {
+	p = path.Clean(p)
+
+	return dir == "." || p == dir || strings.HasPrefix(p, dir+"/")
+}
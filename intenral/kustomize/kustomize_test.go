@@ -0,0 +1,91 @@
+package kustomize
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// newTestTree writes files into a temporary directory and returns the tree of them
+func newTestTree(t *testing.T, files map[string]string) *Tree {
+	t.Helper()
+
+	dir := t.TempDir()
+
+	for name, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return &Tree{dir: dir}
+}
+
+func TestOverlays(t *testing.T) {
+	tree := newTestTree(t, map[string]string{
+		"bases/portal/kustomization.yaml":        "resources:\n- deployment.yaml\n- service.yaml\n",
+		"bases/portal/deployment.yaml":           "",
+		"bases/portal/service.yaml":              "",
+		"bases/modoki/kustomization.yml":         "resources:\n- deployment.yaml\n",
+		"bases/modoki/deployment.yaml":           "",
+		"components/tls/kustomization.yaml":      "resources:\n- certificate.yaml\n",
+		"components/tls/certificate.yaml":        "",
+		"overlays/staging/kustomization.yaml":    "resources:\n- ../../bases/portal\n- https://github.com/MISW/remote//base?ref=v1\n",
+		"overlays/prod/kustomization.yaml":       "bases:\n- ../../bases/portal\n- ../../bases/modoki\ncomponents:\n- ../../components/tls\n",
+		"overlays/modoki/Kustomization":          "resources:\n- ../../bases/modoki\n- ingress.yaml\n",
+		"overlays/modoki/ingress.yaml":           "",
+		"overlays/standalone/kustomization.yaml": "resources:\n- configmap.yaml\n",
+		"overlays/standalone/configmap.yaml":     "",
+
+		// Kustomizations including each other must not loop
+		"overlays/cyclic/kustomization.yaml": "resources:\n- ../../cycle/a\n",
+		"cycle/a/kustomization.yaml":         "resources:\n- ../b\n",
+		"cycle/b/kustomization.yaml":         "resources:\n- ../a\n",
+	})
+
+	tests := []struct {
+		name  string
+		paths []string
+		want  []string
+	}{
+		{"base", []string{"bases/portal"}, []string{"overlays/prod", "overlays/staging"}},
+		{"resource file in base", []string{"bases/modoki/deployment.yaml"}, []string{"overlays/modoki", "overlays/prod"}},
+		{"component", []string{"components/tls/certificate.yaml"}, []string{"overlays/prod"}},
+		{"resource file in overlay", []string{"overlays/modoki/ingress.yaml"}, []string{"overlays/modoki"}},
+		{"through cycle", []string{"cycle/b/configmap.yaml"}, []string{"overlays/cyclic"}},
+		{"unclean path", []string{"bases/portal/../portal/"}, []string{"overlays/prod", "overlays/staging"}},
+		{"multiple paths", []string{"bases/modoki", "overlays/standalone"}, []string{"overlays/modoki", "overlays/prod", "overlays/standalone"}},
+		{"unrelated", []string{"README.md"}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tree.Overlays(tt.paths)
+
+			if err != nil {
+				t.Fatalf("Overlays failed: %v", err)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Overlays(%v) = %v, want %v", tt.paths, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOverlaysWithInvalidKustomization(t *testing.T) {
+	tree := newTestTree(t, map[string]string{
+		"bases/portal/kustomization.yaml": "resources: [",
+	})
+
+	if _, err := tree.Overlays([]string{"bases/portal"}); err == nil {
+		t.Error("invalid kustomization is accepted")
+	}
+}
@@ -0,0 +1,76 @@
+package kustomize
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+// RenderTemplate copies files in src into dst replacing placeholders in them with r
+func RenderTemplate(src, dst string, r *strings.Replacer) error {
+	err := filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, p)
+
+		if err != nil {
+			return err
+		}
+
+		target := filepath.Join(dst, rel)
+
+		if d.IsDir() {
+			return os.MkdirAll(target, 0o755)
+		}
+
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		b, err := os.ReadFile(p)
+
+		if err != nil {
+			return err
+		}
+
+		return os.WriteFile(target, []byte(r.Replace(string(b))), 0o644)
+	})
+
+	if err != nil {
+		return xerrors.Errorf("failed to render template %s into %s: %w", src, dst, err)
+	}
+
+	return nil
+}
+
+// SetImages sets tags of images by name in the kustomization in dir
+func SetImages(ctx context.Context, dir string, images map[string]string) error {
+	names := make([]string, 0, len(images))
+	for name := range images {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		cmd := exec.CommandContext(
+			ctx, "kustomize", "edit", "set", "image", name+":"+images[name],
+		)
+		cmd.Dir = dir
+
+		b, err := cmd.CombinedOutput()
+
+		if err != nil {
+			return xerrors.Errorf("failed to kustomize(%s): %w", string(b), err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,78 @@
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+
+	"github.com/MISW/mischan-bot/intenral/trigger"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// New initializes a logger writing JSON lines to w at level.
+// Records logged with contexts carry attributes added by With, the trigger of the operation and the current span.
+func New(w io.Writer, level slog.Leveler) *slog.Logger {
+	return slog.New(&contextHandler{
+		Handler: slog.NewJSONHandler(w, &slog.HandlerOptions{Level: level}),
+	})
+}
+
+type contextKey struct{}
+
+// With returns a copy of ctx with attributes added to records logged with it.
+// args are key-value pairs or slog.Attr as in slog.Logger.With.
+func With(ctx context.Context, args ...any) context.Context {
+	var r slog.Record
+	r.Add(args...)
+
+	parent, _ := ctx.Value(contextKey{}).([]slog.Attr)
+
+	attrs := make([]slog.Attr, 0, len(parent)+r.NumAttrs())
+	attrs = append(attrs, parent...)
+	r.Attrs(func(a slog.Attr) bool {
+		attrs = append(attrs, a)
+
+		return true
+	})
+
+	return context.WithValue(ctx, contextKey{}, attrs)
+}
+
+// contextHandler adds attributes in contexts to records
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h *contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	t := trigger.From(ctx)
+
+	if t.DeliveryID != "" {
+		r.AddAttrs(slog.String("delivery", t.DeliveryID))
+	}
+
+	if t.Event != "" {
+		r.AddAttrs(slog.String("event", t.Event))
+	}
+
+	if t.Actor != "" {
+		r.AddAttrs(slog.String("actor", t.Actor))
+	}
+
+	if attrs, ok := ctx.Value(contextKey{}).([]slog.Attr); ok {
+		r.AddAttrs(attrs...)
+	}
+
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		r.AddAttrs(slog.String("trace_id", sc.TraceID().String()), slog.String("span_id", sc.SpanID().String()))
+	}
+
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &contextHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h *contextHandler) WithGroup(name string) slog.Handler {
+	return &contextHandler{Handler: h.Handler.WithGroup(name)}
+}
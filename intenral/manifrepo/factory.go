@@ -0,0 +1,50 @@
+package manifrepo
+
+import (
+	"context"
+
+	"github.com/MISW/mischan-bot/intenral/audit"
+	"github.com/MISW/mischan-bot/intenral/ghsink"
+	"github.com/MISW/mischan-bot/intenral/gitutil"
+	"github.com/MISW/mischan-bot/intenral/schema"
+)
+
+// Factory initializes ManifestManipulator with settings shared by all app repositories
+type Factory struct {
+	RepoName                    string
+	CommiterEmail, CommiterName string
+
+	Mirrors   *gitutil.MirrorCache
+	Validator *schema.Validator
+	Audit     *audit.Log
+
+	ghs *ghsink.GitHubSink
+}
+
+// NewFactory initializes a factory of ManifestManipulator for the manifest repository(e.g. MISW/k8s)
+func NewFactory(ghs *ghsink.GitHubSink, repoName string) *Factory {
+	return &Factory{
+		RepoName:      repoName,
+		CommiterName:  "mischan-bot",
+		CommiterEmail: "mischan-bot@users.noreply.github.com",
+
+		ghs: ghs,
+	}
+}
+
+// New initializes ManifestManipulator
+func (f *Factory) New(ctx context.Context) (*ManifestManipulator, error) {
+	mm, err := NewManifestManipulator(ctx, f.ghs, f.RepoName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	mm.CommiterName = f.CommiterName
+	mm.CommiterEmail = f.CommiterEmail
+	mm.Mirrors = f.Mirrors
+	mm.Validator = f.Validator
+	mm.Audit = f.Audit
+
+	return mm, nil
+}
@@ -0,0 +1,260 @@
+package manifrepo
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/MISW/mischan-bot/intenral/gitutil"
+	"github.com/MISW/mischan-bot/intenral/kustomize"
+	"github.com/MISW/mischan-bot/intenral/trailer"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"golang.org/x/xerrors"
+)
+
+// ImageTag is a tag of an image set by a commit in the manifest repository
+type ImageTag struct {
+	Tag    string
+	Commit string
+	Time   time.Time
+
+	// SourceCommit is the full SHA of the app in the trailers of Commit if any
+	SourceCommit string
+}
+
+// ImageHistory returns at most limit tags of image set in the kustomization file in dir on the base branch, newest first.
+// Each tag is reported with the oldest commit of consecutive commits with the same tag, i.e. the commit which deployed it.
+func (mm *ManifestManipulator) ImageHistory(ctx context.Context, dir, image string, limit int) ([]ImageTag, error) {
+	ghu := gitutil.NewGitHubUtil(mm.token, mm.client)
+
+	gitrepo, _, release, err := ghu.CheckoutRepository(
+		ctx,
+		mm.Mirrors,
+		fmt.Sprintf("https://github.com/%s/%s.git", mm.owner, mm.repo),
+		mm.BaseBranch,
+		&gitutil.CloneOptions{
+			Paths: []string{dir},
+		},
+	)
+
+	if err != nil {
+		return nil, xerrors.Errorf("failed to clone repository: %w", err)
+	}
+	defer release()
+
+	head, err := gitrepo.Head()
+
+	if err != nil {
+		return nil, xerrors.Errorf("failed to get HEAD of %s: %w", mm.BaseBranch, err)
+	}
+
+	headCommit, err := gitrepo.CommitObject(head.Hash())
+
+	if err != nil {
+		return nil, xerrors.Errorf("failed to get commit %s: %w", head.Hash(), err)
+	}
+
+	var file string
+	for _, p := range kustomize.KustomizationPaths(dir) {
+		if _, err := headCommit.File(p); err == nil {
+			file = p
+			break
+		}
+	}
+
+	if file == "" {
+		return nil, xerrors.Errorf("no kustomization file in %s", dir)
+	}
+
+	iter, err := gitrepo.Log(&git.LogOptions{
+		From:     head.Hash(),
+		FileName: &file,
+	})
+
+	if err != nil {
+		return nil, xerrors.Errorf("failed to get log of %s: %w", file, err)
+	}
+	defer iter.Close()
+
+	var history []ImageTag
+	err = iter.ForEach(func(c *object.Commit) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		f, err := c.File(file)
+
+		if xerrors.Is(err, object.ErrFileNotFound) {
+			return storer.ErrStop
+		}
+
+		if err != nil {
+			return xerrors.Errorf("failed to get %s in %s: %w", file, c.Hash, err)
+		}
+
+		content, err := f.Contents()
+
+		if err != nil {
+			return xerrors.Errorf("failed to read %s in %s: %w", file, c.Hash, err)
+		}
+
+		tags, err := kustomize.ImageTags([]byte(content))
+
+		if err != nil {
+			return xerrors.Errorf("invalid %s in %s: %w", file, c.Hash, err)
+		}
+
+		tag, ok := tags[image]
+
+		if !ok {
+			return storer.ErrStop
+		}
+
+		entry := ImageTag{
+			Tag:    tag,
+			Commit: c.Hash.String(),
+			Time:   c.Committer.When,
+		}
+
+		if t, ok := trailer.Parse(c.Message); ok {
+			entry.SourceCommit = t.SourceCommit
+		}
+
+		if n := len(history); n != 0 && history[n-1].Tag == tag {
+			history[n-1] = entry
+			return nil
+		}
+
+		if len(history) == limit {
+			return storer.ErrStop
+		}
+
+		history = append(history, entry)
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, xerrors.Errorf("failed to walk history of %s: %w", file, err)
+	}
+
+	return history, nil
+}
+
+// ImageTags returns tags of images set in the kustomization file in dir on the base branch by image name
+func (mm *ManifestManipulator) ImageTags(ctx context.Context, dir string) (map[string]string, error) {
+	ghu := gitutil.NewGitHubUtil(mm.token, mm.client)
+
+	gitrepo, _, release, err := ghu.CheckoutRepository(
+		ctx,
+		mm.Mirrors,
+		fmt.Sprintf("https://github.com/%s/%s.git", mm.owner, mm.repo),
+		mm.BaseBranch,
+		&gitutil.CloneOptions{
+			Depth: 1,
+			Paths: []string{dir},
+		},
+	)
+
+	if err != nil {
+		return nil, xerrors.Errorf("failed to clone repository: %w", err)
+	}
+	defer release()
+
+	head, err := gitrepo.Head()
+
+	if err != nil {
+		return nil, xerrors.Errorf("failed to get HEAD of %s: %w", mm.BaseBranch, err)
+	}
+
+	headCommit, err := gitrepo.CommitObject(head.Hash())
+
+	if err != nil {
+		return nil, xerrors.Errorf("failed to get commit %s: %w", head.Hash(), err)
+	}
+
+	for _, p := range kustomize.KustomizationPaths(dir) {
+		f, err := headCommit.File(p)
+
+		if xerrors.Is(err, object.ErrFileNotFound) {
+			continue
+		}
+
+		if err != nil {
+			return nil, xerrors.Errorf("failed to get %s: %w", p, err)
+		}
+
+		content, err := f.Contents()
+
+		if err != nil {
+			return nil, xerrors.Errorf("failed to read %s: %w", p, err)
+		}
+
+		tags, err := kustomize.ImageTags([]byte(content))
+
+		if err != nil {
+			return nil, xerrors.Errorf("invalid %s: %w", p, err)
+		}
+
+		return tags, nil
+	}
+
+	return nil, xerrors.Errorf("no kustomization file in %s", dir)
+}
+
+// WalkHistory calls fn for at most limit commits on the first-parent chain of the base branch, newest first.
+// fn can return storer.ErrStop to stop walking.
+func (mm *ManifestManipulator) WalkHistory(ctx context.Context, limit int, fn func(c *object.Commit) error) error {
+	ghu := gitutil.NewGitHubUtil(mm.token, mm.client)
+
+	gitrepo, _, release, err := ghu.CheckoutRepository(
+		ctx,
+		mm.Mirrors,
+		fmt.Sprintf("https://github.com/%s/%s.git", mm.owner, mm.repo),
+		mm.BaseBranch,
+		&gitutil.CloneOptions{
+			NoCheckout: true,
+		},
+	)
+
+	if err != nil {
+		return xerrors.Errorf("failed to clone repository: %w", err)
+	}
+	defer release()
+
+	head, err := gitrepo.Head()
+
+	if err != nil {
+		return xerrors.Errorf("failed to get HEAD of %s: %w", mm.BaseBranch, err)
+	}
+
+	c, err := gitrepo.CommitObject(head.Hash())
+
+	for i := 0; i < limit; i++ {
+		if err != nil {
+			return xerrors.Errorf("failed to get commit: %w", err)
+		}
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if err := fn(c); err != nil {
+			if xerrors.Is(err, storer.ErrStop) {
+				return nil
+			}
+
+			return err
+		}
+
+		if c.NumParents() == 0 {
+			return nil
+		}
+
+		c, err = c.Parent(0)
+	}
+
+	return nil
+}
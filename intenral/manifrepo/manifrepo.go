@@ -3,27 +3,78 @@ package manifrepo
 import (
 	"context"
 	"fmt"
-	"log"
-	"os"
+	"log/slog"
+	"net/http"
+	"path"
+	"regexp"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/MISW/mischan-bot/intenral/audit"
 	"github.com/MISW/mischan-bot/intenral/ghsink"
 	"github.com/MISW/mischan-bot/intenral/gitutil"
+	"github.com/MISW/mischan-bot/intenral/metrics"
+	"github.com/MISW/mischan-bot/intenral/schema"
+	"github.com/MISW/mischan-bot/intenral/tracing"
+	"github.com/MISW/mischan-bot/intenral/trailer"
+	"github.com/MISW/mischan-bot/intenral/trigger"
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/config"
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/object"
 	"github.com/google/go-github/v55/github"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/xerrors"
 )
 
+const (
+	// directCommitAttempts is the number of times CommitDirectly tries to push onto the base branch
+	directCommitAttempts = 3
+)
+
+var (
+	// ErrRemoteBranchMoved is returned if a branch in the manifest repository was updated by someone else during a run
+	ErrRemoteBranchMoved = xerrors.New("remote branch moved")
+)
+
+// rejectedPush matches errors of pushes rejected because the remote branch is not the expected one.
+// go-git reports them only as messages, from its own checks or from the remote.
+var rejectedPush = regexp.MustCompile(`non-fast-forward|remote ref \S+ required to be|fetch first|stale info|cannot lock ref`)
+
+// testHookBeforePush is called between checking the remote branch and pushing to it in tests
+var testHookBeforePush = func() {}
+
+// Manipulator updates manifests in a checked out manifest repository
+type Manipulator struct {
+	// Paths are directories in the manifest repository Apply modifies.
+	// Only these directories are checked out and changes outside of them are rejected.
+	// The whole repository is checked out if empty.
+	Paths []string
+
+	// Policy restricts changes by Apply further if set
+	Policy *PathPolicy
+
+	// Apply updates manifests in dir, the root of the checked out manifest repository
+	Apply func(ctx context.Context, dir string) error
+}
+
 // ManifestManipulator is a utility for manifest repository
 type ManifestManipulator struct {
 	BaseBranch                  string
 	CommiterEmail, CommiterName string
 
+	// Mirrors is used to check out the manifest repository if set
+	Mirrors *gitutil.MirrorCache
+
+	// Validator validates rendered manifests against JSON schemas if set
+	Validator *schema.Validator
+
+	// Audit records pushes if set
+	Audit *audit.Log
+
 	ghs    *ghsink.GitHubSink
 	client *github.Client
 
@@ -122,13 +173,13 @@ func (mm *ManifestManipulator) CloseObsoletePRs(ctx context.Context, branchPrefi
 			)
 
 			if err != nil {
-				log.Printf("failed to close pull request %d for %s/%s: %+v", pr.GetNumber(), mm.owner, mm.repo, err)
+				slog.ErrorContext(ctx, "failed to close pull request", "repo", mm.owner+"/"+mm.repo, "pullRequest", pr.GetNumber(), "error", err)
 			}
 
 			_, err = mm.client.Git.DeleteRef(ctx, mm.owner, mm.repo, "heads/"+pr.GetHead().GetRef())
 
 			if err != nil {
-				log.Printf("failed to delete branch for pull requesst %d for %s/%s: %+v", pr.GetNumber(), mm.owner, mm.repo, err)
+				slog.ErrorContext(ctx, "failed to delete branch for pull request", "repo", mm.owner+"/"+mm.repo, "pullRequest", pr.GetNumber(), "error", err)
 			}
 		}(obsoletePRs[i])
 	}
@@ -153,66 +204,337 @@ func (mm *ManifestManipulator) getLatestSHA(ctx context.Context) error {
 func (mm *ManifestManipulator) CreatePullRequest(
 	ctx context.Context,
 	branchName, commitMessage string,
-	manipulator func(ctx context.Context, dir string) error,
-) error {
+	manipulator Manipulator,
+) (err error) {
+	ctx, span := tracing.Start(ctx, "ManifestManipulator.CreatePullRequest", attribute.String("repo", mm.owner+"/"+mm.repo), attribute.String("branch", branchName))
+	defer func() { tracing.End(span, err) }()
+
+	if branchName == mm.BaseBranch {
+		return xerrors.Errorf("refusing to create a pull request from the base branch %s", mm.BaseBranch)
+	}
+
 	if len(mm.cachedLatestSHA) == 0 {
 		if err := mm.getLatestSHA(ctx); err != nil {
 			return xerrors.Errorf("failed to get latest SHA in %s: %w", mm.BaseBranch, err)
 		}
 	}
 
-	_, _, err := mm.client.Git.CreateRef(
-		ctx, mm.owner, mm.repo, &github.Reference{
-			Ref:    github.String(branchName),
-			Object: &github.GitObject{SHA: github.String(mm.cachedLatestSHA)},
+	if err := mm.createBranch(ctx, branchName); err != nil {
+		return xerrors.Errorf("failed to create branch(%s): %w", branchName, err)
+	}
+
+	gitrepo, baseSHA, release, err := mm.commitChanges(ctx, branchName, commitMessage, manipulator)
+
+	if err != nil {
+		mm.deleteBranch(ctx, branchName)
+
+		return err
+	}
+	defer release()
+
+	if gitrepo == nil {
+		// Nothing to merge. The branch is removed so that it is not mistaken for a pending change.
+		mm.deleteBranch(ctx, branchName)
+
+		return nil
+	}
+
+	head, err := gitrepo.Head()
+
+	if err != nil {
+		return xerrors.Errorf("failed to get HEAD: %w", err)
+	}
+
+	result, err := mm.validateChanges(ctx, gitrepo, plumbing.NewHash(baseSHA), head.Hash())
+
+	if err != nil {
+		return xerrors.Errorf("failed to validate manifests: %w", err)
+	}
+
+	if err := mm.pushBranch(ctx, gitrepo, branchName, mm.cachedLatestSHA, true); err != nil {
+		return xerrors.Errorf("failed to push to remote repository: %w", err)
+	}
+
+	if err := mm.publishValidation(ctx, head.Hash().String(), result); err != nil {
+		return xerrors.Errorf("failed to publish validation result: %w", err)
+	}
+
+	if err := result.err(); err != nil {
+		// The branch is kept so that the check run can be inspected. It is reset on the next run.
+		return xerrors.Errorf("refusing to open a pull request from %s: %w", branchName, err)
+	}
+
+	pr, _, err := mm.client.PullRequests.Create(
+		ctx,
+		mm.owner,
+		mm.repo,
+		&github.NewPullRequest{
+			Title:               github.String(trailer.Subject(commitMessage)),
+			Head:                github.String(branchName),
+			Base:                github.String(mm.BaseBranch),
+			MaintainerCanModify: github.Bool(true),
 		},
 	)
 
 	if err != nil {
-		return xerrors.Errorf("failed to create branch(%s): %w", branchName, err)
+		return xerrors.Errorf("failed to create pull request: %w", err)
 	}
 
-	gitutil := gitutil.NewGitHubUtil(mm.token, mm.client)
+	mm.previewPullRequest(ctx, gitrepo, pr.GetNumber(), baseSHA)
+
+	return nil
+}
+
+// createBranch creates branchName at the latest commit of the base branch.
+// A branch left by a run which did not open a pull request, e.g. because of failed validation, is reset to the commit.
+func (mm *ManifestManipulator) createBranch(ctx context.Context, branchName string) error {
+	ref := &github.Reference{
+		Ref:    github.String("refs/heads/" + branchName),
+		Object: &github.GitObject{SHA: github.String(mm.cachedLatestSHA)},
+	}
+
+	_, resp, err := mm.client.Git.CreateRef(ctx, mm.owner, mm.repo, ref)
+
+	if err == nil {
+		return nil
+	}
+
+	if resp == nil || resp.StatusCode != http.StatusUnprocessableEntity {
+		return err
+	}
+
+	// The branch already exists
+	pr, err := mm.FindPullRequest(ctx, branchName)
+
+	if err != nil {
+		return err
+	}
+
+	if pr != nil && pr.GetState() == "open" {
+		return xerrors.Errorf("pull request #%d is already open from %s", pr.GetNumber(), branchName)
+	}
+
+	if _, _, err := mm.client.Git.UpdateRef(ctx, mm.owner, mm.repo, ref, true); err != nil {
+		return xerrors.Errorf("failed to reset existing branch: %w", err)
+	}
+
+	return nil
+}
+
+// deleteBranch deletes branchName created for a pull request which is not opened.
+// Failures are only logged since the branch is replaced on the next run.
+func (mm *ManifestManipulator) deleteBranch(ctx context.Context, branchName string) {
+	if _, err := mm.client.Git.DeleteRef(ctx, mm.owner, mm.repo, "heads/"+branchName); err != nil {
+		slog.ErrorContext(ctx, "failed to delete branch", "repo", mm.owner+"/"+mm.repo, "branch", branchName, "error", err)
+	}
+}
+
+// previewPullRequest comments the rendered differences of HEAD on the pull request.
+// Failures are only logged since the pull request is already pushed.
+func (mm *ManifestManipulator) previewPullRequest(ctx context.Context, gitrepo *git.Repository, prNumber int, baseSHA string) {
+	head, err := gitrepo.Head()
+
+	if err == nil {
+		err = mm.commentRenderedDiff(ctx, gitrepo, prNumber, plumbing.NewHash(baseSHA), head.Hash())
+	}
+
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to preview rendered manifests", "repo", mm.owner+"/"+mm.repo, "pullRequest", prNumber, "error", err)
+	}
+}
+
+// CommitDirectly commits changes by manipulator onto the base branch without a pull request and returns the pushed commit.
+// An empty SHA is returned if manipulator changed nothing.
+// If the base branch moves during the run, the bot commit is rebased by applying manipulator again on top of the new base.
+func (mm *ManifestManipulator) CommitDirectly(
+	ctx context.Context,
+	commitMessage string,
+	manipulator Manipulator,
+) (_ string, err error) {
+	ctx, span := tracing.Start(ctx, "ManifestManipulator.CommitDirectly", attribute.String("repo", mm.owner+"/"+mm.repo), attribute.String("branch", mm.BaseBranch))
+	defer func() { tracing.End(span, err) }()
+
+	for i := 0; ; i++ {
+		sha, err := mm.commitDirectly(ctx, commitMessage, manipulator)
+
+		if err == nil {
+			return sha, nil
+		}
+
+		if !xerrors.Is(err, ErrRemoteBranchMoved) || i+1 >= directCommitAttempts {
+			return "", xerrors.Errorf("failed to commit onto %s: %w", mm.BaseBranch, err)
+		}
+
+		span.AddEvent("rebase", trace.WithAttributes(attribute.Int("attempt", i+1)))
+		slog.WarnContext(ctx, "base branch moved during the run, rebasing the commit", "repo", mm.owner+"/"+mm.repo, "branch", mm.BaseBranch, "error", err)
+	}
+}
+
+func (mm *ManifestManipulator) commitDirectly(
+	ctx context.Context,
+	commitMessage string,
+	manipulator Manipulator,
+) (string, error) {
+	gitrepo, baseSHA, release, err := mm.commitChanges(ctx, mm.BaseBranch, commitMessage, manipulator)
+
+	if err != nil {
+		return "", err
+	}
+	defer release()
+
+	if gitrepo == nil {
+		return "", nil
+	}
+
+	head, err := gitrepo.Head()
+
+	if err != nil {
+		return "", xerrors.Errorf("failed to get HEAD: %w", err)
+	}
+
+	result, err := mm.validateChanges(ctx, gitrepo, plumbing.NewHash(baseSHA), head.Hash())
+
+	if err != nil {
+		return "", xerrors.Errorf("failed to validate manifests: %w", err)
+	}
+
+	if err := result.err(); err != nil {
+		return "", xerrors.Errorf("refusing to commit onto %s(%s): %w", mm.BaseBranch, result.summary(), err)
+	}
+
+	if err := mm.pushBranch(ctx, gitrepo, mm.BaseBranch, baseSHA, false); err != nil {
+		return "", xerrors.Errorf("failed to push to remote repository: %w", err)
+	}
 
-	gitrepo, dir, err := gitutil.CloneRepository(
+	return head.Hash().String(), nil
+}
+
+// commitChanges checks out the base branch, applies manipulator on branchName and commits the changes.
+// gitrepo is nil if manipulator changed nothing. release must be called if err is nil.
+func (mm *ManifestManipulator) commitChanges(
+	ctx context.Context,
+	branchName, commitMessage string,
+	manipulator Manipulator,
+) (gitrepo *git.Repository, baseSHA string, release func(), err error) {
+	ctx, span := tracing.Start(ctx, "ManifestManipulator.commitChanges", attribute.String("repo", mm.owner+"/"+mm.repo), attribute.String("branch", branchName))
+	defer func() { tracing.End(span, err) }()
+
+	var dir string
+
+	ghu := gitutil.NewGitHubUtil(mm.token, mm.client)
+
+	gitrepo, dir, release, err = ghu.CheckoutRepository(
 		ctx,
+		mm.Mirrors,
 		fmt.Sprintf("https://github.com/%s/%s.git", mm.owner, mm.repo),
 		mm.BaseBranch,
+		&gitutil.CloneOptions{
+			Depth: 1,
+			Paths: manipulator.Paths,
+		},
 	)
-	defer os.RemoveAll(dir)
 
 	if err != nil {
-		return xerrors.Errorf("failed to clone repository: %w", err)
+		return nil, "", nil, xerrors.Errorf("failed to clone repository: %w", err)
+	}
+
+	defer func() {
+		if err != nil {
+			release()
+		}
+	}()
+
+	head, err := gitrepo.Head()
+
+	if err != nil {
+		return nil, "", nil, xerrors.Errorf("failed to get HEAD of %s: %w", mm.BaseBranch, err)
 	}
 
 	wt, err := gitrepo.Worktree()
 
 	if err != nil {
-		return xerrors.Errorf("failed to get worktree for git repo: %w", err)
+		return nil, "", nil, xerrors.Errorf("failed to get worktree for git repo: %w", err)
 	}
 
-	if err := wt.Checkout(&git.CheckoutOptions{
-		Create: true,
-		Force:  true,
-		Branch: plumbing.NewBranchReferenceName(branchName),
-	}); err != nil {
-		return xerrors.Errorf("failed to checkout branch %s: %w", branchName, err)
+	if branchName != mm.BaseBranch {
+		if err := wt.Checkout(&git.CheckoutOptions{
+			Create: true,
+			Force:  true,
+			Branch: plumbing.NewBranchReferenceName(branchName),
+		}); err != nil {
+			return nil, "", nil, xerrors.Errorf("failed to checkout branch %s: %w", branchName, err)
+		}
+	}
+
+	changed, err := mm.applyChanges(ctx, wt, dir, branchName, commitMessage, manipulator)
+
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	if !changed {
+		release()
+
+		return nil, "", func() {}, nil
 	}
 
-	if err := manipulator(ctx, dir); err != nil {
-		return xerrors.Errorf("updating image tag failed: %w", err)
+	return gitrepo, head.Hash().String(), release, nil
+}
+
+// applyChanges applies manipulator to the worktree checked out in dir and commits the changes.
+// Files added by manipulator are committed as well once the path policy allows them.
+// changed is false if manipulator changed nothing.
+func (mm *ManifestManipulator) applyChanges(
+	ctx context.Context,
+	wt *git.Worktree,
+	dir, branchName, commitMessage string,
+	manipulator Manipulator,
+) (changed bool, err error) {
+	applyCtx, applySpan := tracing.Start(ctx, "Manipulator.Apply")
+	err = manipulator.Apply(applyCtx, dir)
+	tracing.End(applySpan, err)
+
+	if err != nil {
+		return false, xerrors.Errorf("updating image tag failed: %w", err)
 	}
 
 	stat, err := wt.Status()
 
 	if err != nil {
-		return xerrors.Errorf("failed to get status for git repository: %w", err)
+		return false, xerrors.Errorf("failed to get status for git repository: %w", err)
+	}
+
+	if err := checkChangedPaths(stat, manipulator.Paths); err != nil {
+		return false, xerrors.Errorf("manipulator for %s changed undeclared files: %w", branchName, err)
+	}
+
+	if manipulator.Policy != nil {
+		if err := manipulator.Policy.check(stat); err != nil {
+			return false, xerrors.Errorf("aborting changes for %s: %w", branchName, err)
+		}
 	}
 
 	if stat.IsClean() {
-		return nil
+		return false, nil
+	}
+
+	// Commit with All only stages tracked files
+	for file, fs := range stat {
+		if fs.Worktree != git.Untracked {
+			continue
+		}
+
+		if _, err := wt.Add(file); err != nil {
+			return false, xerrors.Errorf("failed to add %s: %w", file, err)
+		}
 	}
 
+	t := trigger.From(ctx)
+	commitMessage = (&trailer.Trailers{
+		TriggeredBy: trailer.TriggeredBy(t.Event, t.Actor),
+		DeliveryID:  t.DeliveryID,
+	}).Append(commitMessage)
+
 	if _, err := wt.Commit(commitMessage, &git.CommitOptions{
 		All: true,
 		Author: &object.Signature{
@@ -221,33 +543,147 @@ func (mm *ManifestManipulator) CreatePullRequest(
 			When:  time.Now(),
 		},
 	}); err != nil {
-		return xerrors.Errorf("failed to commit changes: %w", err)
+		return false, xerrors.Errorf("failed to commit changes: %w", err)
+	}
+
+	return true, nil
+}
+
+// pushBranch pushes only the branch to the remote repository. Only fast-forward updates are allowed unless force is true.
+// The push is rejected with ErrRemoteBranchMoved unless the remote branch still points to expectedSHA.
+func (mm *ManifestManipulator) pushBranch(ctx context.Context, gitrepo *git.Repository, branchName, expectedSHA string, force bool) (err error) {
+	ctx, span := tracing.Start(ctx, "ManifestManipulator.pushBranch", attribute.String("repo", mm.owner+"/"+mm.repo), attribute.String("branch", branchName), attribute.Bool("force", force))
+	defer func() { tracing.End(span, err) }()
+
+	if force && branchName == mm.BaseBranch {
+		return xerrors.Errorf("refusing to force-push to the base branch %s", mm.BaseBranch)
+	}
+
+	refSpec := config.RefSpec(plumbing.NewBranchReferenceName(branchName) + ":" + plumbing.NewBranchReferenceName(branchName))
+	if force {
+		refSpec = "+" + refSpec
+	}
+
+	ref := plumbing.NewBranchReferenceName(branchName)
+
+	remote, err := gitrepo.Remote("origin")
+
+	if err != nil {
+		return xerrors.Errorf("failed to get remote: %w", err)
+	}
+
+	remoteRefs, err := remote.ListContext(ctx, &git.ListOptions{})
+
+	if err != nil {
+		return xerrors.Errorf("failed to list remote references: %w", err)
+	}
+
+	var actualSHA string
+	for _, r := range remoteRefs {
+		if r.Name() == ref {
+			actualSHA = r.Hash().String()
+			break
+		}
 	}
 
-	if err := gitrepo.PushContext(
+	if actualSHA != expectedSHA {
+		return xerrors.Errorf("%s is expected to be %s but is %s: %w", ref, expectedSHA, actualSHA, ErrRemoteBranchMoved)
+	}
+
+	testHookBeforePush()
+
+	start := time.Now()
+	err = gitrepo.PushContext(
 		ctx,
 		&git.PushOptions{
-			RefSpecs: []config.RefSpec{
-				config.RefSpec("+refs/heads/*:refs/heads/*"),
+			RemoteName: "origin",
+			RefSpecs:   []config.RefSpec{refSpec},
+			// Checked again against the references advertised when pushing
+			RequireRemoteRefs: []config.RefSpec{
+				config.RefSpec(expectedSHA + ":" + ref.String()),
 			},
 		},
-	); err != nil {
-		return xerrors.Errorf("failed to push to remote repository: %w", err)
+	)
+
+	metrics.ObserveGit("push", start)
+
+	if err == git.NoErrAlreadyUpToDate {
+		return nil
 	}
 
-	if _, _, err := mm.client.PullRequests.Create(
-		ctx,
-		mm.owner,
-		mm.repo,
-		&github.NewPullRequest{
-			Title:               github.String(commitMessage),
-			Head:                github.String(branchName),
-			Base:                github.String(mm.BaseBranch),
-			MaintainerCanModify: github.Bool(true),
-		},
-	); err != nil {
-		return xerrors.Errorf("failed to create pull request: %w", err)
+	mm.recordPush(ctx, gitrepo, ref, expectedSHA, force, err)
+
+	// The branch may move after the check above
+	if err != nil && rejectedPush.MatchString(err.Error()) {
+		return xerrors.Errorf("failed to push %s(%v): %w", ref, err, ErrRemoteBranchMoved)
+	}
+
+	if err != nil {
+		return xerrors.Errorf("failed to push %s: %w", ref, err)
+	}
+
+	return nil
+}
+
+// recordPush records the push of ref to the audit log
+func (mm *ManifestManipulator) recordPush(ctx context.Context, gitrepo *git.Repository, ref plumbing.ReferenceName, before string, force bool, pushErr error) {
+	if mm.Audit == nil {
+		return
+	}
+
+	r := &audit.Record{
+		Action:     "git.push",
+		Repository: mm.owner + "/" + mm.repo,
+		Ref:        ref.String(),
+		Before:     before,
+		Force:      force,
+	}
+
+	if local, err := gitrepo.Reference(ref, true); err == nil {
+		r.After = local.Hash().String()
+	}
+
+	if pushErr != nil {
+		r.Error = pushErr.Error()
+	}
+
+	mm.Audit.Record(ctx, r)
+}
+
+// checkChangedPaths returns an error if any file outside of paths is changed
+func checkChangedPaths(stat git.Status, paths []string) error {
+	if len(paths) == 0 {
+		return nil
+	}
+
+	var outside []string
+	for file, fs := range stat {
+		if fs.Staging == git.Unmodified && fs.Worktree == git.Unmodified {
+			continue
+		}
+
+		if !containsPath(paths, file) {
+			outside = append(outside, file)
+		}
+	}
+
+	if len(outside) != 0 {
+		sort.Strings(outside)
+
+		return xerrors.Errorf("%s outside of declared paths %v", strings.Join(outside, ", "), paths)
 	}
 
 	return nil
 }
+
+func containsPath(dirs []string, file string) bool {
+	for _, dir := range dirs {
+		dir = strings.TrimSuffix(path.Clean(dir), "/")
+
+		if file == dir || strings.HasPrefix(file, dir+"/") {
+			return true
+		}
+	}
+
+	return false
+}
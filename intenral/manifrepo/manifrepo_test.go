@@ -0,0 +1,450 @@
+package manifrepo
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MISW/mischan-bot/intenral/kustomize"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/google/go-github/v55/github"
+	"golang.org/x/xerrors"
+)
+
+const (
+	testTemplate = "previews/template"
+	testOverlay  = "previews/portal-pr-1"
+)
+
+// initManifests creates a manifest repository with a preview template in a temporary directory
+func initManifests(t *testing.T) (*git.Repository, string) {
+	t.Helper()
+
+	dir := t.TempDir()
+
+	repo, err := git.PlainInit(dir, false)
+
+	if err != nil {
+		t.Fatalf("failed to init repository: %v", err)
+	}
+
+	template := filepath.Join(dir, filepath.FromSlash(testTemplate))
+
+	if err := os.MkdirAll(template, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	kustomization := "namespace: NAME\nresources:\n- ../../bases/portal\n"
+
+	if err := os.WriteFile(filepath.Join(template, "kustomization.yaml"), []byte(kustomization), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	wt, err := repo.Worktree()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := wt.Add(testTemplate + "/kustomization.yaml"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := wt.Commit("Add preview template", &git.CommitOptions{
+		Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	return repo, dir
+}
+
+func previewManipulator(policy *PathPolicy) Manipulator {
+	return Manipulator{
+		Paths:  []string{testTemplate, testOverlay},
+		Policy: policy,
+		Apply: func(ctx context.Context, root string) error {
+			return kustomize.RenderTemplate(
+				filepath.Join(root, filepath.FromSlash(testTemplate)),
+				filepath.Join(root, filepath.FromSlash(testOverlay)),
+				strings.NewReplacer("NAME", "portal-pr-1"),
+			)
+		},
+	}
+}
+
+func TestApplyChangesCommitsNewOverlay(t *testing.T) {
+	repo, dir := initManifests(t)
+
+	wt, err := repo.Worktree()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mm := &ManifestManipulator{
+		BaseBranch:    "master",
+		CommiterName:  "mischan-bot",
+		CommiterEmail: "mischan-bot@users.noreply.github.com",
+	}
+
+	changed, err := mm.applyChanges(context.Background(), wt, dir, "master", "Update preview portal-pr-1", previewManipulator(&PathPolicy{
+		AllowedPaths:   []string{testOverlay},
+		AllowNewFiles:  true,
+		AllowDeletions: true,
+	}))
+
+	if err != nil {
+		t.Fatalf("applyChanges failed: %v", err)
+	}
+
+	if !changed {
+		t.Fatal("applyChanges reported no changes for a new overlay")
+	}
+
+	head, err := repo.Head()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	commit, err := repo.CommitObject(head.Hash())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := commit.File(testOverlay + "/kustomization.yaml")
+
+	if err != nil {
+		t.Fatalf("overlay is not committed: %v", err)
+	}
+
+	content, err := f.Contents()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(content, "namespace: portal-pr-1\n") {
+		t.Errorf("unexpected overlay: %q", content)
+	}
+
+	stat, err := wt.Status()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !stat.IsClean() {
+		t.Errorf("worktree is not clean after commit: %v", stat)
+	}
+}
+
+func TestApplyChangesRejectsNewFilesAgainstPolicy(t *testing.T) {
+	repo, dir := initManifests(t)
+
+	wt, err := repo.Worktree()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mm := &ManifestManipulator{BaseBranch: "master"}
+
+	_, err = mm.applyChanges(context.Background(), wt, dir, "master", "Update preview portal-pr-1", previewManipulator(&PathPolicy{
+		AllowedPaths: []string{testOverlay},
+	}))
+
+	if !xerrors.Is(err, ErrPolicyViolation) {
+		t.Fatalf("expected policy violation, got %v", err)
+	}
+}
+
+func TestApplyChangesWithoutChanges(t *testing.T) {
+	repo, dir := initManifests(t)
+
+	wt, err := repo.Worktree()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mm := &ManifestManipulator{BaseBranch: "master"}
+
+	changed, err := mm.applyChanges(context.Background(), wt, dir, "master", "Nothing", Manipulator{
+		Paths: []string{testTemplate},
+		Apply: func(ctx context.Context, root string) error { return nil },
+	})
+
+	if err != nil {
+		t.Fatalf("applyChanges failed: %v", err)
+	}
+
+	if changed {
+		t.Error("applyChanges reported changes though nothing changed")
+	}
+}
+
+// commitFile writes a file in the worktree of repo and commits it
+func commitFile(t *testing.T, repo *git.Repository, name, content string) plumbing.Hash {
+	t.Helper()
+
+	wt, err := repo.Worktree()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(wt.Filesystem.Root(), name), []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := wt.Add(name); err != nil {
+		t.Fatal(err)
+	}
+
+	hash, err := wt.Commit("Update "+name, &git.CommitOptions{
+		Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return hash
+}
+
+// initRemote creates a bare repository with master and a bot branch, and returns clones of it
+func initRemote(t *testing.T) (remote string, clone func() *git.Repository, base plumbing.Hash) {
+	t.Helper()
+
+	remote = t.TempDir()
+
+	if _, err := git.PlainInit(remote, true); err != nil {
+		t.Fatal(err)
+	}
+
+	clone = func() *git.Repository {
+		t.Helper()
+
+		repo, err := git.PlainClone(t.TempDir(), false, &git.CloneOptions{URL: remote})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return repo
+	}
+
+	repo, err := git.PlainInit(t.TempDir(), false)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := repo.CreateRemote(&config.RemoteConfig{Name: "origin", URLs: []string{remote}}); err != nil {
+		t.Fatal(err)
+	}
+
+	base = commitFile(t, repo, "kustomization.yaml", "resources: []\n")
+
+	if err := repo.Push(&git.PushOptions{RefSpecs: []config.RefSpec{"refs/heads/master:refs/heads/master", "refs/heads/master:refs/heads/mischan-bot/portal/0123456"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	return remote, clone, base
+}
+
+func TestPushBranchRejectsMovedBranch(t *testing.T) {
+	tests := []struct {
+		name   string
+		branch string
+		force  bool
+	}{
+		{"base branch", "master", false},
+		{"bot branch", "mischan-bot/portal/0123456", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			remote, clone, base := initRemote(t)
+
+			local := clone()
+			commitFile(t, local, "kustomization.yaml", "resources: [bot.yaml]\n")
+
+			if err := local.Storer.SetReference(plumbing.NewHashReference(plumbing.NewBranchReferenceName(tt.branch), mustHead(t, local))); err != nil {
+				t.Fatal(err)
+			}
+
+			// Someone else pushes after the bot checked the remote branch
+			other := clone()
+			moved := commitFile(t, other, "kustomization.yaml", "resources: [other.yaml]\n")
+
+			defer func(hook func()) { testHookBeforePush = hook }(testHookBeforePush)
+			testHookBeforePush = func() {
+				if err := other.Push(&git.PushOptions{RefSpecs: []config.RefSpec{config.RefSpec("+refs/heads/master:refs/heads/" + tt.branch)}}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			mm := &ManifestManipulator{BaseBranch: "master", owner: "MISW", repo: "k8s"}
+
+			err := mm.pushBranch(context.Background(), local, tt.branch, base.String(), tt.force)
+
+			if !xerrors.Is(err, ErrRemoteBranchMoved) {
+				t.Fatalf("expected ErrRemoteBranchMoved, got %v", err)
+			}
+
+			// The commit of the other is kept
+			if got := remoteHead(t, remote, tt.branch); got != moved {
+				t.Errorf("remote branch is %s, want %s", got, moved)
+			}
+		})
+	}
+}
+
+func TestPushBranch(t *testing.T) {
+	remote, clone, base := initRemote(t)
+
+	local := clone()
+	head := commitFile(t, local, "kustomization.yaml", "resources: [bot.yaml]\n")
+
+	mm := &ManifestManipulator{BaseBranch: "master", owner: "MISW", repo: "k8s"}
+
+	if err := mm.pushBranch(context.Background(), local, "master", base.String(), false); err != nil {
+		t.Fatalf("pushBranch failed: %v", err)
+	}
+
+	// Checked before pushing
+	if err := mm.pushBranch(context.Background(), local, "master", base.String(), false); !xerrors.Is(err, ErrRemoteBranchMoved) {
+		t.Errorf("expected ErrRemoteBranchMoved, got %v", err)
+	}
+
+	if got := remoteHead(t, remote, "master"); got != head {
+		t.Errorf("remote branch is %s, want %s", got, head)
+	}
+}
+
+func mustHead(t *testing.T, repo *git.Repository) plumbing.Hash {
+	t.Helper()
+
+	head, err := repo.Head()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return head.Hash()
+}
+
+// remoteHead returns the commit of the branch in the bare repository
+func remoteHead(t *testing.T, remote, branch string) plumbing.Hash {
+	t.Helper()
+
+	repo, err := git.PlainOpen(remote)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ref, err := repo.Reference(plumbing.NewBranchReferenceName(branch), true)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return ref.Hash()
+}
+
+func TestCreateBranch(t *testing.T) {
+	const branch = "mischan-bot/portal/0123456"
+
+	tests := []struct {
+		name    string
+		exists  bool
+		prState string
+		reset   bool
+		wantErr bool
+	}{
+		{name: "new branch"},
+		{name: "left by failed validation", exists: true, reset: true},
+		{name: "pull request closed", exists: true, prState: "closed", reset: true},
+		{name: "pull request open", exists: true, prState: "open", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var reset *github.Reference
+
+			mux := http.NewServeMux()
+			mux.HandleFunc("POST /repos/MISW/k8s/git/refs", func(w http.ResponseWriter, r *http.Request) {
+				if tt.exists {
+					http.Error(w, `{"message": "Reference already exists"}`, http.StatusUnprocessableEntity)
+					return
+				}
+
+				w.WriteHeader(http.StatusCreated)
+				w.Write([]byte(`{}`))
+			})
+			mux.HandleFunc("GET /repos/MISW/k8s/pulls", func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Query().Get("head") != "MISW:"+branch {
+					t.Errorf("unexpected head: %s", r.URL.Query().Get("head"))
+				}
+
+				prs := []*github.PullRequest{}
+				if tt.prState != "" {
+					prs = append(prs, &github.PullRequest{Number: github.Int(1), State: github.String(tt.prState)})
+				}
+
+				json.NewEncoder(w).Encode(prs)
+			})
+			mux.HandleFunc("PATCH /repos/MISW/k8s/git/refs/heads/"+branch, func(w http.ResponseWriter, r *http.Request) {
+				var body struct {
+					SHA   string `json:"sha"`
+					Force bool   `json:"force"`
+				}
+				json.NewDecoder(r.Body).Decode(&body)
+
+				if !body.Force {
+					t.Error("branch is reset without force")
+				}
+
+				reset = &github.Reference{Object: &github.GitObject{SHA: github.String(body.SHA)}}
+				w.Write([]byte(`{}`))
+			})
+
+			server := httptest.NewServer(mux)
+			defer server.Close()
+
+			client := github.NewClient(server.Client())
+			client.BaseURL, _ = url.Parse(server.URL + "/")
+
+			mm := &ManifestManipulator{BaseBranch: "master", owner: "MISW", repo: "k8s", client: client, cachedLatestSHA: "base"}
+
+			err := mm.createBranch(context.Background(), branch)
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("createBranch() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if (reset != nil) != tt.reset {
+				t.Fatalf("branch reset = %v, want %v", reset != nil, tt.reset)
+			}
+
+			if reset != nil && reset.GetObject().GetSHA() != "base" {
+				t.Errorf("branch is reset to %s, want base", reset.GetObject().GetSHA())
+			}
+		})
+	}
+}
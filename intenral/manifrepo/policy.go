@@ -0,0 +1,90 @@
+package manifrepo
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"golang.org/x/xerrors"
+)
+
+var (
+	// ErrPolicyViolation is returned if a manipulator changed files not allowed by PathPolicy
+	ErrPolicyViolation = xerrors.New("path policy violation")
+)
+
+// PathPolicy restricts files a manipulator may change in the manifest repository
+type PathPolicy struct {
+	// AllowedPaths are directories or files which may be modified
+	AllowedPaths []string
+
+	// AllowNewFiles allows adding files under AllowedPaths
+	AllowNewFiles bool
+
+	// AllowDeletions allows deleting files under AllowedPaths
+	AllowDeletions bool
+}
+
+func fileStatus(fs *git.FileStatus) git.StatusCode {
+	if fs.Worktree != git.Unmodified {
+		return fs.Worktree
+	}
+
+	return fs.Staging
+}
+
+// check returns ErrPolicyViolation describing every file changed against the policy
+func (pp *PathPolicy) check(stat git.Status) error {
+	var violations []string
+	for file, fs := range stat {
+		code := fileStatus(fs)
+
+		if code == git.Unmodified {
+			continue
+		}
+
+		if !containsPath(pp.AllowedPaths, file) {
+			violations = append(violations, fmt.Sprintf("%s: %s outside of allowed paths", file, describeStatus(code)))
+			continue
+		}
+
+		switch code {
+		case git.Untracked, git.Added, git.Copied:
+			if !pp.AllowNewFiles {
+				violations = append(violations, fmt.Sprintf("%s: added but new files are not allowed", file))
+			}
+		case git.Deleted:
+			if !pp.AllowDeletions {
+				violations = append(violations, fmt.Sprintf("%s: deleted but deletions are not allowed", file))
+			}
+		case git.Renamed:
+			if !pp.AllowNewFiles || !pp.AllowDeletions {
+				violations = append(violations, fmt.Sprintf("%s: renamed but new files and deletions are not both allowed", file))
+			}
+		}
+	}
+
+	if len(violations) == 0 {
+		return nil
+	}
+
+	sort.Strings(violations)
+
+	return xerrors.Errorf("%s(allowed paths: %v): %w", strings.Join(violations, ", "), pp.AllowedPaths, ErrPolicyViolation)
+}
+
+func describeStatus(code git.StatusCode) string {
+	switch code {
+	case git.Untracked, git.Added:
+		return "added"
+	case git.Deleted:
+		return "deleted"
+	case git.Renamed:
+		return "renamed"
+	case git.Copied:
+		return "copied"
+	default:
+		return "modified"
+	}
+}
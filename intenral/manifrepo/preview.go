@@ -0,0 +1,208 @@
+package manifrepo
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/MISW/mischan-bot/intenral/kustomize"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/google/go-github/v55/github"
+	"golang.org/x/xerrors"
+)
+
+const (
+	renderedDiffMarker = "<!-- mischan-bot:rendered-diff -->"
+
+	// maxCommentLength is a bit shorter than the limit of GitHub(65536 characters)
+	maxCommentLength = 60000
+)
+
+// changedPaths returns paths of files changed between two commits
+func changedPaths(base, head *object.Commit) ([]string, error) {
+	baseTree, err := base.Tree()
+
+	if err != nil {
+		return nil, xerrors.Errorf("failed to get tree for %s: %w", base.Hash, err)
+	}
+
+	headTree, err := head.Tree()
+
+	if err != nil {
+		return nil, xerrors.Errorf("failed to get tree for %s: %w", head.Hash, err)
+	}
+
+	changes, err := object.DiffTree(baseTree, headTree)
+
+	if err != nil {
+		return nil, xerrors.Errorf("failed to diff %s and %s: %w", base.Hash, head.Hash, err)
+	}
+
+	var paths []string
+	for _, c := range changes {
+		if c.From.Name != "" {
+			paths = append(paths, c.From.Name)
+		}
+
+		if c.To.Name != "" && c.To.Name != c.From.Name {
+			paths = append(paths, c.To.Name)
+		}
+	}
+
+	return paths, nil
+}
+
+// overlayBuild is the result of kustomize build for an overlay
+type overlayBuild struct {
+	overlay   string
+	resources map[string]string
+	err       error
+}
+
+// buildAffectedOverlays builds every overlay depending on paths at the commit
+func buildAffectedOverlays(ctx context.Context, commit *object.Commit, paths []string) ([]overlayBuild, error) {
+	tree, err := kustomize.Export(commit)
+
+	if err != nil {
+		return nil, err
+	}
+	defer tree.Close()
+
+	overlays, err := tree.Overlays(paths)
+
+	if err != nil {
+		return nil, xerrors.Errorf("failed to find overlays in %s: %w", commit.Hash, err)
+	}
+
+	builds := make([]overlayBuild, 0, len(overlays))
+	for _, overlay := range overlays {
+		b, err := tree.Build(ctx, overlay)
+
+		var resources map[string]string
+		if err == nil {
+			resources, err = kustomize.SplitResources(b)
+		}
+
+		builds = append(builds, overlayBuild{
+			overlay:   overlay,
+			resources: resources,
+			err:       err,
+		})
+	}
+
+	return builds, nil
+}
+
+// renderDiff builds overlays affected by changes between base and head and returns the differences in Markdown
+func renderDiff(ctx context.Context, gitrepo *git.Repository, base, head plumbing.Hash) (string, error) {
+	baseCommit, err := gitrepo.CommitObject(base)
+
+	if err != nil {
+		return "", xerrors.Errorf("failed to get commit %s: %w", base, err)
+	}
+
+	headCommit, err := gitrepo.CommitObject(head)
+
+	if err != nil {
+		return "", xerrors.Errorf("failed to get commit %s: %w", head, err)
+	}
+
+	paths, err := changedPaths(baseCommit, headCommit)
+
+	if err != nil {
+		return "", err
+	}
+
+	before, err := buildAffectedOverlays(ctx, baseCommit, paths)
+
+	if err != nil {
+		return "", err
+	}
+
+	after, err := buildAffectedOverlays(ctx, headCommit, paths)
+
+	if err != nil {
+		return "", err
+	}
+
+	beforeResources := map[string]map[string]string{}
+	for _, b := range before {
+		beforeResources[b.overlay] = b.resources
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s\n### Rendered manifests\n\n", renderedDiffMarker)
+
+	if len(after) == 0 {
+		sb.WriteString("No overlays depend on the changed files.\n")
+	}
+
+	for _, a := range after {
+		if a.err != nil {
+			fmt.Fprintf(&sb, "#### :x: `%s`: failed to build\n\n```\n%v\n```\n\n", a.overlay, a.err)
+			continue
+		}
+
+		diffs := kustomize.DiffResources(beforeResources[a.overlay], a.resources)
+
+		if len(diffs) == 0 {
+			fmt.Fprintf(&sb, "#### `%s`: no changes\n\n", a.overlay)
+			continue
+		}
+
+		fmt.Fprintf(&sb, "#### `%s`: %d resources changed\n\n", a.overlay, len(diffs))
+
+		for _, d := range diffs {
+			fmt.Fprintf(&sb, "<details><summary><code>%s</code></summary>\n\n```diff\n%s```\n\n</details>\n\n", d.Resource, d.Diff)
+		}
+	}
+
+	body := sb.String()
+
+	if len(body) > maxCommentLength {
+		body = body[:maxCommentLength] + "\n\n(truncated)\n"
+	}
+
+	return body, nil
+}
+
+// commentRenderedDiff posts or updates a comment on the pull request with the rendered differences between base and head
+func (mm *ManifestManipulator) commentRenderedDiff(ctx context.Context, gitrepo *git.Repository, prNumber int, base, head plumbing.Hash) error {
+	body, err := renderDiff(ctx, gitrepo, base, head)
+
+	if err != nil {
+		return xerrors.Errorf("failed to render diff: %w", err)
+	}
+
+	comments, _, err := mm.client.Issues.ListComments(ctx, mm.owner, mm.repo, prNumber, &github.IssueListCommentsOptions{
+		ListOptions: github.ListOptions{PerPage: 100},
+	})
+
+	if err != nil {
+		return xerrors.Errorf("failed to list comments: %w", err)
+	}
+
+	for _, c := range comments {
+		if !strings.HasPrefix(c.GetBody(), renderedDiffMarker) {
+			continue
+		}
+
+		if _, _, err := mm.client.Issues.EditComment(ctx, mm.owner, mm.repo, c.GetID(), &github.IssueComment{
+			Body: github.String(body),
+		}); err != nil {
+			return xerrors.Errorf("failed to update comment %d: %w", c.GetID(), err)
+		}
+
+		return nil
+	}
+
+	if _, _, err := mm.client.Issues.CreateComment(ctx, mm.owner, mm.repo, prNumber, &github.IssueComment{
+		Body: github.String(body),
+	}); err != nil {
+		return xerrors.Errorf("failed to create comment: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,194 @@
+package manifrepo
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/MISW/mischan-bot/intenral/tracing"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/google/go-github/v55/github"
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/xerrors"
+)
+
+const (
+	regenerateConflictMarker = "<!-- mischan-bot:regenerate-conflict "
+)
+
+// ListBotPullRequests lists open pull requests whose branch starts with branchPrefix
+func (mm *ManifestManipulator) ListBotPullRequests(ctx context.Context, branchPrefix string) ([]*github.PullRequest, error) {
+	var prs []*github.PullRequest
+
+	opts := &github.PullRequestListOptions{
+		State:       "open",
+		Base:        mm.BaseBranch,
+		ListOptions: github.ListOptions{PerPage: 100},
+	}
+
+	for {
+		list, resp, err := mm.client.PullRequests.List(ctx, mm.owner, mm.repo, opts)
+
+		if err != nil {
+			return nil, xerrors.Errorf("failed to list pull requests: %w", err)
+		}
+
+		for _, pr := range list {
+			if strings.HasPrefix(pr.GetHead().GetRef(), branchPrefix) {
+				prs = append(prs, pr)
+			}
+		}
+
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+
+	return prs, nil
+}
+
+// RegeneratePullRequest applies manipulator on top of the latest base branch and force-updates the branch of pr.
+// If someone other than the bot pushed to the branch, the branch is left as it is and a comment is posted on pr instead.
+func (mm *ManifestManipulator) RegeneratePullRequest(
+	ctx context.Context,
+	pr *github.PullRequest,
+	commitMessage string,
+	manipulator Manipulator,
+) (err error) {
+	ctx, span := tracing.Start(ctx, "ManifestManipulator.RegeneratePullRequest", attribute.String("repo", mm.owner+"/"+mm.repo), attribute.Int("pullRequest", pr.GetNumber()))
+	defer func() { tracing.End(span, err) }()
+
+	branchName := pr.GetHead().GetRef()
+	headSHA := pr.GetHead().GetSHA()
+
+	if err := mm.getLatestSHA(ctx); err != nil {
+		return xerrors.Errorf("failed to get latest SHA in %s: %w", mm.BaseBranch, err)
+	}
+
+	commits, _, err := mm.client.PullRequests.ListCommits(ctx, mm.owner, mm.repo, pr.GetNumber(), &github.ListOptions{PerPage: 100})
+
+	if err != nil {
+		return xerrors.Errorf("failed to list commits in pull request %d: %w", pr.GetNumber(), err)
+	}
+
+	var humanCommits []string
+	for _, c := range commits {
+		if c.GetCommit().GetAuthor().GetEmail() != mm.CommiterEmail {
+			humanCommits = append(humanCommits, c.GetSHA())
+		}
+	}
+
+	if len(humanCommits) != 0 {
+		if err := mm.commentConflict(ctx, pr, humanCommits); err != nil {
+			return xerrors.Errorf("failed to comment on pull request %d: %w", pr.GetNumber(), err)
+		}
+
+		return nil
+	}
+
+	if len(commits) == 1 && len(commits[0].Parents) == 1 && commits[0].Parents[0].GetSHA() == mm.cachedLatestSHA {
+		// Already based on the latest base branch
+		return nil
+	}
+
+	gitrepo, baseSHA, release, err := mm.commitChanges(ctx, branchName, commitMessage, manipulator)
+
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	if gitrepo == nil {
+		// The base branch already has the changes
+		return mm.closePullRequest(ctx, pr, fmt.Sprintf("%s already contains the changes in this pull request.", mm.BaseBranch))
+	}
+
+	head, err := gitrepo.Head()
+
+	if err != nil {
+		return xerrors.Errorf("failed to get HEAD: %w", err)
+	}
+
+	result, err := mm.validateChanges(ctx, gitrepo, plumbing.NewHash(baseSHA), head.Hash())
+
+	if err != nil {
+		return xerrors.Errorf("failed to validate manifests: %w", err)
+	}
+
+	if err := result.err(); err != nil {
+		// The commit is not pushed, so the result is reported as a comment instead of a check run
+		if _, _, cerr := mm.client.Issues.CreateComment(ctx, mm.owner, mm.repo, pr.GetNumber(), &github.IssueComment{
+			Body: github.String(fmt.Sprintf("%s was updated, but the regenerated manifests are invalid.\n\n%s", mm.BaseBranch, result.summary())),
+		}); cerr != nil {
+			return xerrors.Errorf("failed to comment on pull request %d: %w", pr.GetNumber(), cerr)
+		}
+
+		return xerrors.Errorf("refusing to update %s: %w", branchName, err)
+	}
+
+	if err := mm.pushBranch(ctx, gitrepo, branchName, headSHA, true); err != nil {
+		return xerrors.Errorf("failed to push to remote repository: %w", err)
+	}
+
+	if err := mm.publishValidation(ctx, head.Hash().String(), result); err != nil {
+		return xerrors.Errorf("failed to publish validation result: %w", err)
+	}
+
+	mm.previewPullRequest(ctx, gitrepo, pr.GetNumber(), baseSHA)
+
+	return nil
+}
+
+func (mm *ManifestManipulator) commentConflict(ctx context.Context, pr *github.PullRequest, humanCommits []string) error {
+	marker := regenerateConflictMarker + pr.GetHead().GetSHA() + " -->"
+
+	comments, _, err := mm.client.Issues.ListComments(ctx, mm.owner, mm.repo, pr.GetNumber(), &github.IssueListCommentsOptions{
+		ListOptions: github.ListOptions{PerPage: 100},
+	})
+
+	if err != nil {
+		return xerrors.Errorf("failed to list comments: %w", err)
+	}
+
+	for _, c := range comments {
+		if strings.Contains(c.GetBody(), marker) {
+			return nil
+		}
+	}
+
+	body := fmt.Sprintf(
+		"%s\n%s was updated, but this pull request could not be regenerated on top of it because it contains commits by others: %s\nPlease rebase it manually.",
+		marker,
+		mm.BaseBranch,
+		strings.Join(humanCommits, ", "),
+	)
+
+	if _, _, err := mm.client.Issues.CreateComment(ctx, mm.owner, mm.repo, pr.GetNumber(), &github.IssueComment{
+		Body: github.String(body),
+	}); err != nil {
+		return xerrors.Errorf("failed to create comment: %w", err)
+	}
+
+	return nil
+}
+
+func (mm *ManifestManipulator) closePullRequest(ctx context.Context, pr *github.PullRequest, reason string) error {
+	if _, _, err := mm.client.Issues.CreateComment(ctx, mm.owner, mm.repo, pr.GetNumber(), &github.IssueComment{
+		Body: github.String(reason),
+	}); err != nil {
+		return xerrors.Errorf("failed to comment on pull request %d: %w", pr.GetNumber(), err)
+	}
+
+	if _, _, err := mm.client.PullRequests.Edit(ctx, mm.owner, mm.repo, pr.GetNumber(), &github.PullRequest{
+		State: github.String("closed"),
+	}); err != nil {
+		return xerrors.Errorf("failed to close pull request %d: %w", pr.GetNumber(), err)
+	}
+
+	if _, err := mm.client.Git.DeleteRef(ctx, mm.owner, mm.repo, "heads/"+pr.GetHead().GetRef()); err != nil {
+		return xerrors.Errorf("failed to delete branch for pull request %d: %w", pr.GetNumber(), err)
+	}
+
+	return nil
+}
@@ -0,0 +1,126 @@
+package manifrepo
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/MISW/mischan-bot/intenral/trailer"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/google/go-github/v55/github"
+	"golang.org/x/xerrors"
+)
+
+// FindPullRequest returns the latest pull request from branchName including closed ones.
+// nil is returned if no pull request was opened from the branch.
+func (mm *ManifestManipulator) FindPullRequest(ctx context.Context, branchName string) (*github.PullRequest, error) {
+	prs, _, err := mm.client.PullRequests.List(ctx, mm.owner, mm.repo, &github.PullRequestListOptions{
+		State:       "all",
+		Head:        mm.owner + ":" + branchName,
+		Base:        mm.BaseBranch,
+		Sort:        "created",
+		Direction:   "desc",
+		ListOptions: github.ListOptions{PerPage: 1},
+	})
+
+	if err != nil {
+		return nil, xerrors.Errorf("failed to list pull requests from %s: %w", branchName, err)
+	}
+
+	if len(prs) == 0 {
+		return nil, nil
+	}
+
+	return prs[0], nil
+}
+
+// SourceCommit returns the full SHA of the app in the trailers of the head commit of pr.
+// An empty string is returned if the commit has no trailers.
+func (mm *ManifestManipulator) SourceCommit(ctx context.Context, pr *github.PullRequest) (string, error) {
+	commit, _, err := mm.client.Git.GetCommit(ctx, mm.owner, mm.repo, pr.GetHead().GetSHA())
+
+	if err != nil {
+		return "", xerrors.Errorf("failed to get head commit of pull request %d: %w", pr.GetNumber(), err)
+	}
+
+	t, ok := trailer.Parse(commit.GetMessage())
+
+	if !ok {
+		return "", nil
+	}
+
+	return t.SourceCommit, nil
+}
+
+// CheckFailures returns names of failed check runs and commit statuses for ref in the manifest repository
+func (mm *ManifestManipulator) CheckFailures(ctx context.Context, ref string) ([]string, error) {
+	var failures []string
+
+	checkRuns, _, err := mm.client.Checks.ListCheckRunsForRef(ctx, mm.owner, mm.repo, ref, &github.ListCheckRunsOptions{
+		ListOptions: github.ListOptions{PerPage: 100},
+	})
+
+	if err != nil {
+		return nil, xerrors.Errorf("failed to list check runs for %s: %w", ref, err)
+	}
+
+	for _, run := range checkRuns.CheckRuns {
+		switch run.GetConclusion() {
+		case "failure", "timed_out", "cancelled":
+			failures = append(failures, fmt.Sprintf("%s(%s)", run.GetName(), run.GetConclusion()))
+		}
+	}
+
+	status, _, err := mm.client.Repositories.GetCombinedStatus(ctx, mm.owner, mm.repo, ref, &github.ListOptions{PerPage: 100})
+
+	if err != nil {
+		return nil, xerrors.Errorf("failed to get combined status for %s: %w", ref, err)
+	}
+
+	for _, s := range status.Statuses {
+		switch s.GetState() {
+		case "failure", "error":
+			failures = append(failures, fmt.Sprintf("%s(%s)", s.GetContext(), s.GetState()))
+		}
+	}
+
+	return failures, nil
+}
+
+// GetPullRequest returns the pull request with the number
+func (mm *ManifestManipulator) GetPullRequest(ctx context.Context, number int) (*github.PullRequest, error) {
+	pr, _, err := mm.client.PullRequests.Get(ctx, mm.owner, mm.repo, number)
+
+	if err != nil {
+		return nil, xerrors.Errorf("failed to get pull request %d: %w", number, err)
+	}
+
+	return pr, nil
+}
+
+// Ping checks the base branch of the manifest repository can be listed over git with the installation token
+func (mm *ManifestManipulator) Ping(ctx context.Context) error {
+	remote := git.NewRemote(memory.NewStorage(), &config.RemoteConfig{
+		Name: "origin",
+		URLs: []string{fmt.Sprintf("https://github.com/%s/%s.git", mm.owner, mm.repo)},
+	})
+
+	refs, err := remote.ListContext(ctx, &git.ListOptions{
+		Auth: &http.BasicAuth{Username: "x-access-token", Password: mm.token},
+	})
+
+	if err != nil {
+		return xerrors.Errorf("failed to list remote references: %w", err)
+	}
+
+	for _, r := range refs {
+		if r.Name() == plumbing.NewBranchReferenceName(mm.BaseBranch) {
+			return nil
+		}
+	}
+
+	return xerrors.Errorf("branch %s is not found in %s/%s", mm.BaseBranch, mm.owner, mm.repo)
+}
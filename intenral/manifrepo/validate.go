@@ -0,0 +1,159 @@
+package manifrepo
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/MISW/mischan-bot/intenral/tracing"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/google/go-github/v55/github"
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/xerrors"
+)
+
+const (
+	validationCheckName = "mischan-bot/manifest-validation"
+)
+
+var (
+	// ErrInvalidManifests is returned if manifests changed by a manipulator fail to build or validate
+	ErrInvalidManifests = xerrors.New("invalid manifests")
+)
+
+// validationResult is the result of building and validating affected overlays
+type validationResult struct {
+	overlays []string
+	failures []string
+}
+
+func (vr *validationResult) ok() bool {
+	return len(vr.failures) == 0
+}
+
+func (vr *validationResult) title() string {
+	if vr.ok() {
+		return fmt.Sprintf("%d overlays are valid", len(vr.overlays))
+	}
+
+	return fmt.Sprintf("%d problems found in %d overlays", len(vr.failures), len(vr.overlays))
+}
+
+func (vr *validationResult) summary() string {
+	var sb strings.Builder
+
+	sb.WriteString("Overlays:\n")
+	for _, o := range vr.overlays {
+		fmt.Fprintf(&sb, "- `%s`\n", o)
+	}
+
+	if len(vr.failures) != 0 {
+		sb.WriteString("\nProblems:\n")
+		for _, f := range vr.failures {
+			fmt.Fprintf(&sb, "- %s\n", strings.ReplaceAll(f, "\n", "\n  "))
+		}
+	}
+
+	body := sb.String()
+
+	if len(body) > maxCommentLength {
+		body = body[:maxCommentLength] + "\n\n(truncated)\n"
+	}
+
+	return body
+}
+
+func (vr *validationResult) err() error {
+	if vr.ok() {
+		return nil
+	}
+
+	return xerrors.Errorf("%s: %w", vr.title(), ErrInvalidManifests)
+}
+
+// validateChanges builds every overlay affected by changes between base and head and validates rendered resources at head
+func (mm *ManifestManipulator) validateChanges(ctx context.Context, gitrepo *git.Repository, base, head plumbing.Hash) (_ *validationResult, err error) {
+	ctx, span := tracing.Start(ctx, "ManifestManipulator.validateChanges", attribute.String("base", base.String()), attribute.String("head", head.String()))
+	defer func() { tracing.End(span, err) }()
+
+	baseCommit, err := gitrepo.CommitObject(base)
+
+	if err != nil {
+		return nil, xerrors.Errorf("failed to get commit %s: %w", base, err)
+	}
+
+	headCommit, err := gitrepo.CommitObject(head)
+
+	if err != nil {
+		return nil, xerrors.Errorf("failed to get commit %s: %w", head, err)
+	}
+
+	paths, err := changedPaths(baseCommit, headCommit)
+
+	if err != nil {
+		return nil, err
+	}
+
+	builds, err := buildAffectedOverlays(ctx, headCommit, paths)
+
+	if err != nil {
+		return nil, err
+	}
+
+	result := &validationResult{}
+	defer func() {
+		span.SetAttributes(attribute.Int("overlays", len(result.overlays)), attribute.Int("failures", len(result.failures)))
+	}()
+
+	for _, b := range builds {
+		result.overlays = append(result.overlays, b.overlay)
+
+		if b.err != nil {
+			result.failures = append(result.failures, fmt.Sprintf("`%s`: %v", b.overlay, b.err))
+			continue
+		}
+
+		if mm.Validator == nil {
+			continue
+		}
+
+		names := make([]string, 0, len(b.resources))
+		for name := range b.resources {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			if err := mm.Validator.Validate([]byte(b.resources[name])); err != nil {
+				result.failures = append(result.failures, fmt.Sprintf("`%s` %s: %v", b.overlay, name, err))
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// publishValidation creates a check run with the validation result on the commit
+func (mm *ManifestManipulator) publishValidation(ctx context.Context, headSHA string, result *validationResult) error {
+	conclusion := "success"
+	if !result.ok() {
+		conclusion = "failure"
+	}
+
+	if _, _, err := mm.client.Checks.CreateCheckRun(ctx, mm.owner, mm.repo, github.CreateCheckRunOptions{
+		Name:       validationCheckName,
+		HeadSHA:    headSHA,
+		Status:     github.String("completed"),
+		Conclusion: github.String(conclusion),
+		Output: &github.CheckRunOutput{
+			Title:   github.String(result.title()),
+			Summary: github.String(result.summary()),
+		},
+	}); err != nil {
+		return xerrors.Errorf("failed to create check run for %s: %w", headSHA, err)
+	}
+
+	return nil
+}
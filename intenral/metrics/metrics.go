@@ -0,0 +1,109 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "mischan_bot"
+
+var (
+	// WebhookDeliveries counts webhook deliveries by event type and outcome(accepted, ignored, invalid_signature, invalid_payload, error)
+	WebhookDeliveries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "webhook_deliveries_total",
+		Help:      "Number of webhook deliveries by event type and outcome.",
+	}, []string{"event", "outcome"})
+
+	// WebhookSignatureFailures counts webhook deliveries rejected for invalid signatures
+	WebhookSignatureFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "webhook_signature_failures_total",
+		Help:      "Number of webhook deliveries with invalid signatures.",
+	})
+
+	// EventHandlersInFlight is the number of goroutines handling webhook events by event type
+	EventHandlersInFlight = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "event_handlers_in_flight",
+		Help:      "Number of goroutines handling webhook events.",
+	}, []string{"event"})
+
+	// Runs counts runs for app repositories by result(deployed, frozen, skipped, failed)
+	Runs = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "runs_total",
+		Help:      "Number of runs for app repositories by result.",
+	}, []string{"app", "result"})
+
+	// CheckToPullRequest observes time from completion of checks of apps to changes in the manifest repository
+	CheckToPullRequest = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "check_to_pull_request_seconds",
+		Help:      "Time from completion of checks of the app to creation of the pull request or the commit in the manifest repository.",
+		Buckets:   []float64{5, 10, 30, 60, 120, 300, 600, 1800, 3600},
+	}, []string{"app"})
+
+	// GitDuration observes durations of git operations(clone, fetch, push)
+	GitDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "git_operation_duration_seconds",
+		Help:      "Duration of git operations.",
+		Buckets:   prometheus.ExponentialBuckets(0.1, 2, 10),
+	}, []string{"operation"})
+
+	// GitHubAPICalls counts calls of GitHub API by status code
+	GitHubAPICalls = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "github_api_calls_total",
+		Help:      "Number of GitHub API calls by status code.",
+	}, []string{"code"})
+
+	// GitHubRateLimitRemaining is the remaining rate limit reported by the last response of GitHub API by resource
+	GitHubRateLimitRemaining = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "github_rate_limit_remaining",
+		Help:      "Remaining rate limit of GitHub API reported by the last response.",
+	}, []string{"resource"})
+)
+
+// ObserveGit records the duration of a git operation started at start
+func ObserveGit(operation string, start time.Time) {
+	GitDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+}
+
+// GitHubTransport wraps base to record calls of GitHub API and rate limits
+func GitHubTransport(base http.RoundTripper) http.RoundTripper {
+	return &gitHubTransport{base: base}
+}
+
+type gitHubTransport struct {
+	base http.RoundTripper
+}
+
+func (t *gitHubTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+
+	if err != nil {
+		GitHubAPICalls.WithLabelValues("error").Inc()
+
+		return nil, err
+	}
+
+	GitHubAPICalls.WithLabelValues(strconv.Itoa(resp.StatusCode)).Inc()
+
+	if remaining, err := strconv.Atoi(resp.Header.Get("X-RateLimit-Remaining")); err == nil {
+		resource := resp.Header.Get("X-RateLimit-Resource")
+		if resource == "" {
+			resource = "core"
+		}
+
+		GitHubRateLimitRemaining.WithLabelValues(resource).Set(float64(remaining))
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,111 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+// maxDiscordContent is the maximum length of message content in Discord
+const maxDiscordContent = 2000
+
+// DiscordSink posts messages to a Discord webhook.
+// Messages are threaded only if Forum is set since webhooks cannot start threads in text channels.
+type DiscordSink struct {
+	WebhookURL string
+
+	// Forum starts a thread in the forum channel of the webhook for each SHA
+	Forum bool
+
+	client *http.Client
+}
+
+var _ Sink = &DiscordSink{}
+
+type discordMessage struct {
+	Content    string `json:"content"`
+	ThreadName string `json:"thread_name,omitempty"`
+}
+
+func discordLink(url, text string) string {
+	return "[" + text + "](<" + url + ">)"
+}
+
+func (ds *DiscordSink) Post(ctx context.Context, e *Event, thread string) (string, error) {
+	msg := &discordMessage{
+		Content: e.text(discordLink),
+	}
+
+	if r := []rune(msg.Content); len(r) > maxDiscordContent {
+		msg.Content = string(r[:maxDiscordContent-1]) + "…"
+	}
+
+	u, err := url.Parse(ds.WebhookURL)
+
+	if err != nil {
+		return "", xerrors.Errorf("failed to parse webhook URL: %w", redactURL(err))
+	}
+
+	// wait=true returns the created message
+	q := u.Query()
+	q.Set("wait", "true")
+
+	if ds.Forum {
+		if thread != "" {
+			q.Set("thread_id", thread)
+		} else {
+			msg.ThreadName = threadName(e)
+		}
+	}
+	u.RawQuery = q.Encode()
+
+	b, err := post(ctx, ds.client, u.String(), "", msg)
+
+	if err != nil {
+		return "", err
+	}
+
+	if !ds.Forum {
+		return "", nil
+	}
+
+	if thread != "" {
+		return thread, nil
+	}
+
+	var resp struct {
+		ChannelID string `json:"channel_id"`
+	}
+
+	if err := json.Unmarshal(b, &resp); err != nil {
+		return "", xerrors.Errorf("failed to parse response: %w", err)
+	}
+
+	// Messages starting threads in forum channels are posted in channels for the threads
+	return resp.ChannelID, nil
+}
+
+// threadName returns the name of the thread for the SHA of e
+func threadName(e *Event) string {
+	shortSHA := e.SHA
+	if len(shortSHA) > 7 {
+		shortSHA = shortSHA[:7]
+	}
+
+	return strings.TrimSpace(e.App + " " + shortSHA)
+}
+
+// redactURL removes the URL from err since webhook URLs contain secrets
+func redactURL(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return xerrors.Errorf("%s: %w", urlErr.Op, urlErr.Err)
+	}
+
+	return err
+}
@@ -0,0 +1,244 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/MISW/mischan-bot/config"
+	"golang.org/x/xerrors"
+)
+
+const (
+	// threadTTL is how long messages about a SHA are threaded under the first one
+	threadTTL = 7 * 24 * time.Hour
+
+	postTimeout = 10 * time.Second
+
+	// maxErrorLength is the length of error summaries in messages
+	maxErrorLength = 500
+)
+
+// Kind is a kind of deployment lifecycle events
+type Kind string
+
+const (
+	// KindOpened means a pull request was opened in the manifest repository
+	KindOpened Kind = "opened"
+
+	// KindMerged means changes landed on the base branch of the manifest repository
+	KindMerged Kind = "merged"
+
+	// KindSuperseded means a pull request was closed since a newer one was opened
+	KindSuperseded Kind = "superseded"
+
+	// KindClosed means a pull request was closed without merge
+	KindClosed Kind = "closed"
+
+	// KindFailed means a run failed
+	KindFailed Kind = "failed"
+)
+
+// Event is a deployment lifecycle event of an app
+type Event struct {
+	Kind Kind
+
+	App         string
+	Environment string
+	SHA         string
+
+	// PullRequest and URL are set for events of pull requests
+	PullRequest int
+	URL         string
+
+	Actor string
+	Error string
+}
+
+// text formats the event with link formatting links in the chat service
+func (e *Event) text(link func(url, text string) string) string {
+	shortSHA := e.SHA
+	if len(shortSHA) > 7 {
+		shortSHA = shortSHA[:7]
+	}
+
+	target := e.App
+	if e.SHA != "" {
+		target += " " + link("https://github.com/"+e.App+"/commit/"+e.SHA, shortSHA)
+	}
+
+	if e.Environment != "" {
+		target += " in " + e.Environment
+	}
+
+	pr := ""
+	if e.PullRequest != 0 {
+		pr = link(e.URL, fmt.Sprintf("#%d", e.PullRequest))
+	}
+
+	var text string
+	switch e.Kind {
+	case KindOpened:
+		text = fmt.Sprintf("📝 %s: pull request %s opened", target, pr)
+	case KindMerged:
+		text = fmt.Sprintf("✅ %s: deployed", target)
+		if pr != "" {
+			text += " by merging " + pr
+		}
+	case KindSuperseded:
+		text = fmt.Sprintf("⏭️ %s: pull request %s superseded by a newer one", target, pr)
+	case KindClosed:
+		text = fmt.Sprintf("🚫 %s: pull request %s closed without merge", target, pr)
+	case KindFailed:
+		errText := e.Error
+		if len(errText) > maxErrorLength {
+			errText = errText[:maxErrorLength] + "…"
+		}
+
+		text = fmt.Sprintf("❌ %s: run failed\n```\n%s\n```", target, errText)
+	default:
+		text = fmt.Sprintf("%s: %s", target, e.Kind)
+	}
+
+	if e.Actor != "" {
+		text += fmt.Sprintf(" (%s)", e.Actor)
+	}
+
+	return text
+}
+
+// Sink posts messages to a chat channel
+type Sink interface {
+	// Post posts the event. It is threaded under thread if not empty.
+	// The returned thread threads following messages, or is empty if the sink cannot thread messages.
+	Post(ctx context.Context, e *Event, thread string) (string, error)
+}
+
+type threadKey struct {
+	channel, app, shortSHA string
+}
+
+type threadEntry struct {
+	thread    string
+	createdAt time.Time
+}
+
+// Notifier posts deployment lifecycle events to channels routed for each app.
+// Messages about a SHA are threaded in channels which support threads.
+type Notifier struct {
+	cfg      *config.Config
+	channels map[string]Sink
+
+	lock    sync.Mutex
+	threads map[threadKey]threadEntry
+}
+
+// New initializes sinks for channels in cfg.
+// Messages are posted to standIn instead if not nil.
+func New(cfg *config.Config, standIn *StandIn) (*Notifier, error) {
+	n := &Notifier{
+		cfg:      cfg,
+		channels: map[string]Sink{},
+		threads:  map[threadKey]threadEntry{},
+	}
+
+	client := &http.Client{Timeout: postTimeout}
+
+	for _, c := range cfg.Notifications.Channels {
+		switch {
+		case c.Slack != nil:
+			sink := &SlackSink{
+				WebhookURL: c.Slack.WebhookURL(),
+				Token:      c.Slack.Token(),
+				Channel:    c.Slack.Channel,
+				APIURL:     slackAPIURL,
+				client:     client,
+			}
+
+			if standIn != nil {
+				sink.WebhookURL = standIn.URL() + "/slack/" + c.Name
+				sink.APIURL = standIn.URL() + "/slack/" + c.Name + "/api"
+			}
+
+			if sink.WebhookURL == "" && sink.Token == "" {
+				return nil, xerrors.Errorf("webhook URL or token for notification channel %s is empty", c.Name)
+			}
+
+			n.channels[c.Name] = sink
+		case c.Discord != nil:
+			sink := &DiscordSink{
+				WebhookURL: c.Discord.WebhookURL(),
+				Forum:      c.Discord.Forum,
+				client:     client,
+			}
+
+			if standIn != nil {
+				sink.WebhookURL = standIn.URL() + "/discord/" + c.Name
+			}
+
+			if sink.WebhookURL == "" {
+				return nil, xerrors.Errorf("webhook URL for notification channel %s is empty", c.Name)
+			}
+
+			n.channels[c.Name] = sink
+		}
+	}
+
+	return n, nil
+}
+
+// Notify posts e to channels of the app. Messages are posted synchronously to keep threads in order.
+// Failures are only logged not to fail deployments.
+func (n *Notifier) Notify(ctx context.Context, e *Event) {
+	channels := n.cfg.NotifyChannels(e.App)
+
+	if len(channels) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), postTimeout)
+	defer cancel()
+
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	n.expireThreads()
+
+	shortSHA := e.SHA
+	if len(shortSHA) > 7 {
+		shortSHA = shortSHA[:7]
+	}
+
+	for _, name := range channels {
+		sink, ok := n.channels[name]
+
+		if !ok {
+			continue
+		}
+
+		key := threadKey{channel: name, app: e.App, shortSHA: shortSHA}
+
+		thread, err := sink.Post(ctx, e, n.threads[key].thread)
+
+		if err != nil {
+			slog.ErrorContext(ctx, "failed to post notification", "channel", name, "kind", e.Kind, "app", e.App, "sha", e.SHA, "error", err)
+			continue
+		}
+
+		if _, ok := n.threads[key]; !ok && thread != "" {
+			n.threads[key] = threadEntry{thread: thread, createdAt: time.Now()}
+		}
+	}
+}
+
+// expireThreads forgets threads older than threadTTL. The caller must hold the lock.
+func (n *Notifier) expireThreads() {
+	for key, entry := range n.threads {
+		if time.Since(entry.createdAt) > threadTTL {
+			delete(n.threads, key)
+		}
+	}
+}
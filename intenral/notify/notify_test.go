@@ -0,0 +1,209 @@
+package notify
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/MISW/mischan-bot/config"
+)
+
+const (
+	testApp = "MISW/Portal"
+	testSHA = "0123456789abcdef0123456789abcdef01234567"
+)
+
+// newTestNotifier initializes Notifier posting to si with a channel of each sink mode
+func newTestNotifier(t *testing.T, si *StandIn, apps map[string]config.AppConfig, defaults ...string) *Notifier {
+	t.Helper()
+
+	t.Setenv("TEST_SLACK_WEBHOOK_URL", "https://hooks.slack.invalid/services/secret")
+	t.Setenv("TEST_SLACK_TOKEN", "xoxb-secret")
+	t.Setenv("TEST_DISCORD_WEBHOOK_URL", "https://discord.invalid/api/webhooks/secret")
+
+	cfg := &config.Config{
+		Apps: apps,
+		Notifications: config.NotificationsConfig{
+			Channels: []config.ChannelConfig{
+				{Name: "slack-webhook", Slack: &config.SlackConfig{WebhookURLEnv: "TEST_SLACK_WEBHOOK_URL"}},
+				{Name: "slack-api", Slack: &config.SlackConfig{TokenEnv: "TEST_SLACK_TOKEN", Channel: "#deploy"}},
+				{Name: "discord", Discord: &config.DiscordConfig{WebhookURLEnv: "TEST_DISCORD_WEBHOOK_URL"}},
+				{Name: "discord-forum", Discord: &config.DiscordConfig{WebhookURLEnv: "TEST_DISCORD_WEBHOOK_URL", Forum: true}},
+			},
+			Default: defaults,
+		},
+	}
+
+	n, err := New(cfg, si)
+
+	if err != nil {
+		t.Fatalf("failed to initialize notifier: %v", err)
+	}
+
+	return n
+}
+
+func messagesIn(messages []StandInMessage, channel string) []StandInMessage {
+	var filtered []StandInMessage
+	for _, m := range messages {
+		if m.Channel == channel {
+			filtered = append(filtered, m)
+		}
+	}
+
+	return filtered
+}
+
+func TestNotifyEventKinds(t *testing.T) {
+	channels := []string{"slack-webhook", "slack-api", "discord", "discord-forum"}
+
+	tests := []struct {
+		event *Event
+		want  []string
+	}{
+		{
+			event: &Event{Kind: KindOpened, PullRequest: 12, URL: "https://github.com/MISW/manifests/pull/12"},
+			want:  []string{"📝", "pull request", "#12", "opened"},
+		},
+		{
+			event: &Event{Kind: KindMerged, Environment: "production", PullRequest: 12, URL: "https://github.com/MISW/manifests/pull/12"},
+			want:  []string{"✅", "in production", "deployed by merging", "#12"},
+		},
+		{
+			event: &Event{Kind: KindSuperseded, PullRequest: 12, URL: "https://github.com/MISW/manifests/pull/12"},
+			want:  []string{"⏭️", "#12", "superseded"},
+		},
+		{
+			event: &Event{Kind: KindClosed, PullRequest: 12, URL: "https://github.com/MISW/manifests/pull/12"},
+			want:  []string{"🚫", "#12", "closed without merge"},
+		},
+		{
+			event: &Event{Kind: KindFailed, Error: "failed to kustomize", Actor: "octocat"},
+			want:  []string{"❌", "run failed", "failed to kustomize", "(octocat)"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.event.Kind), func(t *testing.T) {
+			si := NewStandIn()
+			defer si.Close()
+
+			n := newTestNotifier(t, si, nil, channels...)
+
+			tt.event.App = testApp
+			tt.event.SHA = testSHA
+			n.Notify(context.Background(), tt.event)
+
+			messages := si.Messages()
+
+			for _, channel := range channels {
+				got := messagesIn(messages, channel)
+
+				if len(got) != 1 {
+					t.Fatalf("%s received %d messages, want 1", channel, len(got))
+				}
+
+				for _, w := range append(tt.want, testApp, testSHA[:7]) {
+					if !strings.Contains(got[0].Text, w) {
+						t.Errorf("message in %s does not contain %q: %q", channel, w, got[0].Text)
+					}
+				}
+			}
+
+			// Links are formatted for each service
+			if text := messagesIn(messages, "slack-api")[0].Text; !strings.Contains(text, "|"+testSHA[:7]+">") {
+				t.Errorf("SHA is not linked for Slack: %q", text)
+			}
+
+			if text := messagesIn(messages, "discord")[0].Text; !strings.Contains(text, "["+testSHA[:7]+"](<") {
+				t.Errorf("SHA is not linked for Discord: %q", text)
+			}
+		})
+	}
+}
+
+func TestNotifyRoutesPerApp(t *testing.T) {
+	si := NewStandIn()
+	defer si.Close()
+
+	n := newTestNotifier(t, si, map[string]config.AppConfig{
+		"MISW/Portal": {Notify: []string{"slack-api"}},
+		"MISW/quiet":  {Notify: []string{}},
+		"MISW/plain":  {},
+	}, "discord")
+
+	ctx := context.Background()
+	n.Notify(ctx, &Event{Kind: KindMerged, App: "MISW/Portal", SHA: testSHA})
+	n.Notify(ctx, &Event{Kind: KindMerged, App: "MISW/quiet", SHA: testSHA})
+	n.Notify(ctx, &Event{Kind: KindMerged, App: "MISW/plain", SHA: testSHA})
+	n.Notify(ctx, &Event{Kind: KindMerged, App: "MISW/unknown", SHA: testSHA})
+
+	messages := si.Messages()
+
+	if len(messages) != 3 {
+		t.Fatalf("received %d messages, want 3: %v", len(messages), messages)
+	}
+
+	want := []struct {
+		channel, app string
+	}{
+		{"slack-api", "MISW/Portal"},
+		{"discord", "MISW/plain"},
+		{"discord", "MISW/unknown"},
+	}
+
+	for i, w := range want {
+		if messages[i].Channel != w.channel || !strings.Contains(messages[i].Text, w.app) {
+			t.Errorf("message %d is %q in %s, want %s in %s", i, messages[i].Text, messages[i].Channel, w.app, w.channel)
+		}
+	}
+}
+
+func TestNotifyThreadsFollowUps(t *testing.T) {
+	si := NewStandIn()
+	defer si.Close()
+
+	n := newTestNotifier(t, si, nil, "slack-webhook", "slack-api", "discord", "discord-forum")
+
+	ctx := context.Background()
+	otherSHA := "fedcba9876543210fedcba9876543210fedcba98"
+
+	n.Notify(ctx, &Event{Kind: KindOpened, App: testApp, SHA: testSHA, PullRequest: 1})
+	n.Notify(ctx, &Event{Kind: KindMerged, App: testApp, SHA: testSHA, PullRequest: 1})
+	n.Notify(ctx, &Event{Kind: KindOpened, App: testApp, SHA: otherSHA, PullRequest: 2})
+	n.Notify(ctx, &Event{Kind: KindFailed, App: testApp, SHA: testSHA, Error: "boom"})
+
+	messages := si.Messages()
+
+	for _, channel := range []string{"slack-api", "discord-forum"} {
+		got := messagesIn(messages, channel)
+
+		if len(got) != 4 {
+			t.Fatalf("%s received %d messages, want 4", channel, len(got))
+		}
+
+		if got[0].Thread != "" || got[2].Thread != "" {
+			t.Errorf("first messages of SHAs in %s are threaded: %q, %q", channel, got[0].Thread, got[2].Thread)
+		}
+
+		if got[1].Thread == "" || got[1].Thread != got[3].Thread {
+			t.Errorf("follow-ups in %s are not threaded together: %q, %q", channel, got[1].Thread, got[3].Thread)
+		}
+	}
+
+	// Threads are kept for each channel
+	slack := messagesIn(messages, "slack-api")
+	forum := messagesIn(messages, "discord-forum")
+
+	if slack[1].Thread == forum[1].Thread {
+		t.Errorf("threads are shared across channels: %q", slack[1].Thread)
+	}
+
+	for _, channel := range []string{"slack-webhook", "discord"} {
+		for _, m := range messagesIn(messages, channel) {
+			if m.Thread != "" {
+				t.Errorf("message in %s without thread support is threaded: %q", channel, m.Thread)
+			}
+		}
+	}
+}
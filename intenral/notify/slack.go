@@ -0,0 +1,121 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+const slackAPIURL = "https://slack.com/api"
+
+// SlackSink posts messages to Slack.
+// Messages are posted with chat.postMessage and threaded if Token is set, or to the incoming webhook without threads otherwise.
+type SlackSink struct {
+	WebhookURL string
+
+	Token   string
+	Channel string
+
+	// APIURL is the base URL of the Web API
+	APIURL string
+
+	client *http.Client
+}
+
+var _ Sink = &SlackSink{}
+
+type slackMessage struct {
+	Channel  string `json:"channel,omitempty"`
+	Text     string `json:"text"`
+	ThreadTS string `json:"thread_ts,omitempty"`
+}
+
+func slackLink(url, text string) string {
+	return "<" + url + "|" + text + ">"
+}
+
+func (ss *SlackSink) Post(ctx context.Context, e *Event, thread string) (string, error) {
+	msg := &slackMessage{
+		Text: e.text(slackLink),
+	}
+
+	if ss.Token == "" {
+		_, err := post(ctx, ss.client, ss.WebhookURL, "", msg)
+
+		return "", err
+	}
+
+	msg.Channel = ss.Channel
+	msg.ThreadTS = thread
+
+	b, err := post(ctx, ss.client, strings.TrimSuffix(ss.APIURL, "/")+"/chat.postMessage", ss.Token, msg)
+
+	if err != nil {
+		return "", err
+	}
+
+	var resp struct {
+		OK    bool   `json:"ok"`
+		Error string `json:"error"`
+		TS    string `json:"ts"`
+	}
+
+	if err := json.Unmarshal(b, &resp); err != nil {
+		return "", xerrors.Errorf("failed to parse response of chat.postMessage: %w", err)
+	}
+
+	if !resp.OK {
+		return "", xerrors.Errorf("chat.postMessage failed: %s", resp.Error)
+	}
+
+	if thread != "" {
+		return thread, nil
+	}
+
+	return resp.TS, nil
+}
+
+// post posts body as JSON and returns the response body. token is sent as a bearer token if not empty.
+func post(ctx context.Context, client *http.Client, url, token string, body any) ([]byte, error) {
+	b, err := json.Marshal(body)
+
+	if err != nil {
+		return nil, xerrors.Errorf("failed to encode message: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(b))
+
+	if err != nil {
+		return nil, xerrors.Errorf("failed to initialize request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := client.Do(req)
+
+	if err != nil {
+		// The error may contain the webhook URL, which is a secret
+		return nil, xerrors.Errorf("failed to post message: %w", redactURL(err))
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+
+	if err != nil {
+		return nil, xerrors.Errorf("failed to read response: %w", err)
+	}
+
+	if resp.StatusCode >= 300 {
+		return nil, xerrors.Errorf("unexpected status %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+
+	return respBody, nil
+}
@@ -0,0 +1,127 @@
+package notify
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+)
+
+// StandInMessage is a message received by StandIn
+type StandInMessage struct {
+	// Service is slack or discord
+	Service string
+	Channel string
+
+	// Thread is the thread the message was posted in. Empty if not threaded.
+	Thread string
+	Text   string
+}
+
+// StandIn is a local server accepting messages for Slack and Discord for testing.
+// Received messages are logged and kept in memory.
+type StandIn struct {
+	server *httptest.Server
+
+	lock     sync.Mutex
+	messages []StandInMessage
+	lastID   int
+}
+
+// NewStandIn starts a stand-in server. Close must be called to stop it.
+func NewStandIn() *StandIn {
+	si := &StandIn{}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /slack/{channel}", si.slackWebhook)
+	mux.HandleFunc("POST /slack/{channel}/api/chat.postMessage", si.slackPostMessage)
+	mux.HandleFunc("POST /discord/{channel}", si.discordWebhook)
+
+	si.server = httptest.NewServer(mux)
+
+	return si
+}
+
+// URL returns the base URL of the server
+func (si *StandIn) URL() string {
+	return si.server.URL
+}
+
+// Close stops the server
+func (si *StandIn) Close() {
+	si.server.Close()
+}
+
+// Messages returns received messages in order
+func (si *StandIn) Messages() []StandInMessage {
+	si.lock.Lock()
+	defer si.lock.Unlock()
+
+	return append([]StandInMessage(nil), si.messages...)
+}
+
+// record keeps the message and returns a new ID like timestamps of Slack or snowflakes of Discord
+func (si *StandIn) record(msg StandInMessage) string {
+	si.lock.Lock()
+	defer si.lock.Unlock()
+
+	si.messages = append(si.messages, msg)
+	si.lastID++
+
+	slog.Info("notification received by stand-in", "service", msg.Service, "channel", msg.Channel, "thread", msg.Thread, "text", msg.Text)
+
+	return strconv.Itoa(si.lastID)
+}
+
+func (si *StandIn) slackWebhook(w http.ResponseWriter, r *http.Request) {
+	var msg slackMessage
+	if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+		http.Error(w, "invalid_payload", http.StatusBadRequest)
+		return
+	}
+
+	si.record(StandInMessage{Service: "slack", Channel: r.PathValue("channel"), Text: msg.Text})
+
+	w.Write([]byte("ok"))
+}
+
+func (si *StandIn) slackPostMessage(w http.ResponseWriter, r *http.Request) {
+	var msg slackMessage
+	if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+		json.NewEncoder(w).Encode(map[string]any{"ok": false, "error": "invalid_json"})
+		return
+	}
+
+	ts := si.record(StandInMessage{Service: "slack", Channel: r.PathValue("channel"), Thread: msg.ThreadTS, Text: msg.Text})
+
+	json.NewEncoder(w).Encode(map[string]any{"ok": true, "channel": msg.Channel, "ts": ts})
+}
+
+func (si *StandIn) discordWebhook(w http.ResponseWriter, r *http.Request) {
+	var msg discordMessage
+	if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+		http.Error(w, `{"message": "Cannot send an empty message"}`, http.StatusBadRequest)
+		return
+	}
+
+	thread := r.URL.Query().Get("thread_id")
+	id := si.record(StandInMessage{Service: "discord", Channel: r.PathValue("channel"), Thread: thread, Text: msg.Content})
+
+	channelID := r.PathValue("channel")
+	switch {
+	case thread != "":
+		channelID = thread
+	case msg.ThreadName != "":
+		// The thread started by a message in a forum channel has the same ID as the message
+		channelID = id
+	}
+
+	if r.URL.Query().Get("wait") != "true" {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]any{"id": id, "channel_id": channelID, "content": msg.Content})
+}
@@ -0,0 +1,161 @@
+package preview
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// Preview is an environment generated for a pull request of an app
+type Preview struct {
+	App         string `json:"app"`
+	PullRequest int    `json:"pullRequest"`
+
+	// InstallationID is the installation of the GitHub App for the app, used to comment on the pull request
+	InstallationID int64 `json:"installationID"`
+
+	Name    string `json:"name"`
+	Overlay string `json:"overlay"`
+	Host    string `json:"host"`
+	SHA     string `json:"sha"`
+
+	// CommentID is the comment with the preview URL on the pull request
+	CommentID int64 `json:"commentID,omitempty"`
+
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Store keeps previews in a JSON file
+type Store struct {
+	path string
+
+	lock     sync.Mutex
+	previews []*Preview
+}
+
+// NewStore loads previews from path. They are kept only in memory if path is empty.
+func NewStore(path string) (*Store, error) {
+	s := &Store{
+		path: path,
+	}
+
+	if path == "" {
+		return s, nil
+	}
+
+	b, err := os.ReadFile(path)
+
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+
+	if err != nil {
+		return nil, xerrors.Errorf("failed to read %s: %w", path, err)
+	}
+
+	if err := json.Unmarshal(b, &s.previews); err != nil {
+		return nil, xerrors.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	return s, nil
+}
+
+// Get returns the preview for the pull request of the app
+func (s *Store) Get(app string, number int) (*Preview, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, p := range s.previews {
+		if p.App == app && p.PullRequest == number {
+			cp := *p
+
+			return &cp, true
+		}
+	}
+
+	return nil, false
+}
+
+// List returns all previews ordered by the last update
+func (s *Store) List() []Preview {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	list := make([]Preview, 0, len(s.previews))
+	for _, p := range s.previews {
+		list = append(list, *p)
+	}
+
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].UpdatedAt.Before(list[j].UpdatedAt)
+	})
+
+	return list
+}
+
+// Save adds or replaces the preview
+func (s *Store) Save(p Preview) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for i := range s.previews {
+		if s.previews[i].App == p.App && s.previews[i].PullRequest == p.PullRequest {
+			s.previews[i] = &p
+
+			return s.persist()
+		}
+	}
+
+	s.previews = append(s.previews, &p)
+
+	return s.persist()
+}
+
+// Delete removes the preview for the pull request of the app
+func (s *Store) Delete(app string, number int) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for i := range s.previews {
+		if s.previews[i].App == app && s.previews[i].PullRequest == number {
+			s.previews = append(s.previews[:i], s.previews[i+1:]...)
+
+			return s.persist()
+		}
+	}
+
+	return nil
+}
+
+func (s *Store) persist() error {
+	if s.path == "" {
+		return nil
+	}
+
+	b, err := json.MarshalIndent(s.previews, "", "  ")
+
+	if err != nil {
+		return xerrors.Errorf("failed to encode previews: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return xerrors.Errorf("failed to create directory for %s: %w", s.path, err)
+	}
+
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return xerrors.Errorf("failed to write %s: %w", tmp, err)
+	}
+
+	if err := os.Rename(tmp, s.path); err != nil {
+		return xerrors.Errorf("failed to replace %s: %w", s.path, err)
+	}
+
+	return nil
+}
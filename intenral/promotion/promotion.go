@@ -0,0 +1,118 @@
+package promotion
+
+import (
+	"time"
+)
+
+// Status represents the state of a promotion
+type Status string
+
+const (
+	// StatusActive means the promotion is in progress
+	StatusActive Status = "active"
+
+	// StatusCompleted means the SHA reached the last environment
+	StatusCompleted Status = "completed"
+
+	// StatusFailed means a stage failed and the SHA is not promoted further
+	StatusFailed Status = "failed"
+
+	// StatusSuperseded means a newer SHA replaced the pull request for the current stage
+	StatusSuperseded Status = "superseded"
+)
+
+// StageStatus represents the state of a stage in a promotion
+type StageStatus string
+
+const (
+	// StagePending means the environment is not updated yet
+	StagePending StageStatus = "pending"
+
+	// StageOpen means a pull request for the environment is open
+	StageOpen StageStatus = "open"
+
+	// StageSoaking means the change was merged and is soaking in the environment
+	StageSoaking StageStatus = "soaking"
+
+	// StageDone means the change soaked without failures
+	StageDone StageStatus = "done"
+
+	// StageFailed means the change could not be applied or failed in the environment
+	StageFailed StageStatus = "failed"
+)
+
+// Stage is a state of a promotion for an environment
+type Stage struct {
+	Environment string      `json:"environment"`
+	Status      StageStatus `json:"status"`
+
+	Branch      string `json:"branch,omitempty"`
+	PullRequest int    `json:"pullRequest,omitempty"`
+	MergeCommit string `json:"mergeCommit,omitempty"`
+
+	OpenedAt time.Time `json:"openedAt,omitempty"`
+	MergedAt time.Time `json:"mergedAt,omitempty"`
+	DoneAt   time.Time `json:"doneAt,omitempty"`
+
+	// Reason describes why the stage failed or is waiting
+	Reason string `json:"reason,omitempty"`
+}
+
+// Promotion tracks a SHA of an app through its environments
+type Promotion struct {
+	App    string  `json:"app"`
+	SHA    string  `json:"sha"`
+	Status Status  `json:"status"`
+	Stages []Stage `json:"stages"`
+
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// New initializes a promotion of sha through environments in order
+func New(app, sha string, environments []string) *Promotion {
+	now := time.Now()
+
+	p := &Promotion{
+		App:       app,
+		SHA:       sha,
+		Status:    StatusActive,
+		Stages:    make([]Stage, 0, len(environments)),
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	for _, env := range environments {
+		p.Stages = append(p.Stages, Stage{
+			Environment: env,
+			Status:      StagePending,
+		})
+	}
+
+	return p
+}
+
+// Current returns the index of the first stage which is not done. -1 is returned if every stage is done.
+func (p *Promotion) Current() int {
+	for i := range p.Stages {
+		if p.Stages[i].Status != StageDone {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// Fail marks the stage and the promotion as failed
+func (p *Promotion) Fail(stage int, reason string) {
+	p.Stages[stage].Status = StageFailed
+	p.Stages[stage].Reason = reason
+	p.Status = StatusFailed
+}
+
+func (p *Promotion) clone() *Promotion {
+	c := *p
+	c.Stages = append([]Stage(nil), p.Stages...)
+
+	return &c
+}
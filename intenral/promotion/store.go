@@ -0,0 +1,177 @@
+package promotion
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+const (
+	// maxFinishedPerApp is the number of finished promotions kept for each app
+	maxFinishedPerApp = 50
+)
+
+// Store keeps promotions in a JSON file
+type Store struct {
+	path string
+
+	lock       sync.Mutex
+	promotions []*Promotion
+}
+
+// NewStore loads promotions from path. Promotions are kept only in memory if path is empty.
+func NewStore(path string) (*Store, error) {
+	s := &Store{
+		path: path,
+	}
+
+	if path == "" {
+		return s, nil
+	}
+
+	b, err := os.ReadFile(path)
+
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+
+	if err != nil {
+		return nil, xerrors.Errorf("failed to read %s: %w", path, err)
+	}
+
+	if err := json.Unmarshal(b, &s.promotions); err != nil {
+		return nil, xerrors.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	return s, nil
+}
+
+// Get returns the promotion of sha for the app
+func (s *Store) Get(app, sha string) (*Promotion, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, p := range s.promotions {
+		if p.App == app && p.SHA == sha {
+			return p.clone(), true
+		}
+	}
+
+	return nil, false
+}
+
+// List returns promotions for the app, or all promotions if app is empty, newest first
+func (s *Store) List(app string) []*Promotion {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var list []*Promotion
+	for i := len(s.promotions) - 1; i >= 0; i-- {
+		if app == "" || s.promotions[i].App == app {
+			list = append(list, s.promotions[i].clone())
+		}
+	}
+
+	return list
+}
+
+// Active returns promotions in progress, oldest first
+func (s *Store) Active() []*Promotion {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var list []*Promotion
+	for _, p := range s.promotions {
+		if p.Status == StatusActive {
+			list = append(list, p.clone())
+		}
+	}
+
+	return list
+}
+
+// Save inserts or updates the promotion and persists all promotions
+func (s *Store) Save(p *Promotion) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	p.UpdatedAt = time.Now()
+
+	replaced := false
+	for i := range s.promotions {
+		if s.promotions[i].App == p.App && s.promotions[i].SHA == p.SHA {
+			s.promotions[i] = p.clone()
+			replaced = true
+			break
+		}
+	}
+
+	if !replaced {
+		s.promotions = append(s.promotions, p.clone())
+	}
+
+	s.prune()
+
+	return s.persist()
+}
+
+// prune drops old finished promotions
+func (s *Store) prune() {
+	sort.SliceStable(s.promotions, func(i, j int) bool {
+		return s.promotions[i].CreatedAt.Before(s.promotions[j].CreatedAt)
+	})
+
+	finished := map[string]int{}
+	drop := make([]bool, len(s.promotions))
+	for i := len(s.promotions) - 1; i >= 0; i-- {
+		p := s.promotions[i]
+
+		if p.Status == StatusActive {
+			continue
+		}
+
+		finished[p.App]++
+		drop[i] = finished[p.App] > maxFinishedPerApp
+	}
+
+	kept := make([]*Promotion, 0, len(s.promotions))
+	for i, p := range s.promotions {
+		if !drop[i] {
+			kept = append(kept, p)
+		}
+	}
+
+	s.promotions = kept
+}
+
+func (s *Store) persist() error {
+	if s.path == "" {
+		return nil
+	}
+
+	b, err := json.MarshalIndent(s.promotions, "", "  ")
+
+	if err != nil {
+		return xerrors.Errorf("failed to encode promotions: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return xerrors.Errorf("failed to create directory for %s: %w", s.path, err)
+	}
+
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return xerrors.Errorf("failed to write %s: %w", tmp, err)
+	}
+
+	if err := os.Rename(tmp, s.path); err != nil {
+		return xerrors.Errorf("failed to replace %s: %w", s.path, err)
+	}
+
+	return nil
+}
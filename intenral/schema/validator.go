@@ -0,0 +1,167 @@
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+	"golang.org/x/xerrors"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	// ErrSchemaNotFound is returned if no schema is bundled for the resource
+	ErrSchemaNotFound = xerrors.New("schema not found")
+)
+
+// Validator validates Kubernetes resources against JSON schemas on local filesystem without network access.
+//
+// Each directory is searched with the layouts below:
+//   - <dir>/<kind>-<group>-<version>.json (e.g. deployment-apps-v1.json, service-v1.json) as in yannh/kubernetes-json-schema
+//   - <dir>/<group>/<kind>_<version>.json (e.g. cert-manager.io/certificate_v1.json) as in datreeio/CRDs-catalog
+type Validator struct {
+	dirs          []string
+	ignoreMissing bool
+
+	lock    sync.Mutex
+	schemas map[string]*jsonschema.Schema
+	skipped map[string]bool
+}
+
+// NewValidator initializes Validator with schema directories
+// Resources without schemas are accepted if ignoreMissing is true.
+func NewValidator(dirs []string, ignoreMissing bool) *Validator {
+	return &Validator{
+		dirs:          dirs,
+		ignoreMissing: ignoreMissing,
+		schemas:       map[string]*jsonschema.Schema{},
+		skipped:       map[string]bool{},
+	}
+}
+
+type typeMeta struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+}
+
+func (tm typeMeta) candidates(dir string) []string {
+	kind := strings.ToLower(tm.Kind)
+
+	group, version := "", tm.APIVersion
+	if i := strings.LastIndex(tm.APIVersion, "/"); i >= 0 {
+		group, version = tm.APIVersion[:i], tm.APIVersion[i+1:]
+	}
+
+	if group == "" {
+		return []string{
+			filepath.Join(dir, kind+"-"+version+".json"),
+		}
+	}
+
+	return []string{
+		filepath.Join(dir, kind+"-"+strings.Split(group, ".")[0]+"-"+version+".json"),
+		filepath.Join(dir, kind+"-"+strings.ReplaceAll(group, ".", "-")+"-"+version+".json"),
+		filepath.Join(dir, group, kind+"_"+version+".json"),
+	}
+}
+
+func (v *Validator) schema(tm typeMeta) (*jsonschema.Schema, error) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	key := tm.APIVersion + "/" + tm.Kind
+
+	if s, ok := v.schemas[key]; ok {
+		return s, nil
+	}
+
+	for _, dir := range v.dirs {
+		for _, path := range tm.candidates(dir) {
+			if _, err := os.Stat(path); err != nil {
+				continue
+			}
+
+			s, err := jsonschema.NewCompiler().Compile(path)
+
+			if err != nil {
+				return nil, xerrors.Errorf("failed to compile schema %s: %w", path, err)
+			}
+
+			v.schemas[key] = s
+
+			return s, nil
+		}
+	}
+
+	return nil, xerrors.Errorf("%s %s: %w", tm.APIVersion, tm.Kind, ErrSchemaNotFound)
+}
+
+// Validate validates a single resource in YAML
+func (v *Validator) Validate(resource []byte) error {
+	var doc interface{}
+	if err := yaml.Unmarshal(resource, &doc); err != nil {
+		return xerrors.Errorf("failed to parse resource: %w", err)
+	}
+
+	// Convert into the types encoding/json produces, which jsonschema expects
+	b, err := json.Marshal(doc)
+
+	if err != nil {
+		return xerrors.Errorf("failed to convert resource into JSON: %w", err)
+	}
+
+	var tm typeMeta
+	if err := json.Unmarshal(b, &tm); err != nil {
+		return xerrors.Errorf("failed to parse resource: %w", err)
+	}
+
+	if tm.APIVersion == "" || tm.Kind == "" {
+		return xerrors.New("apiVersion and kind are required")
+	}
+
+	s, err := v.schema(tm)
+
+	if xerrors.Is(err, ErrSchemaNotFound) && v.ignoreMissing {
+		v.warnSkipped(tm)
+
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+
+	var obj interface{}
+	if err := dec.Decode(&obj); err != nil {
+		return xerrors.Errorf("failed to parse resource: %w", err)
+	}
+
+	if err := s.Validate(obj); err != nil {
+		return xerrors.Errorf("%s %s is invalid: %w", tm.APIVersion, tm.Kind, err)
+	}
+
+	return nil
+}
+
+// warnSkipped logs a kind accepted without validation, once per kind
+func (v *Validator) warnSkipped(tm typeMeta) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	key := tm.APIVersion + "/" + tm.Kind
+
+	if v.skipped[key] {
+		return
+	}
+	v.skipped[key] = true
+
+	slog.Warn("no schema found, skipping validation", "apiVersion", tm.APIVersion, "kind", tm.Kind, "dirs", v.dirs)
+}
@@ -0,0 +1,155 @@
+package schema
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/xerrors"
+)
+
+const (
+	deploymentSchema = `{
+  "type": "object",
+  "required": ["metadata", "spec"],
+  "properties": {
+    "spec": {
+      "type": "object",
+      "properties": {
+        "replicas": {"type": "integer", "minimum": 0}
+      }
+    }
+  }
+}`
+
+	serviceSchema = `{
+  "type": "object",
+  "properties": {
+    "spec": {
+      "type": "object",
+      "properties": {
+        "ports": {"type": "array", "items": {"type": "object", "required": ["port"]}}
+      }
+    }
+  }
+}`
+
+	certificateSchema = `{
+  "type": "object",
+  "required": ["spec"],
+  "properties": {
+    "spec": {"type": "object", "required": ["secretName"]}
+  }
+}`
+)
+
+// writeSchemas writes schema files into a temporary directory
+func writeSchemas(t *testing.T, files map[string]string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+
+	for name, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return dir
+}
+
+func TestValidate(t *testing.T) {
+	kubernetes := writeSchemas(t, map[string]string{
+		"deployment-apps-v1.json": deploymentSchema,
+		"service-v1.json":         serviceSchema,
+	})
+	crds := writeSchemas(t, map[string]string{
+		"cert-manager.io/certificate_v1.json": certificateSchema,
+	})
+
+	tests := []struct {
+		name          string
+		resource      string
+		ignoreMissing bool
+		valid         bool
+		notFound      bool
+	}{
+		{
+			name:     "valid deployment",
+			resource: "apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: frontend\nspec:\n  replicas: 2\n",
+			valid:    true,
+		},
+		{
+			name:     "invalid deployment",
+			resource: "apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: frontend\nspec:\n  replicas: two\n",
+		},
+		{
+			name:     "missing required field",
+			resource: "apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: frontend\n",
+		},
+		{
+			name:     "core group",
+			resource: "apiVersion: v1\nkind: Service\nmetadata:\n  name: frontend\nspec:\n  ports:\n  - port: 80\n",
+			valid:    true,
+		},
+		{
+			name:     "invalid core group",
+			resource: "apiVersion: v1\nkind: Service\nmetadata:\n  name: frontend\nspec:\n  ports:\n  - targetPort: 80\n",
+		},
+		{
+			name:     "custom resource in the second directory",
+			resource: "apiVersion: cert-manager.io/v1\nkind: Certificate\nmetadata:\n  name: tls\nspec:\n  secretName: tls\n",
+			valid:    true,
+		},
+		{
+			name:     "invalid custom resource",
+			resource: "apiVersion: cert-manager.io/v1\nkind: Certificate\nmetadata:\n  name: tls\nspec: {}\n",
+		},
+		{
+			name:     "unknown kind",
+			resource: "apiVersion: example.com/v1\nkind: Widget\nmetadata:\n  name: w\n",
+			notFound: true,
+		},
+		{
+			name:          "unknown kind ignored",
+			resource:      "apiVersion: example.com/v1\nkind: Widget\nmetadata:\n  name: w\n",
+			ignoreMissing: true,
+			valid:         true,
+		},
+		{
+			name:          "invalid resource is not ignored",
+			resource:      "apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: frontend\nspec:\n  replicas: -1\n",
+			ignoreMissing: true,
+		},
+		{
+			name:     "without kind",
+			resource: "apiVersion: v1\nmetadata:\n  name: frontend\n",
+		},
+		{
+			name:     "malformed",
+			resource: "kind: [",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := NewValidator([]string{kubernetes, crds}, tt.ignoreMissing)
+
+			err := v.Validate([]byte(tt.resource))
+
+			if tt.valid != (err == nil) {
+				t.Errorf("Validate() = %v, want valid: %v", err, tt.valid)
+			}
+
+			if tt.notFound != xerrors.Is(err, ErrSchemaNotFound) {
+				t.Errorf("Validate() = %v, want ErrSchemaNotFound: %v", err, tt.notFound)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package tracing
+
+import (
+	"context"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/xerrors"
+)
+
+const (
+	serviceName = "mischan-bot"
+
+	// ExporterOTLP exports spans with OTLP over HTTP configured by OTEL_EXPORTER_OTLP_* variables
+	ExporterOTLP = "otlp"
+
+	// ExporterConsole writes spans to stdout for local testing
+	ExporterConsole = "console"
+
+	// ExporterNone disables tracing
+	ExporterNone = "none"
+)
+
+var tracer = otel.Tracer("github.com/MISW/mischan-bot")
+
+// Setup installs the global tracer provider exporting spans with the exporter.
+// shutdown flushes spans and must be called before exit.
+func Setup(ctx context.Context, exporter string) (shutdown func(context.Context) error, err error) {
+	var exp sdktrace.SpanExporter
+
+	switch exporter {
+	case "", ExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case ExporterOTLP:
+		exp, err = otlptracehttp.New(ctx)
+	case ExporterConsole, "stdout":
+		exp, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	default:
+		return nil, xerrors.Errorf("unknown trace exporter: %s", exporter)
+	}
+
+	if err != nil {
+		return nil, xerrors.Errorf("failed to initialize %s exporter: %w", exporter, err)
+	}
+
+	res, err := resource.Merge(
+		resource.Default(),
+		resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(serviceName)),
+	)
+
+	if err != nil {
+		return nil, xerrors.Errorf("failed to initialize resource: %w", err)
+	}
+
+	// Set after the default resource so that OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES take precedence
+	res, err = resource.Merge(res, resource.Environment())
+
+	if err != nil {
+		return nil, xerrors.Errorf("failed to initialize resource: %w", err)
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp),
+		sdktrace.WithResource(res),
+	)
+
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	return tp.Shutdown, nil
+}
+
+// Start starts a span as a child of the span in ctx
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// StartLinked starts a root span in ctx linked to the span in origin.
+// It is used for work continued asynchronously after the originating request.
+func StartLinked(ctx, origin context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer.Start(
+		ctx, name,
+		trace.WithNewRoot(),
+		trace.WithLinks(trace.LinkFromContext(origin)),
+		trace.WithAttributes(attrs...),
+	)
+}
+
+// End records err on span if not nil and ends span
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
+}
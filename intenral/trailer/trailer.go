@@ -0,0 +1,202 @@
+package trailer
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Keys of trailers on commits created by the bot
+const (
+	KeySourceRepository = "Source-Repository"
+	KeySourceCommit     = "Source-Commit"
+	KeyEnvironment      = "Environment"
+	KeyImages           = "Images"
+	KeyTriggeredBy      = "Triggered-By"
+	KeyDeliveryID       = "Delivery-ID"
+)
+
+// Trailers are machine-readable metadata of commits in the manifest repository as git trailers.
+// e.g.
+//
+//	Update MISW/Portal to abc1234 in staging
+//
+//	Source-Repository: MISW/Portal
+//	Source-Commit: abc1234
+//	Environment: staging
+//	Images: registry.misw.jp/portal/backend:sha-abc1234, registry.misw.jp/portal/frontend:sha-abc1234
+//	Triggered-By: push by octocat
+//	Delivery-ID: 72d3162e-cc78-11e3-81ab-4c9367dc0958
+type Trailers struct {
+	SourceRepository string
+	SourceCommit     string
+	Environment      string
+
+	// Images are tags of images by name
+	Images map[string]string
+
+	// TriggeredBy is the event and the actor(e.g. "push by octocat", "promotion")
+	TriggeredBy string
+
+	// DeliveryID is the ID of the GitHub webhook delivery which triggered the commit
+	DeliveryID string
+}
+
+// TriggeredBy formats the event and the actor for the Triggered-By trailer
+func TriggeredBy(event, actor string) string {
+	switch {
+	case event == "":
+		return actor
+	case actor == "":
+		return event
+	default:
+		return event + " by " + actor
+	}
+}
+
+// Actor returns the actor in TriggeredBy
+func (t *Trailers) Actor() string {
+	_, actor, found := strings.Cut(t.TriggeredBy, " by ")
+
+	if !found {
+		return ""
+	}
+
+	return actor
+}
+
+func (t *Trailers) lines() []string {
+	var lines []string
+
+	add := func(key, value string) {
+		if value != "" {
+			lines = append(lines, key+": "+value)
+		}
+	}
+
+	add(KeySourceRepository, t.SourceRepository)
+	add(KeySourceCommit, t.SourceCommit)
+	add(KeyEnvironment, t.Environment)
+	add(KeyImages, formatImages(t.Images))
+	add(KeyTriggeredBy, t.TriggeredBy)
+	add(KeyDeliveryID, t.DeliveryID)
+
+	return lines
+}
+
+// Append appends non-empty trailers to message.
+// They are added to the trailer block of message if it already ends with one.
+func (t *Trailers) Append(message string) string {
+	lines := t.lines()
+
+	if len(lines) == 0 {
+		return message
+	}
+
+	message = strings.TrimRight(message, "\n")
+
+	if _, ok := lastBlock(message); !ok {
+		message += "\n"
+	}
+
+	return message + "\n" + strings.Join(lines, "\n") + "\n"
+}
+
+// Subject returns the first line of message
+func Subject(message string) string {
+	subject, _, _ := strings.Cut(message, "\n")
+
+	return strings.TrimSpace(subject)
+}
+
+// Parse parses the trailer block at the end of message.
+// ok is false if message has none of the trailers by the bot.
+func Parse(message string) (t Trailers, ok bool) {
+	block, found := lastBlock(strings.TrimRight(message, "\n"))
+
+	if !found {
+		return Trailers{}, false
+	}
+
+	for _, line := range block {
+		key, value, _ := strings.Cut(line, ":")
+		value = strings.TrimSpace(value)
+
+		switch key {
+		case KeySourceRepository:
+			t.SourceRepository = value
+		case KeySourceCommit:
+			t.SourceCommit = value
+		case KeyEnvironment:
+			t.Environment = value
+		case KeyImages:
+			t.Images = parseImages(value)
+		case KeyTriggeredBy:
+			t.TriggeredBy = value
+		case KeyDeliveryID:
+			t.DeliveryID = value
+		default:
+			continue
+		}
+
+		ok = true
+	}
+
+	return t, ok
+}
+
+// lastBlock returns lines of the last paragraph of message if it is a trailer block.
+// The subject line is never regarded as trailers.
+func lastBlock(message string) ([]string, bool) {
+	i := strings.LastIndex(message, "\n\n")
+
+	if i < 0 {
+		return nil, false
+	}
+
+	lines := strings.Split(message[i+2:], "\n")
+
+	for _, line := range lines {
+		key, _, found := strings.Cut(line, ": ")
+
+		if !found || key == "" || strings.ContainsAny(key, " \t") {
+			return nil, false
+		}
+	}
+
+	return lines, true
+}
+
+func formatImages(images map[string]string) string {
+	names := make([]string, 0, len(images))
+	for name := range images {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	refs := make([]string, 0, len(names))
+	for _, name := range names {
+		refs = append(refs, fmt.Sprintf("%s:%s", name, images[name]))
+	}
+
+	return strings.Join(refs, ", ")
+}
+
+func parseImages(value string) map[string]string {
+	images := map[string]string{}
+
+	for _, ref := range strings.Split(value, ",") {
+		ref = strings.TrimSpace(ref)
+
+		// Registries may have ports, but tags never contain colons
+		i := strings.LastIndex(ref, ":")
+
+		if i <= 0 {
+			continue
+		}
+
+		images[ref[:i]] = ref[i+1:]
+	}
+
+	return images
+}
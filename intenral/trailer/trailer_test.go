@@ -0,0 +1,190 @@
+package trailer
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAppend(t *testing.T) {
+	full := &Trailers{
+		SourceRepository: "MISW/Portal",
+		SourceCommit:     "0123456789abcdef0123456789abcdef01234567",
+		Environment:      "staging",
+		Images: map[string]string{
+			"registry.misw.jp/portal/frontend": "sha-0123456",
+			"registry.misw.jp/portal/backend":  "sha-0123456",
+		},
+		TriggeredBy: "push by octocat",
+		DeliveryID:  "72d3162e-cc78-11e3-81ab-4c9367dc0958",
+	}
+
+	tests := []struct {
+		name     string
+		trailers *Trailers
+		message  string
+		want     string
+	}{
+		{
+			name:     "subject only",
+			trailers: full,
+			message:  "Update MISW/Portal to 0123456 in staging",
+			want: "Update MISW/Portal to 0123456 in staging\n\n" +
+				"Source-Repository: MISW/Portal\n" +
+				"Source-Commit: 0123456789abcdef0123456789abcdef01234567\n" +
+				"Environment: staging\n" +
+				"Images: registry.misw.jp/portal/backend:sha-0123456, registry.misw.jp/portal/frontend:sha-0123456\n" +
+				"Triggered-By: push by octocat\n" +
+				"Delivery-ID: 72d3162e-cc78-11e3-81ab-4c9367dc0958\n",
+		},
+		{
+			name:     "empty trailers are omitted",
+			trailers: &Trailers{SourceRepository: "MISW/Portal", TriggeredBy: "promotion"},
+			message:  "Update MISW/Portal to 0123456\n",
+			want:     "Update MISW/Portal to 0123456\n\nSource-Repository: MISW/Portal\nTriggered-By: promotion\n",
+		},
+		{
+			name:     "body",
+			trailers: &Trailers{SourceCommit: "0123456"},
+			message:  "Update MISW/Portal to 0123456\n\nRegenerated on the latest master.",
+			want:     "Update MISW/Portal to 0123456\n\nRegenerated on the latest master.\n\nSource-Commit: 0123456\n",
+		},
+		{
+			name:     "existing trailer block",
+			trailers: &Trailers{TriggeredBy: "admin by alice"},
+			message:  "Update MISW/Portal to 0123456\n\nSigned-off-by: alice <alice@example.com>\n",
+			want:     "Update MISW/Portal to 0123456\n\nSigned-off-by: alice <alice@example.com>\nTriggered-By: admin by alice\n",
+		},
+		{
+			name:     "no trailers",
+			trailers: &Trailers{},
+			message:  "Update MISW/Portal to 0123456",
+			want:     "Update MISW/Portal to 0123456",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.trailers.Append(tt.message); got != tt.want {
+				t.Errorf("Append() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		want    Trailers
+		ok      bool
+	}{
+		{
+			name: "all trailers",
+			message: "Update MISW/Portal to 0123456 in staging\n\n" +
+				"Source-Repository: MISW/Portal\n" +
+				"Source-Commit: 0123456789abcdef0123456789abcdef01234567\n" +
+				"Environment: staging\n" +
+				"Images: registry.misw.jp/portal/backend:sha-0123456, registry.misw.jp/portal/frontend:sha-0123456\n" +
+				"Triggered-By: push by octocat\n" +
+				"Delivery-ID: 72d3162e-cc78-11e3-81ab-4c9367dc0958\n",
+			want: Trailers{
+				SourceRepository: "MISW/Portal",
+				SourceCommit:     "0123456789abcdef0123456789abcdef01234567",
+				Environment:      "staging",
+				Images: map[string]string{
+					"registry.misw.jp/portal/frontend": "sha-0123456",
+					"registry.misw.jp/portal/backend":  "sha-0123456",
+				},
+				TriggeredBy: "push by octocat",
+				DeliveryID:  "72d3162e-cc78-11e3-81ab-4c9367dc0958",
+			},
+			ok: true,
+		},
+		{
+			name:    "registry with port",
+			message: "Update\n\nImages: localhost:5000/portal/frontend:sha-0123456,broken, :latest\n",
+			want:    Trailers{Images: map[string]string{"localhost:5000/portal/frontend": "sha-0123456"}},
+			ok:      true,
+		},
+		{
+			name:    "unknown trailers are ignored",
+			message: "Update\n\nSigned-off-by: alice <alice@example.com>\nSource-Commit: 0123456\n\n",
+			want:    Trailers{SourceCommit: "0123456"},
+			ok:      true,
+		},
+		{
+			name:    "only unknown trailers",
+			message: "Update\n\nSigned-off-by: alice <alice@example.com>\n",
+		},
+		{
+			name:    "trailers not in the last paragraph",
+			message: "Update\n\nSource-Commit: 0123456\n\nReverted by hand.\n",
+		},
+		{
+			name:    "subject looking like a trailer",
+			message: "Source-Commit: 0123456\n",
+		},
+		{
+			name:    "paragraph with prose",
+			message: "Update\n\nSource-Commit: 0123456\nthis is not a trailer\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := Parse(tt.message)
+
+			if ok != tt.ok {
+				t.Fatalf("Parse() ok = %v, want %v", ok, tt.ok)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAppendAndParse(t *testing.T) {
+	want := Trailers{
+		SourceRepository: "MISW/modoki-k8s",
+		SourceCommit:     "fedcba9876543210fedcba9876543210fedcba98",
+		Images:           map[string]string{"modokipaas/modoki-k8s": "sha-fedcba9"},
+		TriggeredBy:      "rollback by alice",
+	}
+
+	got, ok := Parse(want.Append("Rollback MISW/modoki-k8s to fedcba9"))
+
+	if !ok || !reflect.DeepEqual(got, want) {
+		t.Errorf("Parse(Append()) = %+v, %v, want %+v", got, ok, want)
+	}
+}
+
+func TestActor(t *testing.T) {
+	tests := []struct {
+		event, actor string
+		triggeredBy  string
+	}{
+		{"push", "octocat", "push by octocat"},
+		{"promotion", "", "promotion"},
+		{"", "alice", "alice"},
+	}
+
+	for _, tt := range tests {
+		triggeredBy := TriggeredBy(tt.event, tt.actor)
+
+		if triggeredBy != tt.triggeredBy {
+			t.Errorf("TriggeredBy(%q, %q) = %q, want %q", tt.event, tt.actor, triggeredBy, tt.triggeredBy)
+		}
+
+		// Actors without events cannot be told from events
+		want := tt.actor
+		if tt.event == "" {
+			want = ""
+		}
+
+		if actor := (&Trailers{TriggeredBy: triggeredBy}).Actor(); actor != want {
+			t.Errorf("Actor() of %q = %q, want %q", triggeredBy, actor, want)
+		}
+	}
+}
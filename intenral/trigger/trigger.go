@@ -0,0 +1,31 @@
+package trigger
+
+import (
+	"context"
+)
+
+// Trigger describes what started an operation
+type Trigger struct {
+	// Actor is the GitHub login of the user who triggered the operation if known
+	Actor string
+
+	// Event is the kind of the trigger(e.g. push, check_suite, rollback)
+	Event string
+
+	// DeliveryID is the ID of the GitHub webhook delivery(X-GitHub-Delivery) if triggered by a webhook
+	DeliveryID string
+}
+
+type contextKey struct{}
+
+// With returns a copy of ctx carrying t
+func With(ctx context.Context, t Trigger) context.Context {
+	return context.WithValue(ctx, contextKey{}, t)
+}
+
+// From returns the trigger carried by ctx. A zero value is returned if ctx has none.
+func From(ctx context.Context) Trigger {
+	t, _ := ctx.Value(contextKey{}).(Trigger)
+
+	return t
+}
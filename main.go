@@ -3,13 +3,29 @@ package main
 import (
 	"context"
 	"fmt"
-	"log"
+	"log/slog"
+	"os"
+	"path/filepath"
 	"time"
 
 	"github.com/MISW/mischan-bot/config"
 	"github.com/MISW/mischan-bot/handler"
+	"github.com/MISW/mischan-bot/intenral/audit"
+	"github.com/MISW/mischan-bot/intenral/auth"
+	"github.com/MISW/mischan-bot/intenral/cloudevent"
+	"github.com/MISW/mischan-bot/intenral/freeze"
 	"github.com/MISW/mischan-bot/intenral/ghsink"
+	"github.com/MISW/mischan-bot/intenral/gitutil"
+	"github.com/MISW/mischan-bot/intenral/history"
+	"github.com/MISW/mischan-bot/intenral/logging"
+	"github.com/MISW/mischan-bot/intenral/manifrepo"
+	"github.com/MISW/mischan-bot/intenral/notify"
+	"github.com/MISW/mischan-bot/intenral/preview"
+	"github.com/MISW/mischan-bot/intenral/promotion"
+	"github.com/MISW/mischan-bot/intenral/schema"
+	"github.com/MISW/mischan-bot/intenral/tracing"
 	"github.com/MISW/mischan-bot/repository"
+	"github.com/MISW/mischan-bot/repository/manifest"
 	"github.com/MISW/mischan-bot/repository/mischanbot"
 	"github.com/MISW/mischan-bot/repository/modoki"
 	"github.com/MISW/mischan-bot/repository/portal"
@@ -23,15 +39,69 @@ import (
 
 func must(err error) {
 	if err != nil {
-		log.Fatalf("failed to initialize container: %+v", err)
+		slog.Error("failed to initialize container", "error", err)
+		os.Exit(1)
 	}
 }
 
 func main() {
+	name, args := "serve", []string{}
+	if len(os.Args) > 1 {
+		name, args = os.Args[1], os.Args[2:]
+	}
+
+	// Only the server opens the state. Other commands call its admin API to change it.
+	container := newContainer(name == "serve")
+
+	if err := runCommand(container, name, args); err != nil {
+		slog.Error("command failed", "command", name, "error", err)
+		os.Exit(1)
+	}
+}
+
+// start sets up tracing and registers app repositories for commands handling apps.
+// flush must be called before exit to export batched spans.
+func start(container *dig.Container) (flush func(), err error) {
+	var shutdownTracing func(context.Context) error
+	if err := container.Invoke(func(cfg *config.Config) (err error) {
+		shutdownTracing, err = tracing.Setup(context.Background(), cfg.TracesExporter)
+
+		return err
+	}); err != nil {
+		return nil, err
+	}
+
+	flush = func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		if err := shutdownTracing(ctx); err != nil {
+			slog.Error("failed to flush spans", "error", err)
+		}
+	}
+
+	if err := registerRepositories(container); err != nil {
+		flush()
+
+		return nil, err
+	}
+
+	return flush, nil
+}
+
+// newContainer provides dependencies. Nothing is initialized until invoked.
+// State such as promotions and the deployment history is kept in memory unless persistent is true.
+func newContainer(persistent bool) *dig.Container {
 	container := dig.New()
 
 	must(container.Provide(func() *echo.Echo {
-		return echo.New()
+		e := echo.New()
+
+		// Only JSON lines are written by slog
+		e.HideBanner = true
+		e.HidePort = true
+
+		return e
 	}))
 
 	must(container.Provide(func() (*config.Config, error) {
@@ -41,15 +111,48 @@ func main() {
 			return nil, xerrors.Errorf("failed to initialize config: %w", err)
 		}
 
+		slog.SetDefault(logging.New(os.Stderr, cfg.LogLevel))
+
 		return cfg, nil
 	}))
 
 	must(container.Provide(usecase.NewGitHubEventUsecase))
 
+	must(container.Provide(usecase.NewRollbackUsecase))
+
+	must(container.Provide(usecase.NewFreezeUsecase))
+
+	must(container.Provide(usecase.NewHistoryUsecase))
+
+	must(container.Provide(usecase.NewHealthUsecase))
+
+	must(container.Provide(usecase.NewDashboardUsecase))
+
+	must(container.Provide(usecase.NewAuditUsecase))
+
+	must(container.Provide(usecase.NewRunUsecase))
+
+	must(container.Provide(auth.NewAuthenticator))
+
 	must(container.Provide(repository.NewRepositoryBundler))
 
-	must(container.Provide(func(cfg *config.Config) (*ghsink.GitHubSink, error) {
-		ghs, err := ghsink.NewGitHubSink(cfg)
+	must(container.Provide(func(cfg *config.Config) (*audit.Log, error) {
+		path := cfg.AuditLogPath
+		if path == "" && cfg.StateDir != "" {
+			path = filepath.Join(cfg.StateDir, "audit.jsonl")
+		}
+
+		log, err := audit.NewLog(path, cfg.AuditLogMaxSizeMB<<20, cfg.AuditLogMaxFiles)
+
+		if err != nil {
+			return nil, xerrors.Errorf("failed to initialize audit log: %w", err)
+		}
+
+		return log, nil
+	}))
+
+	must(container.Provide(func(cfg *config.Config, auditLog *audit.Log) (*ghsink.GitHubSink, error) {
+		ghs, err := ghsink.NewGitHubSink(cfg, auditLog)
 
 		if err != nil {
 			return nil, xerrors.Errorf("failed to initialize GitHub App client sink: %w", err)
@@ -58,6 +161,14 @@ func main() {
 		return ghs, nil
 	}))
 
+	must(container.Provide(func(cfg *config.Config) *gitutil.MirrorCache {
+		if cfg.MirrorDir == "" {
+			return nil
+		}
+
+		return gitutil.NewMirrorCache(cfg.MirrorDir)
+	}))
+
 	must(container.Provide(func(ghs *ghsink.GitHubSink) (*github.App, error) {
 		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
@@ -95,22 +206,181 @@ func main() {
 		return user, nil
 	}))
 
-	// Register app repositories
-	must(container.Invoke(func(repoBundler *repository.RepositoryBundler, cfg *config.Config, ghs *ghsink.GitHubSink, app *github.App, botUser *github.User) {
-		repoBundler.RegisterRepository(portal.NewPortalRepository(cfg, ghs, app, botUser))
+	must(container.Provide(func(cfg *config.Config) *schema.Validator {
+		if len(cfg.SchemaDirs) == 0 {
+			return nil
+		}
+
+		return schema.NewValidator(cfg.SchemaDirs, cfg.SchemaIgnoreMissing)
 	}))
-	must(container.Invoke(func(repoBundler *repository.RepositoryBundler, cfg *config.Config, ghs *ghsink.GitHubSink, app *github.App, botUser *github.User) {
-		repoBundler.RegisterRepository(mischanbot.NewMischanBotRepository(cfg, ghs, app, botUser))
+
+	must(container.Provide(func(
+		cfg *config.Config,
+		ghs *ghsink.GitHubSink,
+		mirrors *gitutil.MirrorCache,
+		validator *schema.Validator,
+		auditLog *audit.Log,
+		app *github.App,
+		botUser *github.User,
+	) *manifrepo.Factory {
+		f := manifrepo.NewFactory(ghs, cfg.ManifestRepo)
+		f.Mirrors = mirrors
+		f.Validator = validator
+		f.Audit = auditLog
+		f.CommiterName = app.GetName()
+		f.CommiterEmail = fmt.Sprintf("%d+%s[bot]@users.noreply.github.com", botUser.GetID(), app.GetSlug())
+
+		return f
 	}))
-	must(container.Invoke(func(repoBundler *repository.RepositoryBundler, cfg *config.Config, ghs *ghsink.GitHubSink, app *github.App, botUser *github.User) {
-		repoBundler.RegisterRepository(modoki.NewModokiRepository(cfg, ghs, app, botUser))
+
+	must(container.Provide(func(cfg *config.Config) (*promotion.Store, error) {
+		path := statePath(cfg, persistent, "promotions.json")
+
+		store, err := promotion.NewStore(path)
+
+		if err != nil {
+			return nil, xerrors.Errorf("failed to initialize promotion store: %w", err)
+		}
+
+		return store, nil
 	}))
 
-	must(container.Invoke(func(e *echo.Echo, cfg *config.Config, ghu usecase.GitHubEventUsecase) error {
+	must(container.Provide(repository.NewPromotionPipeline))
+
+	must(container.Provide(func(cfg *config.Config) (*freeze.Store, error) {
+		path := statePath(cfg, persistent, "freeze.json")
+
+		store, err := freeze.NewStore(path)
+
+		if err != nil {
+			return nil, xerrors.Errorf("failed to initialize freeze store: %w", err)
+		}
+
+		return store, nil
+	}))
+
+	must(container.Provide(repository.NewFreezeGate))
+
+	must(container.Provide(func(cfg *config.Config) (*history.Store, error) {
+		path := statePath(cfg, persistent, "history.db")
+
+		store, err := history.NewStore(path)
+
+		if err != nil {
+			return nil, xerrors.Errorf("failed to initialize deployment history: %w", err)
+		}
+
+		return store, nil
+	}))
+
+	must(container.Provide(func(cfg *config.Config) *notify.StandIn {
+		if !cfg.NotifyStandIn {
+			return nil
+		}
+
+		return notify.NewStandIn()
+	}))
+
+	must(container.Provide(notify.New))
+
+	must(container.Provide(cloudevent.NewDispatcher))
+
+	must(container.Provide(repository.NewDeploymentRecorder))
+
+	must(container.Provide(func(cfg *config.Config) (*preview.Store, error) {
+		path := statePath(cfg, persistent, "previews.json")
+
+		store, err := preview.NewStore(path)
+
+		if err != nil {
+			return nil, xerrors.Errorf("failed to initialize preview store: %w", err)
+		}
+
+		return store, nil
+	}))
+
+	must(container.Provide(repository.NewPreviewManager))
+
+	return container
+}
+
+// statePath returns the path of the state file in STATE_DIR, or an empty path to keep the state in memory
+func statePath(cfg *config.Config, persistent bool, name string) string {
+	if !persistent || cfg.StateDir == "" {
+		return ""
+	}
+
+	return filepath.Join(cfg.StateDir, name)
+}
+
+// registerRepositories registers app repositories to RepositoryBundler
+func registerRepositories(container *dig.Container) error {
+	return container.Invoke(func(
+		repoBundler *repository.RepositoryBundler,
+		cfg *config.Config,
+		ghs *ghsink.GitHubSink,
+		manifests *manifrepo.Factory,
+		promotions *repository.PromotionPipeline,
+		freezes *repository.FreezeGate,
+		deployments *repository.DeploymentRecorder,
+		previews *repository.PreviewManager,
+	) {
+		repoBundler.RegisterRepository(portal.NewPortalRepository(cfg, ghs, manifests, promotions, freezes, deployments, previews))
+		repoBundler.RegisterRepository(mischanbot.NewMischanBotRepository(cfg, ghs, manifests, promotions, freezes, deployments, previews))
+		repoBundler.RegisterRepository(modoki.NewModokiRepository(cfg, ghs, manifests, promotions, freezes, deployments, previews))
+		repoBundler.RegisterRepository(manifest.NewManifestRepository(cfg, manifests, repoBundler))
+	})
+}
+
+// serve starts the webhook server
+func serve(container *dig.Container) {
+	must(container.Invoke(func(cfg *config.Config) {
+		slog.Info("starting server", "config", cfg)
+	}))
+
+	// Promote SHAs through environments after soak times
+	must(container.Invoke(func(promotions *repository.PromotionPipeline) {
+		go promotions.Run(context.Background(), time.Minute)
+	}))
+
+	// Record deployments in the manifest repository
+	must(container.Invoke(func(deployments *repository.DeploymentRecorder) {
+		go func() {
+			if err := deployments.Backfill(context.Background()); err != nil {
+				slog.Error("failed to backfill deployment history", "error", err)
+			}
+
+			deployments.Run(context.Background(), time.Minute)
+		}()
+	}))
+
+	// Send deployment events to webhooks
+	must(container.Invoke(func(events *cloudevent.Dispatcher) {
+		go events.Run(context.Background())
+	}))
+
+	// Deploy updates held back by freeze windows after the windows end
+	must(container.Invoke(func(freezes *repository.FreezeGate) {
+		go freezes.Run(context.Background(), time.Minute)
+	}))
+
+	// Remove previews not updated for their TTLs
+	must(container.Invoke(func(previews *repository.PreviewManager) {
+		go previews.Run(context.Background(), time.Minute)
+	}))
+
+	must(container.Invoke(func(e *echo.Echo, cfg *config.Config, ghu usecase.GitHubEventUsecase, ru usecase.RollbackUsecase, fu usecase.FreezeUsecase, hu usecase.HistoryUsecase, heu usecase.HealthUsecase, du usecase.DashboardUsecase, au usecase.AuditUsecase, rnu usecase.RunUsecase, authn *auth.Authenticator) error {
 		e.Use(middleware.Recover())
-		e.Use(middleware.Logger())
+		e.Use(handler.RequestLogger())
 
 		handler.BindHandler(e, cfg, ghu)
+		handler.BindMetricsHandler(e)
+		handler.BindHealthHandler(e, heu)
+		handler.BindAuthHandler(e, cfg, authn)
+		handler.BindAdminHandler(e, cfg, authn, ru, fu, hu, au, rnu, ghu)
+		handler.BindDashboardHandler(e, cfg, authn, du)
+
+		slog.Info("listening", "port", cfg.Port)
 
 		if err := e.Start(fmt.Sprintf(":%d", cfg.Port)); err != nil {
 			return xerrors.Errorf("failed to start handler: %w", err)
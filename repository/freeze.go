@@ -0,0 +1,265 @@
+package repository
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/MISW/mischan-bot/config"
+	"github.com/MISW/mischan-bot/intenral/freeze"
+	"github.com/MISW/mischan-bot/intenral/ghsink"
+	"github.com/MISW/mischan-bot/intenral/logging"
+	"github.com/MISW/mischan-bot/intenral/trigger"
+	"github.com/google/go-github/v55/github"
+	"golang.org/x/xerrors"
+)
+
+const (
+	// FreezeOverrideLabel on a pull request in an app repository allows deploying its commits during freeze windows
+	FreezeOverrideLabel = "freeze-override"
+)
+
+var (
+	// ErrNotFrozen is returned if no freeze window is active
+	ErrNotFrozen = xerrors.New("not frozen")
+)
+
+// FreezeGate holds back deployments during freeze windows in config.
+// Updates of apps without environments are queued and deployed when the window ends,
+// while promotions wait for the window before opening pull requests.
+type FreezeGate struct {
+	config      *config.Config
+	ghs         *ghsink.GitHubSink
+	repoBundler *RepositoryBundler
+	store       *freeze.Store
+}
+
+// NewFreezeGate initializes FreezeGate
+func NewFreezeGate(
+	cfg *config.Config,
+	ghs *ghsink.GitHubSink,
+	repoBundler *RepositoryBundler,
+	store *freeze.Store,
+) *FreezeGate {
+	return &FreezeGate{
+		config:      cfg,
+		ghs:         ghs,
+		repoBundler: repoBundler,
+		store:       store,
+	}
+}
+
+// Check returns the active window if sha of the app must not be deployed to the environment now.
+// environment is empty for apps without environments.
+func (fg *FreezeGate) Check(ctx context.Context, app, environment, sha string) (*config.FreezeWindow, error) {
+	now := time.Now()
+	window, end, active := fg.config.ActiveFreezeWindow(app, environment, now)
+
+	if !active {
+		return nil, nil
+	}
+
+	if _, ok := fg.store.Overridden(app, environment, sha, now); ok {
+		return nil, nil
+	}
+
+	user, err := fg.labeledBy(ctx, app, sha)
+
+	if err != nil {
+		return nil, xerrors.Errorf("failed to check %s label for %s: %w", FreezeOverrideLabel, sha, err)
+	}
+
+	if user == "" {
+		return window, nil
+	}
+
+	if err := fg.store.AddOverride(freeze.Override{
+		App:         app,
+		Environment: environment,
+		SHA:         sha,
+		User:        user,
+		Via:         "label",
+		Window:      window.Name,
+		Until:       end,
+		CreatedAt:   now,
+	}); err != nil {
+		return nil, xerrors.Errorf("failed to record override: %w", err)
+	}
+
+	slog.InfoContext(ctx, "freeze window was overridden with label", "window", window.Name, "app", app, "environment", environment, "sha", sha, "user", user)
+
+	return nil, nil
+}
+
+// labeledBy returns the user who added FreezeOverrideLabel to a pull request with sha in the app repository
+func (fg *FreezeGate) labeledBy(ctx context.Context, app, sha string) (string, error) {
+	owner, repo, ok := strings.Cut(app, "/")
+
+	if !ok {
+		return "", xerrors.Errorf("invalid repository name: %s", app)
+	}
+
+	ins, _, err := fg.ghs.AppsClient().Apps.FindRepositoryInstallation(ctx, owner, repo)
+
+	if err != nil {
+		return "", xerrors.Errorf("failed to get installation for %s: %w", app, err)
+	}
+
+	client := fg.ghs.InstallationClient(ins.GetID())
+
+	prs, _, err := client.PullRequests.ListPullRequestsWithCommit(ctx, owner, repo, sha, nil)
+
+	if err != nil {
+		return "", xerrors.Errorf("failed to list pull requests with %s: %w", sha, err)
+	}
+
+	for _, pr := range prs {
+		if !hasLabel(pr.Labels, FreezeOverrideLabel) {
+			continue
+		}
+
+		events, _, err := client.Issues.ListIssueEvents(ctx, owner, repo, pr.GetNumber(), &github.ListOptions{PerPage: 100})
+
+		if err != nil {
+			return "", xerrors.Errorf("failed to list events of pull request %d: %w", pr.GetNumber(), err)
+		}
+
+		user := ""
+		for _, e := range events {
+			if e.GetEvent() == "labeled" && e.GetLabel().GetName() == FreezeOverrideLabel {
+				user = e.GetActor().GetLogin()
+			}
+		}
+
+		if user != "" {
+			return user, nil
+		}
+	}
+
+	return "", nil
+}
+
+func hasLabel(labels []*github.Label, name string) bool {
+	for _, l := range labels {
+		if l.GetName() == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Queue holds back sha of the app until the window ends
+func (fg *FreezeGate) Queue(app, sha string, window *config.FreezeWindow) error {
+	if err := fg.store.Queue(freeze.Pending{
+		App:      app,
+		SHA:      sha,
+		Window:   window.Name,
+		QueuedAt: time.Now(),
+	}); err != nil {
+		return xerrors.Errorf("failed to queue %s of %s: %w", sha, app, err)
+	}
+
+	slog.Info("update is held back by freeze window", "window", window.Name, "app", app, "sha", sha)
+
+	return nil
+}
+
+// Override allows deploying the app to the environment until the active window ends. user is recorded with the override.
+func (fg *FreezeGate) Override(app, environment, user string) (*freeze.Override, error) {
+	now := time.Now()
+	window, end, active := fg.config.ActiveFreezeWindow(app, environment, now)
+
+	if !active {
+		return nil, xerrors.Errorf("%s %s: %w", app, environment, ErrNotFrozen)
+	}
+
+	o := freeze.Override{
+		App:         app,
+		Environment: environment,
+		User:        user,
+		Via:         "api",
+		Window:      window.Name,
+		Until:       end,
+		CreatedAt:   now,
+	}
+
+	if err := fg.store.AddOverride(o); err != nil {
+		return nil, xerrors.Errorf("failed to record override: %w", err)
+	}
+
+	slog.Info("freeze window was overridden", "window", window.Name, "app", app, "environment", environment, "user", user)
+
+	return &o, nil
+}
+
+// Run deploys held back updates every interval until ctx is canceled
+func (fg *FreezeGate) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := fg.Flush(ctx); err != nil {
+			slog.ErrorContext(ctx, "failed to deploy held back updates", "error", err)
+		}
+	}
+}
+
+// Flush deploys held back updates whose windows ended or were overridden
+func (fg *FreezeGate) Flush(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(trigger.With(ctx, trigger.Trigger{Event: "freeze-window"}), 5*time.Minute)
+	defer cancel()
+
+	var failed int
+	for _, p := range fg.store.Pending() {
+		ctx := logging.With(ctx, "app", p.App, "sha", p.SHA)
+
+		if err := fg.flush(ctx, p); err != nil {
+			failed++
+			slog.ErrorContext(ctx, "failed to deploy held back update", "error", err)
+		}
+	}
+
+	if failed != 0 {
+		return xerrors.Errorf("failed to deploy %d held back updates", failed)
+	}
+
+	return nil
+}
+
+func (fg *FreezeGate) flush(ctx context.Context, p freeze.Pending) error {
+	window, err := fg.Check(ctx, p.App, "", p.SHA)
+
+	if err != nil {
+		return err
+	}
+
+	if window != nil {
+		return nil
+	}
+
+	repo, err := fg.repoBundler.Lookup(p.App)
+
+	if err != nil {
+		return xerrors.Errorf("%s: %w", p.App, err)
+	}
+
+	deployer, ok := repo.(Deployer)
+
+	if !ok {
+		return xerrors.Errorf("%s cannot be deployed", p.App)
+	}
+
+	if err := deployer.Deploy(ctx, p.SHA); err != nil {
+		return xerrors.Errorf("failed to deploy: %w", err)
+	}
+
+	return fg.store.Dequeue(p.App, p.SHA)
+}
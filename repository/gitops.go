@@ -0,0 +1,540 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/MISW/mischan-bot/config"
+	"github.com/MISW/mischan-bot/intenral/ghsink"
+	"github.com/MISW/mischan-bot/intenral/history"
+	"github.com/MISW/mischan-bot/intenral/logging"
+	"github.com/MISW/mischan-bot/intenral/manifrepo"
+	"github.com/MISW/mischan-bot/intenral/metrics"
+	"github.com/MISW/mischan-bot/intenral/tracing"
+	"github.com/MISW/mischan-bot/intenral/trailer"
+	"github.com/google/go-github/v55/github"
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/xerrors"
+)
+
+// GitOpsApp describes an app repository whose images are updated in the manifest repository
+type GitOpsApp struct {
+	Owner, Repo string
+
+	// TargetBranch is the deployed branch of the app
+	TargetBranch string
+
+	// ManifestDir is the directory with kustomization.yaml updated if no environment is configured
+	ManifestDir string
+
+	// BranchPrefix is the prefix of branches for pull requests in the manifest repository
+	BranchPrefix string
+
+	// RollbackImage is the image whose tags are the deployed SHAs
+	RollbackImage string
+
+	// Images returns the image tags deployed for shortSHA
+	Images func(shortSHA string) map[string]string
+
+	// Conclusions are conclusions of check runs regarded as passed. Only "success" is if empty.
+	Conclusions []string
+}
+
+// NewGitOpsRepository initializes repository deploying app through the manifest repository
+func NewGitOpsRepository(
+	app GitOpsApp,
+	cfg *config.Config,
+	ghs *ghsink.GitHubSink,
+	manifests *manifrepo.Factory,
+	promotions *PromotionPipeline,
+	freezes *FreezeGate,
+	deployments *DeploymentRecorder,
+	previews *PreviewManager,
+) *GitOpsRepository {
+	if len(app.Conclusions) == 0 {
+		app.Conclusions = []string{"success"}
+	}
+
+	return &GitOpsRepository{
+		app:         app,
+		config:      cfg,
+		ghs:         ghs,
+		manifests:   manifests,
+		promotions:  promotions,
+		freezes:     freezes,
+		deployments: deployments,
+		previews:    previews,
+	}
+}
+
+// GitOpsRepository deploys an app by updating its images in the manifest repository
+type GitOpsRepository struct {
+	app GitOpsApp
+
+	config      *config.Config
+	ghs         *ghsink.GitHubSink
+	manifests   *manifrepo.Factory
+	promotions  *PromotionPipeline
+	freezes     *FreezeGate
+	deployments *DeploymentRecorder
+	previews    *PreviewManager
+}
+
+var (
+	_ Rollbacker         = &GitOpsRepository{}
+	_ Deployer           = &GitOpsRepository{}
+	_ PullRequestHandler = &GitOpsRepository{}
+	_ Runner             = &GitOpsRepository{}
+)
+
+func (gor *GitOpsRepository) FullName() string {
+	return gor.app.Owner + "/" + gor.app.Repo
+}
+
+func (gor *GitOpsRepository) TargetBranch() string {
+	return gor.app.TargetBranch
+}
+
+func (gor *GitOpsRepository) BranchPrefix() string {
+	return gor.app.BranchPrefix
+}
+
+func (gor *GitOpsRepository) ManifestUpdate(branchName, sha string) (string, manifrepo.Manipulator, error) {
+	shortSHA := strings.TrimPrefix(branchName, gor.app.BranchPrefix)
+
+	// Branches for rollbacks are named <prefix>rollback/<short SHA> or <prefix>rollback/<environment>/<short SHA>
+	shortSHA, rollback := strings.CutPrefix(shortSHA, "rollback/")
+
+	// Branches for environments are named <prefix><environment>/<short SHA>
+	environment := ""
+	if i := strings.LastIndex(shortSHA, "/"); i >= 0 {
+		environment, shortSHA = shortSHA[:i], shortSHA[i+1:]
+	}
+
+	dir, _, err := gor.RollbackTarget(environment)
+
+	if err != nil {
+		return "", manifrepo.Manipulator{}, xerrors.Errorf("invalid branch %s: %w", branchName, err)
+	}
+
+	if len(shortSHA) != 7 {
+		return "", manifrepo.Manipulator{}, xerrors.Errorf("unexpected branch name: %s", branchName)
+	}
+
+	// Trailers trace the commit back to the full SHA if it is known
+	if sha == "" {
+		sha = shortSHA
+	} else if !strings.HasPrefix(sha, shortSHA) {
+		return "", manifrepo.Manipulator{}, xerrors.Errorf("%s is not the commit of branch %s", sha, branchName)
+	}
+
+	commitMessage := gor.commitMessage(sha, environment)
+	if rollback {
+		commitMessage = gor.rollbackMessage(sha, environment)
+	}
+
+	return commitMessage, gor.kustomize(dir, shortSHA), nil
+}
+
+func (gor *GitOpsRepository) RollbackTarget(environment string) (string, string, error) {
+	if environment == "" {
+		return gor.app.ManifestDir, gor.app.RollbackImage, nil
+	}
+
+	env, ok := gor.config.App(gor.FullName()).Environment(environment)
+
+	if !ok {
+		return "", "", xerrors.Errorf("%s: %w", environment, ErrUnknownEnvironment)
+	}
+
+	return env.Path, gor.app.RollbackImage, nil
+}
+
+func (gor *GitOpsRepository) commitMessage(sha, environment string) string {
+	subject := fmt.Sprintf("Update %s to %s", gor.FullName(), sha[:7])
+	if environment != "" {
+		subject += " in " + environment
+	}
+
+	return gor.trailers(sha, environment).Append(subject)
+}
+
+func (gor *GitOpsRepository) rollbackMessage(sha, environment string) string {
+	subject := fmt.Sprintf("Rollback %s to %s", gor.FullName(), sha[:7])
+	if environment != "" {
+		subject += " in " + environment
+	}
+
+	return gor.trailers(sha, environment).Append(subject)
+}
+
+// trailers returns trailers which trace commits in the manifest repository back to sha
+func (gor *GitOpsRepository) trailers(sha, environment string) *trailer.Trailers {
+	return &trailer.Trailers{
+		SourceRepository: gor.FullName(),
+		SourceCommit:     sha,
+		Environment:      environment,
+		Images:           gor.Images(sha[:7]),
+	}
+}
+
+func (gor *GitOpsRepository) checkSuiteStatus(
+	ctx context.Context,
+	installationID int64,
+	ref string,
+) (success bool, sha string, completedAt time.Time, err error) {
+	client := gor.ghs.InstallationClient(installationID)
+
+	checkRuns, _, err := client.Checks.ListCheckRunsForRef(ctx, gor.app.Owner, gor.app.Repo, ref, nil)
+
+	if err != nil {
+		return false, "", time.Time{}, xerrors.Errorf("failed list check suites for %s/%s: %w", gor.app.Owner, gor.app.Repo, err)
+	}
+
+	if len(checkRuns.CheckRuns) == 0 {
+		return false, "", time.Time{}, nil
+	}
+
+	success = true
+	for _, suite := range checkRuns.CheckRuns {
+		if suite.GetStatus() != "completed" {
+			success = false
+			break
+		}
+
+		conclusion := suite.GetConclusion()
+
+		slog.DebugContext(ctx, "check run", "name", suite.GetName(), "sha", suite.GetHeadSHA(), "conclusion", conclusion)
+
+		if !containsString(gor.app.Conclusions, conclusion) {
+			success = false
+			break
+		}
+
+		sha = suite.GetHeadSHA()
+
+		if t := suite.GetCompletedAt().Time; t.After(completedAt) {
+			completedAt = t
+		}
+	}
+
+	return
+}
+
+func (gor *GitOpsRepository) pathPolicy(dir string) *manifrepo.PathPolicy {
+	app := gor.config.App(gor.FullName())
+
+	policy := &manifrepo.PathPolicy{
+		AllowedPaths:   app.AllowedPaths,
+		AllowNewFiles:  app.AllowNewFiles,
+		AllowDeletions: app.AllowDeletions,
+	}
+
+	if len(policy.AllowedPaths) == 0 {
+		policy.AllowedPaths = []string{dir}
+	}
+
+	return policy
+}
+
+// Images returns the image tags deployed for shortSHA
+func (gor *GitOpsRepository) Images(shortSHA string) map[string]string {
+	return gor.app.Images(shortSHA)
+}
+
+// kustomize updates images in dir, a directory with kustomization.yaml in the manifest repository
+func (gor *GitOpsRepository) kustomize(dir, shortSHA string) manifrepo.Manipulator {
+	images := gor.Images(shortSHA)
+
+	names := make([]string, 0, len(images))
+	for name := range images {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return manifrepo.Manipulator{
+		Paths:  []string{dir},
+		Policy: gor.pathPolicy(dir),
+		Apply: func(ctx context.Context, root string) error {
+			for _, name := range names {
+				cmd := exec.CommandContext(
+					ctx, "kustomize", "edit", "set", "image", name+":"+images[name],
+				)
+				cmd.Dir = filepath.Join(root, dir)
+
+				b, err := cmd.CombinedOutput()
+
+				if err != nil {
+					return xerrors.Errorf("failed to kustomize(%s): %w", string(b), err)
+				}
+			}
+
+			return nil
+		},
+	}
+}
+
+// CheckStatus returns whether all checks passed on ref and the SHA they ran on
+func (gor *GitOpsRepository) CheckStatus(ctx context.Context, installationID int64, ref string) (bool, string, error) {
+	success, sha, _, err := gor.checkSuiteStatus(ctx, installationID, ref)
+
+	return success, sha, err
+}
+
+// Run deploys the app through the same path as webhooks with opts
+func (gor *GitOpsRepository) Run(ctx context.Context, installationID int64, opts RunOptions) (*history.Run, error) {
+	return gor.run(ctx, installationID, "", opts)
+}
+
+func (gor *GitOpsRepository) run(ctx context.Context, installationID int64, expectedSHA string, opts RunOptions) (run *history.Run, err error) {
+	ctx, cancel := context.WithTimeout(ctx, 1*time.Minute)
+	defer cancel()
+
+	ctx, span := tracing.Start(ctx, "GitOpsRepository.run", attribute.String("app", gor.FullName()))
+
+	run = NewRun(ctx, gor, installationID, expectedSHA, opts)
+
+	result := "skipped"
+	defer func() {
+		if err != nil {
+			result = "failed"
+		}
+
+		metrics.Runs.WithLabelValues(gor.FullName(), result).Inc()
+		gor.deployments.Finished(ctx, run, result, err)
+
+		span.SetAttributes(attribute.String("result", result))
+		tracing.End(span, err)
+	}()
+
+	// Checks must have passed on the SHA instead of the head of the target branch if specified
+	ref := gor.app.TargetBranch
+	if opts.SHA != "" {
+		ref, expectedSHA = opts.SHA, opts.SHA
+	}
+
+	success, sha, completedAt, err := gor.checkSuiteStatus(ctx, installationID, ref)
+
+	if err != nil {
+		return run, xerrors.Errorf("failed to get latest check suite: %w", err)
+	}
+
+	if !success {
+		return run, nil
+	}
+
+	if len(expectedSHA) != 0 && sha != expectedSHA {
+		return run, nil
+	}
+
+	run.SHA = sha
+	ctx = logging.With(ctx, "app", gor.FullName(), "sha", sha)
+	span.SetAttributes(attribute.String("sha", sha))
+	gor.deployments.Started(ctx, run)
+
+	// Promotions wait for freeze windows of each environment by themselves
+	if opts.Environment == "" && len(gor.config.App(gor.FullName()).Environments) == 0 {
+		window, err := gor.freezes.Check(ctx, gor.FullName(), "", sha)
+
+		if err != nil {
+			return run, xerrors.Errorf("failed to check freeze windows: %w", err)
+		}
+
+		if window != nil {
+			result = "frozen"
+
+			return run, gor.freezes.Queue(gor.FullName(), sha, window)
+		}
+	}
+
+	if opts.Environment != "" {
+		if err := gor.promotions.Restart(ctx, gor, sha, opts.Environment); err != nil {
+			return run, xerrors.Errorf("failed to restart promotion from %s: %w", opts.Environment, err)
+		}
+	} else if err := gor.Deploy(ctx, sha); err != nil {
+		return run, err
+	}
+
+	result = "deployed"
+
+	// Older SHAs deployed manually would skew the latency
+	if opts.SHA == "" {
+		metrics.CheckToPullRequest.WithLabelValues(gor.FullName()).Observe(time.Since(completedAt).Seconds())
+	}
+
+	return run, nil
+}
+
+func (gor *GitOpsRepository) Deploy(ctx context.Context, sha string) error {
+	if len(gor.config.App(gor.FullName()).Environments) != 0 {
+		if err := gor.promotions.Start(ctx, gor, sha); err != nil {
+			return xerrors.Errorf("failed to start promotion: %w", err)
+		}
+
+		return nil
+	}
+
+	manimani, err := gor.manifests.New(ctx)
+
+	if err != nil {
+		return xerrors.Errorf("failed to initialize GitHub client for manifest repository: %w", err)
+	}
+
+	if err := manimani.CloseObsoletePRs(ctx, gor.app.BranchPrefix); err != nil {
+		return xerrors.Errorf("failed to close obsolete PRs: %w", err)
+	}
+
+	shortSHA := sha[:7]
+	commitMessage := gor.commitMessage(sha, "")
+	deployment := NewDeployment(ctx, gor, "", sha)
+
+	if gor.config.App(gor.FullName()).Mode == config.ModeDirect {
+		commit, err := manimani.CommitDirectly(ctx, commitMessage, gor.kustomize(gor.app.ManifestDir, shortSHA))
+		gor.deployments.Committed(ctx, deployment, commit, err)
+
+		if err != nil {
+			return xerrors.Errorf("failed to commit directly: %w", err)
+		}
+
+		return nil
+	}
+
+	deployment.Branch = gor.app.BranchPrefix + shortSHA
+
+	err = manimani.CreatePullRequest(
+		ctx,
+		deployment.Branch,
+		commitMessage,
+		gor.kustomize(gor.app.ManifestDir, shortSHA),
+	)
+	gor.deployments.Opened(ctx, manimani, deployment, err)
+
+	if err != nil {
+		return xerrors.Errorf("failed to create pull request: %w", err)
+	}
+
+	return nil
+}
+
+func (gor *GitOpsRepository) OnCheckSuite(ctx context.Context, event *github.CheckSuiteEvent) error {
+	if event.GetCheckSuite().GetHeadBranch() != gor.app.TargetBranch {
+		for _, pr := range event.GetCheckSuite().PullRequests {
+			if err := gor.preview(ctx, event.GetInstallation().GetID(), pr.GetNumber(), event.GetCheckSuite().GetHeadSHA()); err != nil {
+				return xerrors.Errorf("failed to deploy preview for #%d: %w", pr.GetNumber(), err)
+			}
+		}
+
+		return nil
+	}
+
+	_, err := gor.run(
+		ctx,
+		event.GetInstallation().GetID(),
+		event.GetCheckSuite().GetHeadSHA(),
+		RunOptions{},
+	)
+
+	if err != nil {
+		return xerrors.Errorf("check suite handler failed: %w", err)
+	}
+
+	return nil
+}
+
+func (gor *GitOpsRepository) OnCreate(ctx context.Context, event *github.CreateEvent) error {
+	if event.GetRefType() != "branch" || event.GetRef() != gor.app.TargetBranch {
+		return nil
+	}
+
+	_, err := gor.run(
+		ctx,
+		event.GetInstallation().GetID(),
+		"",
+		RunOptions{},
+	)
+
+	if err != nil {
+		return xerrors.Errorf("check suite handler failed: %w", err)
+	}
+
+	return nil
+}
+
+func (gor *GitOpsRepository) OnPush(ctx context.Context, event *github.PushEvent) error {
+	if event.GetRef() != "refs/heads/"+gor.app.TargetBranch {
+		return nil
+	}
+
+	_, err := gor.run(
+		ctx,
+		event.GetInstallation().GetID(),
+		"",
+		RunOptions{},
+	)
+
+	if err != nil {
+		return xerrors.Errorf("check suite handler failed: %w", err)
+	}
+
+	return nil
+}
+
+func (gor *GitOpsRepository) OnPullRequest(ctx context.Context, event *github.PullRequestEvent) error {
+	pr := event.GetPullRequest()
+
+	switch event.GetAction() {
+	case "opened", "reopened", "synchronize":
+		// Checks have usually not finished yet, and the preview is deployed on check_suite events then
+		err := gor.preview(ctx, event.GetInstallation().GetID(), pr.GetNumber(), pr.GetHead().GetSHA())
+
+		if err != nil {
+			return xerrors.Errorf("failed to deploy preview for #%d: %w", pr.GetNumber(), err)
+		}
+	case "closed":
+		if err := gor.previews.Remove(ctx, gor.FullName(), pr.GetNumber(), "the pull request was closed"); err != nil {
+			return xerrors.Errorf("failed to remove preview for #%d: %w", pr.GetNumber(), err)
+		}
+	}
+
+	return nil
+}
+
+// preview deploys the preview for the pull request if previews are enabled and checks for sha passed
+func (gor *GitOpsRepository) preview(ctx context.Context, installationID int64, number int, sha string) error {
+	if gor.config.App(gor.FullName()).Preview == nil {
+		return nil
+	}
+
+	ctx = logging.With(ctx, "app", gor.FullName(), "sha", sha, "pullRequest", number)
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+
+	success, headSHA, _, err := gor.checkSuiteStatus(ctx, installationID, sha)
+
+	if err != nil {
+		return xerrors.Errorf("failed to get check suite for %s: %w", sha, err)
+	}
+
+	if !success || headSHA != sha {
+		return nil
+	}
+
+	return gor.previews.Deploy(ctx, gor, installationID, number, sha)
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,70 @@
+package repository
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/MISW/mischan-bot/config"
+	"github.com/MISW/mischan-bot/intenral/trailer"
+)
+
+func TestManifestUpdate(t *testing.T) {
+	const sha = "0123456789abcdef0123456789abcdef01234567"
+
+	gor := NewGitOpsRepository(GitOpsApp{
+		Owner:        "MISW",
+		Repo:         "Portal",
+		ManifestDir:  "portal",
+		BranchPrefix: "mischan-bot/portal/",
+		Images: func(shortSHA string) map[string]string {
+			return map[string]string{"ghcr.io/misw/portal": "sha-" + shortSHA}
+		},
+	}, &config.Config{}, nil, nil, nil, nil, nil, nil)
+
+	tests := []struct {
+		name         string
+		branch       string
+		sha          string
+		subject      string
+		sourceCommit string
+		wantErr      bool
+	}{
+		{"update", "mischan-bot/portal/0123456", sha, "Update MISW/Portal to 0123456", sha, false},
+		{"rollback", "mischan-bot/portal/rollback/0123456", sha, "Rollback MISW/Portal to 0123456", sha, false},
+		{"unknown SHA", "mischan-bot/portal/rollback/0123456", "", "Rollback MISW/Portal to 0123456", "0123456", false},
+		{"other SHA", "mischan-bot/portal/0123456", "fedcba9876543210fedcba9876543210fedcba98", "", "", true},
+		{"invalid branch", "mischan-bot/portal/main", sha, "", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, _, err := gor.ManifestUpdate(tt.branch, tt.sha)
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ManifestUpdate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if err != nil {
+				return
+			}
+
+			if subject := trailer.Subject(msg); subject != tt.subject {
+				t.Errorf("subject = %q, want %q", subject, tt.subject)
+			}
+
+			tr, ok := trailer.Parse(msg)
+
+			if !ok {
+				t.Fatalf("no trailers in %q", msg)
+			}
+
+			if tr.SourceCommit != tt.sourceCommit {
+				t.Errorf("Source-Commit = %q, want %q", tr.SourceCommit, tt.sourceCommit)
+			}
+
+			if tag := tr.Images["ghcr.io/misw/portal"]; !strings.HasSuffix(tag, "0123456") {
+				t.Errorf("unexpected image tag %q", tag)
+			}
+		})
+	}
+}
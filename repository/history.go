@@ -0,0 +1,393 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/MISW/mischan-bot/intenral/cloudevent"
+	"github.com/MISW/mischan-bot/intenral/history"
+	"github.com/MISW/mischan-bot/intenral/manifrepo"
+	"github.com/MISW/mischan-bot/intenral/notify"
+	"github.com/MISW/mischan-bot/intenral/trigger"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"golang.org/x/xerrors"
+)
+
+const (
+	// backfillLimit is the number of commits on the base branch of the manifest repository scanned by Backfill
+	backfillLimit = 5000
+)
+
+// DeploymentRecorder records deployments and runs into history.Store and publishes them to chat channels and webhooks
+type DeploymentRecorder struct {
+	manifests   *manifrepo.Factory
+	repoBundler *RepositoryBundler
+	store       *history.Store
+	notifier    *notify.Notifier
+	events      *cloudevent.Dispatcher
+}
+
+// NewDeploymentRecorder initializes DeploymentRecorder
+func NewDeploymentRecorder(
+	manifests *manifrepo.Factory,
+	repoBundler *RepositoryBundler,
+	store *history.Store,
+	notifier *notify.Notifier,
+	events *cloudevent.Dispatcher,
+) *DeploymentRecorder {
+	return &DeploymentRecorder{
+		manifests:   manifests,
+		repoBundler: repoBundler,
+		store:       store,
+		notifier:    notifier,
+		events:      events,
+	}
+}
+
+// NewDeployment initializes a deployment of sha of updater to the environment
+func NewDeployment(ctx context.Context, updater ManifestUpdater, environment, sha string) *history.Deployment {
+	shortSHA := sha
+	if len(shortSHA) > 7 {
+		shortSHA = shortSHA[:7]
+	}
+
+	return &history.Deployment{
+		App:         updater.FullName(),
+		Environment: environment,
+		SHA:         sha,
+		Images:      updater.Images(shortSHA),
+		Actor:       trigger.From(ctx).Actor,
+		OpenedAt:    time.Now(),
+	}
+}
+
+// Opened records the deployment through a pull request from d.Branch, or a failed attempt if err is not nil.
+// Failures to record are only logged not to fail deployments.
+func (dr *DeploymentRecorder) Opened(ctx context.Context, manimani *manifrepo.ManifestManipulator, d *history.Deployment, err error) {
+	if err != nil {
+		d.Status = history.StatusFailed
+		d.Error = err.Error()
+	} else {
+		pr, err := manimani.FindPullRequest(ctx, d.Branch)
+
+		if err != nil {
+			slog.ErrorContext(ctx, "failed to record deployment", "app", d.App, "sha", d.SHA, "error", err)
+			return
+		}
+
+		if pr == nil {
+			// The manifest repository already has the changes
+			return
+		}
+
+		d.Status = history.StatusOpened
+		d.PullRequest = pr.GetNumber()
+	}
+
+	if err := dr.store.Put(d); err != nil {
+		slog.ErrorContext(ctx, "failed to record deployment", "app", d.App, "sha", d.SHA, "error", err)
+	}
+
+	if d.Status == history.StatusOpened {
+		dr.publish(ctx, notify.KindOpened, d, d.Actor)
+	}
+}
+
+// Committed records the deployment committed onto the base branch as commit, or a failed attempt if err is not nil.
+// Failures to record are only logged not to fail deployments.
+func (dr *DeploymentRecorder) Committed(ctx context.Context, d *history.Deployment, commit string, err error) {
+	switch {
+	case err != nil:
+		d.Status = history.StatusFailed
+		d.Error = err.Error()
+	case commit == "":
+		// The manifest repository already has the changes
+		return
+	default:
+		d.Status = history.StatusMerged
+		d.Commit = commit
+		d.MergedAt = time.Now()
+	}
+
+	if err := dr.store.Put(d); err != nil {
+		slog.ErrorContext(ctx, "failed to record deployment", "app", d.App, "sha", d.SHA, "error", err)
+	}
+
+	if d.Status == history.StatusMerged {
+		dr.publish(ctx, notify.KindMerged, d, d.Actor)
+	}
+}
+
+// deploymentEventTypes are types of CloudEvents for lifecycle events of pull requests
+var deploymentEventTypes = map[notify.Kind]string{
+	notify.KindOpened:     cloudevent.TypePullRequestOpened,
+	notify.KindMerged:     cloudevent.TypePullRequestMerged,
+	notify.KindClosed:     cloudevent.TypePullRequestClosed,
+	notify.KindSuperseded: cloudevent.TypePullRequestSuperseded,
+}
+
+// publish notifies channels and webhooks of the app of the event of d
+func (dr *DeploymentRecorder) publish(ctx context.Context, kind notify.Kind, d *history.Deployment, actor string) {
+	e := &notify.Event{
+		Kind:        kind,
+		App:         d.App,
+		Environment: d.Environment,
+		SHA:         d.SHA,
+		Actor:       actor,
+	}
+
+	if d.PullRequest != 0 {
+		e.PullRequest = d.PullRequest
+		e.URL = fmt.Sprintf("https://github.com/%s/pull/%d", dr.manifests.RepoName, d.PullRequest)
+	}
+
+	dr.notifier.Notify(ctx, e)
+
+	eventType := deploymentEventTypes[kind]
+	if kind == notify.KindMerged && d.PullRequest == 0 {
+		eventType = cloudevent.TypeCommitPushed
+	}
+
+	dr.events.Emit(ctx, eventType, &cloudevent.Data{
+		App:            d.App,
+		Environment:    d.Environment,
+		SHA:            d.SHA,
+		Images:         d.Images,
+		PullRequest:    e.PullRequest,
+		URL:            e.URL,
+		Commit:         d.Commit,
+		DeploymentID:   d.ID,
+		Actor:          actor,
+		GitHubDelivery: trigger.From(ctx).DeliveryID,
+	})
+}
+
+// superseded returns true if a newer deployment of the app to the environment of d was attempted
+func (dr *DeploymentRecorder) superseded(d *history.Deployment) (bool, error) {
+	newer, err := dr.store.Find(history.Query{App: d.App, Environment: d.Environment, Since: d.OpenedAt})
+
+	if err != nil {
+		return false, err
+	}
+
+	for _, n := range newer {
+		if n.ID != d.ID && n.Environment == d.Environment && n.OpenedAt.After(d.OpenedAt) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// NewRun initializes a run for repo triggered in ctx
+func NewRun(ctx context.Context, repo Repository, installationID int64, expectedSHA string, opts RunOptions) *history.Run {
+	t := trigger.From(ctx)
+
+	if opts.SHA != "" {
+		expectedSHA = opts.SHA
+	}
+
+	return &history.Run{
+		App:            repo.FullName(),
+		SHA:            expectedSHA,
+		InstallationID: installationID,
+		Environment:    opts.Environment,
+		RetryOf:        opts.RetryOf,
+		Event:          t.Event,
+		Actor:          t.Actor,
+		DeliveryID:     t.DeliveryID,
+		StartedAt:      time.Now(),
+	}
+}
+
+// runEventTypes are types of CloudEvents for results of runs
+var runEventTypes = map[string]string{
+	"deployed": cloudevent.TypeRunSucceeded,
+	"frozen":   cloudevent.TypeRunFrozen,
+	"failed":   cloudevent.TypeRunFailed,
+}
+
+// Started records the run which is deploying r.SHA. Failures to record are only logged not to fail runs.
+func (dr *DeploymentRecorder) Started(ctx context.Context, r *history.Run) {
+	r.Result = "running"
+
+	if err := dr.store.PutRun(r); err != nil {
+		slog.ErrorContext(ctx, "failed to record run", "app", r.App, "sha", r.SHA, "error", err)
+	}
+
+	dr.events.Emit(ctx, cloudevent.TypeRunStarted, runEventData(r))
+}
+
+// Finished records the run with the result. Failures to record are only logged not to fail runs.
+func (dr *DeploymentRecorder) Finished(ctx context.Context, r *history.Run, result string, err error) {
+	r.Result = result
+	r.FinishedAt = time.Now()
+
+	if err != nil {
+		r.Error = err.Error()
+	}
+
+	if err := dr.store.PutRun(r); err != nil {
+		slog.ErrorContext(ctx, "failed to record run", "app", r.App, "sha", r.SHA, "error", err)
+	}
+
+	if err != nil {
+		dr.notifier.Notify(ctx, &notify.Event{
+			Kind:  notify.KindFailed,
+			App:   r.App,
+			SHA:   r.SHA,
+			Actor: r.Actor,
+			Error: r.Error,
+		})
+	}
+
+	if eventType, ok := runEventTypes[result]; ok {
+		dr.events.Emit(ctx, eventType, runEventData(r))
+	}
+}
+
+func runEventData(r *history.Run) *cloudevent.Data {
+	return &cloudevent.Data{
+		App:            r.App,
+		SHA:            r.SHA,
+		RunID:          r.ID,
+		Actor:          r.Actor,
+		GitHubDelivery: r.DeliveryID,
+		Error:          r.Error,
+	}
+}
+
+// Run syncs open deployments every interval until ctx is canceled
+func (dr *DeploymentRecorder) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := dr.Sync(ctx); err != nil {
+			slog.ErrorContext(ctx, "failed to sync deployment history", "error", err)
+		}
+	}
+}
+
+// Sync records merged or closed pull requests of open deployments
+func (dr *DeploymentRecorder) Sync(ctx context.Context) error {
+	open, err := dr.store.Find(history.Query{Status: history.StatusOpened})
+
+	if err != nil {
+		return xerrors.Errorf("failed to find open deployments: %w", err)
+	}
+
+	if len(open) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+
+	manimani, err := dr.manifests.New(ctx)
+
+	if err != nil {
+		return xerrors.Errorf("failed to initialize GitHub client for manifest repository: %w", err)
+	}
+
+	for _, d := range open {
+		pr, err := manimani.GetPullRequest(ctx, d.PullRequest)
+
+		if err != nil {
+			return err
+		}
+
+		var kind notify.Kind
+
+		switch {
+		case pr.GetMerged():
+			d.Status = history.StatusMerged
+			d.Commit = pr.GetMergeCommitSHA()
+			d.MergedAt = pr.GetMergedAt().Time
+			d.MergedBy = pr.GetMergedBy().GetLogin()
+			kind = notify.KindMerged
+		case pr.GetState() == "closed":
+			d.Status = history.StatusClosed
+			d.ClosedAt = pr.GetClosedAt().Time
+			kind = notify.KindClosed
+
+			superseded, err := dr.superseded(d)
+
+			if err != nil {
+				return err
+			}
+
+			if superseded {
+				kind = notify.KindSuperseded
+			}
+		default:
+			continue
+		}
+
+		if err := dr.store.Put(d); err != nil {
+			return err
+		}
+
+		dr.publish(ctx, kind, d, d.MergedBy)
+	}
+
+	return nil
+}
+
+// Backfill records deployments by bot commits on the base branch of the manifest repository which are not recorded yet
+func (dr *DeploymentRecorder) Backfill(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Minute)
+	defer cancel()
+
+	manimani, err := dr.manifests.New(ctx)
+
+	if err != nil {
+		return xerrors.Errorf("failed to initialize GitHub client for manifest repository: %w", err)
+	}
+
+	var count int
+	err = manimani.WalkHistory(ctx, backfillLimit, func(c *object.Commit) error {
+		d, err := history.ParseCommit(c)
+
+		if err != nil || d == nil {
+			return err
+		}
+
+		// Deployments recorded by the bot may have abbreviated SHAs
+		merged, err := dr.store.Merged(d.App, d.Environment, d.SHA[:7])
+
+		if err != nil || merged {
+			return err
+		}
+
+		if d.Images == nil {
+			if repo, err := dr.repoBundler.Lookup(d.App); err == nil {
+				if updater, ok := repo.(ManifestUpdater); ok {
+					d.Images = updater.Images(d.SHA[:7])
+				}
+			}
+		}
+
+		count++
+
+		return dr.store.Put(d)
+	})
+
+	if err != nil {
+		return xerrors.Errorf("failed to backfill deployments: %w", err)
+	}
+
+	if count != 0 {
+		slog.InfoContext(ctx, "backfilled deployments", "count", count, "repo", dr.manifests.RepoName)
+	}
+
+	return nil
+}
@@ -0,0 +1,120 @@
+package manifest
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/MISW/mischan-bot/config"
+	"github.com/MISW/mischan-bot/intenral/manifrepo"
+	"github.com/MISW/mischan-bot/repository"
+	"github.com/google/go-github/v55/github"
+	"golang.org/x/xerrors"
+)
+
+// NewManifestRepository initializes repository for the manifest repository(e.g. MISW/k8s)
+// Pull requests opened by the bot are regenerated when the base branch moves.
+func NewManifestRepository(
+	cfg *config.Config,
+	manifests *manifrepo.Factory,
+	repoBundler *repository.RepositoryBundler,
+) repository.Repository {
+	return &manifestRepository{
+		config:      cfg,
+		manifests:   manifests,
+		repoBundler: repoBundler,
+		baseBranch:  "master",
+	}
+}
+
+type manifestRepository struct {
+	config      *config.Config
+	manifests   *manifrepo.Factory
+	repoBundler *repository.RepositoryBundler
+
+	baseBranch string
+}
+
+var _ repository.Repository = &manifestRepository{}
+
+func (mr *manifestRepository) FullName() string {
+	return mr.config.ManifestRepo
+}
+
+func (mr *manifestRepository) regenerate(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+
+	manimani, err := mr.manifests.New(ctx)
+
+	if err != nil {
+		return xerrors.Errorf("failed to initialize GitHub client for manifest repository: %w", err)
+	}
+
+	manimani.BaseBranch = mr.baseBranch
+
+	var failed int
+	for _, repo := range mr.repoBundler.Repositories() {
+		updater, ok := repo.(repository.ManifestUpdater)
+
+		if !ok {
+			continue
+		}
+
+		prs, err := manimani.ListBotPullRequests(ctx, updater.BranchPrefix())
+
+		if err != nil {
+			return xerrors.Errorf("failed to list pull requests for %s: %w", updater.FullName(), err)
+		}
+
+		for _, pr := range prs {
+			if err := regeneratePullRequest(ctx, manimani, updater, pr); err != nil {
+				failed++
+				slog.ErrorContext(ctx, "failed to regenerate pull request", "app", updater.FullName(), "pullRequest", pr.GetNumber(), "error", err)
+			}
+		}
+	}
+
+	if failed != 0 {
+		return xerrors.Errorf("failed to regenerate %d pull requests", failed)
+	}
+
+	return nil
+}
+
+// regeneratePullRequest regenerates pr of updater with the SHA in the trailers of the pull request
+func regeneratePullRequest(ctx context.Context, manimani *manifrepo.ManifestManipulator, updater repository.ManifestUpdater, pr *github.PullRequest) error {
+	sha, err := manimani.SourceCommit(ctx, pr)
+
+	if err != nil {
+		return err
+	}
+
+	commitMessage, manipulator, err := updater.ManifestUpdate(pr.GetHead().GetRef(), sha)
+
+	if err != nil {
+		return err
+	}
+
+	return manimani.RegeneratePullRequest(ctx, pr, commitMessage, manipulator)
+}
+
+func (mr *manifestRepository) OnCheckSuite(ctx context.Context, event *github.CheckSuiteEvent) error {
+	return nil
+}
+
+func (mr *manifestRepository) OnCreate(ctx context.Context, event *github.CreateEvent) error {
+	return nil
+}
+
+func (mr *manifestRepository) OnPush(ctx context.Context, event *github.PushEvent) error {
+	if event.GetRef() != "refs/heads/"+mr.baseBranch {
+		return nil
+	}
+
+	if err := mr.regenerate(ctx); err != nil {
+		return xerrors.Errorf("push handler failed: %w", err)
+	}
+
+	return nil
+}
@@ -1,198 +1,43 @@
 package mischanbot
 
 import (
-	"context"
-	"fmt"
-	"os/exec"
-	"path/filepath"
-	"time"
-
 	"github.com/MISW/mischan-bot/config"
 	"github.com/MISW/mischan-bot/intenral/ghsink"
 	"github.com/MISW/mischan-bot/intenral/manifrepo"
 	"github.com/MISW/mischan-bot/repository"
-	"github.com/google/go-github/v55/github"
-	"golang.org/x/xerrors"
 )
 
 const (
+	manifestDir  = "bases/mischan-bot"
 	branchPrefix = "mischan-bot/misw/mischan-bot/"
 )
 
 // NewMischanBotRepository initializes repository for MISW/mischan-bot
-func NewMischanBotRepository(cfg *config.Config, ghs *ghsink.GitHubSink, app *github.App, botUser *github.User) repository.Repository {
-	return &gitOpsRepository{
-		config:       cfg,
-		ghs:          ghs,
-		app:          app,
-		botUser:      botUser,
-		targetBranch: "master",
-
-		owner: "MISW",
-		repo:  "mischan-bot",
-	}
-}
-
-type gitOpsRepository struct {
-	config  *config.Config
-	ghs     *ghsink.GitHubSink
-	app     *github.App
-	botUser *github.User
-
-	targetBranch string
-	owner, repo  string
-}
-
-var _ repository.Repository = &gitOpsRepository{}
-
-func (gor *gitOpsRepository) FullName() string {
-	return gor.owner + "/" + gor.repo
-}
-
-func (gor *gitOpsRepository) checkSuiteStatus(
-	ctx context.Context,
-	installationID int64,
-) (success bool, sha string, err error) {
-	client := gor.ghs.InstallationClient(installationID)
-
-	checkRuns, _, err := client.Checks.ListCheckRunsForRef(ctx, gor.owner, gor.repo, gor.targetBranch, nil)
-
-	if err != nil {
-		return false, "", xerrors.Errorf("failed list check suites for %s/%s: %w", gor.owner, gor.repo, err)
-	}
-
-	if len(checkRuns.CheckRuns) == 0 {
-		return false, "", nil
-	}
-
-	success = true
-	for _, suite := range checkRuns.CheckRuns {
-		if suite.GetStatus() != "completed" {
-			success = false
-			break
-		}
-
-		if suite.GetConclusion() != "success" {
-			success = false
-			break
-		}
-
-		sha = suite.GetHeadSHA()
-	}
-
-	return
-}
-
-func (gor *gitOpsRepository) kustomize(shortSHA string) func(ctx context.Context, dir string) error {
-	return func(ctx context.Context, dir string) error {
-		cmd := exec.CommandContext(
-			ctx, "kustomize", "edit", "set", "image", "registry.misw.jp/mischan-bot/mischan-bot:sha-"+shortSHA,
-		)
-		cmd.Dir = filepath.Join(dir, "bases/mischan-bot")
-
-		b, err := cmd.CombinedOutput()
-
-		if err != nil {
-			return xerrors.Errorf("failed to kustomize(%s): %w", string(b), err)
-		}
-
-		return nil
+func NewMischanBotRepository(
+	cfg *config.Config,
+	ghs *ghsink.GitHubSink,
+	manifests *manifrepo.Factory,
+	promotions *repository.PromotionPipeline,
+	freezes *repository.FreezeGate,
+	deployments *repository.DeploymentRecorder,
+	previews *repository.PreviewManager,
+) repository.Repository {
+	app := repository.GitOpsApp{
+		Owner:         "MISW",
+		Repo:          "mischan-bot",
+		TargetBranch:  "master",
+		ManifestDir:   manifestDir,
+		BranchPrefix:  branchPrefix,
+		RollbackImage: "registry.misw.jp/mischan-bot/mischan-bot",
+		Images:        images,
+	}
+
+	return repository.NewGitOpsRepository(app, cfg, ghs, manifests, promotions, freezes, deployments, previews)
+}
+
+// images returns the image tags deployed for shortSHA
+func images(shortSHA string) map[string]string {
+	return map[string]string{
+		"registry.misw.jp/mischan-bot/mischan-bot": "sha-" + shortSHA,
 	}
 }
-
-func (gor *gitOpsRepository) run(installationID int64, expectedSHA string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
-	defer cancel()
-
-	success, sha, err := gor.checkSuiteStatus(ctx, installationID)
-
-	if err != nil {
-		return xerrors.Errorf("failed to get latest check suite: %w", err)
-	}
-
-	if !success {
-		return nil
-	}
-
-	if len(expectedSHA) != 0 && sha != expectedSHA {
-		return nil
-	}
-
-	manimani, err := manifrepo.NewManifestManipulator(ctx, gor.ghs, "MISW/k8s")
-
-	if err != nil {
-		return xerrors.Errorf("failed to initialize GitHub client for manifest repository: %w", err)
-	}
-
-	manimani.CommiterName = gor.app.GetName()
-	manimani.CommiterEmail = fmt.Sprintf("%d+%s[bot]@users.noreply.github.com", gor.botUser.GetID(), gor.app.GetSlug())
-
-	if err := manimani.CloseObsoletePRs(ctx, branchPrefix); err != nil {
-		return xerrors.Errorf("failed to close obsolete PRs: %w", err)
-	}
-
-	shortSHA := sha[:7]
-
-	if err := manimani.CreatePullRequest(
-		ctx,
-		branchPrefix+shortSHA,
-		fmt.Sprintf("Update MISW/mischan-bot to %s", shortSHA),
-		gor.kustomize(shortSHA),
-	); err != nil {
-		return xerrors.Errorf("failed to create pull request: %w", err)
-	}
-
-	return nil
-
-}
-
-func (gor *gitOpsRepository) OnCheckSuite(event *github.CheckSuiteEvent) error {
-	if event.GetCheckSuite().GetHeadBranch() != gor.targetBranch {
-		return nil
-	}
-
-	err := gor.run(
-		event.GetInstallation().GetID(),
-		event.GetCheckSuite().GetHeadSHA(),
-	)
-
-	if err != nil {
-		return xerrors.Errorf("check suite handler failed: %w", err)
-	}
-
-	return nil
-}
-
-func (gor *gitOpsRepository) OnCreate(event *github.CreateEvent) error {
-	if event.GetRefType() != "branch" || event.GetRef() != gor.targetBranch {
-		return nil
-	}
-
-	err := gor.run(
-		event.GetInstallation().GetID(),
-		"",
-	)
-
-	if err != nil {
-		return xerrors.Errorf("check suite handler failed: %w", err)
-	}
-
-	return nil
-}
-
-func (gor *gitOpsRepository) OnPush(event *github.PushEvent) error {
-	if event.GetRef() != "refs/heads/"+gor.targetBranch {
-		return nil
-	}
-
-	err := gor.run(
-		event.GetInstallation().GetID(),
-		"",
-	)
-
-	if err != nil {
-		return xerrors.Errorf("check suite handler failed: %w", err)
-	}
-
-	return nil
-}
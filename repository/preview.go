@@ -0,0 +1,268 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MISW/mischan-bot/config"
+	"github.com/MISW/mischan-bot/intenral/ghsink"
+	"github.com/MISW/mischan-bot/intenral/kustomize"
+	"github.com/MISW/mischan-bot/intenral/manifrepo"
+	"github.com/MISW/mischan-bot/intenral/preview"
+	"github.com/MISW/mischan-bot/intenral/trailer"
+	"github.com/MISW/mischan-bot/intenral/trigger"
+	"github.com/google/go-github/v55/github"
+	"golang.org/x/xerrors"
+)
+
+// PreviewManager generates preview environments for pull requests of apps in the manifest repository
+type PreviewManager struct {
+	config    *config.Config
+	ghs       *ghsink.GitHubSink
+	manifests *manifrepo.Factory
+	store     *preview.Store
+
+	lock sync.Mutex
+}
+
+// NewPreviewManager initializes PreviewManager
+func NewPreviewManager(
+	cfg *config.Config,
+	ghs *ghsink.GitHubSink,
+	manifests *manifrepo.Factory,
+	store *preview.Store,
+) *PreviewManager {
+	return &PreviewManager{
+		config:    cfg,
+		ghs:       ghs,
+		manifests: manifests,
+		store:     store,
+	}
+}
+
+// Deploy generates or updates the preview for the pull request of updater with sha.
+// Nothing happens if previews are not enabled for the app.
+func (pm *PreviewManager) Deploy(ctx context.Context, updater ManifestUpdater, installationID int64, number int, sha string) error {
+	app := updater.FullName()
+	pc := pm.config.App(app).Preview
+
+	if pc == nil {
+		return nil
+	}
+
+	pm.lock.Lock()
+	defer pm.lock.Unlock()
+
+	p, ok := pm.store.Get(app, number)
+
+	if ok && p.SHA == sha {
+		return nil
+	}
+
+	if !ok {
+		p = &preview.Preview{
+			App:         app,
+			PullRequest: number,
+			CreatedAt:   time.Now(),
+		}
+	}
+
+	p.InstallationID = installationID
+	p.Name = pc.PreviewName(number)
+	p.Overlay = pc.Overlay(number)
+	p.Host = pc.PreviewHost(number)
+	p.SHA = sha
+	p.UpdatedAt = time.Now()
+
+	manimani, err := pm.manifests.New(ctx)
+
+	if err != nil {
+		return xerrors.Errorf("failed to initialize GitHub client for manifest repository: %w", err)
+	}
+
+	images := updater.Images(sha[:7])
+	template := pc.Template
+	replacer := pc.Replacer(number)
+	overlay := p.Overlay
+
+	commitMessage := (&trailer.Trailers{
+		SourceRepository: app,
+		SourceCommit:     sha,
+		Environment:      p.Name,
+		Images:           images,
+	}).Append(fmt.Sprintf("Update preview %s to %s", p.Name, sha[:7]))
+
+	_, err = manimani.CommitDirectly(ctx, commitMessage, manifrepo.Manipulator{
+		Paths: []string{template, overlay},
+		Policy: &manifrepo.PathPolicy{
+			AllowedPaths:   []string{overlay},
+			AllowNewFiles:  true,
+			AllowDeletions: true,
+		},
+		Apply: func(ctx context.Context, root string) error {
+			dir := filepath.Join(root, filepath.FromSlash(overlay))
+
+			// Files removed from the template are removed from the preview as well
+			if err := os.RemoveAll(dir); err != nil {
+				return xerrors.Errorf("failed to remove %s: %w", overlay, err)
+			}
+
+			if err := kustomize.RenderTemplate(filepath.Join(root, filepath.FromSlash(template)), dir, replacer); err != nil {
+				return err
+			}
+
+			return kustomize.SetImages(ctx, dir, images)
+		},
+	})
+
+	if err != nil {
+		return xerrors.Errorf("failed to commit preview %s: %w", p.Name, err)
+	}
+
+	body := fmt.Sprintf("Preview environment for %s is deployed at https://%s/", sha[:7], p.Host)
+
+	if err := pm.comment(ctx, p, body); err != nil {
+		slog.ErrorContext(ctx, "failed to comment preview URL", "app", app, "pullRequest", number, "error", err)
+	}
+
+	if err := pm.store.Save(*p); err != nil {
+		return xerrors.Errorf("failed to save preview %s: %w", p.Name, err)
+	}
+
+	return nil
+}
+
+// Remove removes the preview for the pull request of the app if exists
+func (pm *PreviewManager) Remove(ctx context.Context, app string, number int, reason string) error {
+	pm.lock.Lock()
+	defer pm.lock.Unlock()
+
+	return pm.remove(ctx, app, number, reason)
+}
+
+func (pm *PreviewManager) remove(ctx context.Context, app string, number int, reason string) error {
+	p, ok := pm.store.Get(app, number)
+
+	if !ok {
+		return nil
+	}
+
+	manimani, err := pm.manifests.New(ctx)
+
+	if err != nil {
+		return xerrors.Errorf("failed to initialize GitHub client for manifest repository: %w", err)
+	}
+
+	commitMessage := (&trailer.Trailers{
+		SourceRepository: app,
+		Environment:      p.Name,
+	}).Append(fmt.Sprintf("Remove preview %s", p.Name))
+
+	_, err = manimani.CommitDirectly(ctx, commitMessage, manifrepo.Manipulator{
+		Paths: []string{p.Overlay},
+		Policy: &manifrepo.PathPolicy{
+			AllowedPaths:   []string{p.Overlay},
+			AllowDeletions: true,
+		},
+		Apply: func(ctx context.Context, root string) error {
+			return os.RemoveAll(filepath.Join(root, filepath.FromSlash(p.Overlay)))
+		},
+	})
+
+	if err != nil {
+		return xerrors.Errorf("failed to remove preview %s: %w", p.Name, err)
+	}
+
+	if err := pm.comment(ctx, p, fmt.Sprintf("Preview environment was removed since %s.", reason)); err != nil {
+		slog.ErrorContext(ctx, "failed to comment removal of preview", "app", app, "pullRequest", number, "error", err)
+	}
+
+	if err := pm.store.Delete(app, number); err != nil {
+		return xerrors.Errorf("failed to delete preview %s: %w", p.Name, err)
+	}
+
+	return nil
+}
+
+// comment updates the comment about the preview on the pull request, or creates one if not posted yet
+func (pm *PreviewManager) comment(ctx context.Context, p *preview.Preview, body string) error {
+	owner, repo, _ := strings.Cut(p.App, "/")
+	client := pm.ghs.InstallationClient(p.InstallationID)
+
+	if p.CommentID != 0 {
+		_, _, err := client.Issues.EditComment(ctx, owner, repo, p.CommentID, &github.IssueComment{
+			Body: github.String(body),
+		})
+
+		if err == nil {
+			return nil
+		}
+
+		slog.WarnContext(ctx, "failed to update comment, creating a new one", "app", p.App, "pullRequest", p.PullRequest, "comment", p.CommentID, "error", err)
+	}
+
+	c, _, err := client.Issues.CreateComment(ctx, owner, repo, p.PullRequest, &github.IssueComment{
+		Body: github.String(body),
+	})
+
+	if err != nil {
+		return xerrors.Errorf("failed to create comment: %w", err)
+	}
+
+	p.CommentID = c.GetID()
+
+	return nil
+}
+
+// Run removes previews not updated for their TTLs every interval until ctx is canceled
+func (pm *PreviewManager) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := pm.Expire(ctx); err != nil {
+			slog.ErrorContext(ctx, "failed to remove expired previews", "error", err)
+		}
+	}
+}
+
+// Expire removes previews not updated for their TTLs
+func (pm *PreviewManager) Expire(ctx context.Context) error {
+	pm.lock.Lock()
+	defer pm.lock.Unlock()
+
+	ctx, cancel := context.WithTimeout(trigger.With(ctx, trigger.Trigger{Event: "preview-ttl"}), 5*time.Minute)
+	defer cancel()
+
+	var failed int
+	for _, p := range pm.store.List() {
+		pc := pm.config.App(p.App).Preview
+
+		if pc == nil || pc.TTL == 0 || time.Since(p.UpdatedAt) < pc.TTL {
+			continue
+		}
+
+		if err := pm.remove(ctx, p.App, p.PullRequest, fmt.Sprintf("it was not updated for %s", pc.TTL)); err != nil {
+			failed++
+			slog.ErrorContext(ctx, "failed to remove preview", "preview", p.Name, "error", err)
+		}
+	}
+
+	if failed != 0 {
+		return xerrors.Errorf("failed to remove %d previews", failed)
+	}
+
+	return nil
+}
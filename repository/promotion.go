@@ -0,0 +1,497 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MISW/mischan-bot/config"
+	"github.com/MISW/mischan-bot/intenral/logging"
+	"github.com/MISW/mischan-bot/intenral/manifrepo"
+	"github.com/MISW/mischan-bot/intenral/promotion"
+	"github.com/MISW/mischan-bot/intenral/trigger"
+	"golang.org/x/xerrors"
+)
+
+// PromotionPipeline promotes SHAs of apps through environments in config.AppConfig.Environments.
+// A pull request for the next environment is opened after the previous one is merged
+// and soaked for config.EnvironmentConfig.SoakTime without failed checks on the merge commit.
+// Pull requests are not opened for environments during freeze windows.
+type PromotionPipeline struct {
+	config      *config.Config
+	manifests   *manifrepo.Factory
+	repoBundler *RepositoryBundler
+	store       *promotion.Store
+	freezes     *FreezeGate
+	deployments *DeploymentRecorder
+
+	lock sync.Mutex
+}
+
+// NewPromotionPipeline initializes PromotionPipeline
+func NewPromotionPipeline(
+	cfg *config.Config,
+	manifests *manifrepo.Factory,
+	repoBundler *RepositoryBundler,
+	store *promotion.Store,
+	freezes *FreezeGate,
+	deployments *DeploymentRecorder,
+) *PromotionPipeline {
+	return &PromotionPipeline{
+		config:      cfg,
+		manifests:   manifests,
+		repoBundler: repoBundler,
+		store:       store,
+		freezes:     freezes,
+		deployments: deployments,
+	}
+}
+
+// Start begins to promote sha of updater from the first environment.
+// It does nothing if sha was already promoted.
+func (pp *PromotionPipeline) Start(ctx context.Context, updater ManifestUpdater, sha string) error {
+	pp.lock.Lock()
+	defer pp.lock.Unlock()
+
+	if _, ok := pp.store.Get(updater.FullName(), sha); ok {
+		return nil
+	}
+
+	var environments []string
+	for _, env := range pp.config.App(updater.FullName()).Environments {
+		environments = append(environments, env.Name)
+	}
+
+	p := promotion.New(updater.FullName(), sha, environments)
+
+	if err := pp.store.Save(p); err != nil {
+		return xerrors.Errorf("failed to save promotion of %s: %w", sha, err)
+	}
+
+	if err := pp.advance(ctx, updater, p); err != nil {
+		return xerrors.Errorf("failed to promote %s: %w", sha, err)
+	}
+
+	return nil
+}
+
+// Restart promotes sha of updater again from the environment, replacing an earlier promotion of sha
+func (pp *PromotionPipeline) Restart(ctx context.Context, updater ManifestUpdater, sha, environment string) error {
+	pp.lock.Lock()
+	defer pp.lock.Unlock()
+
+	var environments []string
+	for _, env := range pp.config.App(updater.FullName()).Environments {
+		if env.Name == environment || len(environments) != 0 {
+			environments = append(environments, env.Name)
+		}
+	}
+
+	if len(environments) == 0 {
+		return xerrors.Errorf("%s: %w", environment, ErrUnknownEnvironment)
+	}
+
+	p := promotion.New(updater.FullName(), sha, environments)
+
+	if err := pp.store.Save(p); err != nil {
+		return xerrors.Errorf("failed to save promotion of %s: %w", sha, err)
+	}
+
+	if err := pp.advance(ctx, updater, p); err != nil {
+		return xerrors.Errorf("failed to promote %s: %w", sha, err)
+	}
+
+	return nil
+}
+
+// Run advances active promotions every interval until ctx is canceled
+func (pp *PromotionPipeline) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := pp.Advance(ctx); err != nil {
+			slog.ErrorContext(ctx, "failed to advance promotions", "error", err)
+		}
+	}
+}
+
+// Advance advances every active promotion as far as possible
+func (pp *PromotionPipeline) Advance(ctx context.Context) error {
+	pp.lock.Lock()
+	defer pp.lock.Unlock()
+
+	ctx, cancel := context.WithTimeout(trigger.With(ctx, trigger.Trigger{Event: "promotion"}), 5*time.Minute)
+	defer cancel()
+
+	var failed int
+	for _, active := range pp.store.Active() {
+		// Promotions may be superseded by the previous ones
+		p, ok := pp.store.Get(active.App, active.SHA)
+
+		if !ok || p.Status != promotion.StatusActive {
+			continue
+		}
+
+		ctx := logging.With(ctx, "app", p.App, "sha", p.SHA)
+		updater, err := pp.updater(p.App)
+
+		if err == nil {
+			err = pp.advance(ctx, updater, p)
+		}
+
+		if err != nil {
+			failed++
+			slog.ErrorContext(ctx, "failed to promote", "error", err)
+		}
+	}
+
+	if failed != 0 {
+		return xerrors.Errorf("failed to advance %d promotions", failed)
+	}
+
+	return nil
+}
+
+func (pp *PromotionPipeline) updater(name string) (ManifestUpdater, error) {
+	repo, err := pp.repoBundler.Lookup(name)
+
+	if err != nil {
+		return nil, xerrors.Errorf("%s: %w", name, err)
+	}
+
+	updater, ok := repo.(ManifestUpdater)
+
+	if !ok {
+		return nil, xerrors.Errorf("%s does not update manifests", name)
+	}
+
+	return updater, nil
+}
+
+// advance moves p forward until it has to wait and saves the progress
+func (pp *PromotionPipeline) advance(ctx context.Context, updater ManifestUpdater, p *promotion.Promotion) error {
+	manimani, err := pp.manifests.New(ctx)
+
+	if err != nil {
+		return xerrors.Errorf("failed to initialize GitHub client for manifest repository: %w", err)
+	}
+
+	app := pp.config.App(p.App)
+
+	for p.Status == promotion.StatusActive {
+		i := p.Current()
+
+		if i < 0 {
+			p.Status = promotion.StatusCompleted
+			break
+		}
+
+		env, ok := app.Environment(p.Stages[i].Environment)
+
+		if !ok {
+			p.Fail(i, "the environment is no longer configured")
+			break
+		}
+
+		progressed, err := pp.step(ctx, manimani, updater, p, i, env)
+
+		if serr := pp.store.Save(p); serr != nil {
+			return xerrors.Errorf("failed to save promotion of %s: %w", p.SHA, serr)
+		}
+
+		if err != nil {
+			return xerrors.Errorf("failed to promote to %s: %w", env.Name, err)
+		}
+
+		if !progressed {
+			return nil
+		}
+	}
+
+	if err := pp.store.Save(p); err != nil {
+		return xerrors.Errorf("failed to save promotion of %s: %w", p.SHA, err)
+	}
+
+	return nil
+}
+
+// step advances the i-th stage of p once. progressed is false if the stage has to wait.
+func (pp *PromotionPipeline) step(
+	ctx context.Context,
+	manimani *manifrepo.ManifestManipulator,
+	updater ManifestUpdater,
+	p *promotion.Promotion,
+	i int,
+	env config.EnvironmentConfig,
+) (progressed bool, err error) {
+	stage := &p.Stages[i]
+
+	switch stage.Status {
+	case promotion.StagePending:
+		return pp.open(ctx, manimani, updater, p, i)
+
+	case promotion.StageOpen:
+		pr, err := manimani.FindPullRequest(ctx, stage.Branch)
+
+		if err != nil {
+			return false, err
+		}
+
+		if pr == nil {
+			// No pull request is opened if the environment already has the changes
+			deployed, err := pp.deployed(ctx, manimani, updater, p.SHA, env)
+
+			if err != nil {
+				return false, err
+			}
+
+			if !deployed {
+				p.Fail(i, fmt.Sprintf("no pull request was opened from %s but %s does not have the images", stage.Branch, env.Path))
+
+				return false, nil
+			}
+
+			stage.Status = promotion.StageSoaking
+			stage.MergedAt = time.Now()
+
+			return true, nil
+		}
+
+		stage.PullRequest = pr.GetNumber()
+
+		if pr.MergedAt != nil {
+			stage.Status = promotion.StageSoaking
+			stage.MergedAt = pr.GetMergedAt().Time
+			stage.MergeCommit = pr.GetMergeCommitSHA()
+
+			return true, nil
+		}
+
+		if pr.GetState() == "closed" {
+			p.Fail(i, fmt.Sprintf("pull request #%d was closed without merge", pr.GetNumber()))
+		}
+
+		return false, nil
+
+	case promotion.StageSoaking:
+		if stage.MergeCommit != "" {
+			failures, err := manimani.CheckFailures(ctx, stage.MergeCommit)
+
+			if err != nil {
+				return false, err
+			}
+
+			if len(failures) != 0 {
+				p.Fail(i, fmt.Sprintf("checks failed on %s: %s", stage.MergeCommit, strings.Join(failures, ", ")))
+
+				return false, nil
+			}
+		}
+
+		if time.Since(stage.MergedAt) < env.SoakTime {
+			return false, nil
+		}
+
+		stage.Status = promotion.StageDone
+		stage.DoneAt = time.Now()
+
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// deployed reports whether the overlay of env already sets every image of updater to the tag for sha
+func (pp *PromotionPipeline) deployed(
+	ctx context.Context,
+	manimani *manifrepo.ManifestManipulator,
+	updater ManifestUpdater,
+	sha string,
+	env config.EnvironmentConfig,
+) (bool, error) {
+	tags, err := manimani.ImageTags(ctx, env.Path)
+
+	if err != nil {
+		return false, xerrors.Errorf("failed to read images in %s: %w", env.Path, err)
+	}
+
+	for name, tag := range updater.Images(sha[:7]) {
+		if tags[name] != tag {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// open applies the SHA to the environment of the i-th stage
+func (pp *PromotionPipeline) open(
+	ctx context.Context,
+	manimani *manifrepo.ManifestManipulator,
+	updater ManifestUpdater,
+	p *promotion.Promotion,
+	i int,
+) (bool, error) {
+	stage := &p.Stages[i]
+	envPrefix := updater.BranchPrefix() + stage.Environment + "/"
+	branchName := envPrefix + p.SHA[:7]
+
+	window, err := pp.freezes.Check(ctx, p.App, stage.Environment, p.SHA)
+
+	if err != nil {
+		return false, err
+	}
+
+	if window != nil {
+		stage.Reason = fmt.Sprintf("waiting for freeze window %s", window.Name)
+
+		// Only the latest SHA is promoted after the window
+		return false, pp.supersede(p, stage.Environment, promotion.StagePending)
+	}
+	stage.Reason = ""
+
+	commitMessage, manipulator, err := updater.ManifestUpdate(branchName, p.SHA)
+
+	if err != nil {
+		p.Fail(i, err.Error())
+
+		return false, err
+	}
+
+	deployment := NewDeployment(ctx, updater, stage.Environment, p.SHA)
+	deployment.Branch = branchName
+
+	env, _ := pp.config.App(p.App).Environment(stage.Environment)
+
+	if env.Mode == config.ModeDirect {
+		commit, err := manimani.CommitDirectly(ctx, commitMessage, manipulator)
+		pp.deployments.Committed(ctx, deployment, commit, err)
+
+		if err != nil {
+			return false, pp.openFailed(p, i, err)
+		}
+
+		now := time.Now()
+		stage.Status = promotion.StageSoaking
+		stage.OpenedAt = now
+		stage.MergedAt = now
+		stage.MergeCommit = commit
+
+		return true, nil
+	}
+
+	// Pull requests of older SHAs for the environment are replaced
+	if err := manimani.CloseObsoletePRs(ctx, envPrefix); err != nil {
+		return false, xerrors.Errorf("failed to close obsolete PRs: %w", err)
+	}
+
+	if err := pp.supersede(p, stage.Environment, promotion.StagePending, promotion.StageOpen); err != nil {
+		return false, err
+	}
+
+	err = manimani.CreatePullRequest(ctx, branchName, commitMessage, manipulator)
+	pp.deployments.Opened(ctx, manimani, deployment, err)
+
+	if err != nil {
+		return false, pp.openFailed(p, i, err)
+	}
+
+	stage.Status = promotion.StageOpen
+	stage.Branch = branchName
+	stage.OpenedAt = time.Now()
+
+	return true, nil
+}
+
+// openFailed fails the stage if retrying will not help
+func (pp *PromotionPipeline) openFailed(p *promotion.Promotion, i int, err error) error {
+	if xerrors.Is(err, manifrepo.ErrInvalidManifests) || xerrors.Is(err, manifrepo.ErrPolicyViolation) {
+		p.Fail(i, err.Error())
+	}
+
+	return err
+}
+
+// supersede marks older active promotions of the app waiting for the environment in statuses as superseded by p
+func (pp *PromotionPipeline) supersede(p *promotion.Promotion, environment string, statuses ...promotion.StageStatus) error {
+	for _, other := range pp.store.Active() {
+		if other.App != p.App || other.SHA == p.SHA || !other.CreatedAt.Before(p.CreatedAt) {
+			continue
+		}
+
+		i := other.Current()
+
+		if i < 0 || other.Stages[i].Environment != environment {
+			continue
+		}
+
+		if !containsStatus(statuses, other.Stages[i].Status) {
+			continue
+		}
+
+		other.Status = promotion.StatusSuperseded
+
+		if err := pp.store.Save(other); err != nil {
+			return xerrors.Errorf("failed to save promotion of %s: %w", other.SHA, err)
+		}
+	}
+
+	return nil
+}
+
+// Abort fails active promotions of the app which already reached the environment
+func (pp *PromotionPipeline) Abort(app, environment, reason string) error {
+	if environment == "" {
+		return nil
+	}
+
+	pp.lock.Lock()
+	defer pp.lock.Unlock()
+
+	for _, p := range pp.store.Active() {
+		i := p.Current()
+
+		if p.App != app || i < 0 {
+			continue
+		}
+
+		reached := false
+		for j := 0; j <= i; j++ {
+			if p.Stages[j].Environment == environment {
+				reached = p.Stages[j].Status != promotion.StagePending
+			}
+		}
+
+		if !reached {
+			continue
+		}
+
+		p.Fail(i, reason)
+
+		if err := pp.store.Save(p); err != nil {
+			return xerrors.Errorf("failed to save promotion of %s: %w", p.SHA, err)
+		}
+	}
+
+	return nil
+}
+
+func containsStatus(statuses []promotion.StageStatus, status promotion.StageStatus) bool {
+	for _, s := range statuses {
+		if s == status {
+			return true
+		}
+	}
+
+	return false
+}
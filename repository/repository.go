@@ -1,25 +1,103 @@
 package repository
 
 import (
+	"context"
+	"sort"
+	"strings"
 	"sync"
 
+	"github.com/MISW/mischan-bot/intenral/history"
+	"github.com/MISW/mischan-bot/intenral/manifrepo"
 	"github.com/google/go-github/v55/github"
 	"golang.org/x/xerrors"
 )
 
 // Repository handles webhook events for each repository
 type Repository interface {
-	OnPush(event *github.PushEvent) error
+	OnPush(ctx context.Context, event *github.PushEvent) error
 
-	OnCheckSuite(event *github.CheckSuiteEvent) error
+	OnCheckSuite(ctx context.Context, event *github.CheckSuiteEvent) error
 
-	OnCreate(event *github.CreateEvent) error
+	OnCreate(ctx context.Context, event *github.CreateEvent) error
 
 	FullName() string
 }
 
+// PullRequestHandler is implemented by repositories which handle pull_request events
+type PullRequestHandler interface {
+	Repository
+
+	OnPullRequest(ctx context.Context, event *github.PullRequestEvent) error
+}
+
+// BranchWatcher is implemented by repositories which deploy a branch of the app after checks pass
+type BranchWatcher interface {
+	Repository
+
+	// TargetBranch returns the deployed branch of the app
+	TargetBranch() string
+}
+
+// ManifestUpdater is implemented by repositories that open pull requests in the manifest repository
+type ManifestUpdater interface {
+	Repository
+
+	// BranchPrefix returns the prefix of branches for pull requests in the manifest repository
+	BranchPrefix() string
+
+	// ManifestUpdate returns the commit message and manipulator to regenerate the branch in the manifest repository.
+	// sha is the full SHA of the commit deployed by the branch, and the short SHA in branchName is used if it is empty.
+	ManifestUpdate(branchName, sha string) (commitMessage string, manipulator manifrepo.Manipulator, err error)
+
+	// Images returns tags of images set for shortSHA by name
+	Images(shortSHA string) map[string]string
+}
+
+// Deployer is implemented by repositories which can deploy a SHA of the app
+type Deployer interface {
+	Repository
+
+	// Deploy updates manifests to sha without checking CI of the app
+	Deploy(ctx context.Context, sha string) error
+}
+
+// RunOptions change what a manually triggered run deploys. The zero value runs as on webhooks.
+type RunOptions struct {
+	// SHA is deployed instead of the head of the target branch if set. Checks must have passed on it.
+	SHA string
+
+	// Environment is the environment from which the SHA is promoted instead of the first one if set
+	Environment string
+
+	// RetryOf is the ID of the run retried
+	RetryOf string
+}
+
+// Runner is implemented by repositories whose runs can be triggered manually
+type Runner interface {
+	BranchWatcher
+
+	// Run deploys the app through the same path as webhooks and returns the recorded run
+	Run(ctx context.Context, installationID int64, opts RunOptions) (*history.Run, error)
+
+	// CheckStatus returns whether all checks passed on ref and the SHA they ran on
+	CheckStatus(ctx context.Context, installationID int64, ref string) (success bool, sha string, err error)
+}
+
+// Rollbacker is implemented by manifest updaters which can roll back environments to previously deployed SHAs
+type Rollbacker interface {
+	ManifestUpdater
+
+	// RollbackTarget returns the directory in the manifest repository for environment and the image whose tags are the deployed SHAs.
+	// The bases directory of the app is used if environment is empty.
+	RollbackTarget(environment string) (dir, image string, err error)
+}
+
 var (
 	ErrUnknownRepository = xerrors.New("unknown repository")
+
+	// ErrUnknownEnvironment is returned if the environment is not configured for the app
+	ErrUnknownEnvironment = xerrors.New("unknown environment")
 )
 
 type RepositoryBundler struct {
@@ -41,47 +119,109 @@ func (rb *RepositoryBundler) RegisterRepository(repository Repository) {
 	rb.repositories[repository.FullName()] = repository
 }
 
-func (rb *RepositoryBundler) OnCreate(event *github.CreateEvent) error {
+// Repositories returns all registered repositories sorted by name
+func (rb *RepositoryBundler) Repositories() []Repository {
 	rb.lock.RLock()
 	defer rb.lock.RUnlock()
 
-	repo := event.GetRepo().GetFullName()
+	repos := make([]Repository, 0, len(rb.repositories))
+	for _, repo := range rb.repositories {
+		repos = append(repos, repo)
+	}
+
+	sort.Slice(repos, func(i, j int) bool {
+		return repos[i].FullName() < repos[j].FullName()
+	})
+
+	return repos
+}
+
+// Lookup returns the registered repository with the name(e.g. MISW/Portal)
+func (rb *RepositoryBundler) Lookup(repo string) (Repository, error) {
+	rb.lock.RLock()
+	defer rb.lock.RUnlock()
 
 	handler, ok := rb.repositories[repo]
 
 	if !ok {
-		return ErrUnknownRepository
+		return nil, ErrUnknownRepository
 	}
 
-	return handler.OnCreate(event)
+	return handler, nil
 }
 
-func (rb *RepositoryBundler) OnCheckSuite(event *github.CheckSuiteEvent) error {
-	rb.lock.RLock()
-	defer rb.lock.RUnlock()
+// Resolve returns the registered repository with the full name or the repository name, ignoring case(e.g. portal for MISW/Portal)
+func (rb *RepositoryBundler) Resolve(name string) (Repository, error) {
+	if repo, err := rb.Lookup(name); err == nil {
+		return repo, nil
+	}
 
-	repo := event.GetRepo().GetFullName()
+	var found Repository
+	for _, repo := range rb.Repositories() {
+		full := repo.FullName()
+		short := full[strings.Index(full, "/")+1:]
 
-	handler, ok := rb.repositories[repo]
+		if !strings.EqualFold(full, name) && !strings.EqualFold(short, name) {
+			continue
+		}
 
-	if !ok {
-		return ErrUnknownRepository
+		if found != nil {
+			return nil, xerrors.Errorf("%s is ambiguous between %s and %s", name, found.FullName(), full)
+		}
+
+		found = repo
+	}
+
+	if found == nil {
+		return nil, ErrUnknownRepository
 	}
 
-	return handler.OnCheckSuite(event)
+	return found, nil
 }
 
-func (rb *RepositoryBundler) OnPush(event *github.PushEvent) error {
-	rb.lock.RLock()
-	defer rb.lock.RUnlock()
+func (rb *RepositoryBundler) OnCreate(ctx context.Context, event *github.CreateEvent) error {
+	handler, err := rb.Lookup(event.GetRepo().GetFullName())
 
-	repo := event.GetRepo().GetFullName()
+	if err != nil {
+		return err
+	}
 
-	handler, ok := rb.repositories[repo]
+	return handler.OnCreate(ctx, event)
+}
+
+func (rb *RepositoryBundler) OnCheckSuite(ctx context.Context, event *github.CheckSuiteEvent) error {
+	handler, err := rb.Lookup(event.GetRepo().GetFullName())
+
+	if err != nil {
+		return err
+	}
+
+	return handler.OnCheckSuite(ctx, event)
+}
+
+func (rb *RepositoryBundler) OnPush(ctx context.Context, event *github.PushEvent) error {
+	handler, err := rb.Lookup(event.GetRepo().GetFullName())
+
+	if err != nil {
+		return err
+	}
+
+	return handler.OnPush(ctx, event)
+}
+
+// OnPullRequest dispatches the event to the repository if it handles pull requests
+func (rb *RepositoryBundler) OnPullRequest(ctx context.Context, event *github.PullRequestEvent) error {
+	repo, err := rb.Lookup(event.GetRepo().GetFullName())
+
+	if err != nil {
+		return err
+	}
+
+	handler, ok := repo.(PullRequestHandler)
 
 	if !ok {
-		return ErrUnknownRepository
+		return nil
 	}
 
-	return handler.OnPush(event)
+	return handler.OnPullRequest(ctx, event)
 }
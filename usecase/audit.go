@@ -0,0 +1,28 @@
+package usecase
+
+import (
+	"github.com/MISW/mischan-bot/intenral/audit"
+)
+
+// AuditUsecase searches the audit log of actions on GitHub
+type AuditUsecase interface {
+	// Search returns records matching the query, newest first
+	Search(q audit.Query) ([]*audit.Record, error)
+}
+
+var _ AuditUsecase = &auditUsecase{}
+
+type auditUsecase struct {
+	log *audit.Log
+}
+
+// NewAuditUsecase initializes AuditUsecase
+func NewAuditUsecase(log *audit.Log) AuditUsecase {
+	return &auditUsecase{
+		log: log,
+	}
+}
+
+func (au *auditUsecase) Search(q audit.Query) ([]*audit.Record, error) {
+	return au.log.Search(q)
+}
@@ -0,0 +1,214 @@
+package usecase
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/MISW/mischan-bot/config"
+	"github.com/MISW/mischan-bot/intenral/history"
+	"github.com/MISW/mischan-bot/intenral/manifrepo"
+	"github.com/MISW/mischan-bot/repository"
+	"golang.org/x/xerrors"
+)
+
+// Dashboard is an overview of registered repositories
+type Dashboard struct {
+	Repositories []*RepositoryStatus
+	Runs         []*history.Run
+	PullRequests []*BotPullRequest
+
+	// Errors are failures to collect parts of the dashboard
+	Errors []string
+
+	GeneratedAt time.Time
+}
+
+// RepositoryStatus is the state of a registered repository
+type RepositoryStatus struct {
+	Name string
+
+	// TargetBranch is empty if the repository does not deploy a branch
+	TargetBranch string
+
+	Environments []*EnvironmentStatus
+}
+
+// EnvironmentStatus is the deployment in an environment of an app
+type EnvironmentStatus struct {
+	// Name is empty for apps without environments
+	Name string
+
+	// Path is the directory updated in the manifest repository
+	Path string
+
+	// Current is nil if no deployment is recorded
+	Current *history.Deployment
+}
+
+// BotPullRequest is an open pull request by the bot in the manifest repository
+type BotPullRequest struct {
+	App       string
+	Number    int
+	Title     string
+	Branch    string
+	URL       string
+	CreatedAt time.Time
+}
+
+// DashboardUsecase collects the overview of registered repositories
+type DashboardUsecase interface {
+	// Dashboard returns the overview with up to the number of latest runs
+	Dashboard(ctx context.Context, runs int) (*Dashboard, error)
+}
+
+var _ DashboardUsecase = &dashboardUsecase{}
+
+type dashboardUsecase struct {
+	cfg         *config.Config
+	repoBundler *repository.RepositoryBundler
+	manifests   *manifrepo.Factory
+	store       *history.Store
+}
+
+// NewDashboardUsecase initializes DashboardUsecase
+func NewDashboardUsecase(
+	cfg *config.Config,
+	repoBundler *repository.RepositoryBundler,
+	manifests *manifrepo.Factory,
+	store *history.Store,
+) DashboardUsecase {
+	return &dashboardUsecase{
+		cfg:         cfg,
+		repoBundler: repoBundler,
+		manifests:   manifests,
+		store:       store,
+	}
+}
+
+func (du *dashboardUsecase) Dashboard(ctx context.Context, runs int) (*Dashboard, error) {
+	d := &Dashboard{
+		GeneratedAt: time.Now(),
+	}
+
+	for _, repo := range du.repoBundler.Repositories() {
+		status, err := du.repositoryStatus(repo, d.GeneratedAt)
+
+		if err != nil {
+			return nil, xerrors.Errorf("failed to get status of %s: %w", repo.FullName(), err)
+		}
+
+		d.Repositories = append(d.Repositories, status)
+	}
+
+	list, err := du.store.Runs("", "", runs)
+
+	if err != nil {
+		return nil, xerrors.Errorf("failed to list runs: %w", err)
+	}
+	d.Runs = list
+
+	// The dashboard is still useful without pull requests while GitHub is unavailable
+	prs, err := du.pullRequests(ctx)
+
+	if err != nil {
+		d.Errors = append(d.Errors, err.Error())
+	}
+	d.PullRequests = prs
+
+	return d, nil
+}
+
+func (du *dashboardUsecase) repositoryStatus(repo repository.Repository, now time.Time) (*RepositoryStatus, error) {
+	status := &RepositoryStatus{
+		Name: repo.FullName(),
+	}
+
+	if bw, ok := repo.(repository.BranchWatcher); ok {
+		status.TargetBranch = bw.TargetBranch()
+	}
+
+	rb, ok := repo.(repository.Rollbacker)
+
+	if !ok {
+		return status, nil
+	}
+
+	environments := []string{""}
+	if envs := du.cfg.App(repo.FullName()).Environments; len(envs) != 0 {
+		environments = environments[:0]
+
+		for _, env := range envs {
+			environments = append(environments, env.Name)
+		}
+	}
+
+	for _, env := range environments {
+		path, _, err := rb.RollbackTarget(env)
+
+		if err != nil {
+			return nil, err
+		}
+
+		current, err := du.store.Current(repo.FullName(), env, now)
+
+		if err != nil && !xerrors.Is(err, history.ErrNotFound) {
+			return nil, xerrors.Errorf("failed to get current deployment: %w", err)
+		}
+
+		status.Environments = append(status.Environments, &EnvironmentStatus{
+			Name:    env,
+			Path:    path,
+			Current: current,
+		})
+	}
+
+	return status, nil
+}
+
+// pullRequests lists open pull requests from branches of manifest updaters
+func (du *dashboardUsecase) pullRequests(ctx context.Context) ([]*BotPullRequest, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	manimani, err := du.manifests.New(ctx)
+
+	if err != nil {
+		return nil, xerrors.Errorf("failed to initialize GitHub client for manifest repository: %w", err)
+	}
+
+	prs, err := manimani.ListBotPullRequests(ctx, "")
+
+	if err != nil {
+		return nil, err
+	}
+
+	var updaters []repository.ManifestUpdater
+	for _, repo := range du.repoBundler.Repositories() {
+		if mu, ok := repo.(repository.ManifestUpdater); ok {
+			updaters = append(updaters, mu)
+		}
+	}
+
+	list := []*BotPullRequest{}
+	for _, pr := range prs {
+		for _, mu := range updaters {
+			if !strings.HasPrefix(pr.GetHead().GetRef(), mu.BranchPrefix()) {
+				continue
+			}
+
+			list = append(list, &BotPullRequest{
+				App:       mu.FullName(),
+				Number:    pr.GetNumber(),
+				Title:     pr.GetTitle(),
+				Branch:    pr.GetHead().GetRef(),
+				URL:       pr.GetHTMLURL(),
+				CreatedAt: pr.GetCreatedAt().Time,
+			})
+
+			break
+		}
+	}
+
+	return list, nil
+}
@@ -1,23 +1,42 @@
 package usecase
 
 import (
-	"log"
+	"context"
+	"log/slog"
+	"sync"
+	"sync/atomic"
 
+	"github.com/MISW/mischan-bot/intenral/logging"
+	"github.com/MISW/mischan-bot/intenral/metrics"
+	"github.com/MISW/mischan-bot/intenral/tracing"
+	"github.com/MISW/mischan-bot/intenral/trigger"
 	"github.com/MISW/mischan-bot/repository"
 	"github.com/google/go-github/v55/github"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // GitHubEventUsecase handles GtiHub webhook events
+// ctx is the context of the webhook request; events are handled asynchronously in spans linked to it.
+// deliveryID is the ID of the webhook delivery(X-GitHub-Delivery).
 type GitHubEventUsecase interface {
-	Create(e *github.CreateEvent) error
-	CheckSuite(e *github.CheckSuiteEvent) error
-	Push(e *github.PushEvent) error
+	Create(ctx context.Context, deliveryID string, e *github.CreateEvent) error
+	CheckSuite(ctx context.Context, deliveryID string, e *github.CheckSuiteEvent) error
+	Push(ctx context.Context, deliveryID string, e *github.PushEvent) error
+	PullRequest(ctx context.Context, deliveryID string, e *github.PullRequestEvent) error
+
+	// InFlight returns the number of events being handled in background
+	InFlight() int
+
+	// Wait blocks until all events being handled in background are done
+	Wait()
 }
 
 var _ GitHubEventUsecase = &gitHubEventUsecase{}
 
 type gitHubEventUsecase struct {
 	repoBundler *repository.RepositoryBundler
+	inFlight    atomic.Int64
+	wg          sync.WaitGroup
 }
 
 // NewGitHubEventUsecase initializes GitHubEventUsecase
@@ -29,14 +48,30 @@ func NewGitHubEventUsecase(repoBundler *repository.RepositoryBundler) GitHubEven
 
 // Push handles push events
 // ref. https://developer.github.com/v3/activity/events/types/#pushevent
-func (geu *gitHubEventUsecase) Push(e *github.PushEvent) error {
+func (geu *gitHubEventUsecase) Push(reqCtx context.Context, deliveryID string, e *github.PushEvent) error {
+	ctx := trigger.With(context.Background(), trigger.Trigger{
+		Actor:      e.GetSender().GetLogin(),
+		Event:      "push",
+		DeliveryID: deliveryID,
+	})
+	ctx = logging.With(ctx, "repo", e.GetRepo().GetFullName(), "sha", e.GetAfter())
+
+	done := geu.track("push")
+
+	ctx, span := tracing.StartLinked(ctx, reqCtx, "RepositoryBundler.OnPush", attribute.String("github.delivery", deliveryID), attribute.String("github.repository", e.GetRepo().GetFullName()))
+
 	go func() {
-		if err := geu.repoBundler.OnPush(e); err != nil {
-			if err == repository.ErrUnknownRepository {
-				return
-			}
+		defer done()
 
-			log.Printf("push event failed for %s: %+v", e.GetRepo().GetFullName(), err)
+		err := geu.repoBundler.OnPush(ctx, e)
+		if err == repository.ErrUnknownRepository {
+			err = nil
+		}
+
+		tracing.End(span, err)
+
+		if err != nil {
+			slog.ErrorContext(ctx, "push event failed", "error", err)
 		}
 	}()
 
@@ -45,14 +80,30 @@ func (geu *gitHubEventUsecase) Push(e *github.PushEvent) error {
 
 // Create handles create events
 // ref. https://developer.github.com/v3/activity/events/types/#createevent
-func (geu *gitHubEventUsecase) Create(e *github.CreateEvent) error {
+func (geu *gitHubEventUsecase) Create(reqCtx context.Context, deliveryID string, e *github.CreateEvent) error {
+	ctx := trigger.With(context.Background(), trigger.Trigger{
+		Actor:      e.GetSender().GetLogin(),
+		Event:      "create",
+		DeliveryID: deliveryID,
+	})
+	ctx = logging.With(ctx, "repo", e.GetRepo().GetFullName(), "ref", e.GetRef())
+
+	done := geu.track("create")
+
+	ctx, span := tracing.StartLinked(ctx, reqCtx, "RepositoryBundler.OnCreate", attribute.String("github.delivery", deliveryID), attribute.String("github.repository", e.GetRepo().GetFullName()))
+
 	go func() {
-		if err := geu.repoBundler.OnCreate(e); err != nil {
-			if err == repository.ErrUnknownRepository {
-				return
-			}
+		defer done()
 
-			log.Printf("create event failed for %s: %+v", e.GetRepo().GetFullName(), err)
+		err := geu.repoBundler.OnCreate(ctx, e)
+		if err == repository.ErrUnknownRepository {
+			err = nil
+		}
+
+		tracing.End(span, err)
+
+		if err != nil {
+			slog.ErrorContext(ctx, "create event failed", "error", err)
 		}
 	}()
 
@@ -61,16 +112,86 @@ func (geu *gitHubEventUsecase) Create(e *github.CreateEvent) error {
 
 // CheckSuite handles check suite events
 // ref. https://developer.github.com/v3/activity/events/types/#checksuiteevent
-func (geu *gitHubEventUsecase) CheckSuite(e *github.CheckSuiteEvent) error {
+func (geu *gitHubEventUsecase) CheckSuite(reqCtx context.Context, deliveryID string, e *github.CheckSuiteEvent) error {
+	ctx := trigger.With(context.Background(), trigger.Trigger{
+		Actor:      e.GetSender().GetLogin(),
+		Event:      "check_suite",
+		DeliveryID: deliveryID,
+	})
+	ctx = logging.With(ctx, "repo", e.GetRepo().GetFullName(), "sha", e.GetCheckSuite().GetHeadSHA())
+
+	done := geu.track("check_suite")
+
+	ctx, span := tracing.StartLinked(ctx, reqCtx, "RepositoryBundler.OnCheckSuite", attribute.String("github.delivery", deliveryID), attribute.String("github.repository", e.GetRepo().GetFullName()))
+
+	go func() {
+		defer done()
+
+		err := geu.repoBundler.OnCheckSuite(ctx, e)
+		if err == repository.ErrUnknownRepository {
+			err = nil
+		}
+
+		tracing.End(span, err)
+
+		if err != nil {
+			slog.ErrorContext(ctx, "check_suite event failed", "error", err)
+		}
+	}()
+
+	return nil
+}
+
+// PullRequest handles pull request events
+// ref. https://docs.github.com/en/webhooks/webhook-events-and-payloads#pull_request
+func (geu *gitHubEventUsecase) PullRequest(reqCtx context.Context, deliveryID string, e *github.PullRequestEvent) error {
+	ctx := trigger.With(context.Background(), trigger.Trigger{
+		Actor:      e.GetSender().GetLogin(),
+		Event:      "pull_request",
+		DeliveryID: deliveryID,
+	})
+	ctx = logging.With(ctx, "repo", e.GetRepo().GetFullName(), "sha", e.GetPullRequest().GetHead().GetSHA())
+
+	done := geu.track("pull_request")
+
+	ctx, span := tracing.StartLinked(ctx, reqCtx, "RepositoryBundler.OnPullRequest", attribute.String("github.delivery", deliveryID), attribute.String("github.repository", e.GetRepo().GetFullName()))
+
 	go func() {
-		if err := geu.repoBundler.OnCheckSuite(e); err != nil {
-			if err == repository.ErrUnknownRepository {
-				return
-			}
+		defer done()
 
-			log.Printf("check_suite event failed for %s: %+v", e.GetRepo().GetFullName(), err)
+		err := geu.repoBundler.OnPullRequest(ctx, e)
+		if err == repository.ErrUnknownRepository {
+			err = nil
+		}
+
+		tracing.End(span, err)
+
+		if err != nil {
+			slog.ErrorContext(ctx, "pull_request event failed", "error", err)
 		}
 	}()
 
 	return nil
 }
+
+func (geu *gitHubEventUsecase) InFlight() int {
+	return int(geu.inFlight.Load())
+}
+
+// track counts the event as in flight until done is called
+func (geu *gitHubEventUsecase) track(event string) (done func()) {
+	gauge := metrics.EventHandlersInFlight.WithLabelValues(event)
+	gauge.Inc()
+	geu.inFlight.Add(1)
+	geu.wg.Add(1)
+
+	return func() {
+		geu.inFlight.Add(-1)
+		gauge.Dec()
+		geu.wg.Done()
+	}
+}
+
+func (geu *gitHubEventUsecase) Wait() {
+	geu.wg.Wait()
+}
@@ -0,0 +1,66 @@
+package usecase
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/MISW/mischan-bot/intenral/freeze"
+	"github.com/MISW/mischan-bot/repository"
+	"golang.org/x/xerrors"
+)
+
+// FreezeUsecase manages freeze windows
+type FreezeUsecase interface {
+	// Override allows deploying the environment of the app until the active freeze window ends.
+	// Updates held back by the window are deployed in background.
+	Override(ctx context.Context, app, environment, user string) (*freeze.Override, error)
+}
+
+var _ FreezeUsecase = &freezeUsecase{}
+
+type freezeUsecase struct {
+	repoBundler *repository.RepositoryBundler
+	freezes     *repository.FreezeGate
+	promotions  *repository.PromotionPipeline
+}
+
+// NewFreezeUsecase initializes FreezeUsecase
+func NewFreezeUsecase(
+	repoBundler *repository.RepositoryBundler,
+	freezes *repository.FreezeGate,
+	promotions *repository.PromotionPipeline,
+) FreezeUsecase {
+	return &freezeUsecase{
+		repoBundler: repoBundler,
+		freezes:     freezes,
+		promotions:  promotions,
+	}
+}
+
+func (fu *freezeUsecase) Override(ctx context.Context, app, environment, user string) (*freeze.Override, error) {
+	if _, err := fu.repoBundler.Lookup(app); err != nil {
+		return nil, xerrors.Errorf("%s: %w", app, err)
+	}
+
+	o, err := fu.freezes.Override(app, environment, user)
+
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+		defer cancel()
+
+		if err := fu.freezes.Flush(ctx); err != nil {
+			slog.ErrorContext(ctx, "failed to deploy held back updates", "error", err)
+		}
+
+		if err := fu.promotions.Advance(ctx); err != nil {
+			slog.ErrorContext(ctx, "failed to advance promotions", "error", err)
+		}
+	}()
+
+	return o, nil
+}
@@ -0,0 +1,227 @@
+package usecase
+
+import (
+	"context"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MISW/mischan-bot/config"
+	"github.com/MISW/mischan-bot/intenral/ghsink"
+	"github.com/MISW/mischan-bot/intenral/manifrepo"
+	"github.com/MISW/mischan-bot/repository"
+	"golang.org/x/xerrors"
+)
+
+const (
+	// remoteCheckInterval limits calls to GitHub by frequent readiness probes
+	remoteCheckInterval = 30 * time.Second
+
+	healthCheckTimeout = 10 * time.Second
+)
+
+// HealthCheck is the result of checking a dependency
+type HealthCheck struct {
+	Name       string    `json:"name"`
+	OK         bool      `json:"ok"`
+	Error      string    `json:"error,omitempty"`
+	DurationMS int64     `json:"durationMs"`
+	CheckedAt  time.Time `json:"checkedAt"`
+}
+
+// HealthReport is the results of all dependency checks
+type HealthReport struct {
+	OK     bool          `json:"ok"`
+	Checks []HealthCheck `json:"checks"`
+}
+
+// HealthUsecase checks dependencies required to handle events
+type HealthUsecase interface {
+	// Ready checks dependencies. Results of checks against GitHub are reused for a while.
+	Ready(ctx context.Context) *HealthReport
+}
+
+var _ HealthUsecase = &healthUsecase{}
+
+type healthUsecase struct {
+	cfg         *config.Config
+	ghs         *ghsink.GitHubSink
+	manifests   *manifrepo.Factory
+	repoBundler *repository.RepositoryBundler
+	events      GitHubEventUsecase
+
+	lock   sync.Mutex
+	remote []HealthCheck
+}
+
+// NewHealthUsecase initializes HealthUsecase
+func NewHealthUsecase(
+	cfg *config.Config,
+	ghs *ghsink.GitHubSink,
+	manifests *manifrepo.Factory,
+	repoBundler *repository.RepositoryBundler,
+	events GitHubEventUsecase,
+) HealthUsecase {
+	return &healthUsecase{
+		cfg:         cfg,
+		ghs:         ghs,
+		manifests:   manifests,
+		repoBundler: repoBundler,
+		events:      events,
+	}
+}
+
+type healthCheckFunc func(ctx context.Context) error
+
+func (hu *healthUsecase) Ready(ctx context.Context) *HealthReport {
+	checks := append(hu.remoteChecks(ctx), runHealthChecks(ctx, map[string]healthCheckFunc{
+		"kustomize":   checkKustomize,
+		"event-queue": hu.checkEventQueue,
+	})...)
+
+	report := &HealthReport{
+		OK:     true,
+		Checks: checks,
+	}
+
+	for _, c := range checks {
+		if !c.OK {
+			report.OK = false
+		}
+	}
+
+	return report
+}
+
+// remoteChecks returns cached results of checks against GitHub and runs them again if stale
+func (hu *healthUsecase) remoteChecks(ctx context.Context) []HealthCheck {
+	hu.lock.Lock()
+	defer hu.lock.Unlock()
+
+	if len(hu.remote) != 0 && time.Since(hu.remote[0].CheckedAt) < remoteCheckInterval {
+		return hu.remote
+	}
+
+	checks := map[string]healthCheckFunc{
+		"github-app":          hu.checkApp,
+		"manifest-repository": hu.checkManifestRepository,
+	}
+
+	for _, repo := range hu.repoBundler.Repositories() {
+		name := repo.FullName()
+
+		checks["installation/"+name] = func(ctx context.Context) error {
+			return hu.checkInstallation(ctx, name)
+		}
+	}
+
+	hu.remote = runHealthChecks(ctx, checks)
+
+	return hu.remote
+}
+
+// runHealthChecks runs checks concurrently and returns the results sorted by name
+func runHealthChecks(ctx context.Context, checks map[string]healthCheckFunc) []HealthCheck {
+	var wg sync.WaitGroup
+	results := make([]HealthCheck, 0, len(checks))
+	var lock sync.Mutex
+
+	now := time.Now()
+	for name, fn := range checks {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+			defer cancel()
+
+			start := time.Now()
+			err := fn(ctx)
+
+			result := HealthCheck{
+				Name:       name,
+				OK:         err == nil,
+				DurationMS: time.Since(start).Milliseconds(),
+				CheckedAt:  now,
+			}
+
+			if err != nil {
+				result.Error = err.Error()
+			}
+
+			lock.Lock()
+			results = append(results, result)
+			lock.Unlock()
+		}()
+	}
+
+	wg.Wait()
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Name < results[j].Name
+	})
+
+	return results
+}
+
+// checkApp authenticates as the GitHub App with the JWT
+func (hu *healthUsecase) checkApp(ctx context.Context) error {
+	if _, _, err := hu.ghs.AppsClient().Apps.Get(ctx, ""); err != nil {
+		return xerrors.Errorf("failed to authenticate as the app: %w", err)
+	}
+
+	return nil
+}
+
+// checkInstallation mints an installation token for the repository
+func (hu *healthUsecase) checkInstallation(ctx context.Context, repo string) error {
+	owner, name, _ := strings.Cut(repo, "/")
+
+	ins, _, err := hu.ghs.AppsClient().Apps.FindRepositoryInstallation(ctx, owner, name)
+
+	if err != nil {
+		return xerrors.Errorf("failed to get installation for %s: %w", repo, err)
+	}
+
+	if _, err := hu.ghs.InstallationToken(ctx, ins.GetID()); err != nil {
+		return xerrors.Errorf("failed to get token for installation %d: %w", ins.GetID(), err)
+	}
+
+	return nil
+}
+
+// checkManifestRepository checks the base branch of the manifest repository can be fetched
+func (hu *healthUsecase) checkManifestRepository(ctx context.Context) error {
+	mm, err := hu.manifests.New(ctx)
+
+	if err != nil {
+		return xerrors.Errorf("failed to initialize manifest manipulator: %w", err)
+	}
+
+	return mm.Ping(ctx)
+}
+
+// checkKustomize runs the kustomize binary used to update and build manifests
+func checkKustomize(ctx context.Context) error {
+	out, err := exec.CommandContext(ctx, "kustomize", "version").CombinedOutput()
+
+	if err != nil {
+		return xerrors.Errorf("failed to run kustomize(%s): %w", strings.TrimSpace(string(out)), err)
+	}
+
+	return nil
+}
+
+// checkEventQueue fails if as many events as MAX_IN_FLIGHT_EVENTS are being handled
+func (hu *healthUsecase) checkEventQueue(ctx context.Context) error {
+	limit := hu.cfg.MaxInFlightEvents
+
+	if n := hu.events.InFlight(); limit > 0 && n >= limit {
+		return xerrors.Errorf("%d events are in flight(limit: %d)", n, limit)
+	}
+
+	return nil
+}
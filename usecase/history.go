@@ -0,0 +1,51 @@
+package usecase
+
+import (
+	"time"
+
+	"github.com/MISW/mischan-bot/intenral/history"
+)
+
+// HistoryUsecase queries the deployment history
+type HistoryUsecase interface {
+	// Find returns deployments matching the query, newest first
+	Find(q history.Query) ([]*history.Deployment, error)
+
+	// Get returns the deployment with the ID
+	Get(id string) (*history.Deployment, error)
+
+	// Current returns the deployment which was live in the environment of the app at t
+	Current(app, environment string, t time.Time) (*history.Deployment, error)
+
+	// Deliveries returns the latest webhook deliveries to the endpoint, newest first. All endpoints are included if empty.
+	Deliveries(endpoint string, limit int) ([]*history.Delivery, error)
+}
+
+var _ HistoryUsecase = &historyUsecase{}
+
+type historyUsecase struct {
+	store *history.Store
+}
+
+// NewHistoryUsecase initializes HistoryUsecase
+func NewHistoryUsecase(store *history.Store) HistoryUsecase {
+	return &historyUsecase{
+		store: store,
+	}
+}
+
+func (hu *historyUsecase) Find(q history.Query) ([]*history.Deployment, error) {
+	return hu.store.Find(q)
+}
+
+func (hu *historyUsecase) Get(id string) (*history.Deployment, error) {
+	return hu.store.Get(id)
+}
+
+func (hu *historyUsecase) Current(app, environment string, t time.Time) (*history.Deployment, error) {
+	return hu.store.Current(app, environment, t)
+}
+
+func (hu *historyUsecase) Deliveries(endpoint string, limit int) ([]*history.Delivery, error) {
+	return hu.store.Deliveries(endpoint, limit)
+}
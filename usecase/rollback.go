@@ -0,0 +1,148 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/MISW/mischan-bot/intenral/manifrepo"
+	"github.com/MISW/mischan-bot/repository"
+	"golang.org/x/xerrors"
+)
+
+var (
+	// ErrRollbackUnsupported is returned if the app cannot be rolled back
+	ErrRollbackUnsupported = xerrors.New("rollback is not supported")
+
+	// ErrNoPreviousVersion is returned if no version was deployed before the current one
+	ErrNoPreviousVersion = xerrors.New("no previous version")
+)
+
+// RollbackResult is the pull request opened for a rollback
+type RollbackResult struct {
+	App         string `json:"app"`
+	Environment string `json:"environment,omitempty"`
+	From        string `json:"from"`
+	To          string `json:"to"`
+	PullRequest string `json:"pullRequest,omitempty"`
+}
+
+// RollbackUsecase rolls back apps to previously deployed versions
+type RollbackUsecase interface {
+	// Rollback opens a pull request to set the SHA deployed before the current one in the environment of the app.
+	// CI of the app is not checked for the SHA, while rendered manifests are still validated.
+	Rollback(ctx context.Context, app, environment string) (*RollbackResult, error)
+}
+
+var _ RollbackUsecase = &rollbackUsecase{}
+
+type rollbackUsecase struct {
+	repoBundler *repository.RepositoryBundler
+	manifests   *manifrepo.Factory
+	promotions  *repository.PromotionPipeline
+	deployments *repository.DeploymentRecorder
+}
+
+// NewRollbackUsecase initializes RollbackUsecase
+func NewRollbackUsecase(
+	repoBundler *repository.RepositoryBundler,
+	manifests *manifrepo.Factory,
+	promotions *repository.PromotionPipeline,
+	deployments *repository.DeploymentRecorder,
+) RollbackUsecase {
+	return &rollbackUsecase{
+		repoBundler: repoBundler,
+		manifests:   manifests,
+		promotions:  promotions,
+		deployments: deployments,
+	}
+}
+
+func (ru *rollbackUsecase) Rollback(ctx context.Context, app, environment string) (*RollbackResult, error) {
+	repo, err := ru.repoBundler.Lookup(app)
+
+	if err != nil {
+		return nil, xerrors.Errorf("%s: %w", app, err)
+	}
+
+	rollbacker, ok := repo.(repository.Rollbacker)
+
+	if !ok {
+		return nil, xerrors.Errorf("%s: %w", app, ErrRollbackUnsupported)
+	}
+
+	dir, image, err := rollbacker.RollbackTarget(environment)
+
+	if err != nil {
+		return nil, err
+	}
+
+	manimani, err := ru.manifests.New(ctx)
+
+	if err != nil {
+		return nil, xerrors.Errorf("failed to initialize GitHub client for manifest repository: %w", err)
+	}
+
+	history, err := manimani.ImageHistory(ctx, dir, image, 2)
+
+	if err != nil {
+		return nil, xerrors.Errorf("failed to get deployed versions of %s: %w", image, err)
+	}
+
+	if len(history) < 2 {
+		return nil, xerrors.Errorf("%s in %s: %w", image, dir, ErrNoPreviousVersion)
+	}
+
+	current, previous := history[0], history[1]
+	shortSHA := strings.TrimPrefix(previous.Tag, "sha-")
+
+	if len(shortSHA) != 7 {
+		return nil, xerrors.Errorf("unexpected tag %s deployed by %s", previous.Tag, previous.Commit)
+	}
+
+	branchName := rollbacker.BranchPrefix() + "rollback/" + shortSHA
+	if environment != "" {
+		branchName = rollbacker.BranchPrefix() + "rollback/" + environment + "/" + shortSHA
+	}
+
+	commitMessage, manipulator, err := rollbacker.ManifestUpdate(branchName, previous.SourceCommit)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// The version is no longer promoted from the environment
+	if err := ru.promotions.Abort(app, environment, fmt.Sprintf("rolled back from %s to %s", current.Tag, previous.Tag)); err != nil {
+		return nil, xerrors.Errorf("failed to abort promotions: %w", err)
+	}
+
+	deployment := repository.NewDeployment(ctx, rollbacker, environment, shortSHA)
+	deployment.Branch = branchName
+	deployment.Rollback = true
+
+	err = manimani.CreatePullRequest(ctx, branchName, commitMessage, manipulator)
+	ru.deployments.Opened(ctx, manimani, deployment, err)
+
+	if err != nil {
+		return nil, xerrors.Errorf("failed to create pull request: %w", err)
+	}
+
+	result := &RollbackResult{
+		App:         app,
+		Environment: environment,
+		From:        current.Tag,
+		To:          previous.Tag,
+	}
+
+	pr, err := manimani.FindPullRequest(ctx, branchName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if pr != nil {
+		result.PullRequest = pr.GetHTMLURL()
+	}
+
+	return result, nil
+}
@@ -0,0 +1,339 @@
+package usecase
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/MISW/mischan-bot/config"
+	"github.com/MISW/mischan-bot/intenral/ghsink"
+	"github.com/MISW/mischan-bot/intenral/history"
+	"github.com/MISW/mischan-bot/repository"
+	"golang.org/x/xerrors"
+)
+
+var (
+	// ErrRunUnsupported is returned if runs of the app cannot be triggered manually
+	ErrRunUnsupported = xerrors.New("manual runs are not supported")
+
+	// ErrUnknownCommit is returned if the SHA is not found in the app repository
+	ErrUnknownCommit = xerrors.New("unknown commit")
+)
+
+// RunPlan is what a run would do now, computed without changing anything
+type RunPlan struct {
+	App string `json:"app"`
+
+	// Ref is the branch or the SHA on which checks must have passed
+	Ref          string `json:"ref"`
+	SHA          string `json:"sha,omitempty"`
+	ChecksPassed bool   `json:"checksPassed"`
+
+	// Mode is the mode of the app, which environments in Targets may override
+	Mode   config.Mode       `json:"mode"`
+	Images map[string]string `json:"images,omitempty"`
+
+	// Targets are directories updated in order
+	Targets []*PlanTarget `json:"targets"`
+
+	// Action is skip, deploy, promote or queue
+	Action string `json:"action"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// PlanTarget is a directory in the manifest repository updated by a run
+type PlanTarget struct {
+	Environment string      `json:"environment,omitempty"`
+	Path        string      `json:"path"`
+	Mode        config.Mode `json:"mode"`
+
+	// Current is the SHA deployed now if known
+	Current  string `json:"current,omitempty"`
+	UpToDate bool   `json:"upToDate"`
+
+	// FreezeWindow is the name of the freeze window holding back the target
+	FreezeWindow string `json:"freezeWindow,omitempty"`
+}
+
+// RunUsecase triggers and inspects runs of apps. Runs go through the same path as webhooks.
+type RunUsecase interface {
+	// Deploy runs the app with opts. opts.SHA may be abbreviated.
+	Deploy(ctx context.Context, app string, opts repository.RunOptions) (*history.Run, error)
+
+	// Plan returns what Deploy would do with opts without changing anything
+	Plan(ctx context.Context, app string, opts repository.RunOptions) (*RunPlan, error)
+
+	// Runs returns the latest runs of the app with the result, newest first. All apps or results are included if empty.
+	Runs(app, result string, limit int) ([]*history.Run, error)
+
+	// Get returns the run with the ID
+	Get(id string) (*history.Run, error)
+
+	// Retry runs the app of the run again for the same SHA and environment
+	Retry(ctx context.Context, id string) (*history.Run, error)
+}
+
+var _ RunUsecase = &runUsecase{}
+
+type runUsecase struct {
+	cfg         *config.Config
+	ghs         *ghsink.GitHubSink
+	repoBundler *repository.RepositoryBundler
+	freezes     *repository.FreezeGate
+	store       *history.Store
+}
+
+// NewRunUsecase initializes RunUsecase
+func NewRunUsecase(
+	cfg *config.Config,
+	ghs *ghsink.GitHubSink,
+	repoBundler *repository.RepositoryBundler,
+	freezes *repository.FreezeGate,
+	store *history.Store,
+) RunUsecase {
+	return &runUsecase{
+		cfg:         cfg,
+		ghs:         ghs,
+		repoBundler: repoBundler,
+		freezes:     freezes,
+		store:       store,
+	}
+}
+
+func (ru *runUsecase) Deploy(ctx context.Context, app string, opts repository.RunOptions) (*history.Run, error) {
+	runner, err := ru.runner(app)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Environment != "" {
+		if _, ok := ru.cfg.App(runner.FullName()).Environment(opts.Environment); !ok {
+			return nil, xerrors.Errorf("%s: %w", opts.Environment, repository.ErrUnknownEnvironment)
+		}
+	}
+
+	installationID, err := ru.installation(ctx, runner.FullName())
+
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.SHA != "" {
+		if opts.SHA, err = ru.resolve(ctx, installationID, runner.FullName(), opts.SHA); err != nil {
+			return nil, err
+		}
+	}
+
+	return runner.Run(ctx, installationID, opts)
+}
+
+func (ru *runUsecase) Plan(ctx context.Context, app string, opts repository.RunOptions) (*RunPlan, error) {
+	runner, err := ru.runner(app)
+
+	if err != nil {
+		return nil, err
+	}
+
+	appConfig := ru.cfg.App(runner.FullName())
+
+	installationID, err := ru.installation(ctx, runner.FullName())
+
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &RunPlan{
+		App:  runner.FullName(),
+		Ref:  runner.TargetBranch(),
+		Mode: appConfig.Mode,
+	}
+
+	if opts.SHA != "" {
+		if plan.Ref, err = ru.resolve(ctx, installationID, runner.FullName(), opts.SHA); err != nil {
+			return nil, err
+		}
+	}
+
+	if plan.Targets, err = ru.targets(runner, opts.Environment); err != nil {
+		return nil, err
+	}
+
+	if plan.ChecksPassed, plan.SHA, err = runner.CheckStatus(ctx, installationID, plan.Ref); err != nil {
+		return nil, xerrors.Errorf("failed to get checks of %s: %w", plan.Ref, err)
+	}
+
+	if !plan.ChecksPassed || plan.SHA == "" {
+		plan.Action = "skip"
+		plan.Reason = "checks have not passed on " + plan.Ref
+
+		return plan, nil
+	}
+
+	if updater, ok := runner.(repository.ManifestUpdater); ok {
+		plan.Images = updater.Images(plan.SHA[:7])
+	}
+
+	for _, target := range plan.Targets {
+		if d, err := ru.store.Current(plan.App, target.Environment, time.Now()); err == nil {
+			target.Current = d.SHA
+			target.UpToDate = d.SHA == plan.SHA
+		} else if !xerrors.Is(err, history.ErrNotFound) {
+			return nil, xerrors.Errorf("failed to get current deployment: %w", err)
+		}
+
+		window, err := ru.freezes.Check(ctx, plan.App, target.Environment, plan.SHA)
+
+		if err != nil {
+			return nil, xerrors.Errorf("failed to check freeze windows: %w", err)
+		}
+
+		if window != nil {
+			target.FreezeWindow = window.Name
+		}
+	}
+
+	first := plan.Targets[0]
+
+	switch {
+	case first.Environment == "" && first.FreezeWindow != "":
+		plan.Action = "queue"
+		plan.Reason = "deployed after freeze window " + first.FreezeWindow
+	case first.Environment == "":
+		plan.Action = "deploy"
+	case first.FreezeWindow != "":
+		plan.Action = "promote"
+		plan.Reason = first.Environment + " waits for freeze window " + first.FreezeWindow
+	default:
+		plan.Action = "promote"
+	}
+
+	return plan, nil
+}
+
+// targets returns directories updated by runs of the app from the environment
+func (ru *runUsecase) targets(runner repository.Runner, environment string) ([]*PlanTarget, error) {
+	app := ru.cfg.App(runner.FullName())
+	environments := app.Environments
+
+	if environment == "" && len(environments) == 0 {
+		rollbacker, ok := runner.(repository.Rollbacker)
+
+		if !ok {
+			return []*PlanTarget{{Mode: app.Mode}}, nil
+		}
+
+		dir, _, err := rollbacker.RollbackTarget("")
+
+		if err != nil {
+			return nil, err
+		}
+
+		return []*PlanTarget{{Path: dir, Mode: app.Mode}}, nil
+	}
+
+	var targets []*PlanTarget
+	for _, env := range environments {
+		if env.Name == environment || environment == "" || len(targets) != 0 {
+			targets = append(targets, &PlanTarget{Environment: env.Name, Path: env.Path, Mode: env.Mode})
+		}
+	}
+
+	if len(targets) == 0 {
+		return nil, xerrors.Errorf("%s: %w", environment, repository.ErrUnknownEnvironment)
+	}
+
+	return targets, nil
+}
+
+func (ru *runUsecase) Runs(app, result string, limit int) ([]*history.Run, error) {
+	if app != "" {
+		repo, err := ru.repoBundler.Resolve(app)
+
+		if err != nil {
+			return nil, xerrors.Errorf("%s: %w", app, err)
+		}
+
+		app = repo.FullName()
+	}
+
+	return ru.store.Runs(app, result, limit)
+}
+
+func (ru *runUsecase) Get(id string) (*history.Run, error) {
+	return ru.store.GetRun(id)
+}
+
+func (ru *runUsecase) Retry(ctx context.Context, id string) (*history.Run, error) {
+	prev, err := ru.store.GetRun(id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	runner, err := ru.runner(prev.App)
+
+	if err != nil {
+		return nil, err
+	}
+
+	installationID := prev.InstallationID
+	if installationID == 0 {
+		if installationID, err = ru.installation(ctx, runner.FullName()); err != nil {
+			return nil, err
+		}
+	}
+
+	return runner.Run(ctx, installationID, repository.RunOptions{
+		SHA:         prev.SHA,
+		Environment: prev.Environment,
+		RetryOf:     prev.ID,
+	})
+}
+
+func (ru *runUsecase) runner(app string) (repository.Runner, error) {
+	repo, err := ru.repoBundler.Resolve(app)
+
+	if err != nil {
+		return nil, xerrors.Errorf("%s: %w", app, err)
+	}
+
+	runner, ok := repo.(repository.Runner)
+
+	if !ok {
+		return nil, xerrors.Errorf("%s: %w", app, ErrRunUnsupported)
+	}
+
+	return runner, nil
+}
+
+// installation returns the ID of the installation of the GitHub App for the app
+func (ru *runUsecase) installation(ctx context.Context, app string) (int64, error) {
+	owner, repo, _ := strings.Cut(app, "/")
+
+	ins, _, err := ru.ghs.AppsClient().Apps.FindRepositoryInstallation(ctx, owner, repo)
+
+	if err != nil {
+		return 0, xerrors.Errorf("failed to get installation for %s: %w", app, err)
+	}
+
+	return ins.GetID(), nil
+}
+
+// resolve returns the full SHA of the commit in the app repository
+func (ru *runUsecase) resolve(ctx context.Context, installationID int64, app, sha string) (string, error) {
+	owner, repo, _ := strings.Cut(app, "/")
+
+	full, resp, err := ru.ghs.InstallationClient(installationID).Repositories.GetCommitSHA1(ctx, owner, repo, sha, "")
+
+	if resp != nil && (resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusUnprocessableEntity) {
+		return "", xerrors.Errorf("%s in %s: %w", sha, app, ErrUnknownCommit)
+	}
+
+	if err != nil {
+		return "", xerrors.Errorf("failed to resolve %s in %s: %w", sha, app, err)
+	}
+
+	return full, nil
+}